@@ -10,64 +10,108 @@ import (
 	"github.com/goccy/go-yaml"
 )
 
+// AuthMiddleware authenticates requests with the session cookie, or failing that an API token,
+// given as "Authorization: Bearer <token>" or as the password of HTTP Basic auth (for clients that only do Basic).
 func AuthMiddleware(filestore filesystem.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		l := jlogging.MustGet(c)
 
-		sess_id, err := c.Cookie("sessid")
-		if err != nil {
-			c.AbortWithStatus(401)
+		username, sess_id := "", ""
+		if cookie, err := c.Cookie("sessid"); err == nil {
+			sess_id = cookie
+			l.Set("sess", sess_id)
+
+			clean_path, err := filesystem.AbsPath("/etc/sess", sess_id+".sess")
+			if err != nil {
+				l.Printf("invalid path: %v", err)
+				unauthorized(c)
+				return
+			}
+			if !strings.HasPrefix(clean_path, "/etc/sess/") {
+				l.Printf("path traversal: cleaned path doesn't start with /etc/sess/")
+				unauthorized(c)
+				return
+			}
+
+			if bytes, err := filestore.LookupReadAll(c.Request.Context(), clean_path, []string{"sysadmin"}); err != nil {
+				l.Printf("failed to read sess file: %v", err)
+				unauthorized(c)
+				return
+			} else {
+				username = string(bytes)
+			}
+		} else if token, basic_user, ok := requestToken(c); ok {
+			token_user, err := TokenUser(c.Request.Context(), filestore, token)
+			if err != nil {
+				l.Printf("invalid API token: %v", err)
+				unauthorized(c)
+				return
+			}
+			if basic_user != "" && basic_user != token_user {
+				l.Printf("API token of %q used as %q", token_user, basic_user)
+				unauthorized(c)
+				return
+			}
+			username = token_user
+		} else {
+			unauthorized(c)
 			return
 		}
-		l.Set("sess", sess_id)
 
-		clean_path, err := filesystem.AbsPath("/etc/sess", sess_id+".sess")
+		clean_path, err := filesystem.AbsPath("/etc/passwd", username+".login")
 		if err != nil {
-			l.Printf("invalid path: %v", err)
-			c.AbortWithStatus(401)
+			l.Printf("invalid loginfile path: %v", err)
+			unauthorized(c)
 			return
 		}
-		if !strings.HasPrefix(clean_path, "/etc/sess/") {
-			l.Printf("path traversal: cleaned path doesn't start with /etc/sess/")
-			c.AbortWithStatus(401)
+		if !strings.HasPrefix(clean_path, "/etc/passwd/") {
+			l.Printf("path traversal: cleaned path doesn't start with /etc/passwd/ (%q)", clean_path)
+			unauthorized(c)
 			return
 		}
-
 		if bytes, err := filestore.LookupReadAll(c.Request.Context(), clean_path, []string{"sysadmin"}); err != nil {
-			l.Printf("failed to read sess file: %v", err)
-			c.AbortWithStatus(401)
+			l.Printf("failed to read login file: %v", err)
+			unauthorized(c)
 			return
 		} else {
-			username := string(bytes)
-			clean_path, err := filesystem.AbsPath("/etc/passwd", username+".login")
-			if err != nil {
-				l.Printf("invalid loginfile path: %v", err)
-				c.AbortWithStatus(401)
-				return
-			}
-			if !strings.HasPrefix(clean_path, "/etc/passwd/") {
-				l.Printf("path traversal: cleaned path doesn't start with /etc/passwd/ (%q)", clean_path)
-				c.AbortWithStatus(401)
+			credentials := types.CredentialsEntry{}
+			if err := yaml.Unmarshal(bytes, &credentials); err != nil {
+				l.Printf("invalid login file YAML: %v", err)
+				unauthorized(c)
 				return
 			}
-			if bytes, err := filestore.LookupReadAll(c.Request.Context(), clean_path, []string{"sysadmin"}); err != nil {
-				l.Printf("failed to read login file: %v", err)
-				c.AbortWithStatus(401)
-				return
-			} else {
-				credentials := types.CredentialsEntry{}
-				if err := yaml.Unmarshal(bytes, &credentials); err != nil {
-					l.Printf("invalid login file YAML: %v", err)
-					c.AbortWithStatus(401)
-					return
-				}
 
-				c.Set("auth", types.AuthorizationStatus{
-					Username: username,
-					Tags:     credentials.Tags,
-					SessID:   sess_id,
-				})
-			}
+			c.Set("auth", types.AuthorizationStatus{
+				Username: username,
+				Tags:     credentials.Tags,
+				SessID:   sess_id,
+			})
 		}
 	}
 }
+
+// BasicAuthChallenge makes AuthMiddleware ask for HTTP Basic credentials (an API token as the password) when it
+// rejects a request, so clients like WebDAV ones prompt for them. Install it before AuthMiddleware.
+func BasicAuthChallenge(realm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("auth_challenge", `Basic realm="`+realm+`", charset="UTF-8"`)
+	}
+}
+
+func unauthorized(c *gin.Context) {
+	if challenge := c.GetString("auth_challenge"); challenge != "" {
+		c.Header("WWW-Authenticate", challenge)
+	}
+	c.AbortWithStatus(401)
+}
+
+// requestToken returns the API token a request carries, and the username it was given with for Basic auth
+func requestToken(c *gin.Context) (string, string, bool) {
+	if username, password, ok := c.Request.BasicAuth(); ok {
+		return password, username, true
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token), "", true
+	}
+	return "", "", false
+}
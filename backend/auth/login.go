@@ -67,7 +67,7 @@ func Login(filestore filesystem.Store) gin.HandlerFunc {
 		}
 
 		fileRef := primitive.NewObjectID()
-		stream, err := filestore.Bucket.OpenUploadStreamWithID(fileRef, "")
+		stream, err := filestore.Blobs.OpenUpload(c.Request.Context(), fileRef)
 		if err != nil {
 			l.Printf("couldn't open upload stream for sess file: %v", err)
 			c.Status(401)
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -247,7 +248,7 @@ func Signup(filestore filesystem.Store) gin.HandlerFunc {
 
 		// Upload login file
 		loginFileRef := primitive.NewObjectID()
-		stream, err := filestore.Bucket.OpenUploadStreamWithID(loginFileRef, "")
+		stream, err := filestore.Blobs.OpenUpload(c.Request.Context(), loginFileRef)
 		if err != nil {
 			l.Printf("failed to open upload stream: %v", err)
 			c.Status(500)
@@ -267,7 +268,7 @@ func Signup(filestore filesystem.Store) gin.HandlerFunc {
 
 		// Upload user.profile file
 		profileFileRef := primitive.NewObjectID()
-		stream, err = filestore.Bucket.OpenUploadStreamWithID(profileFileRef, "")
+		stream, err = filestore.Blobs.OpenUpload(c.Request.Context(), profileFileRef)
 		if err != nil {
 			l.Printf("failed to open upload stream: %v", err)
 			c.Status(500)
@@ -285,28 +286,43 @@ func Signup(filestore filesystem.Store) gin.HandlerFunc {
 			return
 		}
 
-		// Create home folder
-		user_home, err := filestore.WriteDirectory(c.Request.Context(), home_folder.ID, req.Username, []string{"sysadmin"}, &home_permissions)
-		if err != nil {
-			l.Printf("failed to create user's home directory: %v", err)
-			c.Status(500)
-			return
-		}
+		// Create the account's entries all-or-nothing, so a concurrent signup can't leave a half-created user behind
+		if err := filestore.Atomic(c.Request.Context(), func(ctx context.Context) error {
+			// Re-check inside the transaction, the checks above may be stale by now
+			if home_folder, err := filestore.Nodes.Get(ctx, home_folder.ID); err != nil {
+				return fmt.Errorf("failed to get home folder: %w", err)
+			} else if _, ok := home_folder.Entries.Get(req.Username); ok {
+				return fmt.Errorf("home directory: %w", os.ErrExist)
+			}
+			if passwd_folder, err := filestore.Nodes.Get(ctx, passwd_folder.ID); err != nil {
+				return fmt.Errorf("failed to get passwd folder: %w", err)
+			} else if _, ok := passwd_folder.Entries.Get(req.Username + ".login"); ok {
+				return fmt.Errorf("passwd file: %w", os.ErrExist)
+			}
 
-		if _, err := filestore.WriteFile(c.Request.Context(), user_home.ID, "user.profile", profileFileRef, user_tags); err != nil {
-			l.Printf("failed to create user.profile file: %v", err)
-			c.Status(500)
-			return
-		}
+			user_home, err := filestore.WriteDirectory(ctx, home_folder.ID, req.Username, []string{"sysadmin"}, &home_permissions)
+			if err != nil {
+				return fmt.Errorf("failed to create user's home directory: %w", err)
+			}
 
-		if _, err := filestore.WriteFile(c.Request.Context(), passwd_folder.ID, req.Username+".login", loginFileRef, []string{"sysadmin"}); err != nil {
-			l.Printf("failed to create passwd file: %v", err)
-			c.Status(500)
-			return
-		}
-		if _, err := filestore.RemoveChild(c.Request.Context(), passwd_folder.ID, req.TokStr+".signup", []string{"sysadmin"}); err != nil {
-			l.Printf("failed to remove signup file: %v", err)
-			c.Status(500)
+			if _, err := filestore.WriteFile(ctx, user_home.ID, "user.profile", profileFileRef, user_tags); err != nil {
+				return fmt.Errorf("failed to create user.profile file: %w", err)
+			}
+			if _, err := filestore.WriteFile(ctx, passwd_folder.ID, req.Username+".login", loginFileRef, []string{"sysadmin"}); err != nil {
+				return fmt.Errorf("failed to create passwd file: %w", err)
+			}
+			if _, err := filestore.RemoveChild(ctx, passwd_folder.ID, req.TokStr+".signup", []string{"sysadmin"}); err != nil {
+				return fmt.Errorf("failed to remove signup file: %w", err)
+			}
+
+			return nil
+		}); err != nil {
+			l.Printf("signup failed: %v", err)
+			if errors.Is(err, os.ErrExist) {
+				c.Status(409)
+			} else {
+				c.Status(500)
+			}
 			return
 		}
 
@@ -318,7 +334,7 @@ func Signup(filestore filesystem.Store) gin.HandlerFunc {
 		}
 
 		sessFileRef := primitive.NewObjectID()
-		stream, err = filestore.Bucket.OpenUploadStreamWithID(sessFileRef, "")
+		stream, err = filestore.Blobs.OpenUpload(c.Request.Context(), sessFileRef)
 		if err != nil {
 			l.Printf("couldn't open upload stream for sess file: %v", err)
 			c.Status(500)
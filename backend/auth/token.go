@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/goccy/go-yaml"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TokensDir holds a "<sha256 of token>.token" file for every API token
+const TokensDir = "/etc/tokens"
+
+var ErrTokenExists = errors.New("error: you already have a token with that name")
+
+// tokenPath is where a token's file lives. Tokens are random, so an unsalted hash is enough to keep them secret.
+func tokenPath(token string) string {
+	return TokensDir + "/" + filesystem.Checksum([]byte(token)) + ".token"
+}
+
+// TokenUser returns the user an API token was issued to
+func TokenUser(ctx context.Context, filestore filesystem.Store, token string) (string, error) {
+	if token == "" || strings.ContainsAny(token, "/.") {
+		return "", os.ErrNotExist
+	}
+	data, err := filestore.LookupReadAll(ctx, tokenPath(token), []string{"sysadmin"})
+	if err != nil {
+		return "", err
+	}
+	var issued types.APIToken
+	if err := yaml.Unmarshal(data, &issued); err != nil {
+		return "", err
+	}
+	return issued.Username, nil
+}
+
+// IssueToken creates a new API token for username, returning the token. It can't be recovered afterwards.
+func IssueToken(ctx context.Context, filestore filesystem.Store, username, name string) (string, error) {
+	tags := []string{"sysadmin"}
+	if name == "" {
+		return "", fmt.Errorf("%w: token name can't be empty", os.ErrInvalid)
+	}
+	existing, err := ListTokens(ctx, filestore, username)
+	if err != nil {
+		return "", err
+	}
+	for _, token := range existing {
+		if token.Name == name {
+			return "", ErrTokenExists
+		}
+	}
+
+	token, err := util.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	data, err := util.YamlCRLF(types.APIToken{Username: username, Name: name, CreatedAt: time.Now()})
+	if err != nil {
+		return "", err
+	}
+
+	etc, err := filestore.Lookup(ctx, tags, "/etc")
+	if err != nil {
+		return "", err
+	}
+	// Deployments from before API tokens don't have the directory yet
+	dir, err := filestore.WriteDirectory(ctx, etc.ID, "tokens", tags, nil)
+	if err != nil {
+		return "", err
+	}
+
+	fileRef := primitive.NewObjectID()
+	stream, err := filestore.Blobs.OpenUpload(ctx, fileRef)
+	if err != nil {
+		return "", err
+	}
+	if _, err := stream.Write(data); err != nil {
+		stream.Close()
+		return "", err
+	}
+	if err := stream.Close(); err != nil {
+		return "", err
+	}
+	_, filename, err := filesystem.DirUp(tokenPath(token))
+	if err != nil {
+		return "", err
+	}
+	if _, err := filestore.WriteFile(ctx, dir.ID, filename, fileRef, tags); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ListTokens returns the tokens issued to username
+func ListTokens(ctx context.Context, filestore filesystem.Store, username string) ([]types.APIToken, error) {
+	tokens, _, err := listTokens(ctx, filestore, username)
+	return tokens, err
+}
+
+func listTokens(ctx context.Context, filestore filesystem.Store, username string) ([]types.APIToken, []string, error) {
+	tags := []string{"sysadmin"}
+	tokens, paths := []types.APIToken{}, []string{}
+	dir, err := filestore.Lookup(ctx, tags, TokensDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, paths, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range dir.Entries {
+		path := TokensDir + "/" + entry.Name
+		data, err := filestore.LookupReadAll(ctx, path, tags)
+		if err != nil {
+			return nil, nil, err
+		}
+		var token types.APIToken
+		if err := yaml.Unmarshal(data, &token); err != nil {
+			return nil, nil, fmt.Errorf("invalid token file %q: %w", path, err)
+		}
+		if token.Username == username {
+			tokens = append(tokens, token)
+			paths = append(paths, path)
+		}
+	}
+	return tokens, paths, nil
+}
+
+// RevokeToken deletes username's token with the given name
+func RevokeToken(ctx context.Context, filestore filesystem.Store, username, name string) error {
+	tokens, paths, err := listTokens(ctx, filestore, username)
+	if err != nil {
+		return err
+	}
+	for i, token := range tokens {
+		if token.Name == name {
+			_, err := filestore.RemoveFile(ctx, paths[i], []string{"sysadmin"}, false, false)
+			return err
+		}
+	}
+	return fmt.Errorf("%w: no token named %q", os.ErrNotExist, name)
+}
@@ -0,0 +1,127 @@
+package bootstrap
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/goccy/go-yaml"
+)
+
+func TestBootstrap(t *testing.T) {
+	ctx := context.Background()
+	store := filesystem.NewMemoryStore()
+
+	hash, err := util.HashPwd("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := Vars{Username: "admin", Email: "admin@example.com", Phone: "+1 234: 567", PasswordHash: hash}
+	if err := vars.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Vars{Username: "../etc"}).Validate(); err == nil {
+		t.Fatal("expected a username with a slash to be rejected")
+	}
+
+	manifest, err := ParseManifest(DefaultManifest, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := manifest.Apply(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != len(manifest.Entries) {
+		t.Fatalf("expected every entry to be created, got %v", created)
+	}
+
+	login_bytes, err := store.LookupReadAll(ctx, "/etc/passwd/admin.login", []string{"sysadmin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var credentials types.CredentialsEntry
+	if err := yaml.Unmarshal(login_bytes, &credentials); err != nil {
+		t.Fatalf("invalid login file %q: %v", login_bytes, err)
+	}
+	if !util.CheckPwd(credentials.Password, "secret") || !slices.Equal(credentials.Tags, []string{"sysadmin", "user-admin", "user"}) {
+		t.Fatalf("unexpected credentials: %+v", credentials)
+	}
+
+	profile_bytes, err := store.LookupReadAll(ctx, "/home/admin/user.profile", []string{"user", "user-admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var profile types.UserMetadata
+	if err := yaml.Unmarshal(profile_bytes, &profile); err != nil || profile.Phone != "+1 234: 567" || profile.Role != "sysadmin" {
+		t.Fatalf("unexpected profile %q (%v)", profile_bytes, err)
+	}
+	if _, err := store.LookupReadAll(ctx, "/etc/passwd/admin.login", []string{"user"}); err == nil {
+		t.Fatal("expected the login file to be sysadmin-only")
+	}
+
+	// Applying it again changes nothing
+	if created, err := manifest.Apply(ctx, store); err != nil || len(created) != 0 {
+		t.Fatalf("expected a second apply to create nothing, got %v (%v)", created, err)
+	}
+
+	// An edge node that signed up before flights directories existed
+	if _, err := store.Mkdir(ctx, "/home/node1", []string{"sysadmin"}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeFile(ctx, store, "/home/node1/user.profile", []byte("role: edge-node\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := Migrate(ctx, store, Migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(Migrations) {
+		t.Fatalf("expected every migration to run, got %+v", applied)
+	}
+	for _, path := range []string{"/context", "/etc/signup/pilot.signup", "/home/node1/flights"} {
+		if _, err := store.Lookup(ctx, []string{"sysadmin"}, path); err != nil {
+			t.Fatalf("expected %s to exist: %v", path, err)
+		}
+	}
+	if _, err := store.Lookup(ctx, []string{"sysadmin"}, "/home/admin/flights"); err == nil {
+		t.Fatal("expected only edge nodes to get flights directories")
+	}
+
+	recorded, _, err := AppliedMigrations(ctx, store)
+	if err != nil || len(recorded) != len(Migrations) || recorded[len(recorded)-1].Name != Migrations[len(Migrations)-1].Name {
+		t.Fatalf("unexpected record %+v (%v)", recorded, err)
+	}
+	if applied, err := Migrate(ctx, store, Migrations); err != nil || len(applied) != 0 {
+		t.Fatalf("expected nothing left to migrate, got %+v (%v)", applied, err)
+	}
+
+	// Newer migrations run on top of the recorded ones
+	ran := false
+	next := append(slices.Clone(Migrations), Migration{Version: 100, Name: "test", Up: func(ctx context.Context, store filesystem.Store) error {
+		ran = true
+		return nil
+	}})
+	if applied, err := Migrate(ctx, store, next); err != nil || len(applied) != 1 || !ran {
+		t.Fatalf("expected only the new migration to run, got %+v (%v)", applied, err)
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	for _, data := range []string{
+		"entries:\n  - path: /\n",
+		"entries:\n  - path: /etc/../home\n",
+		"entries:\n  - path: /link\n    type: symlink\n",
+		"entries:\n  - path: /dir\n    content: hello\n",
+		"entries:\n  - path: /thing\n    type: socket\n",
+		"entries:\n  - path: /home/{{ .Nobody }}\n",
+	} {
+		if _, err := ParseManifest([]byte(data), Vars{}); err == nil {
+			t.Errorf("expected %q to be rejected", data)
+		}
+	}
+}
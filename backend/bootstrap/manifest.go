@@ -0,0 +1,216 @@
+// Package bootstrap sets up and evolves the VFS layout: a YAML manifest describes the entries a new deployment
+// starts with, and versioned migrations (recorded in the VFS) bring existing deployments up to date on startup.
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/goccy/go-yaml"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed manifests/*.yaml
+var manifests embed.FS
+
+// DefaultManifest is the layout new deployments start with, used unless BOOTSTRAP_MANIFEST names another
+var DefaultManifest = mustReadManifest("default.yaml")
+
+// adminTags are the tags manifests are applied with
+var adminTags = []string{"sysadmin"}
+
+// Vars describe the bootstrap user to manifest templates
+type Vars struct {
+	Username     string
+	Email        string
+	Phone        string
+	PasswordHash string
+}
+
+// Validate checks that the username can name a home directory and login file
+func (v Vars) Validate() error {
+	if v.Username == "" || v.Username == "." || v.Username == ".." || strings.ContainsAny(v.Username, "/ \t\r\n") {
+		return fmt.Errorf("%w: invalid username %q", os.ErrInvalid, v.Username)
+	}
+	return nil
+}
+
+// Manifest lists VFS entries, parents before their children
+type Manifest struct {
+	Entries []ManifestEntry `yaml:"entries"`
+}
+
+// ManifestEntry describes one entry. Type is "directory" (the default), "file" or "symlink".
+// Without Permissions, an entry gets its parent directory's (and the root needs them).
+// File contents are written with CRLF line endings, like the files the backend writes itself.
+type ManifestEntry struct {
+	Path        string                    `yaml:"path"`
+	Type        string                    `yaml:"type"`
+	Permissions *types.FsEntryPermissions `yaml:"permissions"`
+	Content     string                    `yaml:"content"`
+	Target      string                    `yaml:"target"`
+}
+
+// ParseManifest fills in data's templates with vars and parses the result
+func ParseManifest(data []byte, vars Vars) (*Manifest, error) {
+	tmpl, err := template.New("manifest").Option("missingkey=error").Funcs(template.FuncMap{
+		"quote": func(s string) (string, error) {
+			quoted, err := json.Marshal(s)
+			return string(quoted), err
+		},
+	}).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("failed to fill in manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := yaml.Unmarshal(buf.Bytes(), &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest YAML: %w", err)
+	}
+	for i, entry := range manifest.Entries {
+		clean_path, err := filesystem.CleanupAbsPath(entry.Path)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		if clean_path != entry.Path {
+			return nil, fmt.Errorf("%w: entry %d: path %q isn't clean (expected %q)", os.ErrInvalid, i, entry.Path, clean_path)
+		}
+		if entry.Type == "" {
+			manifest.Entries[i].Type = types.Directory.String()
+		}
+		switch manifest.Entries[i].Type {
+		case types.Directory.String():
+			if entry.Path == "/" && entry.Permissions == nil {
+				return nil, fmt.Errorf("%w: the root needs permissions", os.ErrInvalid)
+			}
+		case types.File.String():
+			if entry.Path == "/" {
+				return nil, fmt.Errorf("%w: the root must be a directory", os.ErrInvalid)
+			}
+		case types.Symlink.String():
+			if entry.Path == "/" || entry.Target == "" {
+				return nil, fmt.Errorf("%w: symlink %q needs a target (and can't be the root)", os.ErrInvalid, entry.Path)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %q has unknown type %q", os.ErrInvalid, entry.Path, entry.Type)
+		}
+		if entry.Content != "" && manifest.Entries[i].Type != types.File.String() {
+			return nil, fmt.Errorf("%w: only files have content (%q)", os.ErrInvalid, entry.Path)
+		}
+	}
+	return &manifest, nil
+}
+
+// Apply creates the manifest's entries that don't exist yet, as sysadmin, and returns their paths.
+// Existing entries are left as they are (so applying a manifest twice is harmless), unless they're of another type.
+func (m Manifest) Apply(ctx context.Context, store filesystem.Store) ([]string, error) {
+	created := []string{}
+	for _, entry := range m.Entries {
+		existing, err := store.LookupNoFollow(ctx, adminTags, entry.Path)
+		if err == nil {
+			if existing.EntryType.String() != entry.Type {
+				return created, fmt.Errorf("%w: %q is a %s, not a %s", os.ErrExist, entry.Path, existing.EntryType, entry.Type)
+			}
+			continue
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return created, fmt.Errorf("failed to look up %q: %w", entry.Path, err)
+		}
+
+		if err := applyEntry(ctx, store, entry); err != nil {
+			return created, fmt.Errorf("failed to create %q: %w", entry.Path, err)
+		}
+		created = append(created, entry.Path)
+	}
+	return created, nil
+}
+
+func applyEntry(ctx context.Context, store filesystem.Store, entry ManifestEntry) error {
+	var node *types.FsEntry
+	switch {
+	case entry.Path == "/":
+		now := time.Now()
+		return store.Nodes.Insert(ctx, types.FsEntry{
+			ID:          primitive.NewObjectID(),
+			IsRoot:      true,
+			EntryType:   types.Directory,
+			Permissions: *entry.Permissions,
+			Timestamps:  types.FileTimestamps{CreatedAt: now, ModifiedAt: now, AccessedAt: now},
+			Entries:     types.FsReferenceList{},
+		})
+	case entry.Type == types.Directory.String():
+		_, err := store.Mkdir(ctx, entry.Path, adminTags, entry.Permissions, false)
+		return err
+	case entry.Type == types.Symlink.String():
+		var err error
+		if node, err = store.Symlink(ctx, entry.Path, entry.Target, adminTags); err != nil {
+			return err
+		}
+	default:
+		var err error
+		if node, err = writeFile(ctx, store, entry.Path, []byte(strings.ReplaceAll(entry.Content, "\n", "\r\n"))); err != nil {
+			return err
+		}
+	}
+
+	if entry.Permissions != nil {
+		if _, err := store.Nodes.Update(ctx, node.ID, filesystem.NodeUpdate{Permissions: entry.Permissions}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile creates the file at abs_path with contents
+func writeFile(ctx context.Context, store filesystem.Store, abs_path string, contents []byte) (*types.FsEntry, error) {
+	folder_path, filename, err := filesystem.DirUp(abs_path)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := store.Lookup(ctx, adminTags, folder_path)
+	if err != nil {
+		return nil, err
+	}
+
+	fileRef := primitive.NewObjectID()
+	stream, err := store.Blobs.OpenUpload(ctx, fileRef)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stream.Write(contents); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+
+	entry, err := store.WriteFileIfRevision(ctx, parent.ID, filename, fileRef, adminTags, 0)
+	if err != nil {
+		store.Blobs.Delete(context.Background(), fileRef)
+		return nil, err
+	}
+	return entry, nil
+}
+
+func mustReadManifest(name string) []byte {
+	data, err := manifests.ReadFile("manifests/" + name)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,171 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/goccy/go-yaml"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MigrationsPath is the file applied migrations are recorded in (a YAML list of AppliedMigration)
+const MigrationsPath = "/etc/migrations"
+
+// Migration is a versioned change to the VFS layout. Up must be idempotent: it also runs on deployments
+// that were set up by hand to look like it already ran, and again if it failed part way.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, store filesystem.Store) error
+}
+
+// AppliedMigration records that a migration ran
+type AppliedMigration struct {
+	Version   int       `yaml:"version"`
+	Name      string    `yaml:"name"`
+	AppliedAt time.Time `yaml:"applied_at"`
+}
+
+// Migrations evolve deployments, in version order. Only ever append to this list.
+var Migrations = []Migration{
+	{Version: 1, Name: "context-directory", Up: manifestMigration("context.yaml")},
+	{Version: 2, Name: "signup-templates", Up: manifestMigration("signup.yaml")},
+	{Version: 3, Name: "flights-directories", Up: flightsDirectories},
+}
+
+// Migrate runs the migrations newer than the last one recorded in MigrationsPath, recording each as it completes,
+// and returns the ones it ran. Concurrent runs (several backends starting at once) make all but one fail
+// with filesystem.ErrRevisionConflict.
+func Migrate(ctx context.Context, store filesystem.Store, migrations []Migration) ([]AppliedMigration, error) {
+	applied, revision, err := AppliedMigrations(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	latest := 0
+	for _, migration := range applied {
+		latest = max(latest, migration.Version)
+	}
+
+	ran := []AppliedMigration{}
+	for _, migration := range migrations {
+		if migration.Version <= latest {
+			continue
+		}
+		if err := migration.Up(ctx, store); err != nil {
+			return ran, fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+		}
+
+		record := AppliedMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}
+		applied = append(applied, record)
+		if revision, err = writeApplied(ctx, store, applied, revision); err != nil {
+			return ran, fmt.Errorf("failed to record migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		ran = append(ran, record)
+		latest = migration.Version
+	}
+	return ran, nil
+}
+
+// AppliedMigrations reads the migrations recorded in MigrationsPath, along with the file's revision (0 if there's none yet)
+func AppliedMigrations(ctx context.Context, store filesystem.Store) ([]AppliedMigration, int64, error) {
+	entry, err := store.Lookup(ctx, adminTags, MigrationsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return []AppliedMigration{}, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	data, err := store.LookupReadAll(ctx, MigrationsPath, adminTags)
+	if err != nil {
+		return nil, 0, err
+	}
+	applied := []AppliedMigration{}
+	if err := yaml.Unmarshal(data, &applied); err != nil {
+		return nil, 0, fmt.Errorf("invalid %s: %w", MigrationsPath, err)
+	}
+	return applied, entry.Revision, nil
+}
+
+// writeApplied replaces the migrations record, if it's still at revision, and returns the new revision
+func writeApplied(ctx context.Context, store filesystem.Store, applied []AppliedMigration, revision int64) (int64, error) {
+	data, err := util.YamlCRLF(applied)
+	if err != nil {
+		return 0, err
+	}
+	folder_path, filename, err := filesystem.DirUp(MigrationsPath)
+	if err != nil {
+		return 0, err
+	}
+	parent, err := store.Lookup(ctx, adminTags, folder_path)
+	if err != nil {
+		return 0, err
+	}
+
+	fileRef := primitive.NewObjectID()
+	stream, err := store.Blobs.OpenUpload(ctx, fileRef)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := stream.Write(data); err != nil {
+		stream.Close()
+		return 0, err
+	}
+	if err := stream.Close(); err != nil {
+		return 0, err
+	}
+	entry, err := store.WriteFileIfRevision(ctx, parent.ID, filename, fileRef, adminTags, revision)
+	if err != nil {
+		store.Blobs.Delete(context.Background(), fileRef)
+		return 0, err
+	}
+	return entry.Revision, nil
+}
+
+// manifestMigration applies one of the embedded manifests (which don't use templates)
+func manifestMigration(name string) func(ctx context.Context, store filesystem.Store) error {
+	return func(ctx context.Context, store filesystem.Store) error {
+		manifest, err := ParseManifest(mustReadManifest(name), Vars{})
+		if err != nil {
+			return err
+		}
+		_, err = manifest.Apply(ctx, store)
+		return err
+	}
+}
+
+// flightsDirectories gives every edge node a flights directory in its home, where finish-flight keeps their flights
+func flightsDirectories(ctx context.Context, store filesystem.Store) error {
+	home, err := store.Lookup(ctx, adminTags, "/home")
+	if err != nil {
+		return err
+	}
+	for _, ref := range home.Entries {
+		home_path := path.Join("/home", ref.Name)
+		profile, err := store.LookupReadAll(ctx, path.Join(home_path, "user.profile"), adminTags)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, types.ErrCantAccessFs) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		var RoleFile struct {
+			Role string `yaml:"role"`
+		}
+		if err := yaml.Unmarshal(profile, &RoleFile); err != nil || RoleFile.Role != "edge-node" {
+			continue
+		}
+
+		// The directory gets the home directory's permissions
+		if _, err := store.Mkdir(ctx, path.Join(home_path, "flights"), adminTags, nil, false); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("failed to create %s/flights: %w", home_path, err)
+		}
+	}
+	return nil
+}
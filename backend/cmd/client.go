@@ -56,8 +56,9 @@ func RunClient(info types.ClientInfo, commands []sh.Command, filestore filesyste
 		Stdout:   stdout,
 		Stderr:   stderr,
 		FS: &filesystem.FSContext{
-			Store:    filestore,
-			UserTags: info.Client.UserTags,
+			Store:       filestore,
+			UserTags:    info.Client.UserTags,
+			HonourLocks: true,
 		},
 	}
 
@@ -162,6 +163,10 @@ func RunClient(info types.ClientInfo, commands []sh.Command, filestore filesyste
 
 				cmd_ctx = context.WithValue(cmd_ctx, "auth_status", info.Client.AuthStatus)
 				cmd_ctx = context.WithValue(cmd_ctx, "tags", info.Client.UserTags)
+				cmd_ctx = context.WithValue(cmd_ctx, "lock_owner", types.LockOwner{
+					SocketID: info.ClientHandle.SocketSession().SocketID(),
+					ClientID: info.Client.ClientID,
+				})
 
 				info.Client.Out <- types.WebSocketMessage{
 					MessageID:   util.RandHex(20),
@@ -109,8 +109,9 @@ func (c *CmdActivate) Run(ctx sh.CommandContext) int {
 		Stdout:   &stdout,
 		Stderr:   &stderr,
 		FS: &filesystem.FSContext{
-			Store:    c.FileStore,
-			UserTags: tags,
+			Store:       c.FileStore,
+			UserTags:    tags,
+			HonourLocks: true,
 		},
 	}
 
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+	"github.com/goccy/go-yaml"
+)
+
+type CmdDefaults struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdDefaults) Identifier() string {
+	return "defaults"
+}
+
+func (c *CmdDefaults) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "set",
+			Aliases:    []string{"s", "set"},
+			Default:    false,
+		},
+		{
+			Identifier: "clear",
+			Aliases:    []string{"c", "clear"},
+			Default:    false,
+		},
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil || len(paths) != 1 || (opts["set"].(bool) && opts["clear"].(bool)) {
+		if err != nil {
+			fmt.Fprint(ctx.Stderr, err, "\r\n")
+		}
+		fmt.Fprint(ctx.Stderr, "usage: defaults [-y] [-s | -c] <DIR>")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	abs_path, err := filesystem.AbsPath(cwd, paths[0])
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", paths[0], err)
+		return 1
+	}
+
+	var entry *types.FsEntry
+	switch {
+	case opts["set"].(bool):
+		data, read_err := io.ReadAll(ctx.Stdin)
+		if read_err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: failed to read stdin: %v", read_err)
+			return 1
+		}
+		var defaults types.DefaultPermissions
+		if err := yaml.UnmarshalWithOptions(data, &defaults, yaml.Strict()); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid defaults YAML: %v", err)
+			return 1
+		}
+		entry, err = c.FileStore.SetDefaults(ctx.Ctx, abs_path, tags, &defaults)
+	case opts["clear"].(bool):
+		entry, err = c.FileStore.SetDefaults(ctx.Ctx, abs_path, tags, nil)
+	default:
+		entry, err = c.FileStore.Lookup(ctx.Ctx, tags, abs_path)
+		if err == nil && entry.EntryType != types.Directory {
+			err = fmt.Errorf("%w: %q is not a directory", os.ErrInvalid, abs_path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error (%q): %v", paths[0], err)
+		return 1
+	}
+
+	defaults := types.DefaultPermissions{}
+	if entry.Defaults != nil {
+		defaults = *entry.Defaults
+	}
+	if opts["yaml_output"].(bool) {
+		data, err := util.YamlCRLF(defaults)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error formatting defaults YAML: %v", err)
+			return 1
+		}
+		fmt.Fprint(ctx.Stdout, string(data))
+	} else {
+		fmt.Fprint(ctx.Stdout, "files:\t", defaultsString(defaults.Files), "\r\n")
+		fmt.Fprint(ctx.Stdout, "directories:\t", defaultsString(defaults.Directories), "\r\n")
+	}
+	return 0
+}
+
+// defaultsString formats default permissions like "r=TAGS w=TAGS x=TAGS p=TAGS"
+func defaultsString(perms *types.FsEntryPermissions) string {
+	if perms == nil {
+		return "(the directory's own)"
+	}
+	return fmt.Sprintf("r=%s w=%s x=%s p=%s",
+		strings.Join(perms.ReadTags, ","),
+		strings.Join(perms.WriteTags, ","),
+		strings.Join(perms.ExecuteTags, ","),
+		strings.Join(perms.UpdatePermissionTags, ","))
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdDf struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdDf) Identifier() string {
+	return "df"
+}
+
+func (c *CmdDf) Run(ctx sh.CommandContext) int {
+	tags := util.GetTags(ctx.Ctx)
+	if !slices.Contains(tags, "sysadmin") {
+		fmt.Fprint(ctx.Stderr, "error: not enough permissions to run this command\r\n")
+		return 1
+	}
+
+	opts, args, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err)
+		return 1
+	}
+	if len(args) != 0 {
+		fmt.Fprint(ctx.Stderr, "usage: df [-y]")
+		return 1
+	}
+
+	usage, err := c.FileStore.DiskUsage(ctx.Ctx)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to measure disk usage: %v", err)
+		return 1
+	}
+
+	if opts["yaml_output"].(bool) {
+		data, err := util.YamlCRLF(usage)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error formatting usage YAML: %v", err)
+			return 1
+		}
+		fmt.Fprint(ctx.Stdout, string(data))
+		return 0
+	}
+
+	fmt.Fprintf(ctx.Stdout, "entries\t%d (%d files, %d directories, %d symlinks)\r\n", usage.Entries, usage.Files, usage.Directories, usage.Symlinks)
+	fmt.Fprintf(ctx.Stdout, "blobs\t%d (%d bytes)\r\n", usage.Storage.Blobs, usage.Storage.Bytes)
+	if usage.Storage.StorageBytes > 0 {
+		fmt.Fprintf(ctx.Stdout, "storage\t%d bytes (%d chunks)\r\n", usage.Storage.StorageBytes, usage.Storage.Chunks)
+	}
+	fmt.Fprintf(ctx.Stdout, "orphans\t%d blobs (%d bytes), %d entries\r\n", usage.OrphanedBlobs, usage.OrphanedBytes, usage.OrphanedEntries)
+	return 0
+}
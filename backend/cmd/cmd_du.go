@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CmdDu struct {
+	FileStore filesystem.Store
+}
+
+// duResult is the usage of one path
+type duResult struct {
+	Path    string `yaml:"path"`
+	Bytes   int64  `yaml:"bytes"`
+	Entries int64  `yaml:"entries"`
+}
+
+func (*CmdDu) Identifier() string {
+	return "du"
+}
+
+func (c *CmdDu) Run(ctx sh.CommandContext) int {
+	const usage = "usage: du [-sy] [-d DEPTH] [PATHS...]"
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "summarize",
+			Aliases:    []string{"s", "summarize"},
+			Default:    false,
+		},
+		{
+			Identifier: "max_depth",
+			Aliases:    []string{"d", "max-depth"},
+			Default:    "",
+		},
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\n", usage)
+		return 1
+	}
+	max_depth := -1
+	if opts["summarize"].(bool) {
+		max_depth = 0
+	}
+	if depth := opts["max_depth"].(string); depth != "" {
+		if max_depth, err = strconv.Atoi(depth); err != nil || max_depth < 0 {
+			fmt.Fprintf(ctx.Stderr, "error: invalid depth %q\r\n%s", depth, usage)
+			return 1
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+
+	// Results are printed as soon as their subtree is done, so big trees show progress
+	report := func(result duResult) error {
+		if opts["yaml_output"].(bool) {
+			data, err := util.YamlCRLF(result)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(ctx.Stdout, "- ")
+			fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+		} else {
+			fmt.Fprint(ctx.Stdout, result.Bytes, "\t", result.Entries, "\t", result.Path, "\r\n")
+		}
+		return nil
+	}
+
+	walker := duWalker{ctx: ctx, store: c.FileStore, tags: tags, maxDepth: max_depth, report: report}
+	for _, path := range paths {
+		abs_path, err := filesystem.AbsPath(cwd, path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v\r\n", path, err)
+			walker.failed = true
+			continue
+		}
+		entry, err := c.FileStore.Lookup(ctx.Ctx, tags, abs_path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot access %q: %v\r\n", path, err)
+			walker.failed = true
+			continue
+		}
+
+		result := duResult{Path: abs_path}
+		if entry.EntryType == types.Directory {
+			if err := walker.walk(abs_path, *entry, 0, &result); err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: du failed: %v", err)
+				return 1
+			}
+			continue
+		}
+		result.Entries = 1
+		if entry.FileReference != nil {
+			if result.Bytes, err = c.FileStore.Blobs.Size(ctx.Ctx, *entry.FileReference); err != nil {
+				fmt.Fprintf(ctx.Stderr, "error checking file size (%q): %v\r\n", path, err)
+				walker.failed = true
+				continue
+			}
+		}
+		if err := report(result); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error formatting YAML: %v", err)
+			return 1
+		}
+	}
+
+	if walker.failed {
+		return 1
+	}
+	return 0
+}
+
+// duWalker totals directory trees the caller can descend into
+type duWalker struct {
+	ctx      sh.CommandContext
+	store    filesystem.Store
+	tags     []string
+	maxDepth int
+	report   func(result duResult) error
+	// failed is set when part of a tree couldn't be measured
+	failed bool
+}
+
+// walk adds the usage of dir's subtree to result, and reports it if it's shallow enough
+func (w *duWalker) walk(abs_path string, dir types.FsEntry, depth int, result *duResult) error {
+	if err := w.ctx.Ctx.Err(); err != nil {
+		return err
+	}
+
+	// Like Walk, only directories the caller can execute are descended into
+	if !dir.Permissions.IsAllowed(types.ExecuteMode, w.tags) {
+		fmt.Fprintf(w.ctx.Stderr, "du: cannot read directory %q: %v\r\n", abs_path, types.ErrCantAccessFs)
+		w.failed = true
+	} else {
+		ids := make([]primitive.ObjectID, len(dir.Entries))
+		for i, ref := range dir.Entries {
+			ids[i] = ref.RefID
+		}
+		children, err := w.store.Nodes.GetMany(w.ctx.Ctx, ids)
+		if err != nil {
+			return err
+		}
+		by_id := make(map[primitive.ObjectID]types.FsEntry, len(children))
+		file_refs := []primitive.ObjectID{}
+		for _, child := range children {
+			by_id[child.ID] = child
+			if child.EntryType == types.File && child.FileReference != nil {
+				file_refs = append(file_refs, *child.FileReference)
+			}
+		}
+		sizes, err := w.store.Blobs.Sizes(w.ctx.Ctx, file_refs)
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range dir.Entries {
+			child, ok := by_id[ref.RefID]
+			if !ok {
+				continue
+			}
+			if child.EntryType == types.Directory {
+				child_path := strings.TrimSuffix(abs_path, "/") + "/" + ref.Name
+				child_result := duResult{Path: child_path}
+				if err := w.walk(child_path, child, depth+1, &child_result); err != nil {
+					return err
+				}
+				result.Bytes += child_result.Bytes
+				result.Entries += child_result.Entries
+				continue
+			}
+			result.Entries++
+			if child.EntryType == types.File && child.FileReference != nil {
+				result.Bytes += sizes[*child.FileReference]
+			}
+		}
+	}
+
+	result.Entries++
+	if w.maxDepth < 0 || depth <= w.maxDepth {
+		return w.report(*result)
+	}
+	return nil
+}
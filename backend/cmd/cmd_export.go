@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdExport struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdExport) Identifier() string {
+	return "export"
+}
+
+func (c *CmdExport) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "zip",
+			Aliases:    []string{"z", "zip"},
+			Default:    false,
+		},
+		{
+			Identifier: "base64",
+			Aliases:    []string{"b", "base64"},
+			Default:    false,
+		},
+		{
+			Identifier: "output",
+			Aliases:    []string{"o", "output"},
+			Default:    "",
+		},
+	}, ctx.Args[1:])
+	if err != nil || len(paths) != 1 {
+		if err != nil {
+			fmt.Fprint(ctx.Stderr, err, "\r\n")
+		}
+		fmt.Fprint(ctx.Stderr, "usage: export [-zb] [-o FILE] <PATH>")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	abs_path, err := filesystem.AbsPath(cwd, paths[0])
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", paths[0], err)
+		return 1
+	}
+	format := filesystem.TarArchive
+	if opts["zip"].(bool) {
+		format = filesystem.ZipArchive
+	}
+
+	encode := opts["base64"].(bool)
+	if output := opts["output"].(string); output != "" {
+		output_path, err := filesystem.AbsPath(cwd, output)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", output, err)
+			return 1
+		}
+		fsctx := filesystem.FSContext{Store: c.FileStore, UserTags: tags, HonourLocks: true}
+		file, err := fsctx.Open(ctx.Ctx, output_path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot write to %q: %v", output, err)
+			return 1
+		}
+		report, code := c.export(ctx, file, format, tags, abs_path, encode)
+		if report == nil {
+			// An unfinished archive is discarded rather than written
+			if aborter, ok := file.(filesystem.Aborter); ok {
+				aborter.Abort()
+			} else {
+				file.Close()
+			}
+			return code
+		}
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot write to %q: %v", output, err)
+			return 1
+		}
+		return code
+	}
+
+	_, code := c.export(ctx, ctx.Stdout, format, tags, abs_path, encode)
+	return code
+}
+
+// export writes the archive to out, reporting skipped entries on stderr. The report is nil if the export failed.
+func (c *CmdExport) export(ctx sh.CommandContext, out io.Writer, format filesystem.ArchiveFormat, tags []string, abs_path string, encode bool) (*filesystem.ArchiveReport, int) {
+	var encoder io.WriteCloser
+	if encode {
+		encoder = base64.NewEncoder(base64.StdEncoding, out)
+		out = encoder
+	}
+	report, err := c.FileStore.Export(ctx.Ctx, out, format, tags, abs_path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: export failed: %v", err)
+		return nil, 1
+	}
+	if encoder != nil {
+		if err := encoder.Close(); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: export failed: %v", err)
+			return nil, 1
+		}
+	}
+
+	for _, skipped := range report.Skipped {
+		fmt.Fprintf(ctx.Stderr, "skipped %q: %s\r\n", skipped.Path, skipped.Error)
+	}
+	if len(report.Skipped) > 0 {
+		return report, 1
+	}
+	return report, 0
+}
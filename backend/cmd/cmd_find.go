@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CmdFind struct {
+	FileStore filesystem.Store
+	// Commands are what -exec can run
+	Commands []sh.Command
+}
+
+func (*CmdFind) Identifier() string {
+	return "find"
+}
+
+const findUsage = "usage: find [-y] [PATHS...] [-name GLOB] [-type f|d|l] [-newer FILE] [-mtime [+|-]DAYS] [-size [+|-]N[k|M|G]] [-readable-by TAG] [-writable-by TAG] [-maxdepth N] [-exec COMMAND [ARGS...] ; | +]"
+
+// findMatch is an entry that passed every predicate except -size
+type findMatch struct {
+	path  string
+	entry types.FsEntry
+	size  int64
+}
+
+// findSize is a -size predicate
+type findSize struct {
+	cmp  int // -1, 0 or 1 for -N, N and +N
+	n    int64
+	unit int64
+}
+
+func (f findSize) matches(size int64) bool {
+	// Like find, sizes are rounded up to the unit before comparing
+	units := (size + f.unit - 1) / f.unit
+	switch f.cmp {
+	case -1:
+		return units < f.n
+	case 1:
+		return units > f.n
+	default:
+		return units == f.n
+	}
+}
+
+func (c *CmdFind) Run(ctx sh.CommandContext) int {
+	tags := util.GetTags(ctx.Ctx)
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+
+	args := ctx.Args[1:]
+	if slices.Contains(args, "-h") || slices.Contains(args, "--help") {
+		fmt.Fprint(ctx.Stderr, findUsage)
+		return 1
+	}
+
+	yaml_output := false
+	paths := []string{}
+	for len(args) > 0 && (args[0] == "-y" || args[0] == "--yaml" || !strings.HasPrefix(args[0], "-")) {
+		if args[0] == "-y" || args[0] == "--yaml" {
+			yaml_output = true
+		} else {
+			paths = append(paths, args[0])
+		}
+		args = args[1:]
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	predicates := []func(name string, entry types.FsEntry) bool{}
+	var size_filter *findSize
+	max_depth := -1
+	var exec_args []string
+	exec_batch := false
+
+	for len(args) > 0 {
+		flag := args[0]
+		args = args[1:]
+		if flag == "-y" || flag == "--yaml" {
+			yaml_output = true
+			continue
+		}
+		if flag == "-exec" {
+			end := slices.IndexFunc(args, func(arg string) bool { return arg == ";" || arg == "+" })
+			if end <= 0 {
+				fmt.Fprint(ctx.Stderr, "error: -exec needs a command, terminated by ';' or '+'")
+				return 1
+			}
+			exec_args, exec_batch = args[:end], args[end] == "+"
+			if exec_batch && exec_args[len(exec_args)-1] != "{}" {
+				fmt.Fprint(ctx.Stderr, "error: -exec ... + needs {} right before the '+'")
+				return 1
+			}
+			args = args[end+1:]
+			continue
+		}
+
+		if len(args) == 0 {
+			fmt.Fprintf(ctx.Stderr, "error: %s needs a value\r\n%s", flag, findUsage)
+			return 1
+		}
+		value := args[0]
+		args = args[1:]
+
+		switch flag {
+		case "-name":
+			if _, err := path.Match(value, ""); err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: invalid -name pattern %q: %v", value, err)
+				return 1
+			}
+			predicates = append(predicates, func(name string, entry types.FsEntry) bool {
+				matched, _ := path.Match(value, name)
+				return matched
+			})
+		case "-type":
+			entry_type, ok := map[string]types.FsEntryType{"f": types.File, "d": types.Directory, "l": types.Symlink}[value]
+			if !ok {
+				fmt.Fprintf(ctx.Stderr, "error: invalid -type %q (expected f, d or l)", value)
+				return 1
+			}
+			predicates = append(predicates, func(_ string, entry types.FsEntry) bool {
+				return entry.EntryType == entry_type
+			})
+		case "-newer":
+			ref_path, err := filesystem.AbsPath(cwd, value)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", value, err)
+				return 1
+			}
+			reference, err := c.FileStore.Lookup(ctx.Ctx, tags, ref_path)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: couldn't find -newer reference (%q): %v", value, err)
+				return 1
+			}
+			predicates = append(predicates, func(_ string, entry types.FsEntry) bool {
+				return entry.Timestamps.ModifiedAt.After(reference.Timestamps.ModifiedAt)
+			})
+		case "-mtime":
+			cmp, n, err := parseFindNumber(value)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: invalid -mtime %q: %v", value, err)
+				return 1
+			}
+			now := time.Now()
+			predicates = append(predicates, func(_ string, entry types.FsEntry) bool {
+				// Like find, ages are counted in whole days
+				days := int64(now.Sub(entry.Timestamps.ModifiedAt) / (24 * time.Hour))
+				return cmp < 0 && days < n || cmp > 0 && days > n || cmp == 0 && days == n
+			})
+		case "-size":
+			number, unit := value, int64(1)
+			switch {
+			case strings.HasSuffix(value, "c"):
+				number = strings.TrimSuffix(value, "c")
+			case strings.HasSuffix(value, "k"):
+				number, unit = strings.TrimSuffix(value, "k"), 1<<10
+			case strings.HasSuffix(value, "M"):
+				number, unit = strings.TrimSuffix(value, "M"), 1<<20
+			case strings.HasSuffix(value, "G"):
+				number, unit = strings.TrimSuffix(value, "G"), 1<<30
+			}
+			cmp, n, err := parseFindNumber(number)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: invalid -size %q: %v", value, err)
+				return 1
+			}
+			size_filter = &findSize{cmp: cmp, n: n, unit: unit}
+		case "-readable-by", "-writable-by":
+			mode := types.ReadMode
+			if flag == "-writable-by" {
+				mode = types.WriteMode
+			}
+			predicates = append(predicates, func(_ string, entry types.FsEntry) bool {
+				return entry.Permissions.IsAllowed(mode, []string{value})
+			})
+		case "-maxdepth":
+			if max_depth, _ = strconv.Atoi(value); max_depth < 0 || strconv.Itoa(max_depth) != value {
+				fmt.Fprintf(ctx.Stderr, "error: invalid -maxdepth %q", value)
+				return 1
+			}
+		default:
+			fmt.Fprintf(ctx.Stderr, "error: unknown predicate %q\r\n%s", flag, findUsage)
+			return 1
+		}
+	}
+
+	matches := []findMatch{}
+	for _, start := range paths {
+		abs_path, err := filesystem.AbsPath(cwd, start)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", start, err)
+			return 1
+		}
+		depth_offset := strings.Count(strings.TrimSuffix(abs_path, "/"), "/")
+
+		if err := c.FileStore.Walk(ctx.Ctx, tags, abs_path, func(entry_path string, entry types.FsEntry) error {
+			name := path.Base(entry_path)
+			for _, predicate := range predicates {
+				if !predicate(name, entry) {
+					return findSkip(entry_path, depth_offset, max_depth)
+				}
+			}
+			matches = append(matches, findMatch{path: entry_path, entry: entry})
+			return findSkip(entry_path, depth_offset, max_depth)
+		}); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: failed to search %q: %v", start, err)
+			return 1
+		}
+	}
+
+	if size_filter != nil || yaml_output {
+		file_refs := []primitive.ObjectID{}
+		for _, match := range matches {
+			if match.entry.EntryType == types.File && match.entry.FileReference != nil {
+				file_refs = append(file_refs, *match.entry.FileReference)
+			}
+		}
+		sizes, err := c.FileStore.Blobs.Sizes(ctx.Ctx, file_refs)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error checking file sizes: %v", err)
+			return 1
+		}
+
+		filtered := matches[:0]
+		for _, match := range matches {
+			if match.entry.EntryType == types.File && match.entry.FileReference != nil {
+				match.size = sizes[*match.entry.FileReference]
+			}
+			if size_filter == nil || size_filter.matches(match.size) {
+				filtered = append(filtered, match)
+			}
+		}
+		matches = filtered
+	}
+
+	if exec_args != nil {
+		return c.exec(ctx, exec_args, exec_batch, matches)
+	}
+
+	for _, match := range matches {
+		if yaml_output {
+			entry_info := map[string]any{
+				"path":          match.path,
+				"type":          match.entry.EntryType.String(),
+				"file_size":     match.size,
+				"modified_time": match.entry.Timestamps.ModifiedAt.Format("Jan _2 15:04 2006"),
+			}
+			if match.entry.EntryType == types.Symlink {
+				entry_info["target"] = match.entry.Target
+			}
+			data, err := util.YamlCRLF(entry_info)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error formatting file YAML: %v", err)
+				return 1
+			}
+
+			fmt.Fprint(ctx.Stdout, "- ")
+			fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+		} else {
+			fmt.Fprint(ctx.Stdout, match.path, "\r\n")
+		}
+	}
+
+	return 0
+}
+
+// exec runs the -exec command for every match (or once for all of them, with batch), replacing {} with the path
+func (c *CmdFind) exec(ctx sh.CommandContext, exec_args []string, batch bool, matches []findMatch) int {
+	var command sh.Command
+	for _, cmd := range c.Commands {
+		if cmd.Identifier() == exec_args[0] {
+			command = cmd
+			break
+		}
+	}
+	if command == nil {
+		fmt.Fprintf(ctx.Stderr, "error: -exec: unknown command %q", exec_args[0])
+		return 1
+	}
+
+	run := func(args []string) int {
+		return command.Run(sh.CommandContext{
+			Args:   args,
+			Stdin:  strings.NewReader(""),
+			Stdout: ctx.Stdout,
+			Stderr: ctx.Stderr,
+			Env:    ctx.Env,
+			Ctx:    ctx.Ctx,
+		})
+	}
+
+	if batch {
+		if len(matches) == 0 {
+			return 0
+		}
+		args := slices.Clone(exec_args[:len(exec_args)-1])
+		for _, match := range matches {
+			args = append(args, match.path)
+		}
+		return run(args)
+	}
+
+	result := 0
+	for _, match := range matches {
+		if err := ctx.Ctx.Err(); err != nil {
+			return 1
+		}
+		args := make([]string, len(exec_args))
+		for i, arg := range exec_args {
+			args[i] = strings.ReplaceAll(arg, "{}", match.path)
+		}
+		if run(args) != 0 {
+			result = 1
+		}
+	}
+	return result
+}
+
+// findSkip stops Walk from descending past -maxdepth
+func findSkip(entry_path string, depth_offset, max_depth int) error {
+	if max_depth >= 0 && strings.Count(strings.TrimSuffix(entry_path, "/"), "/")-depth_offset >= max_depth {
+		return filesystem.SkipDir
+	}
+	return nil
+}
+
+// parseFindNumber parses find's [+|-]N numbers, returning 1 for +N (more than), -1 for -N (less than) and 0 for exactly N
+func parseFindNumber(value string) (int, int64, error) {
+	cmp := 0
+	if strings.HasPrefix(value, "+") {
+		cmp, value = 1, value[1:]
+	} else if strings.HasPrefix(value, "-") {
+		cmp, value = -1, value[1:]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err == nil && n < 0 {
+		err = fmt.Errorf("negative number")
+	}
+	return cmp, n, err
+}
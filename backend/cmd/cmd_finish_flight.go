@@ -48,9 +48,11 @@ func (c *CmdFinishFlight) Run(ctx sh.CommandContext) int {
 		return 1
 	}
 
+	// Edge nodes can lock their flight files while they're still writing them
 	fsCtx := &filesystem.FSContext{
-		Store:    c.FileStore,
-		UserTags: []string{"sysadmin"},
+		Store:       c.FileStore,
+		UserTags:    []string{"sysadmin"},
+		HonourLocks: true,
 	}
 
 	for _, entry := range home.Entries {
@@ -85,30 +87,43 @@ func (c *CmdFinishFlight) Run(ctx sh.CommandContext) int {
 				return 1
 			}
 		} else {
-			defer readWriter.Close()
 			data, err := io.ReadAll(readWriter)
 			if err != nil {
-				fmt.Fprint(ctx.Stderr, "failed to read login file: ", err)
+				abortWrite(readWriter)
+				fmt.Fprint(ctx.Stderr, "failed to read flight file: ", err)
 				return 1
 			}
 
 			var val map[string]any
 			if err := yaml.UnmarshalContext(ctx.Ctx, data, &val); err != nil {
+				abortWrite(readWriter)
 				fmt.Fprint(ctx.Stderr, "flight file contains invalid YAML: ", err)
 				return 1
 			}
 
 			if _, ok := val["end_timestamp"]; ok {
+				abortWrite(readWriter)
 				fmt.Fprint(ctx.Stderr, "flight is already ended")
 				return 1
 			}
 
 			timestamp_str := fmt.Sprintf("\r\nend_timestamp: %d\r\n", time.Now().UnixNano())
 			if _, err := readWriter.Write([]byte(timestamp_str)); err != nil {
+				abortWrite(readWriter)
 				fmt.Fprint(ctx.Stderr, "failed to write to flight file: ", err)
 				return 1
 			}
 
+			// The write commits on close, and fails if someone else changed the flight file meanwhile
+			if err := readWriter.Close(); err != nil {
+				if errors.Is(err, filesystem.ErrRevisionConflict) {
+					fmt.Fprint(ctx.Stderr, "flight file was modified concurrently, try again")
+				} else {
+					fmt.Fprint(ctx.Stderr, "failed to write to flight file: ", err)
+				}
+				return 1
+			}
+
 			return 0
 		}
 	}
@@ -116,3 +131,12 @@ func (c *CmdFinishFlight) Run(ctx sh.CommandContext) int {
 	fmt.Fprint(ctx.Stderr, "flight ID not found")
 	return 1
 }
+
+// abortWrite discards a file opened for writing, or just closes it if it can't be discarded
+func abortWrite(file io.Closer) {
+	if aborter, ok := file.(filesystem.Aborter); ok {
+		aborter.Abort()
+	} else {
+		file.Close()
+	}
+}
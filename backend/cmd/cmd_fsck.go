@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdFsck struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdFsck) Identifier() string {
+	return "fsck"
+}
+
+func (c *CmdFsck) Run(ctx sh.CommandContext) int {
+	tags := util.GetTags(ctx.Ctx)
+	if !slices.Contains(tags, "sysadmin") {
+		fmt.Fprint(ctx.Stderr, "error: not enough permissions to run this command\r\n")
+		return 1
+	}
+
+	opts, args, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "repair",
+			Aliases:    []string{"r", "repair"},
+			Default:    false,
+		},
+		{
+			Identifier: "grace",
+			Aliases:    []string{"g", "grace"},
+			Default:    filesystem.DefaultFsckGracePeriod.String(),
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err)
+		return 1
+	}
+	if len(args) != 0 {
+		fmt.Fprint(ctx.Stderr, "usage: fsck [--repair] [-g GRACE_PERIOD]")
+		return 1
+	}
+	repair := opts["repair"].(bool)
+	grace, err := time.ParseDuration(opts["grace"].(string))
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid grace period: %v", err)
+		return 1
+	}
+
+	report, err := c.FileStore.Fsck(ctx.Ctx, repair, grace)
+	if report != nil {
+		if data, err := util.YamlCRLF(report); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error formatting report YAML: %v", err)
+			return 1
+		} else {
+			fmt.Fprint(ctx.Stdout, string(data))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: fsck failed: %v", err)
+		return 1
+	}
+
+	// Fail when anything is left unrepaired, so fsck can gate scripts
+	for _, issue := range report.Issues {
+		if !repair || issue.Kind != filesystem.FsckUnreachable {
+			return 1
+		}
+	}
+
+	return 0
+}
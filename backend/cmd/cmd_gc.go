@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdGC struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdGC) Identifier() string {
+	return "gc"
+}
+
+func (c *CmdGC) Run(ctx sh.CommandContext) int {
+	tags := util.GetTags(ctx.Ctx)
+	if !slices.Contains(tags, "sysadmin") {
+		fmt.Fprint(ctx.Stderr, "error: not enough permissions to run this command\r\n")
+		return 1
+	}
+
+	opts, args, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "dry_run",
+			Aliases:    []string{"n", "dry-run"},
+			Default:    false,
+		},
+		{
+			Identifier: "grace",
+			Aliases:    []string{"g", "grace"},
+			Default:    filesystem.DefaultGCGracePeriod.String(),
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err)
+		return 1
+	}
+	if len(args) != 0 {
+		fmt.Fprint(ctx.Stderr, "usage: gc [-n] [-g GRACE_PERIOD]")
+		return 1
+	}
+
+	grace, err := time.ParseDuration(opts["grace"].(string))
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid grace period: %v", err)
+		return 1
+	}
+
+	report, err := c.FileStore.CollectGarbage(ctx.Ctx, opts["dry_run"].(bool), grace)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: garbage collection failed: %v", err)
+		return 1
+	}
+
+	data, err := util.YamlCRLF(report)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error formatting report YAML: %v", err)
+		return 1
+	}
+	fmt.Fprint(ctx.Stdout, string(data))
+
+	return 0
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdGrep struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdGrep) Identifier() string {
+	return "grep"
+}
+
+// Run exits with 0 if a line matched, 1 if none did and 2 on errors, like grep
+func (c *CmdGrep) Run(ctx sh.CommandContext) int {
+	const usage = "usage: grep [-ivnrc] [-e PATTERN | PATTERN] [PATHS...]"
+	tags := util.GetTags(ctx.Ctx)
+
+	opts, args, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "ignore_case",
+			Aliases:    []string{"i", "ignore-case"},
+			Default:    false,
+		},
+		{
+			Identifier: "invert",
+			Aliases:    []string{"v", "invert-match"},
+			Default:    false,
+		},
+		{
+			Identifier: "line_numbers",
+			Aliases:    []string{"n", "line-number"},
+			Default:    false,
+		},
+		{
+			Identifier: "recursive",
+			Aliases:    []string{"r", "recursive"},
+			Default:    false,
+		},
+		{
+			Identifier: "count",
+			Aliases:    []string{"c", "count"},
+			Default:    false,
+		},
+		{
+			Identifier: "pattern",
+			Aliases:    []string{"e", "regexp"},
+			Default:    "",
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\n", usage)
+		return 2
+	}
+
+	pattern := opts["pattern"].(string)
+	if pattern == "" {
+		if len(args) == 0 {
+			fmt.Fprint(ctx.Stderr, usage)
+			return 2
+		}
+		pattern, args = args[0], args[1:]
+	}
+	if opts["ignore_case"].(bool) {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid pattern: %v", err)
+		return 2
+	}
+	invert := opts["invert"].(bool)
+	line_numbers := opts["line_numbers"].(bool)
+	count := opts["count"].(bool)
+
+	paths := args
+	if opts["recursive"].(bool) {
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		cwd, ok := ctx.Env["PWD"]
+		if !ok {
+			fmt.Fprint(ctx.Stderr, "error: no PWD available")
+			return 2
+		}
+
+		// Expand directories into the files below them
+		files := []string{}
+		for _, path := range paths {
+			abs_path, err := filesystem.AbsPath(cwd, path)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", path, err)
+				return 2
+			}
+			if err := c.FileStore.Walk(ctx.Ctx, tags, abs_path, func(entry_path string, entry types.FsEntry) error {
+				if entry.EntryType == types.File && entry.Permissions.IsAllowed(types.ReadMode, tags) {
+					files = append(files, entry_path)
+				}
+				return nil
+			}); err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: failed to search %q: %v", path, err)
+				return 2
+			}
+		}
+		paths = files
+		if len(paths) == 0 {
+			return 1
+		}
+	}
+	with_names := len(paths) > 1 || opts["recursive"].(bool)
+
+	matched := false
+	result := forEachInput(ctx, c.FileStore, paths, func(name string, r io.Reader) error {
+		scanner := newLineScanner(r)
+		matches := 0
+		for line_number := 1; scanner.Scan(); line_number++ {
+			if err := ctx.Ctx.Err(); err != nil {
+				return err
+			}
+			line := scanner.Text()
+			if re.MatchString(line) == invert {
+				continue
+			}
+			matches++
+			if count {
+				continue
+			}
+
+			if with_names {
+				fmt.Fprint(ctx.Stdout, name, ":")
+			}
+			if line_numbers {
+				fmt.Fprint(ctx.Stdout, line_number, ":")
+			}
+			fmt.Fprint(ctx.Stdout, line, "\r\n")
+		}
+
+		if count {
+			if with_names {
+				fmt.Fprint(ctx.Stdout, name, ":")
+			}
+			fmt.Fprint(ctx.Stdout, matches, "\r\n")
+		}
+		matched = matched || matches > 0
+		return scanner.Err()
+	})
+
+	if result != 0 {
+		return 2
+	} else if !matched {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdHead struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdHead) Identifier() string {
+	return "head"
+}
+
+func (c *CmdHead) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "lines",
+			Aliases:    []string{"n", "lines"},
+			Default:    "10",
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\nusage: head [-n LINES] [FILES...]")
+		return 1
+	}
+	lines, err := strconv.Atoi(opts["lines"].(string))
+	if err != nil || lines < 0 {
+		fmt.Fprintf(ctx.Stderr, "error: invalid line count %q", opts["lines"])
+		return 1
+	}
+
+	return forEachInput(ctx, c.FileStore, paths, func(name string, r io.Reader) error {
+		if len(paths) > 1 {
+			fmt.Fprintf(ctx.Stdout, "==> %s <==\r\n", name)
+		}
+
+		// Stops reading as soon as enough lines were printed
+		scanner := newLineScanner(r)
+		for i := 0; i < lines && scanner.Scan(); i++ {
+			fmt.Fprint(ctx.Stdout, scanner.Text(), "\r\n")
+		}
+		return scanner.Err()
+	})
+}
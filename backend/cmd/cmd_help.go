@@ -30,11 +30,31 @@ help displays this info menu
 whoami returns structured output concerning the current user session.
 This includes AuthStatus information (such as username and user tags), and the contents of the user's user.profile file
 
+# token [-y] [-c NAME | -r NAME]
+token lists your API tokens ("CREATED<tab>NAME"). API tokens let clients that can't log in with a session, such as WebDAV clients, act as you: send one as "Authorization: Bearer TOKEN", or as the password of HTTP Basic auth with your username.
+options:
+"c": create a token with the given name and print it. It's only shown this once.
+"r": revoke the token with the given name
+"y": list as YAML
+
 # ls [-yl] [DIRS...]
 ls prints out the files available in the specified directory(s).
 options:
 "y": yaml structured output
-"l": long output (as opposed to simple field names)
+"l": long output (as opposed to simple field names), including each file's size and the start of its SHA-256 checksum
+
+# find [-y] [PATHS...] [PREDICATES...] [-exec COMMAND [ARGS...] ; | +]
+find walks each path (the working directory by default) and prints every entry below it that matches all the predicates. It only descends into directories you can execute, and doesn't follow symlinks.
+predicates:
+"-name GLOB": the entry's name matches GLOB (e.g. "*.csv")
+"-type f|d|l": files, directories or symlinks
+"-newer FILE": modified after FILE was
+"-mtime [+|-]DAYS": modified more than (+), less than (-) or exactly DAYS whole days ago
+"-size [+|-]N[k|M|G]": file size (in bytes, or KiB/MiB/GiB rounded up) is more than, less than or exactly N
+"-readable-by TAG" / "-writable-by TAG": the entry grants TAG read / write access
+"-maxdepth N": don't descend more than N levels below the starting path
+-exec runs COMMAND once per match with {} replaced by its path (end it with \;), or once with every path when ended with {} +.
+"-y": yaml structured output
 
 # cat [FILES...]
 cat outputs the contents of the given files in order.
@@ -46,11 +66,25 @@ cd changes the working directory (stored in env as $PWD). This affects commands
 # tee [FILES...]
 tee opens the given files and writes stdin to all the files and stdout.
 If tee isn't given any files to overwrite, it simply doesn't do file ops (same as cat)
+If another client writes one of the files while tee is running, tee fails instead of overwriting their changes.
 
 # mkdir [-p] <PATHS...>
 mkdir is used to create directories. Created directories inherit permissions from their parents.
 The -p flag tells mkdir to make all parent directories if they don't exist, and not to return an error if the target directory already exists.
 
+# stat [-yL] <PATHS...>
+stat prints everything stored about the given entries: ID, type, contents reference, size and SHA-256 checksum, revision, timestamps, permission tags, and whether you can read, write, execute or chmod them.
+options:
+"y": output as YAML
+"L": follow a symlink in the final component instead of describing the link
+
+# touch [-cy] <PATHS...>
+touch sets the modified time of the given entries to now (following symlinks). Paths that don't exist are created as empty files, which inherit permissions from their folder.
+Touching an existing entry requires write access to it.
+options:
+"c": don't create missing files
+"y": output the touched entries as YAML
+
 # mv <SOURCE_PATHS...> <DEST_PATH>
 mv moves files or folders to another destination.
 Both mv and cp share this behavior concerning the destination path:
@@ -61,9 +95,85 @@ If it doesn't exist, the target is to make sure that that exact path exists.
 # cp [-r] <SOURCE_PATHS...> <DEST_PATH>
 cp copies the contents of a file or folder. It doesn't allow you to copy directories without the -r flag.
 
-# rm [-rf] <PATHS...>
+# ln -s <TARGET> <LINK_NAME>
+ln creates a symbolic link at LINK_NAME that points to TARGET. Only symbolic links are supported, so -s is required.
+Relative targets are resolved against the directory containing the link. If LINK_NAME is an existing directory, the link is created inside it.
+Commands follow links when reading, writing or changing directory, while rm and mv act on the link itself.
+
+# rm [-rfP] <PATHS...>
 rm is used to remove entries from the filesystem. The -r flag is necessary for deleting directories
 The -f flag is for cases where you don't have write permission on the parent (in that case, -f checks whether you have update-perm permissions on the parent instead)
+Removed entries are moved to your trash (see trash) unless -P is given, which deletes them permanently. Removing something that's already in your trash deletes it permanently.
+With -r, every directory inside has to be writable too (or, with -f, permission-updatable). -P removes everything else and reports the directories it had to keep, while trashing refuses to move a directory it couldn't fully remove.
+Large removes can be interrupted.
+
+# versions [-y] [-c VERSION | -r VERSION] <FILE>
+versions lists the previous contents of a file, newest first. Version 1 is the contents before the latest write.
+The -c flag prints the given version instead, and -r restores it as the current contents (the replaced contents become a new version, so a restore can be undone).
+Only the last 20 versions of a file are kept.
+
+# quota [-y]
+quota shows storage use against the quotas defined in /etc/quotas: bytes and entry counts per owner tag (nodes whose updatetag tags contain it) and per directory subtree.
+Users see quotas on their own tags and on directories they can reach. Writes, copies and new directories that would go over a quota fail with "quota exceeded".
+
+# du [-sy] [-d DEPTH] [PATHS...]
+du totals the bytes and entries under each path (the current directory by default), printing "BYTES<tab>ENTRIES<tab>PATH" for every directory as soon as its subtree is done.
+Only directories you can execute are counted; the others are reported and make du fail. Bytes count each file's current contents.
+options:
+"s": only print the total for each path
+"d": only print directories at most DEPTH levels below each path
+"y": output as YAML
+
+# verify [-y] [FILES...] // NOTE: only users with "sysadmin" tag can run this without FILES
+verify re-reads the contents of the given files and checks them against the SHA-256 checksums recorded when they were written, reporting corrupt or missing contents (and failing if there are any).
+Contents stored before checksums were recorded get their checksum recorded instead. Without files, every stored blob is checked.
+The -y flag outputs the report as YAML.
+
+# trash [-y] [-u USER] [-r IDS... | -p IDS... | --empty]
+trash lists the entries you removed with rm, newest first, with the path they were removed from. Entries are permanently deleted once they've been in the trash for the configured retention (30 days by default).
+The -r flag restores the given entries to their original paths (which must not be taken), -p permanently deletes them, and --empty permanently deletes everything in the trash.
+Sysadmins can use -u to act on another user's trash.
+
+# watch [-ry] [PATHS...]
+watch prints changes to the given paths (the current directory by default) and the entries directly inside them as they happen, until interrupted: "TIME<tab>KIND<tab>PATH", where KIND is create, modify, delete, move ("OLD -> NEW") or chmod.
+You only see changes to entries whose folder you can execute.
+options:
+"r": also watch everything below the given paths
+"y": output events as YAML
+
+# lock [-swy] [-t TTL] <PATHS...> | lock -l [-y]
+lock takes advisory locks on the given entries for this client, printing "MODE<tab>USER<tab>EXPIRES<tab>PATH" for each. Locks are exclusive by default, which needs write access; shared locks need read access.
+Locks last TTL (5m by default, at most 24h) unless released with unlock, and are released when your socket disconnects. Locking an entry again renews it, or changes its mode.
+While you hold a lock, reads and writes of that file by other clients fail (writes for shared locks). Locks don't stop commands that don't check them.
+options:
+"s": take a shared lock, which others can share
+"w": wait for conflicting locks to be released instead of failing
+"t": how long the lock lasts, e.g. 30s or 10m
+"l": list your locks (sysadmins see everyone's) instead
+"y": output as YAML
+
+# unlock [-f] <PATHS...> // NOTE: only users with "sysadmin" tag can use -f
+unlock releases this client's locks on the given entries.
+options:
+"f": release everyone's locks on them
+
+# export [-zb] [-o FILE] <PATH>
+export writes the given file or directory (with everything below it) to stdout as a tar archive, named after PATH. A ".vfs-manifest.yaml" entry at the start of the archive records every entry's permissions and timestamps.
+Files you can't read and the contents of directories you can't execute are left out, with a message on stderr (and export fails).
+options:
+"z": write a zip archive instead
+"b": base64-encode the archive
+"o": write the archive to FILE instead of stdout
+
+# import [-bpfy] [-i FILE] <DEST>
+import unpacks a tar or zip archive from stdin into the directory DEST, which you need write access to, and prints how many entries it created. Entries are created like any others, so they get DEST's permissions and count against your quota. Modification times are restored from the archive.
+Entries that can't be imported (such as existing files, or paths leading outside DEST) are reported as skipped, and make import fail.
+options:
+"b": the archive is base64-encoded
+"p": restore the permissions in the archive's manifest, where you could set them yourself with chmod (others are skipped)
+"f": overwrite existing files instead of skipping them
+"i": read the archive from FILE instead of stdin
+"y": output the report as YAML
 
 # echo [-en] [ARGS...]
 echo prints the given arguments to stdout with spaces between them according to the options it can be provided.
@@ -71,6 +181,52 @@ options:
 "e": escape - unescape '\' sequences, such as \n.
 "n": no-newline - don't print a newline after all the args.
 
+# grep [-ivnrc] [-e PATTERN | PATTERN] [PATHS...]
+grep prints the lines of the given files (or stdin) that match the regular expression PATTERN.
+options:
+"i": ignore case
+"v": print the lines that don't match instead
+"n": prefix lines with their line number
+"c": only print how many lines matched
+"r": search every readable file below the given directories (the working directory by default)
+grep exits with 0 if a line matched, 1 if none did and 2 on errors.
+
+# head [-n LINES] [FILES...]
+head prints the first 10 lines (or LINES lines) of the given files, or of stdin.
+
+# tail [-f] [-n LINES] [FILES...]
+tail prints the last 10 lines (or LINES lines) of the given files, or of stdin.
+With -f, tail keeps following a single file and prints whatever is appended to it until interrupted.
+
+# wc [-lwc] [FILES...]
+wc counts the lines, words and bytes of the given files (or stdin). The flags pick which counts to print.
+
+# sort [-rnuf] [FILES...]
+sort prints the lines of the given files (or stdin) in order.
+options:
+"r": reverse order
+"n": compare the numbers at the start of each line
+"u": only print the first of equal lines
+"f": ignore case
+
+# uniq [-cdui] [FILES...]
+uniq collapses repeated adjacent lines of the given files (or stdin) into one. Pipe through sort first to collapse all duplicates.
+options:
+"c": prefix lines with how often they occurred
+"d": only print repeated lines
+"u": only print lines that weren't repeated
+"i": ignore case
+
+# yq [-j | -o yaml|json] [-i] <EXPRESSION> [FILES...]
+yq reads YAML or JSON documents from the given files (or stdin) and prints what EXPRESSION selects from each one.
+Expressions: "." (the document), ".a.b", ".a[0]", ".a[-1]", ".a[]" (every element), '.["a key"]', pipes (|), literals ("text", 1, true, null),
+comparisons (== != < <= > >=), "and", "or", select(COND), has("key"), keys, length, not, del(PATH) and assignment (PATH = VALUE).
+Strings and numbers are printed raw; maps and lists are printed as YAML (or JSON).
+options:
+"o": output format, yaml (default) or json
+"j": short for -o json
+"i": edit the file in place (needs write access to its folder)
+
 # chmod [-R] <MODE> <PATHS...>
 chmod changes the tag list for a specific file access mode on your provided file(s).
 MODE is in the form of <tagname><{+|-}><[rwxp]>
@@ -78,6 +234,14 @@ Which indicates that you are either adding, revoking or overriding access for a
 You must have the update permission on the file to do this, and if you try to update permissions update tags, safety rules apply. (i.e., unless you're a sysadmin you can only add/remove update perms you own, and can't lock yourself out of your file)
 If you don't provide -R, this doesn't affect subdirectories
 
+# defaults [-y] [-s | -c] <DIR>
+defaults shows the permissions new entries get in a directory: new files (and symlinks) get its "files" defaults and new subdirectories its "directories" defaults, instead of a copy of the directory's own permissions. Permissions given explicitly when creating an entry still win.
+New subdirectories also inherit the directory's defaults, so they apply to everything created below it. Changing the defaults doesn't change existing entries.
+options:
+"s": set the defaults from YAML on stdin, with optional "files" and "directories" keys holding read_tags, write_tags, execute_tags and updatetag_tags lists. You need the update permission on the directory, and the same safety rules as chmod apply to the defaults' updatetag tags
+"c": remove the defaults
+"y": output as YAML
+
 # error [ARGS...]
 error prints all the arguments, with spaces between them to stderr, and fails immediately with exit code 1.
 
@@ -87,6 +251,21 @@ clients prints out server-tracked information about the different clients connec
 # sockets // NOTE: only users with "sysadmin" tag can run this command
 sockets logs the socket sessions that currently are using resources on the server.
 
+# gc [-n] [-g GRACE_PERIOD] // NOTE: only users with "sysadmin" tag can run this command
+gc deletes stored file contents that no file (or file version) references anymore, and reports how many bytes were reclaimed.
+It also lists entries that no directory references. Those are only reported, not removed.
+The -n flag does a dry run. -g sets how old contents must be before they're collected (default 1h), so uploads still in progress are left alone.
+
+# fsck [--repair] [-g GRACE_PERIOD] // NOTE: only users with "sysadmin" tag can run this command
+fsck walks the filesystem from the root and reports inconsistencies: entries pointing at missing nodes, nodes that can't be reached from the root, duplicate names in a directory, and files whose contents are missing.
+With --repair (-r), unreachable nodes are moved into /lost+found (named "#<id>"). Other issues are only reported.
+-g sets how old unreachable nodes must be before they're reported or recovered (default 1h), so writes still in progress are left alone.
+fsck fails if any issue is left unrepaired.
+
+# df [-y] // NOTE: only users with "sysadmin" tag can run this command
+df reports the storage use of the whole filesystem: entry counts by type, stored contents (blob count and bytes, plus GridFS chunks and on-disk size), and what a gc run would reclaim right now.
+The -y flag outputs YAML.
+
 # pilots // NOTE: only users with either "sysadmin" or "atc" tags can run this command
 pilots prints the names of all pilots on the current filesystem. Further information about a specific pilot can then be found in their home folder at /home/<username>
 
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdImport struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdImport) Identifier() string {
+	return "import"
+}
+
+func (c *CmdImport) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "base64",
+			Aliases:    []string{"b", "base64"},
+			Default:    false,
+		},
+		{
+			Identifier: "permissions",
+			Aliases:    []string{"p", "permissions"},
+			Default:    false,
+		},
+		{
+			Identifier: "force",
+			Aliases:    []string{"f", "force"},
+			Default:    false,
+		},
+		{
+			Identifier: "input",
+			Aliases:    []string{"i", "input"},
+			Default:    "",
+		},
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil || len(paths) != 1 {
+		if err != nil {
+			fmt.Fprint(ctx.Stderr, err, "\r\n")
+		}
+		fmt.Fprint(ctx.Stderr, "usage: import [-bpfy] [-i FILE] <DEST>")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	dest_path, err := filesystem.AbsPath(cwd, paths[0])
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", paths[0], err)
+		return 1
+	}
+
+	var in io.Reader = ctx.Stdin
+	if input := opts["input"].(string); input != "" {
+		input_path, err := filesystem.AbsPath(cwd, input)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", input, err)
+			return 1
+		}
+		fsctx := filesystem.FSContext{Store: c.FileStore, UserTags: tags, HonourLocks: true}
+		file, err := fsctx.Open(ctx.Ctx, input_path, os.O_RDONLY, 0)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot read %q: %v", input, err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+	if opts["base64"].(bool) {
+		in = base64.NewDecoder(base64.StdEncoding, in)
+	}
+
+	report, err := c.FileStore.Import(ctx.Ctx, in, tags, dest_path, filesystem.ImportOptions{
+		Overwrite:   opts["force"].(bool),
+		Permissions: opts["permissions"].(bool),
+	})
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: import failed: %v", err)
+		return 1
+	}
+
+	if opts["yaml_output"].(bool) {
+		data, err := util.YamlCRLF(report)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error formatting report YAML: %v", err)
+			return 1
+		}
+		fmt.Fprint(ctx.Stdout, string(data))
+	} else {
+		fmt.Fprintf(ctx.Stdout, "files\t%d (%d bytes)\r\n", report.Files, report.Bytes)
+		fmt.Fprintf(ctx.Stdout, "directories\t%d\r\n", report.Directories)
+		fmt.Fprintf(ctx.Stdout, "symlinks\t%d\r\n", report.Symlinks)
+		for _, skipped := range report.Skipped {
+			fmt.Fprintf(ctx.Stdout, "skipped\t%s: %s\r\n", skipped.Path, skipped.Error)
+		}
+	}
+
+	if len(report.Skipped) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdLn struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdLn) Identifier() string {
+	return "ln"
+}
+
+func (c *CmdLn) Run(ctx sh.CommandContext) int {
+	tags := util.GetTags(ctx.Ctx)
+
+	opts, args, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "symbolic",
+			Aliases:    []string{"s", "symbolic"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err)
+		return 1
+	}
+
+	if len(args) != 2 {
+		fmt.Fprint(ctx.Stderr, "usage: ln -s <TARGET> <LINK_NAME>")
+		return 1
+	}
+	if !opts["symbolic"].(bool) {
+		fmt.Fprint(ctx.Stderr, "error: hard links aren't supported, use ln -s")
+		return 1
+	}
+
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+
+	// The target is stored as given, so relative targets resolve against the link's directory
+	target, link_path := args[0], args[1]
+	link_abs, err := filesystem.AbsPath(cwd, link_path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", link_path, err)
+		return 1
+	}
+
+	// Linking into an existing directory creates the link inside it, named after the target
+	if node, err := c.FileStore.Lookup(ctx.Ctx, tags, link_abs); err == nil && node.EntryType == types.Directory {
+		link_abs, err = filesystem.AbsPath(link_abs, path.Base(target))
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", target, err)
+			return 1
+		}
+	}
+
+	if _, err := c.FileStore.Symlink(ctx.Ctx, link_abs, target, tags); err != nil {
+		fmt.Fprintf(ctx.Stderr, "error creating symlink (%q, abs path %q): %v", link_path, link_abs, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdLock struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdLock) Identifier() string {
+	return "lock"
+}
+
+func (c *CmdLock) Run(ctx sh.CommandContext) int {
+	const usage = "usage: lock [-swy] [-t TTL] <PATHS...> | lock -l [-y]"
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "shared",
+			Aliases:    []string{"s", "shared"},
+			Default:    false,
+		},
+		{
+			Identifier: "wait",
+			Aliases:    []string{"w", "wait"},
+			Default:    false,
+		},
+		{
+			Identifier: "ttl",
+			Aliases:    []string{"t", "ttl"},
+			Default:    "",
+		},
+		{
+			Identifier: "list",
+			Aliases:    []string{"l", "list"},
+			Default:    false,
+		},
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\n", usage)
+		return 1
+	}
+	if c.FileStore.Locks == nil {
+		fmt.Fprint(ctx.Stderr, filesystem.ErrLockingDisabled)
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+
+	report := func(lock types.FileLock) error {
+		if opts["yaml_output"].(bool) {
+			data, err := util.YamlCRLF(lock)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(ctx.Stdout, "- ")
+			fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+		} else {
+			fmt.Fprintf(ctx.Stdout, "%s\t%s\t%s\t%s\r\n", lock.Mode, lock.Username, lock.ExpiresAt.Format(time.RFC3339), lock.Path)
+		}
+		return nil
+	}
+
+	if opts["list"].(bool) {
+		if len(paths) != 0 {
+			fmt.Fprint(ctx.Stderr, usage)
+			return 1
+		}
+		// Sysadmins see every lock, everyone else sees their own
+		username := util.GetAuthStatus(ctx.Ctx).Username
+		locks, err := c.FileStore.Locks.List(ctx.Ctx)
+		if err != nil {
+			fmt.Fprint(ctx.Stderr, "failed to list locks: ", err)
+			return 1
+		}
+		for _, lock := range locks {
+			if lock.Username != username && !slices.Contains(tags, "sysadmin") {
+				continue
+			}
+			if err := report(lock); err != nil {
+				fmt.Fprintf(ctx.Stderr, "error formatting YAML: %v", err)
+				return 1
+			}
+		}
+		return 0
+	}
+
+	if len(paths) == 0 {
+		fmt.Fprint(ctx.Stderr, usage)
+		return 1
+	}
+	ttl := filesystem.DefaultLockTTL
+	if ttl_str := opts["ttl"].(string); ttl_str != "" {
+		if ttl, err = time.ParseDuration(ttl_str); err != nil || ttl <= 0 || ttl > filesystem.MaxLockTTL {
+			fmt.Fprintf(ctx.Stderr, "error: invalid TTL %q (must be a positive duration of at most %v)", ttl_str, filesystem.MaxLockTTL)
+			return 1
+		}
+	}
+	mode := types.ExclusiveLock
+	if opts["shared"].(bool) {
+		mode = types.SharedLock
+	}
+	owner, ok := util.GetLockOwner(ctx.Ctx)
+	if !ok {
+		fmt.Fprint(ctx.Stderr, filesystem.ErrNoLockOwner)
+		return 1
+	}
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+
+	result := 0
+	for _, path := range paths {
+		abs_path, err := filesystem.AbsPath(cwd, path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v\r\n", path, err)
+			result = 1
+			continue
+		}
+		lock, err := c.FileStore.Lock(ctx.Ctx, abs_path, tags, owner, mode, ttl, opts["wait"].(bool))
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot lock %q: %v\r\n", path, err)
+			result = 1
+			continue
+		}
+		if err := report(*lock); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error formatting YAML: %v", err)
+			return 1
+		}
+	}
+
+	return result
+}
@@ -10,8 +10,7 @@ import (
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
 	"github.com/RoundRobinHood/sh"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type CmdLs struct {
@@ -115,14 +114,23 @@ func (c *CmdLs) Run(ctx sh.CommandContext) int {
 				fmt.Fprintf(ctx.Stderr, "error: (abs_path %q) is not a directory\r\n", path)
 				return 1
 			}
+			ids := make([]primitive.ObjectID, len(node.Entries))
+			for i, entry := range node.Entries {
+				ids[i] = entry.RefID
+			}
+			children, err := c.FileStore.Nodes.GetMany(ctx.Ctx, ids)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error getting file: %v", err)
+				return 1
+			}
+			by_id := make(map[primitive.ObjectID]types.FsEntry, len(children))
+			for _, child := range children {
+				by_id[child.ID] = child
+			}
 			for _, entry := range node.Entries {
-				var child types.FsEntry
-				if err := c.FileStore.Col.FindOne(ctx.Ctx, bson.M{"_id": entry.RefID}).Decode(&child); err != nil {
-					if err == os.ErrNotExist {
-						fmt.Fprintf(ctx.Stderr, "internal issue: %q entry does not have a corresponding document", entry.Name)
-					} else {
-						fmt.Fprintf(ctx.Stderr, "error getting file: %v", err)
-					}
+				child, ok := by_id[entry.RefID]
+				if !ok {
+					fmt.Fprintf(ctx.Stderr, "internal issue: %q entry does not have a corresponding document", entry.Name)
 					return 1
 				}
 				entries = append(entries, EntryInfo{entry.Name, child})
@@ -132,10 +140,14 @@ func (c *CmdLs) Run(ctx sh.CommandContext) int {
 		if !long_format {
 			for _, entry := range entries {
 				if yaml_output {
-					data, err := util.YamlCRLF(map[string]string{
+					entry_info := map[string]string{
 						"name": entry.EntryName,
 						"type": entry.EntryType.String(),
-					})
+					}
+					if entry.EntryType == types.Symlink {
+						entry_info["target"] = entry.Target
+					}
+					data, err := util.YamlCRLF(entry_info)
 					if err != nil {
 						fmt.Fprintf(ctx.Stderr, "error formatting file YAML: %v", err)
 						return 1
@@ -147,6 +159,8 @@ func (c *CmdLs) Run(ctx sh.CommandContext) int {
 					switch entry.EntryType {
 					case types.Directory:
 						fmt.Fprintf(ctx.Stdout, "\x1b[34m%s\x1b[0m\r\n", entry.EntryName)
+					case types.Symlink:
+						fmt.Fprintf(ctx.Stdout, "\x1b[36m%s\x1b[0m -> %s\r\n", entry.EntryName, entry.Target)
 					case types.File:
 						fallthrough
 					default:
@@ -155,31 +169,57 @@ func (c *CmdLs) Run(ctx sh.CommandContext) int {
 				}
 			}
 		} else {
+			file_refs := make([]primitive.ObjectID, 0, len(entries))
+			for _, entry := range entries {
+				if entry.EntryType == types.File && entry.FileReference != nil {
+					file_refs = append(file_refs, *entry.FileReference)
+				}
+			}
+			file_sizes, err := c.FileStore.Blobs.Sizes(ctx.Ctx, file_refs)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error checking file size: %v", err)
+				return 1
+			}
+			checksums, err := c.FileStore.Blobs.Checksums(ctx.Ctx, file_refs)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error checking file checksum: %v", err)
+				return 1
+			}
+
 			for _, entry := range entries {
 				file_count := 1
 				if entry.EntryType == types.Directory {
 					file_count = len(entry.Entries)
 				}
 				file_size := int64(0)
+				checksum := ""
 				if entry.EntryType == types.File && entry.FileReference != nil {
-					var fileInfo gridfs.File
-					if err := c.FileStore.Bucket.GetFilesCollection().FindOne(ctx.Ctx, bson.M{"_id": entry.FileReference}).Decode(&fileInfo); err != nil {
-						fmt.Fprintf(ctx.Stderr, "error checking file size: %v", err)
+					checksum = checksums[*entry.FileReference]
+					length, ok := file_sizes[*entry.FileReference]
+					if !ok {
+						fmt.Fprintf(ctx.Stderr, "error checking file size: %v", os.ErrNotExist)
 						return 1
 					}
-					file_size = fileInfo.Length
+					file_size = length
 				}
 
 				modify_time := entry.Timestamps.ModifiedAt.Format("Jan _2 15:04 2006")
 				if yaml_output {
-					data, err := util.YamlCRLF(map[string]any{
+					entry_info := map[string]any{
 						"type":          entry.EntryType.String(),
 						"permissions":   entry.Permissions,
 						"file_count":    file_count,
 						"file_size":     file_size,
 						"modified_time": modify_time,
 						"name":          entry.EntryName,
-					})
+					}
+					if entry.EntryType == types.Symlink {
+						entry_info["target"] = entry.Target
+					}
+					if checksum != "" {
+						entry_info["sha256"] = checksum
+					}
+					data, err := util.YamlCRLF(entry_info)
 					if err != nil {
 						fmt.Fprintf(ctx.Stderr, "error formatting file YAML: %v", err)
 						return 1
@@ -192,9 +232,16 @@ func (c *CmdLs) Run(ctx sh.CommandContext) int {
 					type_str := entry.EntryType.String() + "\t"
 					if entry.EntryType == types.Directory {
 						name_str = fmt.Sprintf("\x1b[34m%s\x1b[0m", name_str)
+					} else if entry.EntryType == types.Symlink {
+						name_str = fmt.Sprintf("\x1b[36m%s\x1b[0m -> %s", name_str, entry.Target)
 					} else {
 						type_str += "\t"
 					}
+					// The first 12 hex digits are plenty to tell contents apart at a glance
+					checksum_str := "-"
+					if checksum != "" {
+						checksum_str = checksum[:min(len(checksum), 12)]
+					}
 					fmt.Fprint(ctx.Stdout,
 						type_str,
 						strings.Join(entry.Permissions.ReadTags, ","), "\t",
@@ -203,6 +250,7 @@ func (c *CmdLs) Run(ctx sh.CommandContext) int {
 						strings.Join(entry.Permissions.UpdatePermissionTags, ","), "\t",
 						file_count, "\t",
 						file_size, "\t",
+						checksum_str, "\t",
 						modify_time, "\t",
 						name_str, "\r\n")
 				}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdQuota struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdQuota) Identifier() string {
+	return "quota"
+}
+
+func (c *CmdQuota) Run(ctx sh.CommandContext) int {
+	tags := util.GetTags(ctx.Ctx)
+
+	opts, args, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err)
+		return 1
+	}
+	if len(args) != 0 {
+		fmt.Fprint(ctx.Stderr, "usage: quota [-y]")
+		return 1
+	}
+
+	usages, err := c.FileStore.QuotaUsages(ctx.Ctx)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to check quotas: %v", err)
+		return 1
+	}
+
+	for _, usage := range usages {
+		// Users only see quotas on their own tags and on directories they can reach
+		if !slices.Contains(tags, "sysadmin") {
+			if usage.Kind == filesystem.TagQuota && !slices.Contains(tags, usage.Name) {
+				continue
+			}
+			if usage.Kind == filesystem.DirectoryQuota {
+				if _, err := c.FileStore.Lookup(ctx.Ctx, tags, usage.Name); err != nil {
+					continue
+				}
+			}
+		}
+
+		if opts["yaml_output"].(bool) {
+			data, err := util.YamlCRLF(usage)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error formatting quota YAML: %v", err)
+				return 1
+			}
+
+			fmt.Fprint(ctx.Stdout, "- ")
+			fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+		} else {
+			fmt.Fprint(ctx.Stdout,
+				usage.Kind, "\t",
+				usage.Name, "\t",
+				usage.Bytes, "/", quotaLimitString(usage.Limit.Bytes), " bytes\t",
+				usage.Entries, "/", quotaLimitString(usage.Limit.Entries), " entries\r\n")
+		}
+	}
+
+	return 0
+}
+
+func quotaLimitString(limit int64) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(limit)
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"slices"
 
@@ -22,7 +23,7 @@ func (c *CmdRm) Run(ctx sh.CommandContext) int {
 	tags := util.GetTags(ctx.Ctx)
 
 	if len(ctx.Args) == 1 || slices.Contains(ctx.Args, "-h") || slices.Contains(ctx.Args, "--help") {
-		fmt.Fprint(ctx.Stderr, "usage: rm [-rf] <FILES...>")
+		fmt.Fprint(ctx.Stderr, "usage: rm [-rfP] <FILES...>")
 		return 1
 	}
 	cwd, ok := ctx.Env["PWD"]
@@ -42,6 +43,11 @@ func (c *CmdRm) Run(ctx sh.CommandContext) int {
 			Aliases:    []string{"f", "forced"},
 			Default:    false,
 		},
+		{
+			Identifier: "permanent",
+			Aliases:    []string{"P", "permanent"},
+			Default:    false,
+		},
 	}, ctx.Args[1:])
 	if err != nil {
 		fmt.Fprint(ctx.Stderr, err)
@@ -50,9 +56,10 @@ func (c *CmdRm) Run(ctx sh.CommandContext) int {
 
 	rmDirectories := opts["recursive"].(bool)
 	forced := opts["forced"].(bool)
+	permanent := opts["permanent"].(bool)
 
 	if len(paths) == 0 {
-		fmt.Fprint(ctx.Stderr, "usage: rm [-rf] <FILES...>")
+		fmt.Fprint(ctx.Stderr, "usage: rm [-rfP] <FILES...>")
 		return 1
 	}
 
@@ -67,7 +74,28 @@ func (c *CmdRm) Run(ctx sh.CommandContext) int {
 	}
 
 	for i, path := range abs_paths {
-		if _, err := c.FileStore.RemoveFile(ctx.Ctx, path, tags, forced, rmDirectories); err != nil {
+		var err error
+		if permanent {
+			_, err = c.FileStore.RemoveFile(ctx.Ctx, path, tags, forced, rmDirectories)
+		} else {
+			_, err = c.FileStore.Trash(ctx.Ctx, path, tags, forced, rmDirectories)
+		}
+
+		var partial filesystem.ErrPartialRemove
+		if errors.As(err, &partial) {
+			for _, failure := range partial.Failed {
+				fmt.Fprintf(ctx.Stderr, "error: couldn't empty directory (%q): %v\r\n", failure.Path, failure.Reason)
+			}
+			if permanent {
+				fmt.Fprintf(ctx.Stderr, "error: %q was only partially removed (%d entries removed)", paths[i], partial.Removed)
+			} else {
+				fmt.Fprintf(ctx.Stderr, "error: %q wasn't moved to the trash", paths[i])
+			}
+			return 1
+		} else if errors.Is(err, filesystem.ErrNoTrash) || errors.Is(err, filesystem.ErrTrashInside) {
+			fmt.Fprintf(ctx.Stderr, "error: failed to remove file (%q): %v. Use -P to delete it permanently", paths[i], err)
+			return 1
+		} else if err != nil {
 			fmt.Fprintf(ctx.Stderr, "error: failed to remove file (%q): %v", paths[i], err)
 			return 1
 		}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdSort struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdSort) Identifier() string {
+	return "sort"
+}
+
+func (c *CmdSort) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "reverse",
+			Aliases:    []string{"r", "reverse"},
+			Default:    false,
+		},
+		{
+			Identifier: "numeric",
+			Aliases:    []string{"n", "numeric-sort"},
+			Default:    false,
+		},
+		{
+			Identifier: "unique",
+			Aliases:    []string{"u", "unique"},
+			Default:    false,
+		},
+		{
+			Identifier: "ignore_case",
+			Aliases:    []string{"f", "ignore-case"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\nusage: sort [-rnuf] [FILES...]")
+		return 1
+	}
+	numeric, ignore_case := opts["numeric"].(bool), opts["ignore_case"].(bool)
+
+	lines := []string{}
+	if result := forEachInput(ctx, c.FileStore, paths, func(name string, r io.Reader) error {
+		scanner := newLineScanner(r)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		return scanner.Err()
+	}); result != 0 {
+		return result
+	}
+
+	key := func(line string) string {
+		if ignore_case {
+			return strings.ToLower(line)
+		}
+		return line
+	}
+	compare := func(a, b string) int {
+		if numeric {
+			// Like sort -n, lines that don't start with a number sort as 0
+			if by_number := compareNumeric(a, b); by_number != 0 {
+				return by_number
+			}
+		}
+		return strings.Compare(key(a), key(b))
+	}
+
+	slices.SortStableFunc(lines, compare)
+	if opts["reverse"].(bool) {
+		slices.Reverse(lines)
+	}
+	if opts["unique"].(bool) {
+		lines = slices.CompactFunc(lines, func(a, b string) bool {
+			return compare(a, b) == 0
+		})
+	}
+
+	for _, line := range lines {
+		fmt.Fprint(ctx.Stdout, line, "\r\n")
+	}
+	return 0
+}
+
+// compareNumeric compares the numbers at the start of a and b
+func compareNumeric(a, b string) int {
+	leading := func(line string) float64 {
+		line = strings.TrimSpace(line)
+		end := strings.IndexFunc(line, func(r rune) bool {
+			return !strings.ContainsRune("+-.0123456789eE", r)
+		})
+		if end == -1 {
+			end = len(line)
+		}
+		for ; end > 0; end-- {
+			if n, err := strconv.ParseFloat(line[:end], 64); err == nil {
+				return n
+			}
+		}
+		return 0
+	}
+
+	x, y := leading(a), leading(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
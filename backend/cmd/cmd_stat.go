@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CmdStat struct {
+	FileStore filesystem.Store
+}
+
+// statAccess is what the caller's tags allow on an entry
+type statAccess struct {
+	Read              bool `yaml:"read"`
+	Write             bool `yaml:"write"`
+	Execute           bool `yaml:"execute"`
+	UpdatePermissions bool `yaml:"update_permissions"`
+}
+
+// statInfo is the metadata stat prints for an entry
+type statInfo struct {
+	Path        string                   `yaml:"path"`
+	ID          string                   `yaml:"id"`
+	Type        string                   `yaml:"type"`
+	FileRef     string                   `yaml:"file_ref,omitempty"`
+	Size        int64                    `yaml:"size"`
+	Checksum    string                   `yaml:"sha256,omitempty"`
+	Entries     int                      `yaml:"entries,omitempty"`
+	Target      string                   `yaml:"target,omitempty"`
+	Revision    int64                    `yaml:"revision"`
+	ModifiedBy  string                   `yaml:"modified_by,omitempty"`
+	Versions    int                      `yaml:"versions"`
+	CreatedAt   time.Time                `yaml:"created_at"`
+	ModifiedAt  time.Time                `yaml:"modified_at"`
+	AccessedAt  time.Time                `yaml:"accessed_at"`
+	Permissions types.FsEntryPermissions `yaml:"permissions"`
+	Access      statAccess               `yaml:"access"`
+	Trash       *types.TrashInfo         `yaml:"trash,omitempty"`
+}
+
+func (*CmdStat) Identifier() string {
+	return "stat"
+}
+
+func (c *CmdStat) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+		{
+			Identifier: "dereference",
+			Aliases:    []string{"L", "dereference"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil || len(paths) == 0 {
+		if err != nil {
+			fmt.Fprint(ctx.Stderr, err, "\r\n")
+		}
+		fmt.Fprint(ctx.Stderr, "usage: stat [-yL] <PATHS...>")
+		return 1
+	}
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+
+	result := 0
+	for i, path := range paths {
+		abs_path, err := filesystem.AbsPath(cwd, path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v\r\n", path, err)
+			result = 1
+			continue
+		}
+		lookup := c.FileStore.LookupNoFollow
+		if opts["dereference"].(bool) {
+			lookup = c.FileStore.Lookup
+		}
+		entry, err := lookup(ctx.Ctx, tags, abs_path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot stat %q: %v\r\n", path, err)
+			result = 1
+			continue
+		}
+
+		info := statInfo{
+			Path:        abs_path,
+			ID:          entry.ID.Hex(),
+			Type:        entry.EntryType.String(),
+			Target:      entry.Target,
+			Revision:    entry.Revision,
+			ModifiedBy:  entry.ModifiedBy,
+			Versions:    len(entry.Versions),
+			CreatedAt:   entry.Timestamps.CreatedAt,
+			ModifiedAt:  entry.Timestamps.ModifiedAt,
+			AccessedAt:  entry.Timestamps.AccessedAt,
+			Permissions: entry.Permissions,
+			Access: statAccess{
+				Read:              entry.Permissions.IsAllowed(types.ReadMode, tags),
+				Write:             entry.Permissions.IsAllowed(types.WriteMode, tags),
+				Execute:           entry.Permissions.IsAllowed(types.ExecuteMode, tags),
+				UpdatePermissions: entry.Permissions.IsAllowed(types.UpdatePermissionsMode, tags),
+			},
+			Trash: entry.Trash,
+		}
+		if entry.EntryType == types.Directory {
+			info.Entries = len(entry.Entries)
+		}
+		if entry.FileReference != nil {
+			info.FileRef = entry.FileReference.Hex()
+			if info.Size, err = c.FileStore.Blobs.Size(ctx.Ctx, *entry.FileReference); err != nil {
+				fmt.Fprintf(ctx.Stderr, "error checking file size (%q): %v\r\n", path, err)
+				result = 1
+				continue
+			}
+			checksums, err := c.FileStore.Blobs.Checksums(ctx.Ctx, []primitive.ObjectID{*entry.FileReference})
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error checking file checksum (%q): %v\r\n", path, err)
+				result = 1
+				continue
+			}
+			info.Checksum = checksums[*entry.FileReference]
+		}
+
+		if opts["yaml_output"].(bool) {
+			data, err := util.YamlCRLF(info)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error formatting YAML: %v", err)
+				return 1
+			}
+			fmt.Fprint(ctx.Stdout, "- ")
+			fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+		} else {
+			if i > 0 {
+				fmt.Fprint(ctx.Stdout, "\r\n")
+			}
+			printStat(ctx, info)
+		}
+	}
+
+	return result
+}
+
+func printStat(ctx sh.CommandContext, info statInfo) {
+	line := func(label string, value any) {
+		fmt.Fprintf(ctx.Stdout, "%12s: %v\r\n", label, value)
+	}
+	access := func(allowed bool) string {
+		if allowed {
+			return "yes"
+		}
+		return "no"
+	}
+
+	line("Path", info.Path)
+	line("Type", info.Type)
+	line("ID", info.ID)
+	switch info.Type {
+	case "file":
+		if info.FileRef != "" {
+			line("File ref", info.FileRef)
+		}
+		line("Size", info.Size)
+		if info.Checksum != "" {
+			line("SHA-256", info.Checksum)
+		}
+		line("Revision", info.Revision)
+		line("Versions", info.Versions)
+		if info.ModifiedBy != "" {
+			line("Modified by", info.ModifiedBy)
+		}
+	case "directory":
+		line("Entries", info.Entries)
+	case "symlink":
+		line("Target", info.Target)
+	}
+	line("Created", info.CreatedAt.Format(time.RFC3339))
+	line("Modified", info.ModifiedAt.Format(time.RFC3339))
+	line("Accessed", info.AccessedAt.Format(time.RFC3339))
+	line("Read", strings.Join(info.Permissions.ReadTags, ","))
+	line("Write", strings.Join(info.Permissions.WriteTags, ","))
+	line("Execute", strings.Join(info.Permissions.ExecuteTags, ","))
+	line("Update perms", strings.Join(info.Permissions.UpdatePermissionTags, ","))
+	line("Your access", fmt.Sprintf("read=%s write=%s execute=%s chmod=%s",
+		access(info.Access.Read), access(info.Access.Write), access(info.Access.Execute), access(info.Access.UpdatePermissions)))
+	if info.Trash != nil {
+		line("Trashed from", info.Trash.OriginalPath)
+		line("Trashed by", info.Trash.DeletedBy)
+		line("Trashed at", info.Trash.DeletedAt.Format(time.RFC3339))
+	}
+}
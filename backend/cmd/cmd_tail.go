@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdTail struct {
+	FileStore filesystem.Store
+}
+
+// tailFollowInterval is how often tail -f checks the followed file for new contents
+var tailFollowInterval = time.Second
+
+func (*CmdTail) Identifier() string {
+	return "tail"
+}
+
+func (c *CmdTail) Run(ctx sh.CommandContext) int {
+	const usage = "usage: tail [-f] [-n LINES] [FILES...]"
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "lines",
+			Aliases:    []string{"n", "lines"},
+			Default:    "10",
+		},
+		{
+			Identifier: "follow",
+			Aliases:    []string{"f", "follow"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\n", usage)
+		return 1
+	}
+	lines, err := strconv.Atoi(opts["lines"].(string))
+	if err != nil || lines < 0 {
+		fmt.Fprintf(ctx.Stderr, "error: invalid line count %q", opts["lines"])
+		return 1
+	}
+
+	if opts["follow"].(bool) {
+		if len(paths) != 1 || paths[0] == "-" {
+			fmt.Fprint(ctx.Stderr, "error: -f follows exactly one file\r\n", usage)
+			return 1
+		}
+		return c.follow(ctx, paths[0], lines)
+	}
+
+	return forEachInput(ctx, c.FileStore, paths, func(name string, r io.Reader) error {
+		if len(paths) > 1 {
+			fmt.Fprintf(ctx.Stdout, "==> %s <==\r\n", name)
+		}
+		_, err := tailLines(ctx, r, lines)
+		return err
+	})
+}
+
+// tailLines prints the last lines of r, returning how many bytes it read
+func tailLines(ctx sh.CommandContext, r io.Reader, lines int) (int64, error) {
+	counter := &countingReader{Reader: r}
+	scanner := newLineScanner(counter)
+	ring := make([]string, 0, lines)
+	for scanner.Scan() {
+		if lines == 0 {
+			continue
+		}
+		if len(ring) == lines {
+			ring = append(ring[:0], ring[1:]...)
+		}
+		ring = append(ring, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return counter.n, err
+	}
+
+	for _, line := range ring {
+		fmt.Fprint(ctx.Stdout, line, "\r\n")
+	}
+	return counter.n, nil
+}
+
+// follow prints the last lines of the file, then everything appended to it until the command is interrupted
+func (c *CmdTail) follow(ctx sh.CommandContext, path string, lines int) int {
+	tags := util.GetTags(ctx.Ctx)
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	abs_path, err := filesystem.AbsPath(cwd, path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", path, err)
+		return 1
+	}
+
+	entry, err := c.FileStore.Lookup(ctx.Ctx, tags, abs_path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error opening file (%q): %v", path, err)
+		return 1
+	}
+	reader, err := c.FileStore.ReadFileObj(ctx.Ctx, *entry, tags)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error opening file (%q): %v", path, err)
+		return 1
+	}
+	offset, err := tailLines(ctx, reader, lines)
+	reader.Close()
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error reading file (%q): %v", path, err)
+		return 1
+	}
+
+	// Every write replaces the file's contents, so watch for a new blob and print what comes after the old end
+	current := entry.FileReference
+	ticker := time.NewTicker(tailFollowInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+
+		entry, err := c.FileStore.Lookup(ctx.Ctx, tags, abs_path)
+		if err != nil {
+			if ctx.Ctx.Err() != nil {
+				return 0
+			}
+			fmt.Fprintf(ctx.Stderr, "error following file (%q): %v", path, err)
+			return 1
+		}
+		if entry.FileReference == nil || (current != nil && *entry.FileReference == *current) {
+			continue
+		}
+		current = entry.FileReference
+
+		size, err := c.FileStore.Blobs.Size(ctx.Ctx, *current)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(ctx.Stderr, "error following file (%q): %v", path, err)
+			return 1
+		}
+		if size < offset {
+			fmt.Fprintf(ctx.Stderr, "tail: %s: file truncated\r\n", path)
+			offset = 0
+		}
+
+		reader, err := c.FileStore.ReadFileObj(ctx.Ctx, *entry, tags)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error following file (%q): %v", path, err)
+			return 1
+		}
+		buffered := bufio.NewReader(reader)
+		if _, err := buffered.Discard(int(offset)); err == nil {
+			copied, _ := io.Copy(ctx.Stdout, buffered)
+			offset += copied
+		}
+		reader.Close()
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
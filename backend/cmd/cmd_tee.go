@@ -36,12 +36,20 @@ func (c *CmdTee) Run(ctx sh.CommandContext) int {
 	tags := util.GetTags(ctx.Ctx)
 	parents := make([]types.FsEntry, 0, len(ctx.Args)-1)
 	filenames := make([]string, 0, len(ctx.Args)-1)
+	revisions := make([]int64, 0, len(ctx.Args)-1)
+	budget := int64(-1)
 	for _, path := range ctx.Args[1:] {
 		abs_path, err := filesystem.AbsPath(cwd, path)
 		if err != nil {
 			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", path, err)
 			return 1
 		}
+		// Write through symlinks instead of replacing them
+		abs_path, err = c.FileStore.Resolve(ctx.Ctx, tags, abs_path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: failed to resolve path (%q): %v", path, err)
+			return 1
+		}
 		folder_path, filename, err := filesystem.DirUp(abs_path)
 		if err != nil {
 			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", abs_path, err)
@@ -61,19 +69,37 @@ func (c *CmdTee) Run(ctx sh.CommandContext) int {
 				fmt.Fprintf(ctx.Stderr, "error: cannot descend into folder (%q): %v", folder_path, err)
 				return 1
 			}
+			// Remember each file's revision, so a concurrent write makes tee fail instead of being overwritten
+			revision := int64(0)
+			if ref, ok := parent.Entries.Get(filename); ok {
+				if entry, err := c.FileStore.Nodes.Get(ctx.Ctx, ref.RefID); err != nil {
+					fmt.Fprintf(ctx.Stderr, "error: failed to get file (%q): %v", path, err)
+					return 1
+				} else {
+					revision = entry.Revision
+				}
+			}
+			// Stop the upload as soon as it can't fit in every target's quota
+			if file_budget, err := c.FileStore.WriteBudget(ctx.Ctx, *parent, filename); err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: failed to check quota (%q): %v", path, err)
+				return 1
+			} else if file_budget >= 0 && (budget < 0 || file_budget < budget) {
+				budget = file_budget
+			}
 			parents = append(parents, *parent)
 			filenames = append(filenames, filename)
+			revisions = append(revisions, revision)
 		}
 	}
 
 	fileRef := primitive.NewObjectID()
-	stream, err := c.FileStore.Bucket.OpenUploadStreamWithID(fileRef, "")
+	stream, err := c.FileStore.Blobs.OpenUpload(ctx.Ctx, fileRef)
 	if err != nil {
 		fmt.Fprintf(ctx.Stderr, "error: failed to open upload stream: %v", err)
 		return 1
 	}
 
-	writer := io.MultiWriter(stream, ctx.Stdout)
+	writer := io.MultiWriter(filesystem.LimitWriter(stream, budget), ctx.Stdout)
 	if _, err := io.Copy(writer, ctx.Stdin); err != nil {
 		fmt.Fprintf(ctx.Stderr, "error: failed to write to upload stream: %v", err)
 		stream.Close()
@@ -84,8 +110,13 @@ func (c *CmdTee) Run(ctx sh.CommandContext) int {
 		return 1
 	}
 
+	// Every target gets the same blob: sharing it per write would free the upload after the first one
+	if fileRef, err = c.FileStore.ShareBlob(ctx.Ctx, fileRef); err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to store contents: %v", err)
+		return 1
+	}
 	for i := range parents {
-		if _, err := c.FileStore.WriteFile(ctx.Ctx, parents[i].ID, filenames[i], fileRef, tags); err != nil {
+		if _, err := c.FileStore.WriteFileIfRevision(ctx.Ctx, parents[i].ID, filenames[i], fileRef, tags, revisions[i]); err != nil {
 			fmt.Fprintf(ctx.Stderr, "error: failed to write file (%q): %v", filenames[i], err)
 			return 1
 		}
@@ -0,0 +1,689 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/auth"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/sh"
+	"github.com/goccy/go-yaml"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestStore returns an in-memory store containing only a root directory
+func newTestStore(t *testing.T) filesystem.Store {
+	t.Helper()
+	store := filesystem.NewMemoryStore()
+	now := time.Now()
+	tags := []string{"sysadmin", "user"}
+
+	if err := store.Nodes.Insert(context.Background(), types.FsEntry{
+		ID:        primitive.NewObjectID(),
+		IsRoot:    true,
+		EntryType: types.Directory,
+		Permissions: types.FsEntryPermissions{
+			ReadTags:             tags,
+			WriteTags:            tags,
+			ExecuteTags:          tags,
+			UpdatePermissionTags: tags,
+		},
+		Timestamps: types.FileTimestamps{CreatedAt: now, ModifiedAt: now, AccessedAt: now},
+		Entries:    types.FsReferenceList{},
+	}); err != nil {
+		t.Fatalf("failed to seed store: %v", err)
+	}
+
+	return store
+}
+
+// runTestCommand runs a command with the given tags and stdin, returning its exit code, stdout and stderr
+func runTestCommand(command sh.Command, tags []string, stdin string, args ...string) (int, string, string) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	ctx := context.WithValue(context.Background(), "tags", tags)
+	ctx = context.WithValue(ctx, "auth_status", types.AuthorizationStatus{Username: "tester", Tags: tags})
+	ctx = context.WithValue(ctx, "lock_owner", types.LockOwner{SocketID: "test-socket", ClientID: "test-client"})
+
+	result := command.Run(sh.CommandContext{
+		Args:   append([]string{command.Identifier()}, args...),
+		Stdin:  strings.NewReader(stdin),
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Env:    map[string]string{"PWD": "/", "HOME": "/"},
+		Ctx:    ctx,
+	})
+
+	return result, stdout.String(), stderr.String()
+}
+
+func TestFileCommands(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	fsctx := filesystem.FSContext{Store: store, UserTags: tags}
+
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, tags, "", "-p", "/data/logs", "/home/tester"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+
+	if code, stdout, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "hello", "/data/logs/a.txt"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	} else if stdout != "hello" {
+		t.Fatalf("tee should echo stdin, got %q", stdout)
+	}
+
+	if code, stdout, stderr := runTestCommand(&CmdCat{FSCtx: fsctx}, tags, "", "-n", "/data/logs/a.txt"); code != 0 {
+		t.Fatalf("cat failed: %s", stderr)
+	} else if stdout != "hello" {
+		t.Fatalf("unexpected cat output %q", stdout)
+	}
+
+	if code, _, stderr := runTestCommand(&CmdCopy{FileStore: store}, tags, "", "/data/logs/a.txt", "/data/b.txt"); code != 0 {
+		t.Fatalf("cp failed: %s", stderr)
+	}
+
+	if code, stdout, stderr := runTestCommand(&CmdLs{FileStore: store}, tags, "", "-l", "/data"); code != 0 {
+		t.Fatalf("ls failed: %s", stderr)
+	} else if !strings.Contains(stdout, "b.txt") || !strings.Contains(stdout, "logs") {
+		t.Fatalf("ls output missing entries: %q", stdout)
+	}
+
+	if code, _, stderr := runTestCommand(&CmdRm{FileStore: store}, tags, "", "/data/b.txt"); code != 0 {
+		t.Fatalf("rm failed: %s", stderr)
+	}
+
+	if code, stdout, _ := runTestCommand(&CmdLs{FileStore: store}, tags, "", "/data"); code != 0 || strings.Contains(stdout, "b.txt") {
+		t.Fatalf("expected b.txt to be removed, got %q", stdout)
+	}
+
+	if code, stdout, stderr := runTestCommand(&CmdTrash{FileStore: store}, tags, ""); code != 0 {
+		t.Fatalf("trash failed: %s", stderr)
+	} else if !strings.Contains(stdout, "tester\t/data/b.txt") {
+		t.Fatalf("expected b.txt in the trash, got %q", stdout)
+	}
+}
+
+func TestTeeDuplicateContents(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	fsctx := filesystem.FSContext{Store: store, UserTags: tags}
+
+	// The contents already exist, so every target shares the existing copy
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "same", "/original.txt"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "same", "/a.txt", "/b.txt", "/c.txt"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+	for _, path := range []string{"/original.txt", "/a.txt", "/b.txt", "/c.txt"} {
+		if code, stdout, stderr := runTestCommand(&CmdCat{FSCtx: fsctx}, tags, "", "-n", path); code != 0 || stdout != "same" {
+			t.Fatalf("expected %s to read back the teed contents (%s): %q", path, stderr, stdout)
+		}
+	}
+}
+
+func TestCopyTouchedFile(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	fsctx := filesystem.FSContext{Store: store, UserTags: tags}
+
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, tags, "", "/data"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdTouch{FileStore: store}, tags, "", "/empty.txt"); code != 0 {
+		t.Fatalf("touch failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdCopy{FileStore: store}, tags, "", "/empty.txt", "/copy.txt"); code != 0 {
+		t.Fatalf("cp failed: %s", stderr)
+	}
+	if code, stdout, stderr := runTestCommand(&CmdCat{FSCtx: fsctx}, tags, "", "-n", "/copy.txt"); code != 0 || stdout != "" {
+		t.Fatalf("expected an empty copy (%s): %q", stderr, stdout)
+	}
+
+	// Copying over a file with contents empties it, keeping the old contents as a version
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "old", "/data/empty.txt"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdCopy{FileStore: store}, tags, "", "/empty.txt", "/data"); code != 0 {
+		t.Fatalf("cp failed: %s", stderr)
+	}
+	entry, err := store.Lookup(context.Background(), tags, "/data/empty.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.FileReference != nil || len(entry.Versions) != 1 {
+		t.Fatalf("expected the file to be emptied with one version, got %+v", entry)
+	}
+}
+
+func TestLnCommand(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	fsctx := filesystem.FSContext{Store: store, UserTags: tags}
+
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, tags, "", "-p", "/fleet", "/pilot"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdLn{FileStore: store}, tags, "", "/fleet", "/pilot"); code == 0 {
+		t.Fatal("expected ln without -s to fail")
+	} else if !strings.Contains(stderr, "hard links") {
+		t.Fatalf("unexpected error %q", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdLn{FileStore: store}, tags, "", "-s", "/fleet", "/pilot"); code != 0 {
+		t.Fatalf("ln failed: %s", stderr)
+	}
+
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "shared", "/pilot/fleet/plan.txt"); code != 0 {
+		t.Fatalf("tee through symlink failed: %s", stderr)
+	}
+	if code, stdout, stderr := runTestCommand(&CmdCat{FSCtx: fsctx}, tags, "", "-n", "/fleet/plan.txt"); code != 0 {
+		t.Fatalf("cat failed: %s", stderr)
+	} else if stdout != "shared" {
+		t.Fatalf("unexpected cat output %q", stdout)
+	}
+
+	if info, err := fsctx.Stat(context.Background(), "/pilot/fleet"); err != nil || info.Type != sh.FileDir {
+		t.Fatalf("expected symlink to stat as a directory, got %+v (%v)", info, err)
+	}
+	if code, stdout, _ := runTestCommand(&CmdLs{FileStore: store}, tags, "", "/pilot"); code != 0 || !strings.Contains(stdout, "fleet\x1b[0m -> /fleet") {
+		t.Fatalf("expected ls to show link target, got %q", stdout)
+	}
+}
+
+func TestQuotaCommand(t *testing.T) {
+	store := newTestStore(t)
+	admin := []string{"sysadmin"}
+
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, admin, "", "/etc"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, admin, "tags:\n  user: {bytes: 60}\n", filesystem.QuotaConfigPath); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+
+	// The quota file itself is owned by "user" too (the root's updatetag tags)
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, []string{"user"}, strings.Repeat("x", 64), "/big.txt"); code == 0 {
+		t.Fatal("expected tee to go over quota")
+	} else if !strings.Contains(stderr, "quota exceeded") {
+		t.Fatalf("unexpected error %q", stderr)
+	}
+
+	if code, stdout, stderr := runTestCommand(&CmdQuota{FileStore: store}, []string{"user"}, ""); code != 0 {
+		t.Fatalf("quota failed: %s", stderr)
+	} else if !strings.Contains(stdout, "tag\tuser\t26/60 bytes") {
+		t.Fatalf("unexpected quota output %q", stdout)
+	}
+}
+
+func TestDefaultsCommand(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	defaults := &CmdDefaults{FileStore: store}
+
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, tags, "", "/shared"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	if code, stdout, stderr := runTestCommand(defaults, tags, "", "/shared"); code != 0 || !strings.Contains(stdout, "files:\t(the directory's own)") {
+		t.Fatalf("unexpected defaults (%s): %q", stderr, stdout)
+	}
+
+	input := "files:\n  read_tags: [user, atc]\n  write_tags: [user]\n  execute_tags: [user]\n  updatetag_tags: [sysadmin, user]\n"
+	if code, stdout, stderr := runTestCommand(defaults, tags, input, "-s", "/shared"); code != 0 || !strings.Contains(stdout, "files:\tr=user,atc w=user x=user p=sysadmin,user") {
+		t.Fatalf("set failed (%s): %q", stderr, stdout)
+	}
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "plan", "/shared/plan.txt"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+	if entry, err := store.Lookup(context.Background(), tags, "/shared/plan.txt"); err != nil || !slices.Contains(entry.Permissions.ReadTags, "atc") {
+		t.Fatalf("expected the new file to be readable by atc, got %+v (%v)", entry, err)
+	}
+
+	if code, _, _ := runTestCommand(defaults, tags, "files:\n  read: [user]\n", "-s", "/shared"); code == 0 {
+		t.Fatal("expected unknown fields to be rejected")
+	}
+	if code, _, _ := runTestCommand(defaults, []string{"atc"}, "", "-c", "/shared"); code == 0 {
+		t.Fatal("expected atc to be unable to clear the defaults")
+	}
+	if code, stdout, stderr := runTestCommand(defaults, tags, "", "-cy", "/shared"); code != 0 || stdout != "{}\r\n" {
+		t.Fatalf("clear failed (%s): %q", stderr, stdout)
+	}
+}
+
+func TestFindCommand(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	find := &CmdFind{FileStore: store}
+	find.Commands = []sh.Command{&CmdRm{FileStore: store}, find}
+
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, tags, "", "-p", "/flights/day1", "/flights/day2", "/home/tester"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	for path, content := range map[string]string{
+		"/flights/day1/log.csv":  strings.Repeat("x", 2048),
+		"/flights/day2/log.csv":  "short",
+		"/flights/day2/notes.md": "notes",
+	} {
+		if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, content, path); code != 0 {
+			t.Fatalf("tee failed: %s", stderr)
+		}
+	}
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, []string{"sysadmin"}, "", "/flights/private"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdChmod{FileStore: store}, []string{"sysadmin"}, "", "user-x", "/flights/private"); code != 0 {
+		t.Fatalf("chmod failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, []string{"sysadmin"}, "secret", "/flights/private/log.csv"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+
+	// Directories the caller can't execute aren't descended into
+	if code, stdout, stderr := runTestCommand(find, tags, "", "/flights", "-name", "*.csv", "-type", "f"); code != 0 {
+		t.Fatalf("find failed: %s", stderr)
+	} else if stdout != "/flights/day1/log.csv\r\n/flights/day2/log.csv\r\n" {
+		t.Fatalf("unexpected find output %q", stdout)
+	}
+
+	if code, stdout, stderr := runTestCommand(find, tags, "", "/flights", "-size", "+1k"); code != 0 {
+		t.Fatalf("find failed: %s", stderr)
+	} else if stdout != "/flights/day1/log.csv\r\n" {
+		t.Fatalf("unexpected find -size output %q", stdout)
+	}
+
+	if code, stdout, stderr := runTestCommand(find, tags, "", "-y", "/flights", "-maxdepth", "1", "-type", "d", "-writable-by", "sysadmin"); code != 0 {
+		t.Fatalf("find failed: %s", stderr)
+	} else if !strings.Contains(stdout, "path: /flights/private") || strings.Contains(stdout, "log.csv") {
+		t.Fatalf("unexpected find YAML %q", stdout)
+	}
+
+	if code, _, stderr := runTestCommand(find, tags, "", "/flights/day2", "-name", "*.md", "-exec", "rm", "{}", ";"); code != 0 {
+		t.Fatalf("find -exec failed: %s", stderr)
+	}
+	if code, stdout, _ := runTestCommand(find, tags, "", "/flights/day2", "-type", "f"); code != 0 || stdout != "/flights/day2/log.csv\r\n" {
+		t.Fatalf("expected -exec to remove notes.md, got %q", stdout)
+	}
+}
+
+func TestTextCommands(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, tags, "", "-p", "/logs/old"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	for path, content := range map[string]string{
+		"/logs/a.log":     "ok\r\nERROR disk\r\nok\r\n",
+		"/logs/old/b.log": "error net\nok\n",
+	} {
+		if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, content, path); code != 0 {
+			t.Fatalf("tee failed: %s", stderr)
+		}
+	}
+
+	if code, stdout, stderr := runTestCommand(&CmdGrep{FileStore: store}, tags, "", "-rni", "error", "/logs"); code != 0 {
+		t.Fatalf("grep failed: %s", stderr)
+	} else if stdout != "/logs/old/b.log:1:error net\r\n/logs/a.log:2:ERROR disk\r\n" {
+		t.Fatalf("unexpected grep output %q", stdout)
+	}
+	if code, stdout, _ := runTestCommand(&CmdGrep{FileStore: store}, tags, "a\nb\n", "-v", "a"); code != 0 || stdout != "b\r\n" {
+		t.Fatalf("unexpected grep -v result %d %q", code, stdout)
+	}
+	if code, _, _ := runTestCommand(&CmdGrep{FileStore: store}, tags, "a\n", "z"); code != 1 {
+		t.Fatalf("expected grep without matches to exit 1, got %d", code)
+	}
+
+	input := "c\nb\na\nb\n10\n9\n"
+	if code, stdout, _ := runTestCommand(&CmdHead{FileStore: store}, tags, input, "-n", "2"); code != 0 || stdout != "c\r\nb\r\n" {
+		t.Fatalf("unexpected head output %q", stdout)
+	}
+	if code, stdout, _ := runTestCommand(&CmdTail{FileStore: store}, tags, input, "-n", "2"); code != 0 || stdout != "10\r\n9\r\n" {
+		t.Fatalf("unexpected tail output %q", stdout)
+	}
+	if code, stdout, _ := runTestCommand(&CmdWc{FileStore: store}, tags, "", "/logs/a.log"); code != 0 || stdout != "3\t4\t20\t/logs/a.log\r\n" {
+		t.Fatalf("unexpected wc output %q", stdout)
+	}
+	if code, stdout, _ := runTestCommand(&CmdSort{FileStore: store}, tags, input, "-n"); code != 0 || stdout != "a\r\nb\r\nb\r\nc\r\n9\r\n10\r\n" {
+		t.Fatalf("unexpected sort -n output %q", stdout)
+	}
+	if code, stdout, _ := runTestCommand(&CmdUniq{FileStore: store}, tags, "a\na\nb\na\n", "-c"); code != 0 || stdout != "      2 a\r\n      1 b\r\n      1 a\r\n" {
+		t.Fatalf("unexpected uniq -c output %q", stdout)
+	}
+}
+
+func TestTailFollow(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	tailFollowInterval = 10 * time.Millisecond
+
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "one\r\ntwo\r\n", "/flight.log"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+
+	out := make(ChannelWriter, 16)
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "tags", tags))
+	done := make(chan int)
+	go func() {
+		done <- (&CmdTail{FileStore: store}).Run(sh.CommandContext{
+			Args:   []string{"tail", "-f", "-n", "1", "/flight.log"},
+			Stdin:  strings.NewReader(""),
+			Stdout: out,
+			Stderr: &bytes.Buffer{},
+			Env:    map[string]string{"PWD": "/"},
+			Ctx:    ctx,
+		})
+	}()
+
+	if line := <-out; line != "two\r\n" {
+		t.Fatalf("expected the last line first, got %q", line)
+	}
+	// Appending rewrites the file with the old contents first
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "one\r\ntwo\r\nthree\r\n", "/flight.log"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+	if appended := <-out; appended != "three\r\n" {
+		t.Fatalf("expected the appended line, got %q", appended)
+	}
+
+	cancel()
+	if code := <-done; code != 0 {
+		t.Fatalf("expected tail -f to stop cleanly, got %d", code)
+	}
+}
+
+func TestYqCommand(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	doc := "pilots:\n  - name: alice\n    hours: 120\n  - name: bob\n    hours: 40\nfleet: ZS-ABC\n"
+
+	if code, stdout, stderr := runTestCommand(&CmdYq{FileStore: store}, tags, doc, `.pilots[] | select(.hours > 100 and .name != "bob") | .name`); code != 0 {
+		t.Fatalf("yq failed: %s", stderr)
+	} else if stdout != "alice\r\n" {
+		t.Fatalf("unexpected yq output %q", stdout)
+	}
+	if code, stdout, _ := runTestCommand(&CmdYq{FileStore: store}, tags, doc, "-j", ".pilots[-1]"); code != 0 || stdout != "{\"name\": \"bob\", \"hours\": 40}\r\n" {
+		t.Fatalf("unexpected yq -j output %q", stdout)
+	}
+	if code, stdout, _ := runTestCommand(&CmdYq{FileStore: store}, tags, `{"a": [1, 2, 3]}`, ".a | length"); code != 0 || stdout != "3\r\n" {
+		t.Fatalf("unexpected yq output for JSON input %q", stdout)
+	}
+	if code, _, _ := runTestCommand(&CmdYq{FileStore: store}, tags, doc, ".pilots["); code != 1 {
+		t.Fatalf("expected invalid expression to fail, got %d", code)
+	}
+
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, doc, "/crew.yaml"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdYq{FileStore: store}, tags, "", "-i", `del(.pilots[0]) | .fleet = "ZS-XYZ"`, "/crew.yaml"); code != 0 {
+		t.Fatalf("yq -i failed: %s", stderr)
+	}
+	if content, err := store.LookupReadAll(context.Background(), "/crew.yaml", tags); err != nil {
+		t.Fatal(err)
+	} else if string(content) != "pilots:\r\n- name: bob\r\n  hours: 40\r\nfleet: ZS-XYZ\r\n" {
+		t.Fatalf("unexpected file after yq -i %q", content)
+	}
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "a: 1\n---\na: 2\n", "/multi.yaml"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdYq{FileStore: store}, tags, "", "-i", ".a = 3", "/multi.yaml"); code != 0 {
+		t.Fatalf("yq -i failed: %s", stderr)
+	}
+	if content, err := store.LookupReadAll(context.Background(), "/multi.yaml", tags); err != nil {
+		t.Fatal(err)
+	} else if string(content) != "a: 3\r\n---\r\na: 3\r\n" {
+		t.Fatalf("unexpected file after yq -i on several documents %q", content)
+	}
+	if code, _, _ := runTestCommand(&CmdYq{FileStore: store}, []string{"guest"}, "", "-i", ".fleet = null", "/crew.yaml"); code != 1 {
+		t.Fatalf("expected yq -i without write access to fail, got %d", code)
+	}
+}
+
+func TestStatTouch(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+
+	if code, stdout, stderr := runTestCommand(&CmdTouch{FileStore: store}, tags, "", "-y", "/notes.txt"); code != 0 {
+		t.Fatalf("touch failed: %s", stderr)
+	} else if !strings.Contains(stdout, "created: true") {
+		t.Fatalf("expected touch to create the file, got %q", stdout)
+	}
+	before, err := store.Lookup(context.Background(), tags, "/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := store.LookupReadAll(context.Background(), "/notes.txt", tags); err != nil || len(content) != 0 {
+		t.Fatalf("expected an empty file, got %q (%v)", content, err)
+	}
+
+	time.Sleep(time.Millisecond)
+	if code, _, stderr := runTestCommand(&CmdTouch{FileStore: store}, tags, "", "/notes.txt"); code != 0 {
+		t.Fatalf("touch failed: %s", stderr)
+	}
+	if after, err := store.Lookup(context.Background(), tags, "/notes.txt"); err != nil {
+		t.Fatal(err)
+	} else if !after.Timestamps.ModifiedAt.After(before.Timestamps.ModifiedAt) || after.Revision != before.Revision {
+		t.Fatalf("expected touch to only bump the modified time, got %+v", after)
+	}
+	if code, _, _ := runTestCommand(&CmdTouch{FileStore: store}, tags, "", "-c", "/missing.txt"); code != 0 {
+		t.Fatalf("expected touch -c to ignore missing files, got %d", code)
+	}
+	if _, err := store.Lookup(context.Background(), tags, "/missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected touch -c not to create the file, got %v", err)
+	}
+
+	if code, stdout, stderr := runTestCommand(&CmdStat{FileStore: store}, tags, "", "-y", "/notes.txt"); code != 0 {
+		t.Fatalf("stat failed: %s", stderr)
+	} else {
+		var infos []statInfo
+		if err := yaml.Unmarshal([]byte(stdout), &infos); err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) != 1 || infos[0].ID != before.ID.Hex() || infos[0].Type != "file" || infos[0].Size != 0 || infos[0].ModifiedBy != "tester" {
+			t.Fatalf("unexpected stat output %+v", infos)
+		}
+		if !infos[0].Access.Read || !infos[0].Access.Write {
+			t.Fatalf("expected the owner to have read and write access, got %+v", infos[0].Access)
+		}
+	}
+	if _, err := store.Chmod(context.Background(), "/notes.txt", tags, "user", "-", types.WriteMode, false); err != nil {
+		t.Fatal(err)
+	}
+	if code, stdout, _ := runTestCommand(&CmdStat{FileStore: store}, tags, "", "-y", "/notes.txt"); code != 0 || !strings.Contains(stdout, "write: false") {
+		t.Fatalf("expected stat to report no write access, got %d %q", code, stdout)
+	}
+}
+
+func TestDuDf(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, tags, "", "-p", "/logs/old"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	for path, content := range map[string]string{
+		"/logs/a.log":     "abc",
+		"/logs/old/b.log": "error net\nok\n",
+	} {
+		if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, content, path); code != 0 {
+			t.Fatalf("tee failed: %s", stderr)
+		}
+	}
+
+	if code, stdout, stderr := runTestCommand(&CmdDu{FileStore: store}, tags, "", "/logs"); code != 0 {
+		t.Fatalf("du failed: %s", stderr)
+	} else if stdout != "13\t2\t/logs/old\r\n16\t4\t/logs\r\n" {
+		t.Fatalf("unexpected du output %q", stdout)
+	}
+	if code, stdout, _ := runTestCommand(&CmdDu{FileStore: store}, tags, "", "-s", "/logs", "/logs/a.log"); code != 0 || stdout != "16\t4\t/logs\r\n3\t1\t/logs/a.log\r\n" {
+		t.Fatalf("unexpected du -s output %q", stdout)
+	}
+
+	if _, err := store.Chmod(context.Background(), "/logs/old", tags, "user", "-", types.ExecuteMode, false); err != nil {
+		t.Fatal(err)
+	}
+	if code, stdout, _ := runTestCommand(&CmdDu{FileStore: store}, tags, "", "-s", "/logs"); code != 1 || stdout != "3\t3\t/logs\r\n" {
+		t.Fatalf("expected du to skip the unreadable directory and fail, got %d %q", code, stdout)
+	}
+
+	if code, _, _ := runTestCommand(&CmdDf{FileStore: store}, tags, "", "-y"); code != 1 {
+		t.Fatalf("expected df to need sysadmin, got %d", code)
+	}
+	if code, stdout, stderr := runTestCommand(&CmdDf{FileStore: store}, []string{"sysadmin"}, "", "-y"); code != 0 {
+		t.Fatalf("df failed: %s", stderr)
+	} else {
+		var usage filesystem.DiskUsage
+		if err := yaml.Unmarshal([]byte(stdout), &usage); err != nil {
+			t.Fatal(err)
+		}
+		if usage.Entries != 5 || usage.Files != 2 || usage.Directories != 3 || usage.Storage.Blobs != 2 || usage.Storage.Bytes != 16 || usage.OrphanedBlobs != 0 {
+			t.Fatalf("unexpected df output %+v", usage)
+		}
+	}
+}
+
+func TestLockUnlock(t *testing.T) {
+	store := newTestStore(t)
+	store.Locks = filesystem.NewLockTable()
+	tags := []string{"user"}
+	if code, _, stderr := runTestCommand(&CmdTouch{FileStore: store}, tags, "", "/a.txt"); code != 0 {
+		t.Fatalf("touch failed: %s", stderr)
+	}
+
+	code, stdout, stderr := runTestCommand(&CmdLock{FileStore: store}, tags, "", "-y", "-t", "1m", "/a.txt")
+	if code != 0 {
+		t.Fatalf("lock failed: %s", stderr)
+	}
+	var locks []types.FileLock
+	if err := yaml.Unmarshal([]byte(stdout), &locks); err != nil {
+		t.Fatalf("invalid lock YAML: %v\n%s", err, stdout)
+	}
+	if len(locks) != 1 || locks[0].Mode != types.ExclusiveLock || locks[0].Path != "/a.txt" || locks[0].Username != "tester" {
+		t.Fatalf("unexpected lock: %+v", locks)
+	}
+
+	// Another socket's lock stops reads through cat
+	held, err := store.Locks.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := held[0]
+	other.Owner.SocketID = "other-socket"
+	store.Locks.ReleaseSocket(context.Background(), "test-socket")
+	if _, err := store.Locks.Acquire(context.Background(), other, false); err != nil {
+		t.Fatal(err)
+	}
+	fsctx := filesystem.FSContext{Store: store, UserTags: tags, HonourLocks: true}
+	if code, _, stderr := runTestCommand(&CmdCat{FSCtx: fsctx}, tags, "", "/a.txt"); code == 0 || !strings.Contains(stderr, "locked") {
+		t.Fatalf("expected cat to fail on a locked file, got %d: %s", code, stderr)
+	}
+	if code, _, _ := runTestCommand(&CmdLock{FileStore: store}, tags, "", "-s", "/a.txt"); code == 0 {
+		t.Fatal("expected shared lock to conflict with an exclusive one")
+	}
+	if code, _, _ := runTestCommand(&CmdUnlock{FileStore: store}, tags, "", "/a.txt"); code == 0 {
+		t.Fatal("expected unlock to fail without holding the lock")
+	}
+	if code, _, _ := runTestCommand(&CmdUnlock{FileStore: store}, tags, "", "-f", "/a.txt"); code == 0 {
+		t.Fatal("expected non-sysadmins to be refused breaking locks")
+	}
+	if code, _, stderr := runTestCommand(&CmdUnlock{FileStore: store}, []string{"sysadmin"}, "", "-f", "/a.txt"); code != 0 {
+		t.Fatalf("unlock -f failed: %s", stderr)
+	}
+	if code, stdout, stderr := runTestCommand(&CmdLock{FileStore: store}, tags, "", "-l"); code != 0 || stdout != "" {
+		t.Fatalf("expected no locks left, got %d: %q %s", code, stdout, stderr)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "hello", "/a.txt"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+
+	code, stdout, stderr := runTestCommand(&CmdStat{FileStore: store}, tags, "", "-y", "/a.txt")
+	if code != 0 {
+		t.Fatalf("stat failed: %s", stderr)
+	}
+	if !strings.Contains(stdout, "sha256: "+filesystem.Checksum([]byte("hello"))) {
+		t.Fatalf("expected stat to show the checksum, got %s", stdout)
+	}
+
+	if code, stdout, stderr := runTestCommand(&CmdVerify{FileStore: store}, tags, "", "/a.txt"); code != 0 || !strings.Contains(stdout, "verified\t1") {
+		t.Fatalf("verify failed (%d): %s %s", code, stdout, stderr)
+	}
+	if code, _, _ := runTestCommand(&CmdVerify{FileStore: store}, tags, ""); code == 0 {
+		t.Fatal("expected verifying every blob to need sysadmin")
+	}
+	if code, stdout, stderr := runTestCommand(&CmdVerify{FileStore: store}, []string{"sysadmin"}, "", "-y"); code != 0 || !strings.Contains(stdout, "scanned_blobs: 1") {
+		t.Fatalf("verify failed (%d): %s %s", code, stdout, stderr)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	store := newTestStore(t)
+	tags := []string{"user"}
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, tags, "", "-p", "/flights/2024", "/backup", "/restore"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	if code, _, stderr := runTestCommand(&CmdTee{FileStore: store}, tags, "t,hr\n0,60\n", "/flights/2024/f1.csv"); code != 0 {
+		t.Fatalf("tee failed: %s", stderr)
+	}
+
+	// Through stdout and stdin, base64-encoded
+	code, archive, stderr := runTestCommand(&CmdExport{FileStore: store}, tags, "", "-zb", "/flights")
+	if code != 0 {
+		t.Fatalf("export failed: %s", stderr)
+	}
+	if code, stdout, stderr := runTestCommand(&CmdImport{FileStore: store}, tags, archive, "-bp", "/restore"); code != 0 || !strings.Contains(stdout, "files\t1") {
+		t.Fatalf("import failed (%d): %s %s", code, stdout, stderr)
+	}
+	if code, stdout, _ := runTestCommand(&CmdCat{FSCtx: filesystem.FSContext{Store: store, UserTags: tags}}, tags, "", "/restore/flights/2024/f1.csv"); code != 0 || !strings.HasPrefix(stdout, "t,hr\n0,60\n") {
+		t.Fatalf("unexpected restored contents %q", stdout)
+	}
+
+	// Through files in the VFS
+	if code, _, stderr := runTestCommand(&CmdExport{FileStore: store}, tags, "", "-o", "/backup/flights.tar", "/flights"); code != 0 {
+		t.Fatalf("export failed: %s", stderr)
+	}
+	if code, stdout, stderr := runTestCommand(&CmdImport{FileStore: store}, tags, "", "-i", "/backup/flights.tar", "/restore"); code == 0 || !strings.Contains(stdout, "skipped\tflights/2024/f1.csv") {
+		t.Fatalf("expected the existing file to be skipped (%d): %s %s", code, stdout, stderr)
+	}
+	if code, stdout, stderr := runTestCommand(&CmdImport{FileStore: store}, tags, "", "-fy", "-i", "/backup/flights.tar", "/restore"); code != 0 || !strings.Contains(stdout, "files: 1") {
+		t.Fatalf("import failed (%d): %s %s", code, stdout, stderr)
+	}
+}
+
+func TestToken(t *testing.T) {
+	store := newTestStore(t)
+	sysadmin := []string{"sysadmin"}
+	if code, _, stderr := runTestCommand(&CmdMkdir{FileStore: store}, sysadmin, "", "/etc"); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+
+	code, token, stderr := runTestCommand(&CmdToken{FileStore: store}, []string{"user"}, "", "-c", "laptop")
+	if code != 0 {
+		t.Fatalf("token failed: %s", stderr)
+	}
+	token = strings.TrimSpace(token)
+	if username, err := auth.TokenUser(context.Background(), store, token); err != nil || username != "tester" {
+		t.Fatalf("expected the token to belong to tester, got %q (%v)", username, err)
+	}
+	if code, _, _ := runTestCommand(&CmdToken{FileStore: store}, []string{"user"}, "", "-c", "laptop"); code == 0 {
+		t.Fatal("expected a second token with the same name to be refused")
+	}
+	if code, stdout, stderr := runTestCommand(&CmdToken{FileStore: store}, []string{"user"}, "", "-y"); code != 0 || !strings.Contains(stdout, "name: laptop") || strings.Contains(stdout, token) {
+		t.Fatalf("unexpected token list (%d): %s %s", code, stdout, stderr)
+	}
+
+	if code, _, stderr := runTestCommand(&CmdToken{FileStore: store}, []string{"user"}, "", "-r", "laptop"); code != 0 {
+		t.Fatalf("revoke failed: %s", stderr)
+	}
+	if _, err := auth.TokenUser(context.Background(), store, token); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the revoked token to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/auth"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdToken struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdToken) Identifier() string {
+	return "token"
+}
+
+func (c *CmdToken) Run(ctx sh.CommandContext) int {
+	const usage = "usage: token [-y] [-c NAME | -r NAME]"
+	opts, args, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "create",
+			Aliases:    []string{"c", "create"},
+			Default:    "",
+		},
+		{
+			Identifier: "revoke",
+			Aliases:    []string{"r", "revoke"},
+			Default:    "",
+		},
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	create, revoke := opts["create"].(string), opts["revoke"].(string)
+	if err != nil || len(args) != 0 || (create != "" && revoke != "") {
+		if err != nil {
+			fmt.Fprint(ctx.Stderr, err, "\r\n")
+		}
+		fmt.Fprint(ctx.Stderr, usage)
+		return 1
+	}
+	username := util.GetAuthStatus(ctx.Ctx).Username
+
+	switch {
+	case create != "":
+		token, err := auth.IssueToken(ctx.Ctx, c.FileStore, username, create)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot create token: %v", err)
+			return 1
+		}
+		fmt.Fprint(ctx.Stdout, token, "\r\n")
+	case revoke != "":
+		if err := auth.RevokeToken(ctx.Ctx, c.FileStore, username, revoke); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot revoke token: %v", err)
+			return 1
+		}
+	default:
+		tokens, err := auth.ListTokens(ctx.Ctx, c.FileStore, username)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot list tokens: %v", err)
+			return 1
+		}
+		for _, token := range tokens {
+			if opts["yaml_output"].(bool) {
+				data, err := util.YamlCRLF(token)
+				if err != nil {
+					fmt.Fprintf(ctx.Stderr, "error formatting YAML: %v", err)
+					return 1
+				}
+				fmt.Fprint(ctx.Stdout, "- ")
+				fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+			} else {
+				fmt.Fprintf(ctx.Stdout, "%s\t%s\r\n", token.CreatedAt.Format(time.RFC3339), token.Name)
+			}
+		}
+	}
+
+	return 0
+}
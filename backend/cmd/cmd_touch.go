@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdTouch struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdTouch) Identifier() string {
+	return "touch"
+}
+
+func (c *CmdTouch) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "no_create",
+			Aliases:    []string{"c", "no-create"},
+			Default:    false,
+		},
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil || len(paths) == 0 {
+		if err != nil {
+			fmt.Fprint(ctx.Stderr, err, "\r\n")
+		}
+		fmt.Fprint(ctx.Stderr, "usage: touch [-cy] <PATHS...>")
+		return 1
+	}
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+
+	result := 0
+	for _, path := range paths {
+		abs_path, err := filesystem.AbsPath(cwd, path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v\r\n", path, err)
+			result = 1
+			continue
+		}
+		entry, created, err := c.FileStore.Touch(ctx.Ctx, abs_path, tags, opts["no_create"].(bool))
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot touch %q: %v\r\n", path, err)
+			result = 1
+			continue
+		} else if entry == nil {
+			continue
+		}
+
+		if opts["yaml_output"].(bool) {
+			data, err := util.YamlCRLF(map[string]any{
+				"path":        abs_path,
+				"id":          entry.ID.Hex(),
+				"created":     created,
+				"modified_at": entry.Timestamps.ModifiedAt.Format(time.RFC3339),
+			})
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error formatting YAML: %v", err)
+				return 1
+			}
+			fmt.Fprint(ctx.Stdout, "- ")
+			fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+		}
+	}
+
+	return result
+}
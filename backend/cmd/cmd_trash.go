@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdTrash struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdTrash) Identifier() string {
+	return "trash"
+}
+
+func (c *CmdTrash) Run(ctx sh.CommandContext) int {
+	tags := util.GetTags(ctx.Ctx)
+	auth_status := util.GetAuthStatus(ctx.Ctx)
+	const usage = "usage: trash [-y] [-u USER] [-r IDS... | -p IDS... | --empty]"
+
+	opts, ids, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "restore",
+			Aliases:    []string{"r", "restore"},
+			Default:    false,
+		},
+		{
+			Identifier: "purge",
+			Aliases:    []string{"p", "purge"},
+			Default:    false,
+		},
+		{
+			Identifier: "empty",
+			Aliases:    []string{"empty"},
+			Default:    false,
+		},
+		{
+			Identifier: "user",
+			Aliases:    []string{"u", "user"},
+			Default:    "",
+		},
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err)
+		return 1
+	}
+
+	restore, purge, empty := opts["restore"].(bool), opts["purge"].(bool), opts["empty"].(bool)
+	modes := 0
+	for _, set := range []bool{restore, purge, empty} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 || ((restore || purge) && len(ids) == 0) || ((modes == 0 || empty) && len(ids) != 0) {
+		fmt.Fprint(ctx.Stderr, usage)
+		return 1
+	}
+
+	username := auth_status.Username
+	if user := opts["user"].(string); user != "" && user != username {
+		// Other users' trash is only for sysadmins
+		if !slices.Contains(tags, "sysadmin") {
+			fmt.Fprint(ctx.Stderr, "error: not enough permissions to run this command\r\n")
+			return 1
+		}
+		username = user
+	}
+
+	switch {
+	case restore:
+		for _, id := range ids {
+			if _, err := c.FileStore.RestoreTrash(ctx.Ctx, username, id, tags); err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: failed to restore %q: %v", id, err)
+				return 1
+			}
+		}
+	case purge, empty:
+		if _, err := c.FileStore.PurgeTrash(ctx.Ctx, username, ids); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: failed to purge trash: %v", err)
+			return 1
+		}
+	default:
+		items, err := c.FileStore.ListTrash(ctx.Ctx, username)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: failed to list trash: %v", err)
+			return 1
+		}
+
+		for _, item := range items {
+			if opts["yaml_output"].(bool) {
+				data, err := util.YamlCRLF(item)
+				if err != nil {
+					fmt.Fprintf(ctx.Stderr, "error formatting trash YAML: %v", err)
+					return 1
+				}
+
+				fmt.Fprint(ctx.Stdout, "- ")
+				fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+			} else {
+				fmt.Fprint(ctx.Stdout,
+					item.ID, "\t",
+					item.Type, "\t",
+					item.DeletedAt.Format("Jan _2 15:04 2006"), "\t",
+					item.DeletedBy, "\t",
+					item.OriginalPath, "\r\n")
+			}
+		}
+	}
+
+	return 0
+}
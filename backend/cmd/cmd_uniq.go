@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdUniq struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdUniq) Identifier() string {
+	return "uniq"
+}
+
+func (c *CmdUniq) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "count",
+			Aliases:    []string{"c", "count"},
+			Default:    false,
+		},
+		{
+			Identifier: "repeated",
+			Aliases:    []string{"d", "repeated"},
+			Default:    false,
+		},
+		{
+			Identifier: "unique",
+			Aliases:    []string{"u", "unique"},
+			Default:    false,
+		},
+		{
+			Identifier: "ignore_case",
+			Aliases:    []string{"i", "ignore-case"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\nusage: uniq [-cdui] [FILES...]")
+		return 1
+	}
+	count, repeated, unique := opts["count"].(bool), opts["repeated"].(bool), opts["unique"].(bool)
+	same := func(a, b string) bool {
+		if opts["ignore_case"].(bool) {
+			return strings.EqualFold(a, b)
+		}
+		return a == b
+	}
+
+	// Only adjacent lines are compared, so input is streamed
+	var current string
+	run := 0
+	flush := func() {
+		if run == 0 || (repeated && run == 1) || (unique && run > 1) {
+			return
+		}
+		if count {
+			fmt.Fprintf(ctx.Stdout, "%7d ", run)
+		}
+		fmt.Fprint(ctx.Stdout, current, "\r\n")
+	}
+
+	result := forEachInput(ctx, c.FileStore, paths, func(name string, r io.Reader) error {
+		scanner := newLineScanner(r)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if run > 0 && same(current, line) {
+				run++
+				continue
+			}
+			flush()
+			current, run = line, 1
+		}
+		return scanner.Err()
+	})
+	flush()
+
+	return result
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdUnlock struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdUnlock) Identifier() string {
+	return "unlock"
+}
+
+func (c *CmdUnlock) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "force",
+			Aliases:    []string{"f", "force"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil || len(paths) == 0 {
+		if err != nil {
+			fmt.Fprint(ctx.Stderr, err, "\r\n")
+		}
+		fmt.Fprint(ctx.Stderr, "usage: unlock [-f] <PATHS...>")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+	force := opts["force"].(bool)
+	if force && !slices.Contains(tags, "sysadmin") {
+		fmt.Fprint(ctx.Stderr, "error: not enough permissions to break other users' locks\r\n")
+		return 1
+	}
+	owner, ok := util.GetLockOwner(ctx.Ctx)
+	if !ok && !force {
+		fmt.Fprint(ctx.Stderr, filesystem.ErrNoLockOwner)
+		return 1
+	}
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+
+	result := 0
+	for _, path := range paths {
+		abs_path, err := filesystem.AbsPath(cwd, path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v\r\n", path, err)
+			result = 1
+			continue
+		}
+		if force {
+			_, err = c.FileStore.BreakLocks(ctx.Ctx, abs_path, tags)
+		} else {
+			err = c.FileStore.Unlock(ctx.Ctx, abs_path, tags, owner)
+		}
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot unlock %q: %v\r\n", path, err)
+			result = 1
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CmdVerify struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdVerify) Identifier() string {
+	return "verify"
+}
+
+func (c *CmdVerify) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\nusage: verify [-y] [FILES...]")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+
+	// Without paths every blob is checked, which only sysadmins may do
+	var ids []primitive.ObjectID
+	names := map[primitive.ObjectID][]string{}
+	if len(paths) == 0 {
+		if !slices.Contains(tags, "sysadmin") {
+			fmt.Fprint(ctx.Stderr, "error: not enough permissions to verify every file (give paths to verify your own)\r\n")
+			return 1
+		}
+	} else {
+		cwd, ok := ctx.Env["PWD"]
+		if !ok {
+			fmt.Fprint(ctx.Stderr, "error: no PWD available")
+			return 1
+		}
+		ids = []primitive.ObjectID{}
+		for _, path := range paths {
+			abs_path, err := filesystem.AbsPath(cwd, path)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", path, err)
+				return 1
+			}
+			entry, err := c.FileStore.Lookup(ctx.Ctx, tags, abs_path)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error: cannot verify %q: %v", path, err)
+				return 1
+			}
+			if entry.EntryType != types.File {
+				fmt.Fprintf(ctx.Stderr, "error: cannot verify %q: not a file", path)
+				return 1
+			}
+			if !entry.Permissions.IsAllowed(types.ReadMode, tags) {
+				fmt.Fprintf(ctx.Stderr, "error: cannot verify %q: %v", path, types.ErrCantAccessFs)
+				return 1
+			}
+			// Files without contents have nothing to verify
+			if entry.FileReference != nil {
+				if _, seen := names[*entry.FileReference]; !seen {
+					ids = append(ids, *entry.FileReference)
+				}
+				names[*entry.FileReference] = append(names[*entry.FileReference], abs_path)
+			}
+		}
+	}
+
+	report, err := c.FileStore.VerifyBlobs(ctx.Ctx, ids)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: verify failed: %v", err)
+		return 1
+	}
+
+	if opts["yaml_output"].(bool) {
+		data, err := util.YamlCRLF(report)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error formatting report YAML: %v", err)
+			return 1
+		}
+		fmt.Fprint(ctx.Stdout, string(data))
+	} else {
+		describe := func(hex string) string {
+			if id, err := primitive.ObjectIDFromHex(hex); err == nil && len(names[id]) > 0 {
+				return fmt.Sprintf("%s (%s)", hex, strings.Join(names[id], ", "))
+			}
+			return hex
+		}
+		fmt.Fprintf(ctx.Stdout, "scanned\t%d blobs (%d bytes)\r\n", report.ScannedBlobs, report.ScannedBytes)
+		fmt.Fprintf(ctx.Stdout, "verified\t%d\r\n", report.VerifiedBlobs)
+		fmt.Fprintf(ctx.Stdout, "recorded\t%d\r\n", report.RecordedBlobs)
+		for _, blob := range report.Corrupt {
+			fmt.Fprintf(ctx.Stdout, "corrupt\t%s: expected sha256 %s, got %s\r\n", describe(blob.ID), blob.Expected, blob.Actual)
+		}
+		for _, id := range report.Missing {
+			fmt.Fprintf(ctx.Stdout, "missing\t%s\r\n", describe(id))
+		}
+	}
+
+	if len(report.Corrupt) > 0 || len(report.Missing) > 0 {
+		return 1
+	}
+	return 0
+}
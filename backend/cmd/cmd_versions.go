@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdVersions struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdVersions) Identifier() string {
+	return "versions"
+}
+
+func (c *CmdVersions) Run(ctx sh.CommandContext) int {
+	tags := util.GetTags(ctx.Ctx)
+
+	opts, args, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "cat",
+			Aliases:    []string{"c", "cat"},
+			Default:    "",
+		},
+		{
+			Identifier: "restore",
+			Aliases:    []string{"r", "restore"},
+			Default:    "",
+		},
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err)
+		return 1
+	}
+
+	cat_version := opts["cat"].(string)
+	restore_version := opts["restore"].(string)
+	if len(args) != 1 || (cat_version != "" && restore_version != "") {
+		fmt.Fprint(ctx.Stderr, "usage: versions [-y] [-c VERSION | -r VERSION] <FILE>")
+		return 1
+	}
+
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	abs_path, err := filesystem.AbsPath(cwd, args[0])
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", args[0], err)
+		return 1
+	}
+
+	if cat_version != "" {
+		version, err := strconv.Atoi(cat_version)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid version %q", cat_version)
+			return 1
+		}
+
+		reader, err := c.FileStore.ReadVersion(ctx.Ctx, abs_path, tags, version)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error reading version %d of %q: %v", version, args[0], err)
+			return 1
+		}
+		defer reader.Close()
+
+		if _, err := io.Copy(ctx.Stdout, reader); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error reading version %d of %q: %v", version, args[0], err)
+			return 1
+		}
+		return 0
+	}
+
+	if restore_version != "" {
+		version, err := strconv.Atoi(restore_version)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid version %q", restore_version)
+			return 1
+		}
+
+		if _, err := c.FileStore.RestoreVersion(ctx.Ctx, abs_path, tags, version); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error restoring version %d of %q: %v", version, args[0], err)
+			return 1
+		}
+		return 0
+	}
+
+	entry, err := c.FileStore.Lookup(ctx.Ctx, tags, abs_path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to get file (%q): %v", args[0], err)
+		return 1
+	}
+	if !entry.Permissions.IsAllowed(types.ReadMode, tags) {
+		fmt.Fprintf(ctx.Stderr, "error: cannot read file (%q): %v", args[0], types.ErrCantAccessFs)
+		return 1
+	}
+	if entry.EntryType != types.File {
+		fmt.Fprintf(ctx.Stderr, "error: (abs_path %q) is not a file", abs_path)
+		return 1
+	}
+
+	// Newest first, matching the numbering used by -c and -r
+	for i := len(entry.Versions) - 1; i >= 0; i-- {
+		version := entry.Versions[i]
+		number := len(entry.Versions) - i
+		file_size, err := c.FileStore.Blobs.Size(ctx.Ctx, version.FileReference)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error checking size of version %d: %v", number, err)
+			return 1
+		}
+		modify_time := version.ModifiedAt.Format("Jan _2 15:04 2006")
+
+		if yaml_output := opts["yaml_output"].(bool); yaml_output {
+			data, err := util.YamlCRLF(map[string]any{
+				"version":       number,
+				"modified_time": modify_time,
+				"modified_by":   version.ModifiedBy,
+				"file_size":     file_size,
+			})
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error formatting version YAML: %v", err)
+				return 1
+			}
+
+			fmt.Fprint(ctx.Stdout, "- ")
+			fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+		} else {
+			fmt.Fprint(ctx.Stdout, number, "\t", modify_time, "\t", version.ModifiedBy, "\t", file_size, "\r\n")
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdWatch struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdWatch) Identifier() string {
+	return "watch"
+}
+
+func (c *CmdWatch) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "recursive",
+			Aliases:    []string{"r", "recursive"},
+			Default:    false,
+		},
+		{
+			Identifier: "yaml_output",
+			Aliases:    []string{"y", "yaml"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\nusage: watch [-ry] [PATHS...]")
+		return 1
+	}
+	if c.FileStore.Watcher == nil {
+		fmt.Fprint(ctx.Stderr, "error: watching is not available")
+		return 1
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+
+	// Events are matched by path, so watch where symlinks lead instead of the links themselves
+	abs_paths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		abs_path, err := filesystem.AbsPath(cwd, path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", path, err)
+			return 1
+		}
+		if abs_path, err = c.FileStore.Resolve(ctx.Ctx, tags, abs_path); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot watch %q: %v", path, err)
+			return 1
+		}
+		if _, err := c.FileStore.Lookup(ctx.Ctx, tags, abs_path); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: cannot watch %q: %v", path, err)
+			return 1
+		}
+		abs_paths = append(abs_paths, abs_path)
+	}
+
+	subscription, err := c.FileStore.Watcher.Subscribe(ctx.Ctx, abs_paths, opts["recursive"].(bool), tags)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: cannot watch: %v", err)
+		return 1
+	}
+	defer subscription.Unsubscribe()
+
+	for {
+		event, err := subscription.Next(ctx.Ctx)
+		if err != nil {
+			// Interrupted
+			return 0
+		}
+
+		if opts["yaml_output"].(bool) {
+			data, err := util.YamlCRLF(event)
+			if err != nil {
+				fmt.Fprintf(ctx.Stderr, "error formatting event YAML: %v", err)
+				return 1
+			}
+			fmt.Fprint(ctx.Stdout, "- ")
+			fmt.Fprint(ctx.Stdout, string(bytes.ReplaceAll(data, []byte("\n"), []byte("\n  "))), "\r\n")
+		} else if event.Kind == filesystem.WatchMove {
+			fmt.Fprintf(ctx.Stdout, "%s\t%s\t%s -> %s\r\n", event.Time.Format(time.RFC3339), event.Kind, event.OldPath, event.Path)
+		} else {
+			fmt.Fprintf(ctx.Stdout, "%s\t%s\t%s\r\n", event.Time.Format(time.RFC3339), event.Kind, event.Path)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+type CmdWc struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdWc) Identifier() string {
+	return "wc"
+}
+
+func (c *CmdWc) Run(ctx sh.CommandContext) int {
+	opts, paths, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "lines",
+			Aliases:    []string{"l", "lines"},
+			Default:    false,
+		},
+		{
+			Identifier: "words",
+			Aliases:    []string{"w", "words"},
+			Default:    false,
+		},
+		{
+			Identifier: "bytes",
+			Aliases:    []string{"c", "bytes"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\nusage: wc [-lwc] [FILES...]")
+		return 1
+	}
+	show_lines, show_words, show_bytes := opts["lines"].(bool), opts["words"].(bool), opts["bytes"].(bool)
+	if !show_lines && !show_words && !show_bytes {
+		show_lines, show_words, show_bytes = true, true, true
+	}
+
+	report := func(lines, words, bytes int64, name string) {
+		counts := []string{}
+		if show_lines {
+			counts = append(counts, fmt.Sprint(lines))
+		}
+		if show_words {
+			counts = append(counts, fmt.Sprint(words))
+		}
+		if show_bytes {
+			counts = append(counts, fmt.Sprint(bytes))
+		}
+		if name != "-" {
+			counts = append(counts, name)
+		}
+		fmt.Fprint(ctx.Stdout, strings.Join(counts, "\t"), "\r\n")
+	}
+
+	var total_lines, total_words, total_bytes int64
+	result := forEachInput(ctx, c.FileStore, paths, func(name string, r io.Reader) error {
+		var lines, words, bytes int64
+		in_word := false
+		reader := bufio.NewReader(r)
+		for {
+			char, size, err := reader.ReadRune()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			bytes += int64(size)
+			if char == '\n' {
+				lines++
+			}
+			if unicode.IsSpace(char) {
+				in_word = false
+			} else if !in_word {
+				in_word = true
+				words++
+			}
+		}
+
+		report(lines, words, bytes, name)
+		total_lines, total_words, total_bytes = total_lines+lines, total_words+words, total_bytes+bytes
+		return nil
+	})
+
+	if len(paths) > 1 {
+		report(total_lines, total_words, total_bytes, "total")
+	}
+	return result
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+	"github.com/goccy/go-yaml"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CmdYq struct {
+	FileStore filesystem.Store
+}
+
+func (*CmdYq) Identifier() string {
+	return "yq"
+}
+
+func (c *CmdYq) Run(ctx sh.CommandContext) int {
+	const usage = "usage: yq [-j | -o yaml|json] [-i] EXPRESSION [FILES...]"
+	opts, args, err := util.ParseArgs([]types.OptionDescriptor{
+		{
+			Identifier: "output",
+			Aliases:    []string{"o", "output-format"},
+			Default:    "yaml",
+		},
+		{
+			Identifier: "json",
+			Aliases:    []string{"j", "json"},
+			Default:    false,
+		},
+		{
+			Identifier: "in_place",
+			Aliases:    []string{"i", "in-place"},
+			Default:    false,
+		},
+	}, ctx.Args[1:])
+	if err != nil {
+		fmt.Fprint(ctx.Stderr, err, "\r\n", usage)
+		return 1
+	}
+	if len(args) == 0 {
+		fmt.Fprint(ctx.Stderr, "error: missing expression\r\n", usage)
+		return 1
+	}
+	as_json := opts["json"].(bool)
+	switch opts["output"].(string) {
+	case "yaml", "yml", "y":
+	case "json", "j":
+		as_json = true
+	default:
+		fmt.Fprintf(ctx.Stderr, "error: unknown output format %q\r\n%s", opts["output"], usage)
+		return 1
+	}
+
+	expr, err := util.ParseYQ(args[0])
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: %v", err)
+		return 1
+	}
+	paths := args[1:]
+
+	if opts["in_place"].(bool) {
+		if len(paths) != 1 || paths[0] == "-" {
+			fmt.Fprint(ctx.Stderr, "error: -i edits exactly one file\r\n", usage)
+			return 1
+		}
+		return c.editInPlace(ctx, expr, paths[0], as_json)
+	}
+
+	first := true
+	return forEachInput(ctx, c.FileStore, paths, func(name string, r io.Reader) error {
+		return yqEachDocument(r, func(doc any) error {
+			results, err := expr.Eval(doc)
+			if err != nil {
+				return err
+			}
+			for _, result := range results {
+				text, structured, err := yqFormat(result, as_json)
+				if err != nil {
+					return err
+				}
+				// Keep multiple YAML documents apart, so the output parses again
+				if structured && !as_json && !first {
+					fmt.Fprint(ctx.Stdout, "---\r\n")
+				}
+				first = false
+				fmt.Fprint(ctx.Stdout, strings.ReplaceAll(text, "\n", "\r\n"), "\r\n")
+			}
+			return nil
+		})
+	})
+}
+
+// editInPlace replaces every document in the file with the expression's result
+func (c *CmdYq) editInPlace(ctx sh.CommandContext, expr *util.YQExpr, path string, as_json bool) int {
+	tags := util.GetTags(ctx.Ctx)
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available")
+		return 1
+	}
+	abs_path, err := filesystem.AbsPath(cwd, path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", path, err)
+		return 1
+	}
+	// Edit symlink targets instead of replacing the link
+	abs_path, err = c.FileStore.Resolve(ctx.Ctx, tags, abs_path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to resolve path (%q): %v", path, err)
+		return 1
+	}
+	folder_path, filename, err := filesystem.DirUp(abs_path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v", abs_path, err)
+		return 1
+	}
+
+	parent, err := c.FileStore.Lookup(ctx.Ctx, tags, folder_path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to get folder (%q): %v", folder_path, err)
+		return 1
+	}
+	if !parent.Permissions.IsAllowed(types.WriteMode, tags) {
+		fmt.Fprintf(ctx.Stderr, "error: cannot write to folder (%q)", folder_path)
+		return 1
+	}
+	entry, err := c.FileStore.Lookup(ctx.Ctx, tags, abs_path)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error opening file (%q): %v", path, err)
+		return 1
+	}
+	reader, err := c.FileStore.ReadFileObj(ctx.Ctx, *entry, tags)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error opening file (%q): %v", path, err)
+		return 1
+	}
+
+	docs := []string{}
+	err = yqEachDocument(reader, func(doc any) error {
+		results, err := expr.Eval(doc)
+		if err != nil {
+			return err
+		}
+		if len(results) != 1 {
+			return fmt.Errorf("expression must produce one document per input document, got %d", len(results))
+		}
+		text, _, err := yqFormat(results[0], as_json)
+		// Written back with CRLF line endings, like every other YAML the store writes (see util.YamlCRLF)
+		docs = append(docs, strings.ReplaceAll(text, "\n", "\r\n")+"\r\n")
+		return err
+	})
+	reader.Close()
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error editing file (%q): %v", path, err)
+		return 1
+	}
+
+	fileRef := primitive.NewObjectID()
+	stream, err := c.FileStore.Blobs.OpenUpload(ctx.Ctx, fileRef)
+	if err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to open upload stream: %v", err)
+		return 1
+	}
+	if _, err := io.WriteString(stream, strings.Join(docs, "---\r\n")); err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to write to upload stream: %v", err)
+		stream.Close()
+		return 1
+	}
+	if err := stream.Close(); err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to close upload stream: %v", err)
+		return 1
+	}
+
+	// Fail instead of overwriting a write that happened while the file was being edited
+	if _, err := c.FileStore.WriteFileIfRevision(ctx.Ctx, parent.ID, filename, fileRef, tags, entry.Revision); err != nil {
+		fmt.Fprintf(ctx.Stderr, "error: failed to write file (%q): %v", path, err)
+		return 1
+	}
+	return 0
+}
+
+// yqEachDocument decodes every YAML (or JSON) document in r, keeping map key order
+func yqEachDocument(r io.Reader, fn func(doc any) error) error {
+	decoder := yaml.NewDecoder(r, yaml.UseOrderedMap())
+	for {
+		var doc any
+		if err := decoder.Decode(&doc); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
+
+// yqFormat renders a result without a trailing newline. Scalars are printed raw in YAML mode.
+func yqFormat(value any, as_json bool) (text string, structured bool, err error) {
+	switch value.(type) {
+	case yaml.MapSlice, []any:
+		structured = true
+	case string:
+		if !as_json {
+			return value.(string), false, nil
+		}
+	case nil:
+		return "null", false, nil
+	}
+
+	var out []byte
+	if as_json {
+		out, err = yaml.MarshalWithOptions(value, yaml.JSON())
+	} else {
+		out, err = yaml.Marshal(value)
+	}
+	return string(bytes.TrimRight(out, "\n")), structured, err
+}
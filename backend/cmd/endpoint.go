@@ -49,8 +49,9 @@ func CmdWebhook(
 		available_commands := InitCommands(
 			filestore,
 			filesystem.FSContext{
-				Store:    filestore,
-				UserTags: auth_status.Tags,
+				Store:       filestore,
+				UserTags:    auth_status.Tags,
+				HonourLocks: true,
 			},
 			session,
 			sessionStore,
@@ -91,6 +92,14 @@ func CmdWebhook(
 
 			wg.Wait()
 			sessionStore.DetachSession(socketID)
+			// Locks belong to the socket's clients, so nothing can release them after this
+			if filestore.Locks != nil {
+				if released, err := filestore.Locks.ReleaseSocket(context.Background(), socketID); err != nil {
+					log.Printf("Couldn't release locks held by socket %q: %v", socketID, err)
+				} else if released > 0 {
+					log.Printf("Released %d locks held by socket %q", released, socketID)
+				}
+			}
 		}()
 
 		wg.Add(1)
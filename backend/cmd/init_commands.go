@@ -29,6 +29,7 @@ func InitCommands(
 		CmdHelp{},
 		&CmdChangePassword{FileStore: filestore},
 		&CmdLogout{FileStore: filestore},
+		&CmdToken{FileStore: filestore},
 
 		CmdEcho{},
 		CmdError{},
@@ -36,18 +37,35 @@ func InitCommands(
 
 		&CmdLs{FileStore: filestore},
 		&CmdMkdir{FileStore: filestore},
+		&CmdStat{FileStore: filestore},
+		&CmdTouch{FileStore: filestore},
 		&CmdCat{FSCtx: fsctx},
 		&CmdTee{FileStore: filestore},
 		&CmdRm{FileStore: filestore},
 		&CmdMv{FileStore: filestore},
 		&CmdChmod{FileStore: filestore},
+		&CmdDefaults{FileStore: filestore},
 		&CmdCopy{FileStore: filestore},
+		&CmdLn{FileStore: filestore},
+		&CmdVersions{FileStore: filestore},
+		&CmdQuota{FileStore: filestore},
+		&CmdDu{FileStore: filestore},
+		&CmdVerify{FileStore: filestore},
+		&CmdTrash{FileStore: filestore},
+		&CmdWatch{FileStore: filestore},
+		&CmdLock{FileStore: filestore},
+		&CmdUnlock{FileStore: filestore},
+		&CmdExport{FileStore: filestore},
+		&CmdImport{FileStore: filestore},
 
 		&CmdEmbed{Conn: jsonConn, FileStore: filestore},
 		CmdCryptoRand{},
 
 		&CmdClients{Socket: socketSession},
 		&CmdSockets{SessionStore: sessionStore},
+		&CmdGC{FileStore: filestore},
+		&CmdFsck{FileStore: filestore},
+		&CmdDf{FileStore: filestore},
 
 		&CmdPilots{FileStore: filestore},
 		&CmdEdgeNodes{FileStore: filestore},
@@ -59,6 +77,13 @@ func InitCommands(
 
 		CmdB64{},
 		CmdHex{},
+		&CmdGrep{FileStore: filestore},
+		&CmdHead{FileStore: filestore},
+		&CmdTail{FileStore: filestore},
+		&CmdWc{FileStore: filestore},
+		&CmdSort{FileStore: filestore},
+		&CmdUniq{FileStore: filestore},
+		&CmdYq{FileStore: filestore},
 
 		&CmdSendText{UazapiConfig: uazapi_cfg},
 		CmdEmail{EmailConfig: email_cfg},
@@ -69,8 +94,11 @@ func InitCommands(
 		FileStore: filestore,
 	}
 
-	commands = append(commands, activate_cmd)
+	find_cmd := &CmdFind{FileStore: filestore}
+
+	commands = append(commands, activate_cmd, find_cmd)
 	activate_cmd.Commands = commands
+	find_cmd.Commands = commands
 
 	return commands
 }
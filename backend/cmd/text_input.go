@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"github.com/RoundRobinHood/sh"
+)
+
+// maxLineLength is the longest line the text commands accept
+const maxLineLength = 1 << 20
+
+// newLineScanner returns a scanner over the lines of r. Both LF and CRLF line endings are stripped.
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	return scanner
+}
+
+// forEachInput calls fn with every file argument opened for reading (stdin if there are none, or for "-").
+// It returns the exit code to use: 1 if any file couldn't be opened or fn failed.
+func forEachInput(ctx sh.CommandContext, store filesystem.Store, paths []string, fn func(name string, r io.Reader) error) int {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	cwd, ok := ctx.Env["PWD"]
+	if !ok {
+		fmt.Fprint(ctx.Stderr, "error: no PWD available\r\n")
+		return 1
+	}
+	tags := util.GetTags(ctx.Ctx)
+
+	result := 0
+	for _, path := range paths {
+		if path == "-" {
+			if err := fn(path, ctx.Stdin); err != nil {
+				fmt.Fprintf(ctx.Stderr, "error reading stdin: %v\r\n", err)
+				result = 1
+			}
+			continue
+		}
+
+		abs_path, err := filesystem.AbsPath(cwd, path)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error: invalid path (%q): %v\r\n", path, err)
+			result = 1
+			continue
+		}
+		reader, err := store.LookupRead(ctx.Ctx, abs_path, tags)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "error opening file (%q): %v\r\n", path, err)
+			result = 1
+			continue
+		}
+		if err := fn(path, reader); err != nil {
+			fmt.Fprintf(ctx.Stderr, "error reading file (%q): %v\r\n", path, err)
+			result = 1
+		}
+		reader.Close()
+	}
+
+	return result
+}
@@ -0,0 +1,230 @@
+// Package dav serves the VFS over WebDAV, so desktop tools can browse and edit it.
+// Requests are authenticated by auth.AuthMiddleware and every operation is checked against the user's tags
+// with the same permission rules as the shell commands.
+package dav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/jlogging"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+)
+
+// Methods are the HTTP methods WebDAV clients use
+var Methods = []string{
+	"OPTIONS", "GET", "HEAD", "POST", "PUT", "DELETE",
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// Handler serves WebDAV requests for paths under prefix (where the handler is mounted).
+// WebDAV locks are kept in memory for the handler's lifetime; they're separate from the lock command's,
+// but files locked with that can't be read or written here either.
+func Handler(filestore filesystem.Store, prefix string) gin.HandlerFunc {
+	locks := webdav.NewMemLS()
+	return func(c *gin.Context) {
+		auth_get, ok := c.Get("auth")
+		if !ok {
+			jlogging.MustGet(c).Printf("missing auth middleware")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		status := auth_get.(types.AuthorizationStatus)
+		ctx := context.WithValue(c.Request.Context(), "auth_status", status)
+		ctx = context.WithValue(ctx, "tags", status.Tags)
+
+		// The webdav package skips unreadable directories while walking, which would answer listing one with nothing
+		if c.Request.Method == "PROPFIND" && c.GetHeader("Depth") != "0" {
+			if abs_path, err := filesystem.CleanupAbsPath("/" + strings.TrimPrefix(c.Param("path"), "/")); err == nil {
+				entry, err := filestore.Lookup(ctx, status.Tags, abs_path)
+				if err == nil && entry.EntryType == types.Directory && !entry.Permissions.IsAllowed(types.ReadMode, status.Tags) {
+					c.AbortWithStatus(http.StatusForbidden)
+					return
+				}
+			}
+		}
+
+		// Behind a proxy that strips a prefix (like /api), links must include it again
+		public_prefix := strings.TrimSuffix(c.GetHeader("X-Forwarded-Prefix"), "/")
+		request := c.Request.WithContext(ctx)
+		if public_prefix != "" {
+			request.URL.Path = public_prefix + request.URL.Path
+		}
+
+		handler := &webdav.Handler{
+			Prefix:     public_prefix + prefix,
+			FileSystem: &FileSystem{Store: filestore, Tags: status.Tags},
+			LockSystem: locks,
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					jlogging.MustGet(c).Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		}
+		handler.ServeHTTP(c.Writer, request)
+	}
+}
+
+// FileSystem is a webdav.FileSystem over a Store, acting with a user's tags
+type FileSystem struct {
+	Store filesystem.Store
+	Tags  []string
+}
+
+var _ webdav.FileSystem = (*FileSystem)(nil)
+
+func (f *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	abs_path, err := filesystem.CleanupAbsPath(name)
+	if err != nil {
+		return davError(err)
+	}
+	if _, err := f.writableParent(ctx, abs_path); err != nil {
+		return err
+	}
+	_, err = f.Store.Mkdir(ctx, abs_path, f.Tags, nil, false)
+	return davError(err)
+}
+
+func (f *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	abs_path, err := filesystem.CleanupAbsPath(name)
+	if err != nil {
+		return nil, davError(err)
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		entry, err := f.Store.Lookup(ctx, f.Tags, abs_path)
+		if err != nil {
+			return nil, davError(err)
+		}
+		if !entry.Permissions.IsAllowed(types.ReadMode, f.Tags) {
+			return nil, os.ErrPermission
+		}
+		if err := f.Store.CheckLock(ctx, entry.ID, types.LockOwner{}, types.ReadMode); err != nil {
+			return nil, davError(err)
+		}
+		return f.open(ctx, abs_path, *entry)
+	}
+
+	// WebDAV only writes whole files (PUT, COPY), so writes always replace the contents
+	if flag&os.O_TRUNC == 0 {
+		return nil, fmt.Errorf("%w: files can only be replaced", os.ErrInvalid)
+	}
+	fsctx := filesystem.FSContext{Store: f.Store, UserTags: f.Tags, HonourLocks: true}
+	open_flag := os.O_WRONLY | os.O_TRUNC | (flag & (os.O_CREATE | os.O_EXCL))
+	stream, err := fsctx.Open(ctx, abs_path, open_flag, perm)
+	if err != nil {
+		return nil, davError(err)
+	}
+	return &file{fs: f, ctx: ctx, name: path.Base(abs_path), writer: stream}, nil
+}
+
+func (f *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	abs_path, err := filesystem.CleanupAbsPath(name)
+	if err != nil {
+		return davError(err)
+	}
+	entry, err := f.Store.LookupNoFollow(ctx, f.Tags, abs_path)
+	if err != nil {
+		return davError(err)
+	}
+	if err := f.Store.CheckLock(ctx, entry.ID, types.LockOwner{}, types.WriteMode); err != nil {
+		return davError(err)
+	}
+
+	// Like rm, removed entries go to the trash when the user has one
+	_, err = f.Store.Trash(ctx, abs_path, f.Tags, false, true)
+	if errors.Is(err, filesystem.ErrNoTrash) {
+		_, err = f.Store.RemoveFile(ctx, abs_path, f.Tags, false, true)
+	}
+	return davError(err)
+}
+
+func (f *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	src_path, err := filesystem.CleanupAbsPath(oldName)
+	if err != nil {
+		return davError(err)
+	}
+	dest_path, err := filesystem.CleanupAbsPath(newName)
+	if err != nil {
+		return davError(err)
+	}
+	entry, err := f.Store.LookupNoFollow(ctx, f.Tags, src_path)
+	if err != nil {
+		return davError(err)
+	}
+	if err := f.Store.CheckLock(ctx, entry.ID, types.LockOwner{}, types.WriteMode); err != nil {
+		return davError(err)
+	}
+	// Moving takes an entry out of its folder, which mv needs write access to as well
+	if _, err := f.writableParent(ctx, src_path); err != nil {
+		return err
+	}
+	if _, err := f.writableParent(ctx, dest_path); err != nil {
+		return err
+	}
+	_, err = f.Store.Move(ctx, dest_path, src_path, f.Tags)
+	return davError(err)
+}
+
+func (f *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	abs_path, err := filesystem.CleanupAbsPath(name)
+	if err != nil {
+		return nil, davError(err)
+	}
+	entry, err := f.Store.Lookup(ctx, f.Tags, abs_path)
+	if err != nil {
+		return nil, davError(err)
+	}
+	size := int64(0)
+	if entry.EntryType == types.File && entry.FileReference != nil {
+		if size, err = f.Store.Blobs.Size(ctx, *entry.FileReference); err != nil {
+			return nil, davError(err)
+		}
+	}
+	return newFileInfo(path.Base(abs_path), *entry, size), nil
+}
+
+// writableParent returns the directory abs_path is in, if tags can create and remove entries there
+func (f *FileSystem) writableParent(ctx context.Context, abs_path string) (*types.FsEntry, error) {
+	folder_path, filename, err := filesystem.DirUp(abs_path)
+	if err != nil {
+		return nil, davError(err)
+	}
+	if filename == "" {
+		return nil, os.ErrPermission
+	}
+	parent, err := f.Store.Lookup(ctx, f.Tags, folder_path)
+	if err != nil {
+		return nil, davError(err)
+	}
+	if parent.EntryType != types.Directory {
+		return nil, os.ErrNotExist
+	}
+	if !parent.Permissions.IsAllowed(types.WriteMode, f.Tags) || !parent.Permissions.IsAllowed(types.ExecuteMode, f.Tags) {
+		return nil, os.ErrPermission
+	}
+	return parent, nil
+}
+
+// davError turns store errors into the os errors the webdav package maps to status codes
+func davError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		return os.ErrNotExist
+	case errors.Is(err, os.ErrExist):
+		return os.ErrExist
+	case errors.Is(err, types.ErrCantAccessFs), errors.Is(err, filesystem.ErrLocked):
+		return os.ErrPermission
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package dav
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/jlogging"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestRouter mounts WebDAV on an in-memory store with a /home/alice directory only "user-alice" can use,
+// authenticating requests as whoever the X-Test-User header names
+func newTestRouter(t *testing.T) (*gin.Engine, filesystem.Store) {
+	t.Helper()
+	store := filesystem.NewMemoryStore()
+	now := time.Now()
+	perms := func(tags ...string) types.FsEntryPermissions {
+		return types.FsEntryPermissions{ReadTags: tags, WriteTags: tags, ExecuteTags: tags, UpdatePermissionTags: tags}
+	}
+	alice := types.FsEntry{
+		ID:          primitive.NewObjectID(),
+		EntryType:   types.Directory,
+		Permissions: perms("sysadmin", "user-alice"),
+		Timestamps:  types.FileTimestamps{CreatedAt: now, ModifiedAt: now, AccessedAt: now},
+		Entries:     types.FsReferenceList{},
+	}
+	home := types.FsEntry{
+		ID:        primitive.NewObjectID(),
+		EntryType: types.Directory,
+		Permissions: types.FsEntryPermissions{
+			ReadTags:             []string{"sysadmin", "user"},
+			WriteTags:            []string{"sysadmin"},
+			ExecuteTags:          []string{"sysadmin", "user"},
+			UpdatePermissionTags: []string{"sysadmin"},
+		},
+		Timestamps: types.FileTimestamps{CreatedAt: now, ModifiedAt: now, AccessedAt: now},
+		Entries:    types.FsReferenceList{{Name: "alice", RefID: alice.ID}},
+	}
+	root := types.FsEntry{
+		ID:          primitive.NewObjectID(),
+		IsRoot:      true,
+		EntryType:   types.Directory,
+		Permissions: home.Permissions,
+		Timestamps:  types.FileTimestamps{CreatedAt: now, ModifiedAt: now, AccessedAt: now},
+		Entries:     types.FsReferenceList{{Name: "home", RefID: home.ID}},
+	}
+	if err := store.Nodes.Insert(context.Background(), alice, home, root); err != nil {
+		t.Fatalf("failed to seed store: %v", err)
+	}
+	if err := store.ReindexPaths(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(jlogging.Middleware())
+	group := r.Group("/dav", func(c *gin.Context) {
+		user := c.GetHeader("X-Test-User")
+		c.Set("auth", types.AuthorizationStatus{Username: user, Tags: []string{"user", "user-" + user}})
+	})
+	handler := Handler(store, "/dav")
+	for _, method := range Methods {
+		group.Handle(method, "/*path", handler)
+	}
+	return r, store
+}
+
+func serve(r *gin.Engine, user, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("X-Test-User", user)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestWebDAV(t *testing.T) {
+	r, store := newTestRouter(t)
+	ctx := context.Background()
+	alice := []string{"user", "user-alice"}
+
+	if w := serve(r, "alice", "MKCOL", "/dav/home/alice/flights", ""); w.Code != http.StatusCreated {
+		t.Fatalf("MKCOL failed (%d): %s", w.Code, w.Body)
+	}
+	if w := serve(r, "alice", "PUT", "/dav/home/alice/flights/f1.csv", "t,hr\n0,60\n"); w.Code != http.StatusCreated {
+		t.Fatalf("PUT failed (%d): %s", w.Code, w.Body)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/flights/f1.csv", alice); err != nil || string(data) != "t,hr\n0,60\n" {
+		t.Fatalf("unexpected contents %q (%v)", data, err)
+	}
+	if w := serve(r, "alice", "GET", "/dav/home/alice/flights/f1.csv", "", "Range", "bytes=5-"); w.Code != http.StatusPartialContent || w.Body.String() != "0,60\n" {
+		t.Fatalf("unexpected GET (%d): %q", w.Code, w.Body)
+	}
+
+	w := serve(r, "alice", "PROPFIND", "/dav/home/alice/", "", "Depth", "infinity")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND failed (%d): %s", w.Code, w.Body)
+	}
+	for _, href := range []string{"/dav/home/alice/", "/dav/home/alice/flights/", "/dav/home/alice/flights/f1.csv"} {
+		if !strings.Contains(w.Body.String(), "<D:href>"+href+"</D:href>") {
+			t.Fatalf("expected PROPFIND to list %q, got %s", href, w.Body)
+		}
+	}
+	if !strings.Contains(w.Body.String(), "<D:getcontentlength>10</D:getcontentlength>") {
+		t.Fatalf("expected PROPFIND to report the file's size, got %s", w.Body)
+	}
+
+	if w := serve(r, "alice", "COPY", "/dav/home/alice/flights", "", "Destination", "http://example.com/dav/home/alice/backup"); w.Code != http.StatusCreated {
+		t.Fatalf("COPY failed (%d): %s", w.Code, w.Body)
+	}
+	if w := serve(r, "alice", "MOVE", "/dav/home/alice/backup/f1.csv", "", "Destination", "http://example.com/dav/home/alice/f1-copy.csv"); w.Code != http.StatusCreated {
+		t.Fatalf("MOVE failed (%d): %s", w.Code, w.Body)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/f1-copy.csv", alice); err != nil || string(data) != "t,hr\n0,60\n" {
+		t.Fatalf("unexpected copied contents %q (%v)", data, err)
+	}
+	if w := serve(r, "alice", "DELETE", "/dav/home/alice/backup", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE failed (%d): %s", w.Code, w.Body)
+	}
+	if _, err := store.Lookup(ctx, alice, "/home/alice/backup"); err == nil {
+		t.Fatal("expected the deleted directory to be gone")
+	}
+
+	// Bob gets the same answers the shell would give him
+	if w := serve(r, "bob", "PROPFIND", "/dav/home/alice/", "", "Depth", "1"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected bob's PROPFIND of alice's home to be forbidden, got %d", w.Code)
+	}
+	if w := serve(r, "bob", "PUT", "/dav/home/bob.txt", "mine"); w.Code == http.StatusCreated {
+		t.Fatal("expected bob's PUT into /home to fail")
+	}
+	if w := serve(r, "bob", "MKCOL", "/dav/home/bob", ""); w.Code == http.StatusCreated {
+		t.Fatal("expected bob's MKCOL in /home to fail")
+	}
+	if home, err := store.Lookup(ctx, []string{"sysadmin"}, "/home"); err != nil || len(home.Entries) != 1 {
+		t.Fatalf("expected bob to leave /home alone, got %v (%v)", home, err)
+	}
+	if w := serve(r, "bob", "MOVE", "/dav/home/alice", "", "Destination", "http://example.com/dav/alice"); w.Code == http.StatusCreated {
+		t.Fatal("expected bob's MOVE of alice's home to fail")
+	}
+
+	// Links include the prefix a proxy stripped
+	w = serve(r, "alice", "PROPFIND", "/dav/home/alice/flights/", "", "Depth", "0", "X-Forwarded-Prefix", "/api")
+	if w.Code != http.StatusMultiStatus || !strings.Contains(w.Body.String(), "<D:href>/api/dav/home/alice/flights/</D:href>") {
+		t.Fatalf("expected hrefs under /api (%d): %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,186 @@
+package dav
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/fileapi"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/webdav"
+)
+
+// file is an open VFS entry: a file being read or written, or a directory being listed
+type file struct {
+	fs      *FileSystem
+	ctx     context.Context
+	name    string
+	absPath string
+	info    *fileInfo
+
+	reader  io.ReadSeekCloser
+	writer  io.WriteCloser
+	written int64
+
+	// entries are a directory's children, read on the first Readdir
+	entries []fs.FileInfo
+	listed  bool
+}
+
+func (f *FileSystem) open(ctx context.Context, abs_path string, entry types.FsEntry) (*file, error) {
+	info, err := f.Stat(ctx, abs_path)
+	if err != nil {
+		return nil, err
+	}
+	opened := &file{fs: f, ctx: ctx, name: info.Name(), absPath: abs_path, info: info.(*fileInfo)}
+	if entry.EntryType == types.File {
+		opened.reader = filesystem.NewBlobReader(ctx, f.Store.Blobs, entry.FileReference, info.Size())
+	}
+	return opened, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		return 0, os.ErrInvalid
+	}
+	return f.reader.Read(p)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.reader == nil {
+		return 0, os.ErrInvalid
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.writer == nil {
+		return 0, os.ErrPermission
+	}
+	n, err := f.writer.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// Close commits written contents
+func (f *file) Close() error {
+	if f.writer != nil {
+		return davError(f.writer.Close())
+	}
+	if f.reader != nil {
+		return f.reader.Close()
+	}
+	return nil
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	if f.info == nil {
+		// Still being written: the contents are what's been written so far
+		return &fileInfo{name: f.name, size: f.written, modified: time.Now()}, nil
+	}
+	return f.info, nil
+}
+
+// Readdir lists a directory's children (following symlinks), leaving out ones tags can't see
+func (f *file) Readdir(count int) ([]fs.FileInfo, error) {
+	if f.info == nil || !f.info.IsDir() {
+		return nil, os.ErrInvalid
+	}
+	if !f.listed {
+		if err := f.list(); err != nil {
+			return nil, err
+		}
+		f.listed = true
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	count = min(count, len(f.entries))
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+func (f *file) list() error {
+	dir := f.info.entry
+	children := make([]types.FsEntry, 0, len(dir.Entries))
+	names := make([]string, 0, len(dir.Entries))
+	refs := []primitive.ObjectID{}
+	for _, ref := range dir.Entries {
+		child, err := f.fs.Store.Nodes.Get(f.ctx, ref.RefID)
+		if err != nil {
+			return davError(err)
+		}
+		if child.EntryType == types.Symlink {
+			if child, err = f.fs.Store.Lookup(f.ctx, f.fs.Tags, path.Join(f.absPath, ref.Name)); err != nil {
+				// Dangling or inaccessible links have nothing to show
+				continue
+			}
+		}
+		if child.EntryType == types.File && child.FileReference != nil {
+			refs = append(refs, *child.FileReference)
+		}
+		children = append(children, *child)
+		names = append(names, ref.Name)
+	}
+
+	sizes, err := f.fs.Store.Blobs.Sizes(f.ctx, refs)
+	if err != nil {
+		return davError(err)
+	}
+	f.entries = make([]fs.FileInfo, len(children))
+	for i, child := range children {
+		size := int64(0)
+		if child.FileReference != nil {
+			size = sizes[*child.FileReference]
+		}
+		f.entries[i] = newFileInfo(names[i], child, size)
+	}
+	return nil
+}
+
+// fileInfo describes an entry. Its ETag matches the /fs endpoints'.
+type fileInfo struct {
+	name     string
+	size     int64
+	modified time.Time
+	entry    *types.FsEntry
+}
+
+func newFileInfo(name string, entry types.FsEntry, size int64) *fileInfo {
+	return &fileInfo{name: name, size: size, modified: entry.Timestamps.ModifiedAt, entry: &entry}
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return i.modified }
+func (i *fileInfo) Sys() any           { return i.entry }
+
+func (i *fileInfo) IsDir() bool {
+	return i.entry != nil && i.entry.EntryType == types.Directory
+}
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.IsDir() {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (i *fileInfo) ETag(ctx context.Context) (string, error) {
+	if i.entry == nil || i.entry.EntryType != types.File {
+		return "", webdav.ErrNotImplemented
+	}
+	return fileapi.ETag(*i.entry), nil
+}
@@ -0,0 +1,317 @@
+// Package fileapi serves the VFS over plain HTTP, for clients that need to move whole files (such as face images)
+// without going through the command socket. Every handler expects auth.AuthMiddleware to have run, and checks
+// permissions with the authenticated user's tags like any command would.
+package fileapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/jlogging"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// emptyETag identifies files that have no contents (and so no blob)
+const emptyETag = `"empty"`
+
+// ETag is the entity tag of a file's current contents: its blob ID
+func ETag(entry types.FsEntry) string {
+	if entry.FileReference == nil {
+		return emptyETag
+	}
+	return `"` + entry.FileReference.Hex() + `"`
+}
+
+// Download serves GET and HEAD /fs/*path: a file's contents, with Range, If-Match, If-None-Match and
+// If-Modified-Since support. Symlinks are followed.
+func Download(filestore filesystem.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, status, ok := requestContext(c)
+		if !ok {
+			return
+		}
+		abs_path, err := requestPath(c)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+
+		entry, err := filestore.Lookup(ctx, status.Tags, abs_path)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if entry.EntryType != types.File {
+			fail(c, fmt.Errorf("%w: %q is not a file", os.ErrInvalid, abs_path))
+			return
+		}
+		if !entry.Permissions.IsAllowed(types.ReadMode, status.Tags) {
+			fail(c, types.ErrCantAccessFs)
+			return
+		}
+		if err := filestore.CheckLock(ctx, entry.ID, types.LockOwner{}, types.ReadMode); err != nil {
+			fail(c, err)
+			return
+		}
+
+		size := int64(0)
+		if entry.FileReference != nil {
+			if size, err = filestore.Blobs.Size(ctx, *entry.FileReference); err != nil {
+				fail(c, err)
+				return
+			}
+		}
+
+		c.Header("ETag", ETag(*entry))
+		// ServeContent sniffs the contents for anything the extension doesn't give away
+		if content_type := mime.TypeByExtension(path.Ext(abs_path)); content_type != "" {
+			c.Header("Content-Type", content_type)
+		}
+		contents := filesystem.NewBlobReader(ctx, filestore.Blobs, entry.FileReference, size)
+		defer contents.Close()
+		http.ServeContent(c.Writer, c.Request, path.Base(abs_path), entry.Timestamps.ModifiedAt, contents)
+	}
+}
+
+// Upload serves PUT /fs/*path: it streams the request body into a new blob and makes it the file's contents,
+// creating the file if needed (its parent must exist). Writes go through symlinks.
+// If-Match makes the write conditional on the file's current ETag, and "If-None-Match: *" on it not existing yet.
+func Upload(filestore filesystem.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, status, ok := requestContext(c)
+		if !ok {
+			return
+		}
+		abs_path, err := requestPath(c)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if abs_path, err = filestore.Resolve(ctx, status.Tags, abs_path); err != nil {
+			fail(c, err)
+			return
+		}
+		folder_path, filename, err := filesystem.DirUp(abs_path)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if filename == "" {
+			fail(c, fmt.Errorf("%w: can't write to /", os.ErrInvalid))
+			return
+		}
+
+		parent, err := filestore.Lookup(ctx, status.Tags, folder_path)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if parent.EntryType != types.Directory {
+			fail(c, fmt.Errorf("%w: parent not a directory", os.ErrInvalid))
+			return
+		}
+		if !parent.Permissions.IsAllowed(types.WriteMode, status.Tags) || !parent.Permissions.IsAllowed(types.ExecuteMode, status.Tags) {
+			fail(c, types.ErrCantAccessFs)
+			return
+		}
+
+		// The write only commits if nobody else writes the file in the meantime (0 means it doesn't exist yet)
+		var existing *types.FsEntry
+		revision := int64(0)
+		if ref, ok := parent.Entries.Get(filename); ok {
+			if existing, err = filestore.Nodes.Get(ctx, ref.RefID); err != nil {
+				fail(c, err)
+				return
+			}
+			if existing.EntryType != types.File {
+				fail(c, fmt.Errorf("%w: %q is not a file", os.ErrInvalid, abs_path))
+				return
+			}
+			if err := filestore.CheckLock(ctx, existing.ID, types.LockOwner{}, types.WriteMode); err != nil {
+				fail(c, err)
+				return
+			}
+			revision = existing.Revision
+		}
+		if !preconditionsMet(c, existing) {
+			c.Status(http.StatusPreconditionFailed)
+			return
+		}
+
+		// Refuse uploads that announce they won't fit before reading any of them
+		budget, err := filestore.WriteBudget(ctx, *parent, filename)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if budget >= 0 && c.Request.ContentLength > budget {
+			fail(c, filesystem.ErrQuotaExceeded)
+			return
+		}
+
+		fileRef := primitive.NewObjectID()
+		stream, err := filestore.Blobs.OpenUpload(ctx, fileRef)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if _, err := io.Copy(filesystem.LimitWriter(stream, budget), c.Request.Body); err != nil {
+			if aborter, ok := stream.(filesystem.Aborter); ok {
+				aborter.Abort()
+			} else {
+				stream.Close()
+			}
+			fail(c, err)
+			return
+		}
+		if err := stream.Close(); err != nil {
+			fail(c, err)
+			return
+		}
+
+		entry, err := filestore.WriteFileIfRevision(ctx, parent.ID, filename, fileRef, status.Tags, revision)
+		if err != nil {
+			// Nothing references the upload, so it can go straight away
+			if err := filestore.Blobs.Delete(context.Background(), fileRef); err != nil && !errors.Is(err, os.ErrNotExist) {
+				jlogging.MustGet(c).Printf("couldn't delete failed upload %s: %v", fileRef.Hex(), err)
+			}
+			fail(c, err)
+			return
+		}
+
+		c.Header("ETag", ETag(*entry))
+		if existing == nil {
+			c.Status(http.StatusCreated)
+		} else {
+			c.Status(http.StatusNoContent)
+		}
+	}
+}
+
+// Delete serves DELETE /fs/*path: it moves the entry to the user's trash, like rm.
+// Directories need ?recursive=true, and ?permanent=true deletes instead. Symlinks are removed, not followed.
+// If-Match makes removing a file conditional on its current ETag.
+func Delete(filestore filesystem.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, status, ok := requestContext(c)
+		if !ok {
+			return
+		}
+		abs_path, err := requestPath(c)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+
+		entry, err := filestore.LookupNoFollow(ctx, status.Tags, abs_path)
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		if err := filestore.CheckLock(ctx, entry.ID, types.LockOwner{}, types.WriteMode); err != nil {
+			fail(c, err)
+			return
+		}
+		if !preconditionsMet(c, entry) {
+			c.Status(http.StatusPreconditionFailed)
+			return
+		}
+
+		recursive := c.Query("recursive") == "true"
+		if c.Query("permanent") == "true" {
+			_, err = filestore.RemoveFile(ctx, abs_path, status.Tags, false, recursive)
+		} else {
+			_, err = filestore.Trash(ctx, abs_path, status.Tags, false, recursive)
+		}
+		if err != nil {
+			fail(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// requestContext returns the request's context carrying the authenticated user, as the store expects it
+// (for version history and trash), or responds 401 if there's none
+func requestContext(c *gin.Context) (context.Context, types.AuthorizationStatus, bool) {
+	auth_get, ok := c.Get("auth")
+	if !ok {
+		jlogging.MustGet(c).Printf("missing auth middleware")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return nil, types.AuthorizationStatus{}, false
+	}
+	status := auth_get.(types.AuthorizationStatus)
+	ctx := context.WithValue(c.Request.Context(), "auth_status", status)
+	ctx = context.WithValue(ctx, "tags", status.Tags)
+	return ctx, status, true
+}
+
+func requestPath(c *gin.Context) (string, error) {
+	return filesystem.CleanupAbsPath("/" + strings.TrimPrefix(c.Param("path"), "/"))
+}
+
+// preconditionsMet evaluates If-Match and If-None-Match against an entry (nil if it doesn't exist)
+func preconditionsMet(c *gin.Context, entry *types.FsEntry) bool {
+	etag := ""
+	if entry != nil {
+		etag = ETag(*entry)
+	}
+	if header := c.GetHeader("If-Match"); header != "" && !matchesETag(header, etag) {
+		return false
+	}
+	if header := c.GetHeader("If-None-Match"); header != "" && matchesETag(header, etag) {
+		return false
+	}
+	return true
+}
+
+// matchesETag reports whether a comma-separated If-Match or If-None-Match list matches etag ("" if there's no entity)
+func matchesETag(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// fail responds with the status code matching a store error
+func fail(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		code = http.StatusNotFound
+	case errors.Is(err, types.ErrCantAccessFs):
+		code = http.StatusForbidden
+	case errors.Is(err, filesystem.ErrLocked):
+		code = http.StatusLocked
+	case errors.Is(err, filesystem.ErrRevisionConflict):
+		code = http.StatusPreconditionFailed
+	case errors.Is(err, filesystem.ErrQuotaExceeded):
+		code = http.StatusInsufficientStorage
+	case errors.Is(err, filesystem.ErrNoTrash), errors.Is(err, filesystem.ErrTrashInside), errors.Is(err, os.ErrExist), errors.As(err, new(filesystem.ErrPartialRemove)):
+		code = http.StatusConflict
+	case errors.Is(err, os.ErrInvalid):
+		code = http.StatusBadRequest
+	}
+	if code == http.StatusInternalServerError {
+		jlogging.MustGet(c).Printf("VFS error: %v", err)
+		c.AbortWithStatus(code)
+		return
+	}
+	c.AbortWithStatusJSON(code, gin.H{"error": err.Error()})
+}
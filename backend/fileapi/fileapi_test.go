@@ -0,0 +1,133 @@
+package fileapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/jlogging"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestRouter serves an in-memory store with a root directory that "user" can use, authenticating every request as tester
+func newTestRouter(t *testing.T) (*gin.Engine, filesystem.Store) {
+	t.Helper()
+	store := filesystem.NewMemoryStore()
+	store.Locks = filesystem.NewLockTable()
+	now := time.Now()
+	tags := []string{"sysadmin", "user"}
+	if err := store.Nodes.Insert(context.Background(), types.FsEntry{
+		ID:        primitive.NewObjectID(),
+		IsRoot:    true,
+		EntryType: types.Directory,
+		Permissions: types.FsEntryPermissions{
+			ReadTags:             tags,
+			WriteTags:            tags,
+			ExecuteTags:          tags,
+			UpdatePermissionTags: tags,
+		},
+		Timestamps: types.FileTimestamps{CreatedAt: now, ModifiedAt: now, AccessedAt: now},
+		Entries:    types.FsReferenceList{},
+	}); err != nil {
+		t.Fatalf("failed to seed store: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(jlogging.Middleware())
+	files := r.Group("/fs", func(c *gin.Context) {
+		c.Set("auth", types.AuthorizationStatus{Username: "tester", Tags: []string{"user"}})
+	})
+	files.GET("/*path", Download(store))
+	files.HEAD("/*path", Download(store))
+	files.PUT("/*path", Upload(store))
+	files.DELETE("/*path", Delete(store))
+	return r, store
+}
+
+func serve(r *gin.Engine, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestFileAPI(t *testing.T) {
+	r, store := newTestRouter(t)
+
+	w := serve(r, "PUT", "/fs/faces/tester.png", "\x89PNG\r\n\x1a\nimage")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected uploading into a missing directory to 404, got %d", w.Code)
+	}
+	if _, err := store.Mkdir(context.Background(), "/faces", []string{"user"}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(r, "PUT", "/fs/faces/tester.png", "\x89PNG\r\n\x1a\nimage")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload failed (%d): %s", w.Code, w.Body)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected the upload to return an ETag")
+	}
+
+	w = serve(r, "GET", "/fs/faces/tester.png", "")
+	if w.Code != http.StatusOK || w.Body.String() != "\x89PNG\r\n\x1a\nimage" {
+		t.Fatalf("unexpected download (%d): %q", w.Code, w.Body)
+	}
+	if content_type := w.Header().Get("Content-Type"); content_type != "image/png" || w.Header().Get("ETag") != etag {
+		t.Fatalf("unexpected headers: %v", w.Header())
+	}
+	if w = serve(r, "GET", "/fs/faces/tester.png", "", "Range", "bytes=8-"); w.Code != http.StatusPartialContent || w.Body.String() != "image" {
+		t.Fatalf("unexpected range download (%d): %q", w.Code, w.Body)
+	}
+	if w = serve(r, "GET", "/fs/faces/tester.png", "", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("expected a matching If-None-Match to give 304, got %d", w.Code)
+	}
+
+	// Conditional writes
+	if w = serve(r, "PUT", "/fs/faces/tester.png", "other", "If-None-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected If-None-Match: * on an existing file to fail, got %d", w.Code)
+	}
+	if w = serve(r, "PUT", "/fs/faces/tester.png", "other", "If-Match", `"stale"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected a stale If-Match to fail, got %d", w.Code)
+	}
+	if w = serve(r, "PUT", "/fs/faces/tester.png", "newer", "If-Match", etag); w.Code != http.StatusNoContent || w.Header().Get("ETag") == etag {
+		t.Fatalf("expected a matching If-Match to update the file (%d): %v", w.Code, w.Header())
+	}
+	if data, err := store.LookupReadAll(context.Background(), "/faces/tester.png", []string{"user"}); err != nil || string(data) != "newer" {
+		t.Fatalf("unexpected contents %q (%v)", data, err)
+	}
+
+	// Locks held over the command socket keep HTTP clients out
+	owner := types.LockOwner{SocketID: "socket", ClientID: "client"}
+	if _, err := store.Lock(context.Background(), "/faces/tester.png", []string{"user"}, owner, types.ExclusiveLock, time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	if w = serve(r, "GET", "/fs/faces/tester.png", ""); w.Code != http.StatusLocked {
+		t.Fatalf("expected a locked file to give 423, got %d", w.Code)
+	}
+	store.Locks.ReleaseSocket(context.Background(), "socket")
+
+	if w = serve(r, "GET", "/fs/faces", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected downloading a directory to fail, got %d", w.Code)
+	}
+	if w = serve(r, "DELETE", "/fs/faces", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected deleting a directory without recursive to fail, got %d", w.Code)
+	}
+	if w = serve(r, "DELETE", "/fs/faces/tester.png?permanent=true", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete failed (%d): %s", w.Code, w.Body)
+	}
+	if w = serve(r, "GET", "/fs/faces/tester.png", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected the deleted file to 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,586 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/goccy/go-yaml"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ArchiveFormat is the container format of an exported subtree
+type ArchiveFormat string
+
+const (
+	TarArchive ArchiveFormat = "tar"
+	ZipArchive ArchiveFormat = "zip"
+)
+
+// ArchiveManifestName is the sidecar that carries what archive headers can't: permissions and all timestamps.
+// Exports write it first, so tar imports can stream.
+const ArchiveManifestName = ".vfs-manifest.yaml"
+
+// MaxZipImportSize bounds zip imports, which are buffered in memory since zip needs random access
+const MaxZipImportSize = 512 << 20
+
+var ErrUnknownArchive = errors.New("error: not a tar or zip archive")
+
+// ArchiveManifest describes every entry of an exported subtree
+type ArchiveManifest struct {
+	Version int `yaml:"version"`
+	// Source is the exported path
+	Source  string         `yaml:"source"`
+	Entries []ArchiveEntry `yaml:"entries"`
+}
+
+// ArchiveEntry is the metadata of one archived entry
+type ArchiveEntry struct {
+	// Path is the entry's slash-separated path inside the archive
+	Path        string                   `yaml:"path"`
+	Type        string                   `yaml:"type"`
+	Target      string                   `yaml:"target,omitempty"`
+	Permissions types.FsEntryPermissions `yaml:"permissions"`
+	CreatedAt   time.Time                `yaml:"created_at"`
+	ModifiedAt  time.Time                `yaml:"modified_at"`
+	AccessedAt  time.Time                `yaml:"accessed_at"`
+}
+
+// ArchiveSkip is an entry an export or import left out (or only partly restored), and why
+type ArchiveSkip struct {
+	Path  string `yaml:"path"`
+	Error string `yaml:"error"`
+}
+
+// ArchiveReport describes the outcome of an export or import
+type ArchiveReport struct {
+	Files       int           `yaml:"files"`
+	Directories int           `yaml:"directories"`
+	Symlinks    int           `yaml:"symlinks"`
+	Bytes       int64         `yaml:"bytes"`
+	Skipped     []ArchiveSkip `yaml:"skipped"`
+}
+
+func (r *ArchiveReport) skip(path string, err error) {
+	r.Skipped = append(r.Skipped, ArchiveSkip{Path: path, Error: err.Error()})
+}
+
+func (r *ArchiveReport) count(entryType types.FsEntryType) {
+	switch entryType {
+	case types.File:
+		r.Files++
+	case types.Directory:
+		r.Directories++
+	case types.Symlink:
+		r.Symlinks++
+	}
+}
+
+// archivedEntry is an entry queued for export
+type archivedEntry struct {
+	name  string
+	entry types.FsEntry
+}
+
+// Export writes the subtree at abs_path (following a symlink there) to w as an archive, under the subtree's name.
+// Like Walk, it only descends into directories tags can execute, and it leaves out files tags can't read.
+// Both are reported as skipped instead of failing the export.
+func (s Store) Export(ctx context.Context, w io.Writer, format ArchiveFormat, tags []string, abs_path string) (*ArchiveReport, error) {
+	if format != TarArchive && format != ZipArchive {
+		return nil, fmt.Errorf("%w: unknown archive format %q", os.ErrInvalid, format)
+	}
+	abs_path, err := s.Resolve(ctx, tags, abs_path)
+	if err != nil {
+		return nil, err
+	}
+	base := path.Base(abs_path)
+	if abs_path == "/" {
+		base = "root"
+	}
+
+	report := ArchiveReport{Skipped: []ArchiveSkip{}}
+	manifest := ArchiveManifest{Version: 1, Source: abs_path, Entries: []ArchiveEntry{}}
+	queued := []archivedEntry{}
+	file_refs := []primitive.ObjectID{}
+	if err := s.Walk(ctx, tags, abs_path, func(entry_path string, entry types.FsEntry) error {
+		name := base + strings.TrimPrefix(entry_path, abs_path)
+		if abs_path == "/" && entry_path != "/" {
+			name = base + entry_path
+		}
+		switch entry.EntryType {
+		case types.File:
+			if !entry.Permissions.IsAllowed(types.ReadMode, tags) {
+				report.skip(entry_path, types.ErrCantAccessFs)
+				return nil
+			}
+			if entry.FileReference != nil {
+				file_refs = append(file_refs, *entry.FileReference)
+			}
+		case types.Directory:
+			if len(entry.Entries) > 0 && !entry.Permissions.IsAllowed(types.ExecuteMode, tags) {
+				report.skip(entry_path, fmt.Errorf("contents left out: %w", types.ErrCantAccessFs))
+			}
+		}
+		queued = append(queued, archivedEntry{name: name, entry: entry})
+		manifest.Entries = append(manifest.Entries, ArchiveEntry{
+			Path:        name,
+			Type:        entry.EntryType.String(),
+			Target:      entry.Target,
+			Permissions: entry.Permissions,
+			CreatedAt:   entry.Timestamps.CreatedAt,
+			ModifiedAt:  entry.Timestamps.ModifiedAt,
+			AccessedAt:  entry.Timestamps.AccessedAt,
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sizes, err := s.Blobs.Sizes(ctx, file_refs)
+	if err != nil {
+		return nil, err
+	}
+	manifest_data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var archive archiveWriter
+	if format == TarArchive {
+		archive = tarWriter{tar.NewWriter(w)}
+	} else {
+		archive = zipWriter{zip.NewWriter(w)}
+	}
+	if err := archive.add(ArchiveManifestName, types.File, time.Now(), "", int64(len(manifest_data)), bytes.NewReader(manifest_data)); err != nil {
+		return nil, err
+	}
+
+	for _, queued := range queued {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		entry := queued.entry
+		var contents io.ReadCloser
+		size := int64(0)
+		if entry.EntryType == types.File && entry.FileReference != nil {
+			var ok bool
+			if size, ok = sizes[*entry.FileReference]; !ok {
+				return nil, fmt.Errorf("contents of %q: %w", queued.name, os.ErrNotExist)
+			}
+			if contents, err = s.Blobs.OpenDownload(ctx, *entry.FileReference); err != nil {
+				return nil, err
+			}
+		}
+		err := archive.add(queued.name, entry.EntryType, entry.Timestamps.ModifiedAt, entry.Target, size, contents)
+		if contents != nil {
+			contents.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.count(entry.EntryType)
+		report.Bytes += size
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// archiveWriter adds entries to a tar or zip archive
+type archiveWriter interface {
+	// add writes an entry. contents is nil for directories, symlinks and empty files.
+	add(name string, entryType types.FsEntryType, modified time.Time, target string, size int64, contents io.Reader) error
+	Close() error
+}
+
+type tarWriter struct {
+	*tar.Writer
+}
+
+func (t tarWriter) add(name string, entryType types.FsEntryType, modified time.Time, target string, size int64, contents io.Reader) error {
+	header := &tar.Header{Name: name, ModTime: modified, Format: tar.FormatPAX}
+	switch entryType {
+	case types.Directory:
+		header.Typeflag, header.Name, header.Mode = tar.TypeDir, name+"/", 0o755
+	case types.Symlink:
+		header.Typeflag, header.Linkname, header.Mode = tar.TypeSymlink, target, 0o777
+	default:
+		header.Typeflag, header.Size, header.Mode = tar.TypeReg, size, 0o644
+	}
+	if err := t.WriteHeader(header); err != nil {
+		return err
+	}
+	if contents != nil {
+		if _, err := io.Copy(t, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (z zipWriter) add(name string, entryType types.FsEntryType, modified time.Time, target string, size int64, contents io.Reader) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified}
+	switch entryType {
+	case types.Directory:
+		header.Name, header.Method = name+"/", zip.Store
+		header.SetMode(os.ModeDir | 0o755)
+	case types.Symlink:
+		// Zip stores symlinks as entries whose contents are the target
+		header.SetMode(os.ModeSymlink | 0o777)
+		contents = strings.NewReader(target)
+	default:
+		header.SetMode(0o644)
+	}
+	writer, err := z.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if contents != nil {
+		if _, err := io.Copy(writer, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportOptions controls how Import treats what's already at the destination and in the manifest
+type ImportOptions struct {
+	// Overwrite replaces existing files (keeping their old contents as a version) instead of skipping them
+	Overwrite bool
+	// Permissions restores archived permissions where CanUpdatePermTags allows, instead of inheriting the destination's
+	Permissions bool
+}
+
+// importedEntry is an entry Import created or updated, waiting for its metadata
+type importedEntry struct {
+	id   primitive.ObjectID
+	path string
+	// modified is the timestamp from the entry's archive header
+	modified time.Time
+}
+
+// Import unpacks a tar or zip archive (as written by Export, or any other) into the directory at dest_path,
+// which tags must be able to write to. Entries are created like any other, so they inherit the destination's
+// permissions and count against quotas. Timestamps, and with opts.Permissions permissions, are then restored
+// from the manifest (or the archive headers). Entries that can't be imported are reported as skipped.
+func (s Store) Import(ctx context.Context, r io.Reader, tags []string, dest_path string, opts ImportOptions) (*ArchiveReport, error) {
+	dest_path, err := s.Resolve(ctx, tags, dest_path)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := s.Lookup(ctx, tags, dest_path)
+	if err != nil {
+		return nil, err
+	}
+	if dest.EntryType != types.Directory {
+		return nil, fmt.Errorf("%w: %q is not a directory", os.ErrInvalid, dest_path)
+	}
+	if !dest.Permissions.IsAllowed(types.WriteMode, tags) || !dest.Permissions.IsAllowed(types.ExecuteMode, tags) {
+		return nil, types.ErrCantAccessFs
+	}
+
+	importer := archiveImporter{
+		store:    s,
+		ctx:      ctx,
+		tags:     tags,
+		opts:     opts,
+		dest:     dest_path,
+		dirs:     map[string]primitive.ObjectID{"": dest.ID},
+		manifest: map[string]ArchiveEntry{},
+		report:   ArchiveReport{Skipped: []ArchiveSkip{}},
+	}
+
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(262)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		err = importer.unzip(buffered)
+	case len(magic) == 262 && bytes.HasPrefix(magic[257:], []byte("ustar")):
+		err = importer.untar(buffered)
+	default:
+		err = ErrUnknownArchive
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := importer.restoreMetadata(); err != nil {
+		return nil, err
+	}
+	return &importer.report, nil
+}
+
+type archiveImporter struct {
+	store Store
+	ctx   context.Context
+	tags  []string
+	opts  ImportOptions
+	dest  string
+	// dirs maps archive paths of directories to their nodes ("" is the destination)
+	dirs     map[string]primitive.ObjectID
+	manifest map[string]ArchiveEntry
+	imported []importedEntry
+	report   ArchiveReport
+}
+
+func (i *archiveImporter) untar(r io.Reader) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = i.add(header.Name, types.Directory, header.ModTime, "", nil)
+		case tar.TypeSymlink:
+			err = i.add(header.Name, types.Symlink, header.ModTime, header.Linkname, nil)
+		case tar.TypeReg:
+			err = i.add(header.Name, types.File, header.ModTime, "", reader)
+		default:
+			i.report.skip(header.Name, fmt.Errorf("%w: unsupported tar entry type %q", os.ErrInvalid, header.Typeflag))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (i *archiveImporter) unzip(r io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(r, MaxZipImportSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxZipImportSize {
+		return fmt.Errorf("%w: zip archives can be at most %d bytes (use tar for bigger imports)", os.ErrInvalid, MaxZipImportSize)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	// The manifest comes first in our archives, but others may put it anywhere
+	files := slices.Clone(reader.File)
+	slices.SortStableFunc(files, func(a, b *zip.File) int {
+		if a.Name == ArchiveManifestName {
+			return -1
+		} else if b.Name == ArchiveManifestName {
+			return 1
+		}
+		return 0
+	})
+	for _, file := range files {
+		mode := file.Mode()
+		var err error
+		switch {
+		case mode.IsDir():
+			err = i.add(file.Name, types.Directory, file.Modified, "", nil)
+		case mode&os.ModeSymlink != 0:
+			var target []byte
+			if target, err = readZipFile(file); err == nil {
+				err = i.add(file.Name, types.Symlink, file.Modified, string(target), nil)
+			}
+		case mode.IsRegular():
+			var contents io.ReadCloser
+			if contents, err = file.Open(); err == nil {
+				err = i.add(file.Name, types.File, file.Modified, "", contents)
+				contents.Close()
+			}
+		default:
+			i.report.skip(file.Name, fmt.Errorf("%w: unsupported zip entry mode %v", os.ErrInvalid, mode))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// add imports one archive entry. Problems with the entry itself are reported as skipped, other errors returned.
+func (i *archiveImporter) add(name string, entryType types.FsEntryType, modified time.Time, target string, contents io.Reader) error {
+	if err := i.ctx.Err(); err != nil {
+		return err
+	}
+	clean := path.Clean(strings.TrimSuffix(name, "/"))
+	if name == ArchiveManifestName {
+		var manifest ArchiveManifest
+		data, err := io.ReadAll(contents)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &manifest); err != nil {
+			i.report.skip(name, fmt.Errorf("invalid manifest: %w", err))
+			return nil
+		}
+		for _, entry := range manifest.Entries {
+			i.manifest[path.Clean(entry.Path)] = entry
+		}
+		return nil
+	}
+	// Archives can't write outside the destination
+	if clean == "." || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		i.report.skip(name, fmt.Errorf("%w: path leaves the destination", os.ErrInvalid))
+		return nil
+	}
+
+	parent_name, filename := path.Split(clean)
+	parentID, err := i.dir(strings.TrimSuffix(parent_name, "/"))
+	if err != nil {
+		i.report.skip(name, err)
+		return nil
+	}
+	abs_path := strings.TrimSuffix(i.dest, "/") + "/" + clean
+
+	var entry *types.FsEntry
+	switch entryType {
+	case types.Directory:
+		if _, err := i.dir(clean); err != nil {
+			i.report.skip(name, err)
+			return nil
+		}
+		i.imported = append(i.imported, importedEntry{id: i.dirs[clean], path: clean, modified: modified})
+		i.report.count(entryType)
+		return nil
+	case types.Symlink:
+		entry, err = i.store.Symlink(i.ctx, abs_path, target, i.tags)
+	case types.File:
+		entry, err = i.writeFile(parentID, filename, contents)
+	}
+	if err != nil {
+		if i.ctx.Err() != nil {
+			return err
+		}
+		i.report.skip(name, err)
+		return nil
+	}
+
+	i.imported = append(i.imported, importedEntry{id: entry.ID, path: clean, modified: modified})
+	i.report.count(entryType)
+	return nil
+}
+
+// dir returns the directory at an archive path, creating it (and its parents) if needed
+func (i *archiveImporter) dir(name string) (primitive.ObjectID, error) {
+	if name == "." {
+		name = ""
+	}
+	if id, ok := i.dirs[name]; ok {
+		return id, nil
+	}
+	parent_name, dirname := path.Split(name)
+	parentID, err := i.dir(strings.TrimSuffix(parent_name, "/"))
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	entry, err := i.store.WriteDirectory(i.ctx, parentID, dirname, i.tags, nil)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if entry.EntryType != types.Directory {
+		return primitive.NilObjectID, fmt.Errorf("%w: %q exists and is a %s", os.ErrExist, name, entry.EntryType)
+	}
+	i.dirs[name] = entry.ID
+	return entry.ID, nil
+}
+
+func (i *archiveImporter) writeFile(parentID primitive.ObjectID, filename string, contents io.Reader) (*types.FsEntry, error) {
+	parent, err := i.store.Nodes.Get(i.ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := parent.Entries.Get(filename); exists && !i.opts.Overwrite {
+		return nil, os.ErrExist
+	}
+	// Stop the upload as soon as it can't fit in the quota
+	budget, err := i.store.WriteBudget(i.ctx, *parent, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fileRef := primitive.NewObjectID()
+	stream, err := i.store.Blobs.OpenUpload(i.ctx, fileRef)
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(LimitWriter(stream, budget), contents)
+	if err != nil {
+		if aborter, ok := stream.(Aborter); ok {
+			aborter.Abort()
+		} else {
+			stream.Close()
+		}
+		return nil, err
+	}
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+
+	entry, err := i.store.WriteFile(i.ctx, parentID, filename, fileRef, i.tags)
+	if err != nil {
+		i.store.releaseBlob(i.ctx, fileRef)
+		return nil, err
+	}
+	i.report.Bytes += size
+	return entry, nil
+}
+
+// restoreMetadata applies archived timestamps and permissions once everything is created,
+// so adding children doesn't touch their directories' times, and restored permissions can't get in the way
+func (i *archiveImporter) restoreMetadata() error {
+	for _, imported := range slices.Backward(i.imported) {
+		if err := i.ctx.Err(); err != nil {
+			return err
+		}
+		archived, ok := i.manifest[imported.path]
+		if !ok && imported.modified.IsZero() {
+			continue
+		}
+		update := NodeUpdate{ModifiedAt: &imported.modified, AccessedAt: &imported.modified}
+		if ok {
+			update.ModifiedAt, update.AccessedAt = &archived.ModifiedAt, &archived.AccessedAt
+			if i.opts.Permissions {
+				entry, err := i.store.Nodes.Get(i.ctx, imported.id)
+				if err != nil {
+					return err
+				}
+				if entry.Permissions.CanUpdatePermTags(archived.Permissions.UpdatePermissionTags, i.tags) {
+					update.Permissions = &archived.Permissions
+				} else {
+					i.report.skip(imported.path, fmt.Errorf("permissions not restored: %w", types.ErrCantAccessFs))
+				}
+			}
+		}
+		if _, err := i.store.Nodes.Update(i.ctx, imported.id, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
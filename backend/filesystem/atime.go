@@ -0,0 +1,174 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AtimePolicy decides when reading a node records its access time
+type AtimePolicy int
+
+const (
+	// RelAtime records an access only if the node changed since it was last accessed, or at most once a day
+	RelAtime AtimePolicy = iota
+	// NoAtime never records accesses
+	NoAtime
+	// StrictAtime records every access
+	StrictAtime
+)
+
+// relAtimeInterval is how stale an access time may get under RelAtime
+const relAtimeInterval = 24 * time.Hour
+
+// DefaultAtimeFlushInterval is how often the AtimeWriter writes queued access times
+const DefaultAtimeFlushInterval = 10 * time.Second
+
+func (p AtimePolicy) String() string {
+	switch p {
+	case RelAtime:
+		return "relatime"
+	case NoAtime:
+		return "noatime"
+	case StrictAtime:
+		return "strictatime"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseAtimePolicy(s string) (AtimePolicy, error) {
+	switch s {
+	case "relatime":
+		return RelAtime, nil
+	case "noatime":
+		return NoAtime, nil
+	case "strictatime":
+		return StrictAtime, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown atime policy %q (expected noatime, relatime or strictatime)", os.ErrInvalid, s)
+	}
+}
+
+// ParseAtimeOverrides parses per-subtree policies written as "/etc=noatime,/home=strictatime"
+func ParseAtimeOverrides(s string) (map[string]AtimePolicy, error) {
+	overrides := map[string]AtimePolicy{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		path, policy_str, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: atime override %q should look like /path=policy", os.ErrInvalid, item)
+		}
+		clean_path, err := CleanupAbsPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid atime override path %q: %w", path, err)
+		}
+		policy, err := ParseAtimePolicy(policy_str)
+		if err != nil {
+			return nil, err
+		}
+		overrides[clean_path] = policy
+	}
+
+	return overrides, nil
+}
+
+// AtimeWriter queues access times recorded by lookups and writes them in batches,
+// so reads never wait on (or contend with) a write.
+type AtimeWriter struct {
+	Nodes  NodeStore
+	Policy AtimePolicy
+	// Overrides sets the policy for a subtree, by absolute path. The deepest match wins.
+	Overrides map[string]AtimePolicy
+
+	mu      sync.Mutex
+	pending map[primitive.ObjectID]time.Time
+}
+
+func NewAtimeWriter(nodes NodeStore, policy AtimePolicy, overrides map[string]AtimePolicy) *AtimeWriter {
+	return &AtimeWriter{
+		Nodes:     nodes,
+		Policy:    policy,
+		Overrides: overrides,
+		pending:   map[primitive.ObjectID]time.Time{},
+	}
+}
+
+// PolicyFor returns the policy that applies to abs_path
+func (w *AtimeWriter) PolicyFor(abs_path string) AtimePolicy {
+	policy := w.Policy
+	longest := -1
+	for prefix, override := range w.Overrides {
+		if len(prefix) > longest && (abs_path == prefix || prefix == "/" || strings.HasPrefix(abs_path, prefix+"/")) {
+			policy = override
+			longest = len(prefix)
+		}
+	}
+	return policy
+}
+
+// Record queues an access to entry (found at abs_path) if the policy wants it recorded
+func (w *AtimeWriter) Record(entry types.FsEntry, abs_path string, at time.Time) {
+	switch w.PolicyFor(abs_path) {
+	case NoAtime:
+		return
+	case RelAtime:
+		last := entry.Timestamps.AccessedAt
+		if last.After(entry.Timestamps.ModifiedAt) && at.Sub(last) < relAtimeInterval {
+			return
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if queued, ok := w.pending[entry.ID]; !ok || at.After(queued) {
+		w.pending[entry.ID] = at
+	}
+}
+
+// Pending returns how many access times are waiting to be written
+func (w *AtimeWriter) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+// Flush writes every queued access time in one batch
+func (w *AtimeWriter) Flush(ctx context.Context) error {
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = map[primitive.ObjectID]time.Time{}
+	w.mu.Unlock()
+
+	return w.Nodes.Touch(ctx, batch)
+}
+
+// Run flushes every interval until ctx is done, then flushes one last time
+func (w *AtimeWriter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := w.Flush(context.Background()); err != nil {
+				log.Printf("[atime] final flush failed: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := w.Flush(ctx); err != nil {
+				log.Printf("[atime] flush failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NodeUpdate describes a partial update to a single FsEntry.
+// Nil fields are left untouched. RemoveEntries is applied before AddEntries,
+// so a directory entry can be replaced by name in a single update.
+type NodeUpdate struct {
+	FileReference *primitive.ObjectID
+	Permissions   *types.FsEntryPermissions
+	ModifiedAt    *time.Time
+	AccessedAt    *time.Time
+	ModifiedBy    *string
+	// Versions replaces the whole version list when non-nil
+	Versions      []types.FileVersion
+	RemoveEntries []string
+	AddEntries    []types.FsEntryReference
+	// IfRevision makes the update conditional: it fails with ErrRevisionConflict unless the node is still at this revision
+	IfRevision *int64
+	// BumpRevision increments the node's revision
+	BumpRevision bool
+	// ClearFileReference removes a file's contents (leaving it empty)
+	ClearFileReference bool
+	// Trash marks the node as trashed, and ClearTrash unmarks it
+	Trash      *types.TrashInfo
+	ClearTrash bool
+	// Defaults replaces a directory's default permissions for new children, and ClearDefaults removes them
+	Defaults      *types.DefaultPermissions
+	ClearDefaults bool
+}
+
+// ErrRevisionConflict is returned when a conditional write finds the node was changed by someone else
+var ErrRevisionConflict = errors.New("error: file was modified by someone else (revision conflict)")
+
+// NodeStore persists FsEntry nodes. Implementations return os.ErrNotExist for missing nodes.
+type NodeStore interface {
+	// Root returns the node flagged with is_root
+	Root(ctx context.Context) (*types.FsEntry, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*types.FsEntry, error)
+	Insert(ctx context.Context, entries ...types.FsEntry) error
+	// Update applies the update and returns the node as it is afterwards
+	Update(ctx context.Context, id primitive.ObjectID, update NodeUpdate) (*types.FsEntry, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// DeleteMany deletes several nodes in one round-trip. Missing ids are skipped.
+	DeleteMany(ctx context.Context, ids []primitive.ObjectID) error
+	// ReferencedBlobs counts the references to each of blobs from nodes' contents and version histories.
+	// Blobs nothing references are left out.
+	ReferencedBlobs(ctx context.Context, blobs []primitive.ObjectID) (map[primitive.ObjectID]int, error)
+	// ForEach calls fn for every stored node, stopping at the first error
+	ForEach(ctx context.Context, fn func(entry types.FsEntry) error) error
+	// ForEachOwned calls fn for every node owned by tag (its UpdatePermissionTags contain tag)
+	ForEachOwned(ctx context.Context, tag string, fn func(entry types.FsEntry) error) error
+	// GetMany returns the nodes with the given ids in one round-trip. Missing ids are skipped.
+	GetMany(ctx context.Context, ids []primitive.ObjectID) ([]types.FsEntry, error)
+	// GetByPaths returns every node whose materialized path is one of paths
+	GetByPaths(ctx context.Context, paths []string) ([]types.FsEntry, error)
+	// SetPaths updates the materialized path of several nodes at once
+	SetPaths(ctx context.Context, paths map[primitive.ObjectID]string) error
+	// Touch sets the access time of several nodes at once. An access time never moves backwards.
+	Touch(ctx context.Context, times map[primitive.ObjectID]time.Time) error
+}
+
+// Transactor is implemented by node stores that can run several operations as one transaction.
+// Calls made with the ctx passed to fn belong to the transaction, and nested calls join it.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Aborter is implemented by write streams that can be discarded instead of committed on close
+type Aborter interface {
+	Abort() error
+}
+
+// BlobStatter is implemented by blob stores that can report how much storage they use
+type BlobStatter interface {
+	Stats(ctx context.Context) (*BlobStats, error)
+}
+
+// BlobStats describes a blob store's storage use
+type BlobStats struct {
+	Blobs int64 `yaml:"blobs"`
+	// Bytes is the total size of the blobs' contents
+	Bytes int64 `yaml:"bytes"`
+	// Chunks and StorageBytes describe the backing storage, if the store knows it
+	Chunks       int64 `yaml:"chunks,omitempty"`
+	StorageBytes int64 `yaml:"storage_bytes,omitempty"`
+}
+
+// BlobStore persists file contents referenced by FsEntry.FileReference.
+// Implementations return os.ErrNotExist for missing blobs.
+type BlobStore interface {
+	// OpenUpload returns a writer whose contents become visible under id once closed,
+	// along with their checksum. The writer may implement Aborter.
+	OpenUpload(ctx context.Context, id primitive.ObjectID) (io.WriteCloser, error)
+	OpenDownload(ctx context.Context, id primitive.ObjectID) (io.ReadCloser, error)
+	Size(ctx context.Context, id primitive.ObjectID) (int64, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// Sizes returns the size of several blobs in one round-trip. Missing blobs are left out.
+	Sizes(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]int64, error)
+	// ForEach calls fn with the id and size of every stored blob, stopping at the first error
+	ForEach(ctx context.Context, fn func(id primitive.ObjectID, size int64) error) error
+	// Checksums returns the recorded checksums (see Checksum) of several blobs in one round-trip.
+	// Missing blobs, and blobs stored before checksums were recorded, are left out.
+	Checksums(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]string, error)
+	// FindChecksum returns the blobs recorded with the given checksum, oldest first
+	FindChecksum(ctx context.Context, sum string) ([]primitive.ObjectID, error)
+	// SetChecksum records a blob's checksum, for blobs stored before checksums were recorded
+	SetChecksum(ctx context.Context, id primitive.ObjectID, sum string) error
+	// Pin records that a blob was just handed out to share (see Store.sharedBlob), outside any transaction.
+	// Pinned blobs aren't freed for a grace period, so the write that shares one can land first.
+	Pin(ctx context.Context, id primitive.ObjectID) error
+	// Pins returns when each of several blobs was last pinned. Blobs never pinned are left out.
+	Pins(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]time.Time, error)
+}
@@ -0,0 +1,83 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewBlobReader returns a seekable reader of a blob of the given size (nil ref reads as empty), such as
+// http.ServeContent needs. Nothing is downloaded until the first read.
+func NewBlobReader(ctx context.Context, blobs BlobStore, ref *primitive.ObjectID, size int64) io.ReadSeekCloser {
+	return &blobReader{ctx: ctx, blobs: blobs, ref: ref, size: size}
+}
+
+// skipper is implemented by download streams that can skip ahead without reading (such as GridFS's)
+type skipper interface {
+	Skip(int64) (int64, error)
+}
+
+// blobReader is a seekable blob. Seeking only records the offset;
+// the next read (re)opens the download there, so reading a range only downloads what it needs.
+type blobReader struct {
+	ctx    context.Context
+	blobs  BlobStore
+	ref    *primitive.ObjectID
+	size   int64
+	offset int64
+
+	stream io.ReadCloser
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	if b.ref == nil || b.offset >= b.size {
+		return 0, io.EOF
+	}
+	if b.stream == nil {
+		stream, err := b.blobs.OpenDownload(b.ctx, *b.ref)
+		if err != nil {
+			return 0, err
+		}
+		b.stream = stream
+		if skipper, ok := stream.(skipper); ok {
+			_, err = skipper.Skip(b.offset)
+		} else {
+			_, err = io.CopyN(io.Discard, stream, b.offset)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := b.stream.Read(p)
+	b.offset += int64(n)
+	return n, err
+}
+
+func (b *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("%w: negative offset", os.ErrInvalid)
+	}
+	if offset != b.offset {
+		b.Close()
+		b.offset = offset
+	}
+	return offset, nil
+}
+
+func (b *blobReader) Close() error {
+	if b.stream == nil {
+		return nil
+	}
+	err := b.stream.Close()
+	b.stream = nil
+	return err
+}
@@ -0,0 +1,204 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Checksum is the hex SHA-256 of data, as blob stores record it
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// CorruptBlob is a blob whose contents no longer match its recorded checksum
+type CorruptBlob struct {
+	ID       string `yaml:"id"`
+	Expected string `yaml:"expected"`
+	Actual   string `yaml:"actual"`
+}
+
+// VerifyReport describes the outcome of re-hashing blobs
+type VerifyReport struct {
+	ScannedBlobs  int   `yaml:"scanned_blobs"`
+	ScannedBytes  int64 `yaml:"scanned_bytes"`
+	VerifiedBlobs int   `yaml:"verified_blobs"`
+	// RecordedBlobs had no checksum yet (they predate checksums), so theirs was recorded instead
+	RecordedBlobs int           `yaml:"recorded_blobs"`
+	Corrupt       []CorruptBlob `yaml:"corrupt"`
+	// Missing lists requested blobs that don't exist
+	Missing []string `yaml:"missing"`
+}
+
+// VerifyBlobs re-hashes the given blobs (every blob if ids is nil) and compares them to their recorded checksums.
+// Blobs without a checksum get one recorded, so they can be de-duplicated and verified from then on.
+func (s Store) VerifyBlobs(ctx context.Context, ids []primitive.ObjectID) (*VerifyReport, error) {
+	report := VerifyReport{Corrupt: []CorruptBlob{}, Missing: []string{}}
+	if ids == nil {
+		ids = []primitive.ObjectID{}
+		if err := s.Blobs.ForEach(ctx, func(id primitive.ObjectID, size int64) error {
+			ids = append(ids, id)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	checksums, err := s.Blobs.Checksums(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		stream, err := s.Blobs.OpenDownload(ctx, id)
+		if errors.Is(err, os.ErrNotExist) {
+			report.Missing = append(report.Missing, id.Hex())
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		size, err := io.Copy(hash, stream)
+		stream.Close()
+		if err != nil {
+			return nil, err
+		}
+		report.ScannedBlobs++
+		report.ScannedBytes += size
+
+		actual := hex.EncodeToString(hash.Sum(nil))
+		if expected, ok := checksums[id]; !ok {
+			if err := s.Blobs.SetChecksum(ctx, id, actual); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			report.RecordedBlobs++
+		} else if expected != actual {
+			report.Corrupt = append(report.Corrupt, CorruptBlob{ID: id.Hex(), Expected: expected, Actual: actual})
+		} else {
+			report.VerifiedBlobs++
+		}
+	}
+
+	return &report, nil
+}
+
+// ShareBlob returns the blob to write the contents uploaded to fileRef with: an existing copy of the
+// same contents (freeing fileRef), or fileRef itself. WriteFile already does this, but a write frees
+// its upload when it shares a copy, so callers writing one upload to several files call ShareBlob
+// once and write what it returns to each.
+func (s Store) ShareBlob(ctx context.Context, fileRef primitive.ObjectID) (primitive.ObjectID, error) {
+	shared, err := s.sharedBlob(ctx, fileRef)
+	if err != nil || shared == fileRef {
+		return fileRef, err
+	}
+	// sharedBlob pinned the shared copy, so it outlives a concurrent rm until the writes land
+	s.releaseBlob(ctx, fileRef)
+	return shared, nil
+}
+
+// sharedBlob finds an older blob with the same contents as id that's still in use, or returns id if there's none
+func (s Store) sharedBlob(ctx context.Context, id primitive.ObjectID) (primitive.ObjectID, error) {
+	checksums, err := s.Blobs.Checksums(ctx, []primitive.ObjectID{id})
+	if err != nil {
+		return id, err
+	}
+	sum, ok := checksums[id]
+	if !ok {
+		return id, nil
+	}
+	candidates, err := s.Blobs.FindChecksum(ctx, sum)
+	if err != nil {
+		return id, err
+	}
+	candidates = slices.DeleteFunc(candidates, func(candidate primitive.ObjectID) bool {
+		return candidate == id
+	})
+	if len(candidates) == 0 {
+		return id, nil
+	}
+
+	// Pin before checking references: a remove that takes away the last reference after the check
+	// then leaves the pinned blob for GC, instead of freeing it under the write that's about to use it
+	pinned := []primitive.ObjectID{}
+	for _, candidate := range candidates {
+		if err := s.Blobs.Pin(ctx, candidate); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return id, err
+		}
+		pinned = append(pinned, candidate)
+	}
+
+	// Unreferenced copies may be about to be garbage-collected, so only share ones in use
+	referenced, err := s.Nodes.ReferencedBlobs(ctx, pinned)
+	if err != nil {
+		return id, err
+	}
+	for _, candidate := range pinned {
+		if referenced[candidate] > 0 {
+			return candidate, nil
+		}
+	}
+	return id, nil
+}
+
+// releasedBlobsKey holds the blobs released inside an Atomic call, which are only freed once it commits
+type releasedBlobsKey struct{}
+
+// releaseBlobs frees the blobs nothing references anymore (and nobody pinned lately).
+// Inside Atomic, that waits until the transaction commits, so a rollback never loses contents.
+// Failures are only logged, since GC frees them later anyway.
+func (s Store) releaseBlobs(ctx context.Context, ids []primitive.ObjectID) {
+	if released, ok := ctx.Value(releasedBlobsKey{}).(*[]primitive.ObjectID); ok {
+		*released = append(*released, ids...)
+		return
+	}
+
+	cutoff := time.Now().Add(-DefaultGCGracePeriod)
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), removeBatchSize)]
+		ids = ids[len(batch):]
+		if err := s.freeBlobs(ctx, batch, cutoff); err != nil {
+			log.Printf("[VFS] couldn't free %d blobs: %v", len(batch), err)
+		}
+	}
+}
+
+// freeBlobs deletes the blobs in ids that no node references and that weren't pinned after cutoff
+func (s Store) freeBlobs(ctx context.Context, ids []primitive.ObjectID, cutoff time.Time) error {
+	referenced, err := s.Nodes.ReferencedBlobs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	pins, err := s.Blobs.Pins(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if referenced[id] > 0 || pins[id].After(cutoff) {
+			continue
+		}
+		if err := s.Blobs.Delete(ctx, id); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		// Listed twice (shared by several removed nodes): only delete it once
+		referenced[id]++
+	}
+	return nil
+}
+
+// releaseBlob is releaseBlobs for one blob
+func (s Store) releaseBlob(ctx context.Context, id primitive.ObjectID) {
+	s.releaseBlobs(ctx, []primitive.ObjectID{id})
+}
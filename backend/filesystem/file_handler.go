@@ -8,9 +8,7 @@ import (
 	"os"
 
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"mvdan.cc/sh/v3/interp"
 )
 
@@ -45,19 +43,15 @@ func (s Store) OpenHandler(user_tags []string) interp.OpenHandlerFunc {
 			if !ok {
 				return nil, os.ErrNotExist
 			}
-			var entry types.FsEntry
-			if err := s.Col.FindOne(ctx, bson.M{"_id": entryRef.RefID}).Decode(&entry); err != nil {
-				if err == mongo.ErrNoDocuments {
-					return nil, os.ErrNotExist
-				} else {
-					return nil, err
-				}
+			entry, err := s.Nodes.Get(ctx, entryRef.RefID)
+			if err != nil {
+				return nil, err
 			}
 
 			if entry.FileReference == nil {
 				return RdonlyFileStream{}, nil
 			} else {
-				stream, err := s.Bucket.OpenDownloadStream(entry.FileReference)
+				stream, err := s.Blobs.OpenDownload(ctx, *entry.FileReference)
 				if err != nil {
 					return nil, err
 				} else {
@@ -75,47 +69,57 @@ func (s Store) OpenHandler(user_tags []string) interp.OpenHandlerFunc {
 				return nil, os.ErrExist
 			}
 
-			fileRef := primitive.NewObjectID()
-			stream, err := s.Bucket.OpenUploadStreamWithID(fileRef, "")
-
-			if err != nil {
-				return nil, err
-			}
-
+			var entry *types.FsEntry
 			if flag&os.O_APPEND != 0 && entryExists {
-				var entry types.FsEntry
-				if err := s.Col.FindOne(ctx, bson.M{"_id": entryRef.RefID}).Decode(&entry); err != nil {
+				if entry, err = s.Nodes.Get(ctx, entryRef.RefID); err != nil {
 					return nil, err
 				}
 
 				if entry.EntryType != types.File {
 					return nil, fmt.Errorf("can't append text to a directory")
 				}
-				if entry.FileReference != nil {
-					download, err := s.Bucket.OpenDownloadStream(entry.FileReference)
-					if err != nil {
-						return nil, err
-					}
-
-					if _, err := io.Copy(stream, download); err != nil {
-						download.Close()
-						return nil, err
-					}
-
-					if err := download.Close(); err != nil {
-						return nil, err
-					}
-				}
 			}
 
-			return &WronlyFileStream{
+			budget, err := s.WriteBudget(ctx, *parent, filename)
+			if err != nil {
+				return nil, err
+			}
+			fileRef := primitive.NewObjectID()
+			stream, err := s.Blobs.OpenUpload(ctx, fileRef)
+
+			if err != nil {
+				return nil, err
+			}
+			writer := &WronlyFileStream{
 				FileRef:  fileRef,
 				ParentID: parent.ID,
 				Stream:   stream,
 				Store:    s,
 				FileName: filename,
 				UserTags: user_tags,
-			}, nil
+				quota:    &quotaWriter{Writer: stream, limit: budget},
+			}
+
+			if entry != nil && entry.FileReference != nil {
+				download, err := s.Blobs.OpenDownload(ctx, *entry.FileReference)
+				if err != nil {
+					writer.Abort()
+					return nil, err
+				}
+
+				if _, err := io.Copy(writer.quota, download); err != nil {
+					download.Close()
+					writer.Abort()
+					return nil, err
+				}
+
+				if err := download.Close(); err != nil {
+					writer.Abort()
+					return nil, err
+				}
+			}
+
+			return writer, nil
 		}
 
 		// At this point, if O_RDWR isn't set, it's a bug
@@ -132,6 +136,7 @@ func (s Store) OpenHandler(user_tags []string) interp.OpenHandlerFunc {
 		}
 		writeStream, err := fun(ctx, path, writeFlag, perm)
 		if err != nil {
+			readStream.Close()
 			return nil, err
 		}
 
@@ -164,14 +169,12 @@ func (s Store) StatHandler(user_tags []string) interp.StatHandlerFunc {
 		size := int64(0)
 		if entry.EntryType == types.File {
 			if entry.FileReference != nil {
-				var fileEntry struct {
-					Length int64 `bson:"length"`
-				}
-				if err := s.Bucket.GetFilesCollection().FindOne(ctx, bson.M{"_id": entry.FileReference}).Decode(&fileEntry); err != nil {
+				length, err := s.Blobs.Size(ctx, *entry.FileReference)
+				if err != nil {
 					return nil, err
 				}
 
-				size = fileEntry.Length
+				size = length
 			}
 		}
 
@@ -0,0 +1,321 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of corruption reported by Fsck
+const (
+	FsckDanglingReference = "dangling_reference" // A directory entry points at a node that doesn't exist
+	FsckUnreachable       = "unreachable"        // A node can't be reached from the root
+	FsckDuplicateName     = "duplicate_name"     // A directory has several entries with the same name
+	FsckMissingBlob       = "missing_blob"       // A file (or one of its versions) points at contents that don't exist
+)
+
+// DefaultFsckGracePeriod protects recently created nodes from being reported or recovered as unreachable.
+// Writes insert a node before linking it into its directory, so young unreachable nodes may still be in use.
+const DefaultFsckGracePeriod = time.Hour
+
+// LostAndFoundName is the root directory that fsck --repair moves unreachable nodes into
+const LostAndFoundName = "lost+found"
+
+type FsckIssue struct {
+	Kind    string `yaml:"kind"`
+	EntryID string `yaml:"entry_id"`
+	Path    string `yaml:"path,omitempty"` // Empty for unreachable nodes
+	Detail  string `yaml:"detail"`
+}
+
+type FsckReport struct {
+	ScannedEntries int `yaml:"scanned_entries"`
+	// RecentEntries counts unreachable nodes left alone because they were created within the grace period
+	RecentEntries int         `yaml:"recent_entries"`
+	Issues        []FsckIssue `yaml:"issues"`
+	// Recovered lists the paths unreachable nodes were moved to (only with repair)
+	Recovered []string `yaml:"recovered"`
+}
+
+// Fsck walks the tree from the root node and reports every inconsistency it finds.
+// With repair set, unreachable nodes are linked into /lost+found as "#<id>".
+// Other issues are only reported. Unreachable nodes created within grace (and what they hold)
+// are skipped, since a write may not have linked them in yet.
+func (s Store) Fsck(ctx context.Context, repair bool, grace time.Duration) (*FsckReport, error) {
+	cutoff := time.Now().Add(-grace)
+	if repair {
+		defer s.quotaUsageChanged()
+	}
+	report := FsckReport{Issues: []FsckIssue{}, Recovered: []string{}}
+
+	nodes := map[primitive.ObjectID]types.FsEntry{}
+	var root_id *primitive.ObjectID
+	if err := s.Nodes.ForEach(ctx, func(entry types.FsEntry) error {
+		report.ScannedEntries++
+		nodes[entry.ID] = entry
+		if entry.IsRoot {
+			id := entry.ID
+			root_id = &id
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if root_id == nil {
+		return nil, fmt.Errorf("%w: no root node", os.ErrNotExist)
+	}
+
+	blobs := map[primitive.ObjectID]struct{}{}
+	if err := s.Blobs.ForEach(ctx, func(id primitive.ObjectID, size int64) error {
+		blobs[id] = struct{}{}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Breadth-first from the root, remembering the first path each node is found at
+	paths := map[primitive.ObjectID]string{*root_id: "/"}
+	queue := []primitive.ObjectID{*root_id}
+	for len(queue) > 0 {
+		current := nodes[queue[0]]
+		current_path := paths[current.ID]
+		queue = queue[1:]
+
+		checkFile(&report, current, current_path, blobs)
+
+		seen_names := map[string]struct{}{}
+		for _, ref := range current.Entries {
+			child_path := current_path + "/" + ref.Name
+			if current_path == "/" {
+				child_path = "/" + ref.Name
+			}
+
+			if _, dup := seen_names[ref.Name]; dup {
+				report.Issues = append(report.Issues, FsckIssue{
+					Kind:    FsckDuplicateName,
+					EntryID: current.ID.Hex(),
+					Path:    current_path,
+					Detail:  fmt.Sprintf("name %q appears more than once", ref.Name),
+				})
+			}
+			seen_names[ref.Name] = struct{}{}
+
+			if _, ok := nodes[ref.RefID]; !ok {
+				report.Issues = append(report.Issues, FsckIssue{
+					Kind:    FsckDanglingReference,
+					EntryID: current.ID.Hex(),
+					Path:    current_path,
+					Detail:  fmt.Sprintf("entry %q points at missing node %s", ref.Name, ref.RefID.Hex()),
+				})
+				continue
+			}
+			if _, visited := paths[ref.RefID]; !visited {
+				paths[ref.RefID] = child_path
+				queue = append(queue, ref.RefID)
+			}
+		}
+	}
+
+	// Recent nodes may belong to a write that hasn't linked them in yet. Whatever they hold is theirs
+	// too: recovering it would list it in two directories once the write finishes.
+	recent := map[primitive.ObjectID]struct{}{}
+	var hold func(id primitive.ObjectID)
+	hold = func(id primitive.ObjectID) {
+		if _, ok := recent[id]; ok {
+			return
+		}
+		recent[id] = struct{}{}
+		for _, ref := range nodes[id].Entries {
+			if _, ok := nodes[ref.RefID]; ok {
+				hold(ref.RefID)
+			}
+		}
+	}
+	for id, entry := range nodes {
+		if _, ok := paths[id]; !ok && entry.Timestamps.CreatedAt.After(cutoff) {
+			hold(id)
+		}
+	}
+
+	unreachable := make([]primitive.ObjectID, 0)
+	for id := range nodes {
+		if _, ok := paths[id]; ok {
+			continue
+		}
+		if _, ok := recent[id]; ok {
+			report.RecentEntries++
+			continue
+		}
+		unreachable = append(unreachable, id)
+	}
+	// Stable output (map iteration order is random)
+	slices.SortFunc(unreachable, func(a, b primitive.ObjectID) int {
+		return slices.Compare(a[:], b[:])
+	})
+	for _, id := range unreachable {
+		checkFile(&report, nodes[id], "", blobs)
+		report.Issues = append(report.Issues, FsckIssue{
+			Kind:    FsckUnreachable,
+			EntryID: id.Hex(),
+			Detail:  fmt.Sprintf("%s isn't referenced from the root", nodes[id].EntryType),
+		})
+	}
+
+	if repair && len(unreachable) > 0 {
+		if err := s.recoverUnreachable(ctx, &report, nodes, *root_id, unreachable); err != nil {
+			return &report, err
+		}
+	}
+
+	return &report, nil
+}
+
+// checkFile reports missing contents for a file node
+func checkFile(report *FsckReport, entry types.FsEntry, path string, blobs map[primitive.ObjectID]struct{}) {
+	if entry.EntryType != types.File {
+		return
+	}
+
+	if entry.FileReference != nil {
+		if _, ok := blobs[*entry.FileReference]; !ok {
+			report.Issues = append(report.Issues, FsckIssue{
+				Kind:    FsckMissingBlob,
+				EntryID: entry.ID.Hex(),
+				Path:    path,
+				Detail:  fmt.Sprintf("contents %s don't exist", entry.FileReference.Hex()),
+			})
+		}
+	}
+	for _, version := range entry.Versions {
+		if _, ok := blobs[version.FileReference]; !ok {
+			report.Issues = append(report.Issues, FsckIssue{
+				Kind:    FsckMissingBlob,
+				EntryID: entry.ID.Hex(),
+				Path:    path,
+				Detail:  fmt.Sprintf("version contents %s don't exist", version.FileReference.Hex()),
+			})
+		}
+	}
+}
+
+// recoverUnreachable links the top of every unreachable subtree into /lost+found.
+// Nodes below a recovered node come back with it, so only the tops are moved.
+func (s Store) recoverUnreachable(ctx context.Context, report *FsckReport, nodes map[primitive.ObjectID]types.FsEntry, root_id primitive.ObjectID, unreachable []primitive.ObjectID) error {
+	now := time.Now()
+
+	referenced := map[primitive.ObjectID]struct{}{}
+	for _, id := range unreachable {
+		for _, ref := range nodes[id].Entries {
+			referenced[ref.RefID] = struct{}{}
+		}
+	}
+
+	covered := map[primitive.ObjectID]struct{}{}
+	var cover func(id primitive.ObjectID)
+	cover = func(id primitive.ObjectID) {
+		if _, ok := covered[id]; ok {
+			return
+		}
+		covered[id] = struct{}{}
+		for _, ref := range nodes[id].Entries {
+			if _, ok := nodes[ref.RefID]; ok {
+				cover(ref.RefID)
+			}
+		}
+	}
+
+	tops := make([]primitive.ObjectID, 0)
+	for _, id := range unreachable {
+		if _, ok := referenced[id]; !ok {
+			tops = append(tops, id)
+			cover(id)
+		}
+	}
+	// Whatever is left is part of a detached cycle, so any node in it will do
+	for _, id := range unreachable {
+		if _, ok := covered[id]; !ok {
+			tops = append(tops, id)
+			cover(id)
+		}
+	}
+
+	lost_found, err := s.lostAndFound(ctx, root_id)
+	if err != nil {
+		return err
+	}
+
+	refs := make([]types.FsEntryReference, len(tops))
+	for i, id := range tops {
+		refs[i] = types.FsEntryReference{Name: "#" + id.Hex(), RefID: id}
+	}
+	if _, err := s.Nodes.Update(ctx, lost_found.ID, NodeUpdate{
+		ModifiedAt: &now,
+		AccessedAt: &now,
+		AddEntries: refs,
+	}); err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if err := s.reindexPaths(ctx, ref.RefID, childPath(*lost_found, ref.Name)); err != nil {
+			return err
+		}
+		report.Recovered = append(report.Recovered, "/"+LostAndFoundName+"/"+ref.Name)
+	}
+	return nil
+}
+
+// lostAndFound returns /lost+found, creating it (readable by sysadmins only) if it doesn't exist
+func (s Store) lostAndFound(ctx context.Context, root_id primitive.ObjectID) (*types.FsEntry, error) {
+	now := time.Now()
+	root, err := s.Nodes.Get(ctx, root_id)
+	if err != nil {
+		return nil, err
+	}
+
+	if ref, ok := root.Entries.Get(LostAndFoundName); ok {
+		if entry, err := s.Nodes.Get(ctx, ref.RefID); err != nil {
+			return nil, err
+		} else if entry.EntryType != types.Directory {
+			return nil, fmt.Errorf("%w: /%s is not a directory", os.ErrInvalid, LostAndFoundName)
+		} else {
+			return entry, nil
+		}
+	}
+
+	sysadmin := []string{"sysadmin"}
+	created := types.FsEntry{
+		ID:        primitive.NewObjectID(),
+		EntryType: types.Directory,
+		Permissions: types.FsEntryPermissions{
+			ReadTags:             sysadmin,
+			WriteTags:            sysadmin,
+			ExecuteTags:          sysadmin,
+			UpdatePermissionTags: sysadmin,
+		},
+		Timestamps: types.FileTimestamps{
+			CreatedAt:  now,
+			ModifiedAt: now,
+			AccessedAt: now,
+		},
+		Entries: types.FsReferenceList{},
+		Path:    "/" + LostAndFoundName,
+	}
+	if err := s.Nodes.Insert(ctx, created); err != nil {
+		return nil, err
+	}
+	if _, err := s.Nodes.Update(ctx, root_id, NodeUpdate{
+		ModifiedAt: &now,
+		AccessedAt: &now,
+		AddEntries: []types.FsEntryReference{{Name: LostAndFoundName, RefID: created.ID}},
+	}); err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
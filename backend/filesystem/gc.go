@@ -0,0 +1,144 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultGCGracePeriod protects recently created blobs and nodes from collection.
+// Writes upload a blob before any node references it, so young blobs may still be in use.
+const DefaultGCGracePeriod = time.Hour
+
+// GCReport describes the outcome of a garbage collection run
+type GCReport struct {
+	DryRun         bool  `yaml:"dry_run"`
+	ScannedEntries int   `yaml:"scanned_entries"`
+	ScannedBlobs   int   `yaml:"scanned_blobs"`
+	RemovedBlobs   int   `yaml:"removed_blobs"`
+	ReclaimedBytes int64 `yaml:"reclaimed_bytes"`
+	// OrphanedEntries lists nodes that can't be reached from the root. They're reported, not removed.
+	OrphanedEntries []string `yaml:"orphaned_entries"`
+}
+
+// CollectGarbage deletes blobs that no node references (mark and sweep).
+// Blobs still referenced by orphaned nodes or by a file's version history are kept.
+// With dryRun set, nothing is deleted and the report shows what would be reclaimed.
+func (s Store) CollectGarbage(ctx context.Context, dryRun bool, grace time.Duration) (*GCReport, error) {
+	cutoff := time.Now().Add(-grace)
+	report := GCReport{DryRun: dryRun, OrphanedEntries: []string{}}
+
+	// Mark: every blob referenced by any node is live
+	live_blobs := map[primitive.ObjectID]struct{}{}
+	children := map[primitive.ObjectID][]primitive.ObjectID{}
+	var root_id *primitive.ObjectID
+	if err := s.Nodes.ForEach(ctx, func(entry types.FsEntry) error {
+		report.ScannedEntries++
+		if entry.IsRoot {
+			id := entry.ID
+			root_id = &id
+		}
+		if entry.FileReference != nil {
+			live_blobs[*entry.FileReference] = struct{}{}
+		}
+		for _, version := range entry.Versions {
+			live_blobs[version.FileReference] = struct{}{}
+		}
+		refs := make([]primitive.ObjectID, len(entry.Entries))
+		for i, ref := range entry.Entries {
+			refs[i] = ref.RefID
+		}
+		children[entry.ID] = refs
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if root_id == nil {
+		return nil, os.ErrNotExist
+	}
+
+	reachable := map[primitive.ObjectID]struct{}{*root_id: {}}
+	queue := []primitive.ObjectID{*root_id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, child := range children[current] {
+			if _, seen := reachable[child]; !seen {
+				reachable[child] = struct{}{}
+				queue = append(queue, child)
+			}
+		}
+	}
+	for id := range children {
+		if _, ok := reachable[id]; !ok && id.Timestamp().Before(cutoff) {
+			report.OrphanedEntries = append(report.OrphanedEntries, id.Hex())
+		}
+	}
+
+	// Sweep: collect candidates first, so deletes don't race the blob listing
+	garbage := map[primitive.ObjectID]int64{}
+	if err := s.Blobs.ForEach(ctx, func(id primitive.ObjectID, size int64) error {
+		report.ScannedBlobs++
+		if _, ok := live_blobs[id]; !ok && id.Timestamp().Before(cutoff) {
+			garbage[id] = size
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Blobs handed out to share lately may be about to be referenced again
+	candidates := make([]primitive.ObjectID, 0, len(garbage))
+	for id := range garbage {
+		candidates = append(candidates, id)
+	}
+	pins, err := s.Blobs.Pins(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	for id, pinned := range pins {
+		if pinned.After(cutoff) {
+			delete(garbage, id)
+		}
+	}
+
+	for id, size := range garbage {
+		if !dryRun {
+			if err := s.Blobs.Delete(ctx, id); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+		}
+		report.RemovedBlobs++
+		report.ReclaimedBytes += size
+	}
+
+	return &report, nil
+}
+
+// ScheduleGC runs CollectGarbage every interval until ctx is done
+func ScheduleGC(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if report, err := store.CollectGarbage(ctx, false, DefaultGCGracePeriod); err != nil {
+				log.Printf("[GC] run failed: %v", err)
+			} else {
+				log.Printf("[GC] removed %d blobs (%d bytes), %d orphaned entries", report.RemovedBlobs, report.ReclaimedBytes, len(report.OrphanedEntries))
+			}
+		}
+	}
+}
@@ -9,15 +9,16 @@ import (
 	"os"
 
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
 	"github.com/RoundRobinHood/sh"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type FSContext struct {
 	Store    Store
 	UserTags []string
+	// HonourLocks makes Open fail with ErrLocked when another socket client's lock excludes the access
+	HonourLocks bool
 }
 
 func (c *FSContext) Stat(ctx context.Context, path string) (sh.FileInfo, error) {
@@ -55,6 +56,12 @@ func (c *FSContext) Stat(ctx context.Context, path string) (sh.FileInfo, error)
 }
 
 func (c *FSContext) Open(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+	// Follow symlinks up front so reads and writes land on the link target
+	path, err := c.Store.Resolve(ctx, c.UserTags, path)
+	if err != nil {
+		return nil, err
+	}
+
 	folder_path, filename, err := DirUp(path)
 	if err != nil {
 		return nil, err
@@ -77,23 +84,22 @@ func (c *FSContext) Open(ctx context.Context, path string, flag int, perm os.Fil
 		if !ok {
 			return nil, os.ErrNotExist
 		}
-		var entry types.FsEntry
-		if err := c.Store.Col.FindOne(ctx, bson.M{"_id": entryRef.RefID}).Decode(&entry); err != nil {
-			if err == mongo.ErrNoDocuments {
-				return nil, os.ErrNotExist
-			} else {
-				return nil, err
-			}
+		entry, err := c.Store.Nodes.Get(ctx, entryRef.RefID)
+		if err != nil {
+			return nil, err
 		}
 
 		if !entry.Permissions.IsAllowed(types.ReadMode, c.UserTags) {
 			return nil, types.ErrCantAccessFs
 		}
+		if err := c.checkLock(ctx, entry.ID, types.ReadMode); err != nil {
+			return nil, err
+		}
 
 		if entry.FileReference == nil {
 			return RdonlyFileStream{}, nil
 		} else {
-			stream, err := c.Store.Bucket.OpenDownloadStream(entry.FileReference)
+			stream, err := c.Store.Blobs.OpenDownload(ctx, *entry.FileReference)
 			if err != nil {
 				return nil, err
 			} else {
@@ -103,59 +109,7 @@ func (c *FSContext) Open(ctx context.Context, path string, flag int, perm os.Fil
 	}
 
 	if open_mode == os.O_WRONLY {
-		entryRef, entryExists := parent.Entries.Get(filename)
-		if flag&os.O_CREATE == 0 && !entryExists {
-			return nil, os.ErrNotExist
-		}
-		if flag&os.O_EXCL != 0 && entryExists {
-			return nil, os.ErrExist
-		}
-
-		fileRef := primitive.NewObjectID()
-		stream, err := c.Store.Bucket.OpenUploadStreamWithID(fileRef, "")
-
-		if err != nil {
-			return nil, err
-		}
-
-		if flag&os.O_APPEND != 0 && entryExists {
-			var entry types.FsEntry
-			if err := c.Store.Col.FindOne(ctx, bson.M{"_id": entryRef.RefID}).Decode(&entry); err != nil {
-				return nil, err
-			}
-
-			if !entry.Permissions.IsAllowed(types.WriteMode, c.UserTags) {
-				return nil, types.ErrCantAccessFs
-			}
-
-			if entry.EntryType != types.File {
-				return nil, fmt.Errorf("can't append text to a directory")
-			}
-			if entry.FileReference != nil {
-				download, err := c.Store.Bucket.OpenDownloadStream(entry.FileReference)
-				if err != nil {
-					return nil, err
-				}
-
-				if _, err := io.Copy(stream, download); err != nil {
-					download.Close()
-					return nil, err
-				}
-
-				if err := download.Close(); err != nil {
-					return nil, err
-				}
-			}
-		}
-
-		return &WronlyFileStream{
-			FileRef:  fileRef,
-			ParentID: parent.ID,
-			Stream:   stream,
-			Store:    c.Store,
-			FileName: filename,
-			UserTags: c.UserTags,
-		}, nil
+		return c.openWriter(ctx, parent, filename, flag)
 	}
 
 	// At this point, if O_RDWR isn't set, it's a bug
@@ -166,12 +120,14 @@ func (c *FSContext) Open(ctx context.Context, path string, flag int, perm os.Fil
 	readFlag := (flag&^os.O_RDWR | os.O_RDONLY)
 	writeFlag := (flag&^os.O_RDWR | os.O_WRONLY)
 
-	readStream, err := c.Open(ctx, path, readFlag, perm)
+	// Open the writer first: it pins the revision, so if the file changes before the read, the write conflicts instead of clobbering it
+	writeStream, err := c.openWriter(ctx, parent, filename, writeFlag)
 	if err != nil {
 		return nil, err
 	}
-	writeStream, err := c.Open(ctx, path, writeFlag, perm)
+	readStream, err := c.Open(ctx, path, readFlag, perm)
 	if err != nil {
+		writeStream.Abort()
 		return nil, err
 	}
 
@@ -180,3 +136,94 @@ func (c *FSContext) Open(ctx context.Context, path string, flag int, perm os.Fil
 		WriteStream: writeStream,
 	}, nil
 }
+
+// openWriter opens filename in parent for writing. Unless the open truncates (so the old contents don't matter),
+// the write only commits if nobody else wrote the file since it was opened: appends and read-modify-writes
+// fail with ErrRevisionConflict instead of losing someone else's write.
+func (c *FSContext) openWriter(ctx context.Context, parent *types.FsEntry, filename string, flag int) (*WronlyFileStream, error) {
+	entryRef, entryExists := parent.Entries.Get(filename)
+	if flag&os.O_CREATE == 0 && !entryExists {
+		return nil, os.ErrNotExist
+	}
+	if flag&os.O_EXCL != 0 && entryExists {
+		return nil, os.ErrExist
+	}
+
+	// 0 means the file doesn't exist yet
+	var entry *types.FsEntry
+	revision := int64(0)
+	if entryExists {
+		var err error
+		if entry, err = c.Store.Nodes.Get(ctx, entryRef.RefID); err != nil {
+			return nil, err
+		}
+		revision = entry.Revision
+		if err := c.checkLock(ctx, entry.ID, types.WriteMode); err != nil {
+			return nil, err
+		}
+	}
+
+	appending := flag&os.O_APPEND != 0 && entryExists
+	if appending {
+		if !entry.Permissions.IsAllowed(types.WriteMode, c.UserTags) {
+			return nil, types.ErrCantAccessFs
+		}
+		if entry.EntryType != types.File {
+			return nil, fmt.Errorf("can't append text to a directory")
+		}
+	}
+
+	budget, err := c.Store.WriteBudget(ctx, *parent, filename)
+	if err != nil {
+		return nil, err
+	}
+	fileRef := primitive.NewObjectID()
+	stream, err := c.Store.Blobs.OpenUpload(ctx, fileRef)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &WronlyFileStream{
+		FileRef:  fileRef,
+		ParentID: parent.ID,
+		Stream:   stream,
+		Store:    c.Store,
+		FileName: filename,
+		UserTags: c.UserTags,
+		Writer:   writerName(ctx),
+		quota:    &quotaWriter{Writer: stream, limit: budget},
+	}
+	if flag&os.O_TRUNC == 0 {
+		writer.IfRevision = &revision
+	}
+
+	if appending && entry.FileReference != nil {
+		download, err := c.Store.Blobs.OpenDownload(ctx, *entry.FileReference)
+		if err != nil {
+			writer.Abort()
+			return nil, err
+		}
+
+		if _, err := io.Copy(writer.quota, download); err != nil {
+			download.Close()
+			writer.Abort()
+			return nil, err
+		}
+
+		if err := download.Close(); err != nil {
+			writer.Abort()
+			return nil, err
+		}
+	}
+
+	return writer, nil
+}
+
+// checkLock fails if locks are honoured and someone else's lock excludes the access
+func (c *FSContext) checkLock(ctx context.Context, id primitive.ObjectID, access types.FsAccessMode) error {
+	if !c.HonourLocks {
+		return nil
+	}
+	owner, _ := util.GetLockOwner(ctx)
+	return c.Store.CheckLock(ctx, id, owner, access)
+}
@@ -0,0 +1,286 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultLockTTL is how long a lock lasts when no TTL is given
+const DefaultLockTTL = 5 * time.Minute
+
+// MaxLockTTL bounds lock TTLs, so a forgotten lock can't block an entry for long
+const MaxLockTTL = 24 * time.Hour
+
+var ErrLocked = errors.New("error: file is locked by someone else")
+var ErrNotLocked = errors.New("error: you don't hold a lock on this file")
+var ErrLockingDisabled = errors.New("error: locking is not available")
+var ErrNoLockOwner = errors.New("error: locks can only be taken by socket clients")
+
+// LockStore holds the advisory locks of a Store. Locks only exclude callers that check them
+// (FSContext with HonourLocks, and other lockers).
+type LockStore interface {
+	// Acquire adds lock, replacing any lock its owner already holds on the entry (which upgrades,
+	// downgrades or renews it). If another owner's lock conflicts, it fails with ErrLocked,
+	// or with wait, waits until that lock is released or expires.
+	Acquire(ctx context.Context, lock types.FileLock, wait bool) (types.FileLock, error)
+	// Check fails with ErrLocked if another owner holds a lock that excludes owner's access to an entry.
+	// Reading is excluded by exclusive locks, and writing by any lock.
+	Check(ctx context.Context, id primitive.ObjectID, owner types.LockOwner, access types.FsAccessMode) error
+	// Release drops owner's lock on an entry, reporting whether it held one
+	Release(ctx context.Context, id primitive.ObjectID, owner types.LockOwner) (bool, error)
+	// ReleaseEntry drops every lock on an entry, returning how many there were
+	ReleaseEntry(ctx context.Context, id primitive.ObjectID) (int, error)
+	// ReleaseSocket drops every lock held by a socket's clients, returning how many there were
+	ReleaseSocket(ctx context.Context, socketID string) (int, error)
+	// List returns the locks that haven't expired, oldest first
+	List(ctx context.Context) ([]types.FileLock, error)
+}
+
+// LockTable is a LockStore that keeps locks in process memory. They're forgotten on restart
+// and only seen by this instance, so it's only fit for a single backend; use MongoLocks when
+// several instances share a database.
+type LockTable struct {
+	mu    sync.Mutex
+	locks map[primitive.ObjectID][]types.FileLock
+	// released is closed (and replaced) whenever locks are released, to wake waiters
+	released chan struct{}
+}
+
+func NewLockTable() *LockTable {
+	return &LockTable{
+		locks:    map[primitive.ObjectID][]types.FileLock{},
+		released: make(chan struct{}),
+	}
+}
+
+func (t *LockTable) Acquire(ctx context.Context, lock types.FileLock, wait bool) (types.FileLock, error) {
+	for {
+		t.mu.Lock()
+		held := t.live(lock.EntryID, time.Now())
+		conflict, ok := conflictingLock(held, lock.Owner, lock.Mode)
+		if !ok {
+			t.locks[lock.EntryID] = append(slices.DeleteFunc(held, func(l types.FileLock) bool {
+				return l.Owner == lock.Owner
+			}), lock)
+			t.mu.Unlock()
+			return lock, nil
+		}
+		released := t.released
+		t.mu.Unlock()
+
+		if !wait {
+			return types.FileLock{}, lockedError(conflict)
+		}
+		timer := time.NewTimer(time.Until(conflict.ExpiresAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return types.FileLock{}, ctx.Err()
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (t *LockTable) Check(ctx context.Context, id primitive.ObjectID, owner types.LockOwner, access types.FsAccessMode) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if conflict, ok := conflictingLock(t.live(id, time.Now()), owner, accessLockMode(access)); ok {
+		return lockedError(conflict)
+	}
+	return nil
+}
+
+func (t *LockTable) Release(ctx context.Context, id primitive.ObjectID, owner types.LockOwner) (bool, error) {
+	return t.release(func(lock types.FileLock) bool {
+		return lock.EntryID == id && lock.Owner == owner
+	}) > 0, nil
+}
+
+func (t *LockTable) ReleaseEntry(ctx context.Context, id primitive.ObjectID) (int, error) {
+	return t.release(func(lock types.FileLock) bool {
+		return lock.EntryID == id
+	}), nil
+}
+
+func (t *LockTable) ReleaseSocket(ctx context.Context, socketID string) (int, error) {
+	return t.release(func(lock types.FileLock) bool {
+		return lock.Owner.SocketID == socketID
+	}), nil
+}
+
+func (t *LockTable) List(ctx context.Context) ([]types.FileLock, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	locks := []types.FileLock{}
+	for id := range t.locks {
+		locks = append(locks, t.live(id, now)...)
+	}
+	sortLocks(locks)
+	return locks, nil
+}
+
+func (t *LockTable) release(match func(lock types.FileLock) bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := 0
+	for id, locks := range t.locks {
+		kept := slices.DeleteFunc(locks, match)
+		count += len(locks) - len(kept)
+		if len(kept) == 0 {
+			delete(t.locks, id)
+		} else {
+			t.locks[id] = kept
+		}
+	}
+	if count > 0 {
+		close(t.released)
+		t.released = make(chan struct{})
+	}
+	return count
+}
+
+// live drops an entry's expired locks and returns the rest. t.mu must be held.
+func (t *LockTable) live(id primitive.ObjectID, now time.Time) []types.FileLock {
+	locks := liveLocks(t.locks[id], now)
+	if len(locks) == 0 {
+		delete(t.locks, id)
+		return nil
+	}
+	t.locks[id] = locks
+	return slices.Clone(locks)
+}
+
+// liveLocks drops the locks that have expired by now
+func liveLocks(locks []types.FileLock, now time.Time) []types.FileLock {
+	return slices.DeleteFunc(locks, func(lock types.FileLock) bool {
+		return !now.Before(lock.ExpiresAt)
+	})
+}
+
+func sortLocks(locks []types.FileLock) {
+	slices.SortFunc(locks, func(a, b types.FileLock) int {
+		return a.AcquiredAt.Compare(b.AcquiredAt)
+	})
+}
+
+// accessLockMode is the lock mode whose holders an access has to respect: reads respect exclusive locks, writes any lock
+func accessLockMode(access types.FsAccessMode) types.LockMode {
+	if access == types.WriteMode {
+		return types.ExclusiveLock
+	}
+	return types.SharedLock
+}
+
+// conflictingLock finds a lock held by someone other than owner that excludes a lock in mode
+func conflictingLock(held []types.FileLock, owner types.LockOwner, mode types.LockMode) (types.FileLock, bool) {
+	for _, lock := range held {
+		if lock.Owner != owner && (mode == types.ExclusiveLock || lock.Mode == types.ExclusiveLock) {
+			return lock, true
+		}
+	}
+	return types.FileLock{}, false
+}
+
+func lockedError(lock types.FileLock) error {
+	return fmt.Errorf("%w (%s lock held by %q until %s)", ErrLocked, lock.Mode, lock.Username, lock.ExpiresAt.Format(time.RFC3339))
+}
+
+// Lock takes an advisory lock on the entry at abs_path (following symlinks) for owner, lasting ttl.
+// Shared locks need read access to the entry, and exclusive locks need write access.
+// If someone else's lock conflicts, it fails with ErrLocked, or with wait, waits for it to go away.
+func (s Store) Lock(ctx context.Context, abs_path string, tags []string, owner types.LockOwner, mode types.LockMode, ttl time.Duration, wait bool) (*types.FileLock, error) {
+	if s.Locks == nil {
+		return nil, ErrLockingDisabled
+	}
+	if owner == (types.LockOwner{}) {
+		return nil, ErrNoLockOwner
+	}
+	if ttl <= 0 || ttl > MaxLockTTL {
+		return nil, fmt.Errorf("%w: lock TTL must be positive and at most %v", os.ErrInvalid, MaxLockTTL)
+	}
+	access := types.ReadMode
+	switch mode {
+	case types.SharedLock:
+	case types.ExclusiveLock:
+		access = types.WriteMode
+	default:
+		return nil, fmt.Errorf("%w: unknown lock mode %q", os.ErrInvalid, mode)
+	}
+
+	abs_path, err := s.Resolve(ctx, tags, abs_path)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := s.Lookup(ctx, tags, abs_path)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.Permissions.IsAllowed(access, tags) {
+		return nil, types.ErrCantAccessFs
+	}
+
+	now := time.Now()
+	lock, err := s.Locks.Acquire(ctx, types.FileLock{
+		EntryID:    entry.ID,
+		Path:       abs_path,
+		Mode:       mode,
+		Owner:      owner,
+		Username:   writerName(ctx),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}, wait)
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// Unlock releases owner's lock on the entry at abs_path (following symlinks)
+func (s Store) Unlock(ctx context.Context, abs_path string, tags []string, owner types.LockOwner) error {
+	if s.Locks == nil {
+		return ErrLockingDisabled
+	}
+	entry, err := s.Lookup(ctx, tags, abs_path)
+	if err != nil {
+		return err
+	}
+	if released, err := s.Locks.Release(ctx, entry.ID, owner); err != nil {
+		return err
+	} else if !released {
+		return ErrNotLocked
+	}
+	return nil
+}
+
+// BreakLocks releases everyone's locks on the entry at abs_path (following symlinks), returning how many there were
+func (s Store) BreakLocks(ctx context.Context, abs_path string, tags []string) (int, error) {
+	if s.Locks == nil {
+		return 0, ErrLockingDisabled
+	}
+	entry, err := s.Lookup(ctx, tags, abs_path)
+	if err != nil {
+		return 0, err
+	}
+	return s.Locks.ReleaseEntry(ctx, entry.ID)
+}
+
+// CheckLock fails with ErrLocked if someone other than owner holds a lock excluding read or write access to an entry
+func (s Store) CheckLock(ctx context.Context, id primitive.ObjectID, owner types.LockOwner, access types.FsAccessMode) error {
+	if s.Locks == nil {
+		return nil
+	}
+	return s.Locks.Check(ctx, id, owner, access)
+}
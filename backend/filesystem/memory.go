@@ -0,0 +1,498 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryNodes keeps FsEntry nodes in a map. It is meant for tests and local tooling.
+type MemoryNodes struct {
+	nodes map[primitive.ObjectID]types.FsEntry
+	mu    *sync.RWMutex
+}
+
+// MemoryBlobs keeps file contents in a map. It is meant for tests and local tooling.
+type MemoryBlobs struct {
+	blobs     map[primitive.ObjectID][]byte
+	checksums map[primitive.ObjectID]string
+	pins      map[primitive.ObjectID]time.Time
+	mu        *sync.RWMutex
+}
+
+func NewMemoryNodes() MemoryNodes {
+	return MemoryNodes{
+		nodes: map[primitive.ObjectID]types.FsEntry{},
+		mu:    new(sync.RWMutex),
+	}
+}
+
+func NewMemoryBlobs() MemoryBlobs {
+	return MemoryBlobs{
+		blobs:     map[primitive.ObjectID][]byte{},
+		checksums: map[primitive.ObjectID]string{},
+		pins:      map[primitive.ObjectID]time.Time{},
+		mu:        new(sync.RWMutex),
+	}
+}
+
+func NewMemoryStore() Store {
+	return Store{
+		Nodes: NewMemoryNodes(),
+		Blobs: NewMemoryBlobs(),
+	}
+}
+
+func clonePermissions(p types.FsEntryPermissions) types.FsEntryPermissions {
+	return types.FsEntryPermissions{
+		ReadTags:             slices.Clone(p.ReadTags),
+		WriteTags:            slices.Clone(p.WriteTags),
+		ExecuteTags:          slices.Clone(p.ExecuteTags),
+		UpdatePermissionTags: slices.Clone(p.UpdatePermissionTags),
+	}
+}
+
+func cloneDefaults(d *types.DefaultPermissions) *types.DefaultPermissions {
+	if d == nil {
+		return nil
+	}
+	clone := types.DefaultPermissions{}
+	if d.Files != nil {
+		perms := clonePermissions(*d.Files)
+		clone.Files = &perms
+	}
+	if d.Directories != nil {
+		perms := clonePermissions(*d.Directories)
+		clone.Directories = &perms
+	}
+	return &clone
+}
+
+func cloneEntry(entry types.FsEntry) types.FsEntry {
+	clone := entry
+	clone.Permissions = clonePermissions(entry.Permissions)
+	clone.Entries = slices.Clone(entry.Entries)
+	clone.Versions = slices.Clone(entry.Versions)
+	if entry.FileReference != nil {
+		ref := *entry.FileReference
+		clone.FileReference = &ref
+	}
+	if entry.Trash != nil {
+		info := *entry.Trash
+		clone.Trash = &info
+	}
+	clone.Defaults = cloneDefaults(entry.Defaults)
+
+	return clone
+}
+
+func (m MemoryNodes) Root(ctx context.Context) (*types.FsEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, entry := range m.nodes {
+		if entry.IsRoot && entry.EntryType == types.Directory {
+			root := cloneEntry(entry)
+			return &root, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+func (m MemoryNodes) Get(ctx context.Context, id primitive.ObjectID) (*types.FsEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if entry, ok := m.nodes[id]; ok {
+		clone := cloneEntry(entry)
+		return &clone, nil
+	} else {
+		return nil, os.ErrNotExist
+	}
+}
+
+func (m MemoryNodes) Insert(ctx context.Context, entries ...types.FsEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, entry := range entries {
+		if _, ok := m.nodes[entry.ID]; ok {
+			return os.ErrExist
+		}
+	}
+	for _, entry := range entries {
+		m.nodes[entry.ID] = cloneEntry(entry)
+	}
+
+	return nil
+}
+
+func (m MemoryNodes) Update(ctx context.Context, id primitive.ObjectID, update NodeUpdate) (*types.FsEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.nodes[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if update.IfRevision != nil && entry.Revision != *update.IfRevision {
+		return nil, ErrRevisionConflict
+	}
+	if update.BumpRevision {
+		entry.Revision++
+	}
+
+	if update.FileReference != nil {
+		ref := *update.FileReference
+		entry.FileReference = &ref
+	}
+	if update.ClearFileReference {
+		entry.FileReference = nil
+	}
+	if update.Permissions != nil {
+		entry.Permissions = clonePermissions(*update.Permissions)
+	}
+	if update.ModifiedAt != nil {
+		entry.Timestamps.ModifiedAt = *update.ModifiedAt
+	}
+	if update.AccessedAt != nil {
+		entry.Timestamps.AccessedAt = *update.AccessedAt
+	}
+	if update.ModifiedBy != nil {
+		entry.ModifiedBy = *update.ModifiedBy
+	}
+	if update.Versions != nil {
+		entry.Versions = slices.Clone(update.Versions)
+	}
+	if update.Trash != nil {
+		info := *update.Trash
+		entry.Trash = &info
+	}
+	if update.ClearTrash {
+		entry.Trash = nil
+	}
+	if update.Defaults != nil {
+		entry.Defaults = cloneDefaults(update.Defaults)
+	}
+	if update.ClearDefaults {
+		entry.Defaults = nil
+	}
+	if len(update.RemoveEntries) > 0 {
+		entry.Entries = slices.DeleteFunc(slices.Clone(entry.Entries), func(ref types.FsEntryReference) bool {
+			return slices.Contains(update.RemoveEntries, ref.Name)
+		})
+	}
+	for _, ref := range update.AddEntries {
+		if !slices.Contains(entry.Entries, ref) {
+			entry.Entries = append(entry.Entries, ref)
+		}
+	}
+
+	m.nodes[id] = entry
+	updated := cloneEntry(entry)
+	return &updated, nil
+}
+
+func (m MemoryNodes) Delete(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.nodes[id]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m.nodes, id)
+
+	return nil
+}
+
+func (m MemoryNodes) ForEach(ctx context.Context, fn func(entry types.FsEntry) error) error {
+	// Snapshot first so fn can modify the store
+	m.mu.RLock()
+	entries := make([]types.FsEntry, 0, len(m.nodes))
+	for _, entry := range m.nodes {
+		entries = append(entries, cloneEntry(entry))
+	}
+	m.mu.RUnlock()
+
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m MemoryNodes) ForEachOwned(ctx context.Context, tag string, fn func(entry types.FsEntry) error) error {
+	return m.ForEach(ctx, func(entry types.FsEntry) error {
+		if slices.Contains(entry.Permissions.UpdatePermissionTags, tag) {
+			return fn(entry)
+		}
+		return nil
+	})
+}
+
+func (m MemoryNodes) DeleteMany(ctx context.Context, ids []primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		delete(m.nodes, id)
+	}
+
+	return nil
+}
+
+func (m MemoryNodes) ReferencedBlobs(ctx context.Context, blobs []primitive.ObjectID) (map[primitive.ObjectID]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	referenced := map[primitive.ObjectID]int{}
+	for _, entry := range m.nodes {
+		if entry.FileReference != nil && slices.Contains(blobs, *entry.FileReference) {
+			referenced[*entry.FileReference]++
+		}
+		for _, version := range entry.Versions {
+			if slices.Contains(blobs, version.FileReference) {
+				referenced[version.FileReference]++
+			}
+		}
+	}
+
+	return referenced, nil
+}
+
+func (m MemoryNodes) GetMany(ctx context.Context, ids []primitive.ObjectID) ([]types.FsEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]types.FsEntry, 0, len(ids))
+	for _, id := range ids {
+		if entry, ok := m.nodes[id]; ok {
+			entries = append(entries, cloneEntry(entry))
+		}
+	}
+
+	return entries, nil
+}
+
+func (m MemoryNodes) GetByPaths(ctx context.Context, paths []string) ([]types.FsEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]types.FsEntry, 0, len(paths))
+	for _, entry := range m.nodes {
+		if entry.Path != "" && slices.Contains(paths, entry.Path) {
+			entries = append(entries, cloneEntry(entry))
+		}
+	}
+
+	return entries, nil
+}
+
+func (m MemoryNodes) SetPaths(ctx context.Context, paths map[primitive.ObjectID]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, path := range paths {
+		if entry, ok := m.nodes[id]; ok {
+			entry.Path = path
+			m.nodes[id] = entry
+		}
+	}
+
+	return nil
+}
+
+func (m MemoryNodes) Touch(ctx context.Context, times map[primitive.ObjectID]time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, at := range times {
+		if entry, ok := m.nodes[id]; ok && at.After(entry.Timestamps.AccessedAt) {
+			entry.Timestamps.AccessedAt = at
+			m.nodes[id] = entry
+		}
+	}
+
+	return nil
+}
+
+type memoryUpload struct {
+	id    primitive.ObjectID
+	buf   bytes.Buffer
+	blobs MemoryBlobs
+}
+
+func (u *memoryUpload) Write(p []byte) (int, error) {
+	return u.buf.Write(p)
+}
+
+func (u *memoryUpload) Close() error {
+	u.blobs.mu.Lock()
+	defer u.blobs.mu.Unlock()
+
+	u.blobs.blobs[u.id] = bytes.Clone(u.buf.Bytes())
+	u.blobs.checksums[u.id] = Checksum(u.buf.Bytes())
+	return nil
+}
+
+func (u *memoryUpload) Abort() error {
+	return nil
+}
+
+func (m MemoryBlobs) OpenUpload(ctx context.Context, id primitive.ObjectID) (io.WriteCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.blobs[id]; ok {
+		return nil, os.ErrExist
+	}
+
+	return &memoryUpload{id: id, blobs: m}, nil
+}
+
+func (m MemoryBlobs) OpenDownload(ctx context.Context, id primitive.ObjectID) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if data, ok := m.blobs[id]; ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	} else {
+		return nil, os.ErrNotExist
+	}
+}
+
+func (m MemoryBlobs) Size(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if data, ok := m.blobs[id]; ok {
+		return int64(len(data)), nil
+	} else {
+		return 0, os.ErrNotExist
+	}
+}
+
+func (m MemoryBlobs) Delete(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.blobs[id]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m.blobs, id)
+	delete(m.checksums, id)
+	delete(m.pins, id)
+
+	return nil
+}
+
+func (m MemoryBlobs) Sizes(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sizes := make(map[primitive.ObjectID]int64, len(ids))
+	for _, id := range ids {
+		if data, ok := m.blobs[id]; ok {
+			sizes[id] = int64(len(data))
+		}
+	}
+
+	return sizes, nil
+}
+
+func (m MemoryBlobs) ForEach(ctx context.Context, fn func(id primitive.ObjectID, size int64) error) error {
+	// Snapshot first so fn can modify the store
+	m.mu.RLock()
+	sizes := make(map[primitive.ObjectID]int64, len(m.blobs))
+	for id, data := range m.blobs {
+		sizes[id] = int64(len(data))
+	}
+	m.mu.RUnlock()
+
+	for id, size := range sizes {
+		if err := fn(id, size); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m MemoryBlobs) Checksums(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	checksums := make(map[primitive.ObjectID]string, len(ids))
+	for _, id := range ids {
+		if sum, ok := m.checksums[id]; ok {
+			checksums[id] = sum
+		}
+	}
+
+	return checksums, nil
+}
+
+func (m MemoryBlobs) FindChecksum(ctx context.Context, sum string) ([]primitive.ObjectID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := []primitive.ObjectID{}
+	for id, blob_sum := range m.checksums {
+		if blob_sum == sum {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b primitive.ObjectID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	return ids, nil
+}
+
+func (m MemoryBlobs) SetChecksum(ctx context.Context, id primitive.ObjectID, sum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.blobs[id]; !ok {
+		return os.ErrNotExist
+	}
+	m.checksums[id] = sum
+
+	return nil
+}
+
+func (m MemoryBlobs) Pin(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.blobs[id]; !ok {
+		return os.ErrNotExist
+	}
+	m.pins[id] = time.Now()
+
+	return nil
+}
+
+func (m MemoryBlobs) Pins(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pins := make(map[primitive.ObjectID]time.Time, len(ids))
+	for _, id := range ids {
+		if pinned, ok := m.pins[id]; ok {
+			pins[id] = pinned
+		}
+	}
+
+	return pins, nil
+}
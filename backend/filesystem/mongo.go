@@ -0,0 +1,943 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoNodes stores FsEntry nodes as documents in a MongoDB collection
+type MongoNodes struct {
+	Col *mongo.Collection
+	// transactions caches whether the server supports transactions (checked on first use)
+	transactions *transactionSupport
+}
+
+type transactionSupport struct {
+	mu        sync.Mutex
+	checked   bool
+	supported bool
+}
+
+// GridFSBlobs stores file contents in a GridFS bucket
+type GridFSBlobs struct {
+	Bucket *gridfs.Bucket
+}
+
+func NewMongoStore(col *mongo.Collection, bucket *gridfs.Bucket) Store {
+	return Store{
+		Nodes: MongoNodes{Col: col, transactions: &transactionSupport{}},
+		Blobs: GridFSBlobs{Bucket: bucket},
+	}
+}
+
+func (m MongoNodes) Root(ctx context.Context) (*types.FsEntry, error) {
+	root := types.FsEntry{}
+	if err := m.Col.FindOne(ctx, bson.M{
+		"type":    types.Directory,
+		"is_root": true,
+	}).Decode(&root); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, os.ErrNotExist
+		} else {
+			return nil, err
+		}
+	}
+
+	return &root, nil
+}
+
+func (m MongoNodes) Get(ctx context.Context, id primitive.ObjectID) (*types.FsEntry, error) {
+	entry := types.FsEntry{}
+	if err := m.Col.FindOne(ctx, bson.M{"_id": id}).Decode(&entry); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, os.ErrNotExist
+		} else {
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+func (m MongoNodes) Insert(ctx context.Context, entries ...types.FsEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	docs := make([]any, len(entries))
+	for i, entry := range entries {
+		docs[i] = entry
+	}
+
+	_, err := m.Col.InsertMany(ctx, docs)
+	return err
+}
+
+func (m MongoNodes) Update(ctx context.Context, id primitive.ObjectID, update NodeUpdate) (*types.FsEntry, error) {
+	set_doc := bson.M{}
+	if update.FileReference != nil {
+		set_doc["file_ref"] = *update.FileReference
+	}
+	if update.ClearFileReference {
+		set_doc["file_ref"] = nil
+	}
+	if update.Permissions != nil {
+		set_doc["permissions"] = *update.Permissions
+	}
+	if update.Defaults != nil {
+		set_doc["defaults"] = *update.Defaults
+	}
+	if update.ModifiedAt != nil {
+		set_doc["timestamps.modified_at"] = *update.ModifiedAt
+	}
+	if update.AccessedAt != nil {
+		set_doc["timestamps.accessed_at"] = *update.AccessedAt
+	}
+	if update.ModifiedBy != nil {
+		set_doc["modified_by"] = *update.ModifiedBy
+	}
+	if update.Versions != nil {
+		set_doc["versions"] = update.Versions
+	}
+	if update.Trash != nil {
+		set_doc["trash"] = *update.Trash
+	}
+
+	filter := bson.M{"_id": id}
+	if update.IfRevision != nil {
+		if *update.IfRevision == 0 {
+			// Documents written before revisions existed don't have the field
+			filter["revision"] = bson.M{"$in": bson.A{0, nil}}
+		} else {
+			filter["revision"] = *update.IfRevision
+		}
+	}
+
+	// MongoDB rejects $pull and $addToSet on the same field in one update,
+	// so removals go out first as their own update.
+	if len(update.RemoveEntries) > 0 {
+		if result, err := m.Col.UpdateOne(ctx, filter, bson.M{
+			"$pull": bson.M{"entries": bson.M{"name": bson.M{"$in": update.RemoveEntries}}},
+		}); err != nil {
+			return nil, err
+		} else if result.MatchedCount == 0 {
+			return nil, m.missOrConflict(ctx, id)
+		}
+	}
+
+	doc := bson.M{}
+	if len(set_doc) > 0 {
+		doc["$set"] = set_doc
+	}
+	if len(update.AddEntries) > 0 {
+		doc["$addToSet"] = bson.M{"entries": bson.M{"$each": update.AddEntries}}
+	}
+	if update.BumpRevision {
+		doc["$inc"] = bson.M{"revision": 1}
+	}
+	unset_doc := bson.M{}
+	if update.ClearTrash {
+		unset_doc["trash"] = ""
+	}
+	if update.ClearDefaults {
+		unset_doc["defaults"] = ""
+	}
+	if len(unset_doc) > 0 {
+		doc["$unset"] = unset_doc
+	}
+
+	if len(doc) == 0 {
+		if entry, err := m.Get(ctx, id); err != nil {
+			return nil, err
+		} else if update.IfRevision != nil && entry.Revision != *update.IfRevision {
+			return nil, ErrRevisionConflict
+		} else {
+			return entry, nil
+		}
+	}
+
+	updated := types.FsEntry{}
+	if err := m.Col.FindOneAndUpdate(ctx, filter, doc,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, m.missOrConflict(ctx, id)
+		} else {
+			return nil, err
+		}
+	}
+
+	return &updated, nil
+}
+
+// missOrConflict tells apart why a filtered update matched nothing
+func (m MongoNodes) missOrConflict(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := m.Get(ctx, id); err != nil {
+		return err
+	} else {
+		return ErrRevisionConflict
+	}
+}
+
+func (m MongoNodes) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if result, err := m.Col.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return err
+	} else if result.DeletedCount == 0 {
+		return os.ErrNotExist
+	}
+
+	return nil
+}
+
+func (m MongoNodes) DeleteMany(ctx context.Context, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := m.Col.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+func (m MongoNodes) ReferencedBlobs(ctx context.Context, blobs []primitive.ObjectID) (map[primitive.ObjectID]int, error) {
+	referenced := map[primitive.ObjectID]int{}
+	if len(blobs) == 0 {
+		return referenced, nil
+	}
+
+	wanted := make(map[primitive.ObjectID]struct{}, len(blobs))
+	for _, id := range blobs {
+		wanted[id] = struct{}{}
+	}
+	cursor, err := m.Col.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"file_ref": bson.M{"$in": blobs}},
+		bson.M{"versions.file_ref": bson.M{"$in": blobs}},
+	}}, options.Find().SetProjection(bson.M{"file_ref": 1, "versions.file_ref": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry types.FsEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, err
+		}
+		if entry.FileReference != nil {
+			if _, ok := wanted[*entry.FileReference]; ok {
+				referenced[*entry.FileReference]++
+			}
+		}
+		for _, version := range entry.Versions {
+			if _, ok := wanted[version.FileReference]; ok {
+				referenced[version.FileReference]++
+			}
+		}
+	}
+
+	return referenced, cursor.Err()
+}
+
+func (m MongoNodes) ForEach(ctx context.Context, fn func(entry types.FsEntry) error) error {
+	return m.forEach(ctx, bson.M{}, fn)
+}
+
+func (m MongoNodes) ForEachOwned(ctx context.Context, tag string, fn func(entry types.FsEntry) error) error {
+	return m.forEach(ctx, bson.M{"permissions.updatetag_tags": tag}, fn)
+}
+
+func (m MongoNodes) forEach(ctx context.Context, filter bson.M, fn func(entry types.FsEntry) error) error {
+	cursor, err := m.Col.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		entry := types.FsEntry{}
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// CreateIndexes creates the indexes lookups and quota checks rely on. It's safe to call on every startup.
+func (m MongoNodes) CreateIndexes(ctx context.Context) error {
+	_, err := m.Col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "path", Value: 1}}},
+		{Keys: bson.D{{Key: "permissions.updatetag_tags", Value: 1}}},
+	})
+	return err
+}
+
+func (m MongoNodes) GetMany(ctx context.Context, ids []primitive.ObjectID) ([]types.FsEntry, error) {
+	entries := make([]types.FsEntry, 0, len(ids))
+	if len(ids) == 0 {
+		return entries, nil
+	}
+
+	cursor, err := m.Col.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (m MongoNodes) GetByPaths(ctx context.Context, paths []string) ([]types.FsEntry, error) {
+	entries := make([]types.FsEntry, 0, len(paths))
+	if len(paths) == 0 {
+		return entries, nil
+	}
+
+	cursor, err := m.Col.Find(ctx, bson.M{"path": bson.M{"$in": paths}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (m MongoNodes) SetPaths(ctx context.Context, paths map[primitive.ObjectID]string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(paths))
+	for id, path := range paths {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$set": bson.M{"path": path}}))
+	}
+
+	_, err := m.Col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (m MongoNodes) Touch(ctx context.Context, times map[primitive.ObjectID]time.Time) error {
+	if len(times) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(times))
+	for id, at := range times {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$max": bson.M{"timestamps.accessed_at": at}}))
+	}
+
+	_, err := m.Col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// WithTransaction runs fn in a transaction on replica sets and sharded clusters.
+// Standalone servers don't support transactions, so fn just runs directly there.
+func (m MongoNodes) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	// Nested call: join the transaction that's already running
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	if supported, err := m.supportsTransactions(ctx); err != nil {
+		return err
+	} else if !supported {
+		return fn(ctx)
+	}
+
+	session, err := m.Col.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// WatchChanges follows the collection's change stream. It needs a replica set, like transactions do.
+func (m MongoNodes) WatchChanges(ctx context.Context, opened func() error, fn func(id primitive.ObjectID, entry *types.FsEntry) error) error {
+	if supported, err := m.supportsTransactions(ctx); err != nil {
+		return err
+	} else if !supported {
+		return ErrChangesUnsupported
+	}
+
+	stream, err := m.Col.Watch(ctx, mongo.Pipeline{}, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	if err := opened(); err != nil {
+		return err
+	}
+
+	for stream.Next(ctx) {
+		// Change events use camelCase fields, so they're read from the raw document
+		switch operation := stream.Current.Lookup("operationType").StringValue(); operation {
+		case "insert", "update", "replace", "delete":
+		case "drop", "rename", "invalidate":
+			return fmt.Errorf("change stream ended: collection %s", operation)
+		default:
+			continue
+		}
+
+		id, ok := stream.Current.Lookup("documentKey", "_id").ObjectIDOK()
+		if !ok {
+			continue
+		}
+		// Updates carry the node as it is now, which is nil if it has been deleted since
+		var entry *types.FsEntry
+		if document, ok := stream.Current.Lookup("fullDocument").DocumentOK(); ok {
+			entry = &types.FsEntry{}
+			if err := bson.Unmarshal(document, entry); err != nil {
+				return err
+			}
+		}
+		if err := fn(id, entry); err != nil {
+			return err
+		}
+	}
+
+	return stream.Err()
+}
+
+func (m MongoNodes) supportsTransactions(ctx context.Context) (bool, error) {
+	if m.transactions != nil {
+		m.transactions.mu.Lock()
+		defer m.transactions.mu.Unlock()
+		if m.transactions.checked {
+			return m.transactions.supported, nil
+		}
+	}
+
+	hello := bson.M{}
+	if err := m.Col.Database().Client().Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	// Replica set members report a set name, and mongos identifies itself with "isdbgrid"
+	_, is_replica_set := hello["setName"]
+	supported := is_replica_set || hello["msg"] == "isdbgrid"
+
+	if m.transactions != nil {
+		m.transactions.checked = true
+		m.transactions.supported = supported
+	}
+	return supported, nil
+}
+
+func (g GridFSBlobs) OpenUpload(ctx context.Context, id primitive.ObjectID) (io.WriteCloser, error) {
+	stream, err := g.Bucket.OpenUploadStreamWithID(id, "")
+	if err != nil {
+		return nil, err
+	}
+	return &gridfsUpload{UploadStream: stream, files: g.Bucket.GetFilesCollection(), id: id, hash: sha256.New()}, nil
+}
+
+// gridfsUpload hashes an upload's contents, and records the checksum in the file's metadata once it's committed
+type gridfsUpload struct {
+	*gridfs.UploadStream
+	files *mongo.Collection
+	id    primitive.ObjectID
+	hash  hash.Hash
+}
+
+func (u *gridfsUpload) Write(p []byte) (int, error) {
+	n, err := u.UploadStream.Write(p)
+	u.hash.Write(p[:n])
+	return n, err
+}
+
+func (u *gridfsUpload) Close() error {
+	if err := u.UploadStream.Close(); err != nil {
+		return err
+	}
+	// Like the upload itself, this shouldn't be cut short by the writer's ctx ending
+	_, err := u.files.UpdateOne(context.Background(), bson.M{"_id": u.id}, bson.M{
+		"$set": bson.M{"metadata.sha256": hex.EncodeToString(u.hash.Sum(nil))},
+	})
+	return err
+}
+
+func (g GridFSBlobs) OpenDownload(ctx context.Context, id primitive.ObjectID) (io.ReadCloser, error) {
+	stream, err := g.Bucket.OpenDownloadStream(id)
+	if err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, os.ErrNotExist
+		} else {
+			return nil, err
+		}
+	}
+
+	return stream, nil
+}
+
+func (g GridFSBlobs) Size(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	var fileInfo gridfs.File
+	if err := g.Bucket.GetFilesCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&fileInfo); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, os.ErrNotExist
+		} else {
+			return 0, err
+		}
+	}
+
+	return fileInfo.Length, nil
+}
+
+func (g GridFSBlobs) Sizes(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	sizes := make(map[primitive.ObjectID]int64, len(ids))
+	if len(ids) == 0 {
+		return sizes, nil
+	}
+
+	cursor, err := g.Bucket.GetFilesCollection().Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var fileInfo gridfs.File
+		if err := cursor.Decode(&fileInfo); err != nil {
+			return nil, err
+		}
+		if id, ok := fileInfo.ID.(primitive.ObjectID); ok {
+			sizes[id] = fileInfo.Length
+		}
+	}
+
+	return sizes, cursor.Err()
+}
+
+func (g GridFSBlobs) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if err := g.Bucket.DeleteContext(ctx, id); err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return os.ErrNotExist
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g GridFSBlobs) ForEach(ctx context.Context, fn func(id primitive.ObjectID, size int64) error) error {
+	cursor, err := g.Bucket.FindContext(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var fileInfo gridfs.File
+		if err := cursor.Decode(&fileInfo); err != nil {
+			return err
+		}
+		// The filesystem only ever uploads with ObjectID ids, anything else isn't ours
+		if id, ok := fileInfo.ID.(primitive.ObjectID); ok {
+			if err := fn(id, fileInfo.Length); err != nil {
+				return err
+			}
+		}
+	}
+
+	return cursor.Err()
+}
+
+// Stats reports the bucket's blob count and size, and the storage used by its files and chunks collections
+func (g GridFSBlobs) Stats(ctx context.Context) (*BlobStats, error) {
+	stats := BlobStats{}
+	cursor, err := g.Bucket.GetFilesCollection().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": nil, "blobs": bson.M{"$sum": 1}, "bytes": bson.M{"$sum": "$length"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var totals []struct {
+		Blobs int64 `bson:"blobs"`
+		Bytes int64 `bson:"bytes"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+	if len(totals) > 0 {
+		stats.Blobs, stats.Bytes = totals[0].Blobs, totals[0].Bytes
+	}
+
+	if stats.Chunks, err = g.Bucket.GetChunksCollection().EstimatedDocumentCount(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, col := range []*mongo.Collection{g.Bucket.GetFilesCollection(), g.Bucket.GetChunksCollection()} {
+		cursor, err := col.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$collStats", Value: bson.M{"storageStats": bson.M{}}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		// collStats reports camelCase fields, read them straight from the raw documents
+		for cursor.Next(ctx) {
+			for _, field := range []string{"storageSize", "totalIndexSize"} {
+				if size, ok := cursor.Current.Lookup("storageStats", field).AsInt64OK(); ok {
+					stats.StorageBytes += size
+				}
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			cursor.Close(ctx)
+			return nil, err
+		}
+		cursor.Close(ctx)
+	}
+
+	return &stats, nil
+}
+
+// CreateIndexes creates the index checksum lookups rely on. It's safe to call on every startup.
+func (g GridFSBlobs) CreateIndexes(ctx context.Context) error {
+	_, err := g.Bucket.GetFilesCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "metadata.sha256", Value: 1}},
+	})
+	return err
+}
+
+func (g GridFSBlobs) Checksums(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	checksums := make(map[primitive.ObjectID]string, len(ids))
+	if len(ids) == 0 {
+		return checksums, nil
+	}
+
+	cursor, err := g.Bucket.GetFilesCollection().Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"metadata.sha256": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		id, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+		if !ok {
+			continue
+		}
+		if sum, ok := cursor.Current.Lookup("metadata", "sha256").StringValueOK(); ok {
+			checksums[id] = sum
+		}
+	}
+
+	return checksums, cursor.Err()
+}
+
+func (g GridFSBlobs) FindChecksum(ctx context.Context, sum string) ([]primitive.ObjectID, error) {
+	cursor, err := g.Bucket.GetFilesCollection().Find(ctx, bson.M{"metadata.sha256": sum},
+		options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := []primitive.ObjectID{}
+	for cursor.Next(ctx) {
+		if id, ok := cursor.Current.Lookup("_id").ObjectIDOK(); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, cursor.Err()
+}
+
+func (g GridFSBlobs) SetChecksum(ctx context.Context, id primitive.ObjectID, sum string) error {
+	result, err := g.Bucket.GetFilesCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"metadata.sha256": sum},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func (g GridFSBlobs) Pin(ctx context.Context, id primitive.ObjectID) error {
+	// A pin has to be visible to other sessions right away, so it never joins the caller's transaction
+	ctx = mongo.NewSessionContext(ctx, nil)
+	result, err := g.Bucket.GetFilesCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"metadata.pinned_at": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+func (g GridFSBlobs) Pins(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]time.Time, error) {
+	pins := make(map[primitive.ObjectID]time.Time, len(ids))
+	if len(ids) == 0 {
+		return pins, nil
+	}
+
+	cursor, err := g.Bucket.GetFilesCollection().Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "metadata.pinned_at": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"metadata.pinned_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		id, ok := cursor.Current.Lookup("_id").ObjectIDOK()
+		if !ok {
+			continue
+		}
+		if pinned, ok := cursor.Current.Lookup("metadata", "pinned_at").TimeOK(); ok {
+			pins[id] = pinned
+		}
+	}
+
+	return pins, cursor.Err()
+}
+
+// LockPollInterval is how often MongoLocks retries while waiting for a conflicting lock, since
+// it can't be woken when another instance releases one
+const LockPollInterval = time.Second
+
+// MongoLocks is a LockStore that keeps locks as expiring leases in a MongoDB collection, so every
+// backend instance sharing the database sees them. Locks of an instance that dies without releasing
+// them last until they expire.
+type MongoLocks struct {
+	Col *mongo.Collection
+}
+
+// lockDocument holds the locks on one entry
+type lockDocument struct {
+	EntryID primitive.ObjectID `bson:"_id"`
+	Locks   []types.FileLock   `bson:"locks"`
+	// Revision makes every change compare-and-swap, so two instances can't both take conflicting locks
+	Revision int64 `bson:"revision"`
+	// ExpiresAt is when the last lock runs out, after which the TTL index drops the document
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// CreateIndexes creates the TTL index that drops expired leases, and the index socket cleanup relies on.
+// It's safe to call on every startup.
+func (m MongoLocks) CreateIndexes(ctx context.Context) error {
+	_, err := m.Col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "locks.owner.socket_id", Value: 1}}},
+	})
+	return err
+}
+
+func (m MongoLocks) Acquire(ctx context.Context, lock types.FileLock, wait bool) (types.FileLock, error) {
+	for {
+		var conflict types.FileLock
+		conflicted := false
+		if err := m.update(ctx, lock.EntryID, func(held []types.FileLock) ([]types.FileLock, bool) {
+			if conflict, conflicted = conflictingLock(held, lock.Owner, lock.Mode); conflicted {
+				return nil, false
+			}
+			return append(slices.DeleteFunc(held, func(l types.FileLock) bool {
+				return l.Owner == lock.Owner
+			}), lock), true
+		}); err != nil {
+			return types.FileLock{}, err
+		}
+		if !conflicted {
+			return lock, nil
+		}
+
+		if !wait {
+			return types.FileLock{}, lockedError(conflict)
+		}
+		timer := time.NewTimer(min(time.Until(conflict.ExpiresAt), LockPollInterval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return types.FileLock{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (m MongoLocks) Check(ctx context.Context, id primitive.ObjectID, owner types.LockOwner, access types.FsAccessMode) error {
+	doc, err := m.get(ctx, id)
+	if err != nil || doc == nil {
+		return err
+	}
+	if conflict, ok := conflictingLock(liveLocks(doc.Locks, time.Now()), owner, accessLockMode(access)); ok {
+		return lockedError(conflict)
+	}
+	return nil
+}
+
+func (m MongoLocks) Release(ctx context.Context, id primitive.ObjectID, owner types.LockOwner) (bool, error) {
+	released, err := m.release(ctx, id, func(lock types.FileLock) bool {
+		return lock.Owner == owner
+	})
+	return released > 0, err
+}
+
+func (m MongoLocks) ReleaseEntry(ctx context.Context, id primitive.ObjectID) (int, error) {
+	return m.release(ctx, id, func(lock types.FileLock) bool {
+		return true
+	})
+}
+
+func (m MongoLocks) ReleaseSocket(ctx context.Context, socketID string) (int, error) {
+	ctx = mongo.NewSessionContext(ctx, nil)
+	cursor, err := m.Col.Find(ctx, bson.M{"locks.owner.socket_id": socketID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var docs []lockDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, doc := range docs {
+		released, err := m.release(ctx, doc.EntryID, func(lock types.FileLock) bool {
+			return lock.Owner.SocketID == socketID
+		})
+		count += released
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (m MongoLocks) List(ctx context.Context) ([]types.FileLock, error) {
+	ctx = mongo.NewSessionContext(ctx, nil)
+	cursor, err := m.Col.Find(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return nil, err
+	}
+	var docs []lockDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	locks := []types.FileLock{}
+	for _, doc := range docs {
+		locks = append(locks, liveLocks(doc.Locks, now)...)
+	}
+	sortLocks(locks)
+	return locks, nil
+}
+
+// release drops the live locks on an entry that match, returning how many there were
+func (m MongoLocks) release(ctx context.Context, id primitive.ObjectID, match func(lock types.FileLock) bool) (int, error) {
+	count := 0
+	err := m.update(ctx, id, func(held []types.FileLock) ([]types.FileLock, bool) {
+		kept := slices.DeleteFunc(slices.Clone(held), match)
+		count = len(held) - len(kept)
+		return kept, count > 0
+	})
+	return count, err
+}
+
+// get reads an entry's lock document, which is nil if nothing locks it
+func (m MongoLocks) get(ctx context.Context, id primitive.ObjectID) (*lockDocument, error) {
+	// Leases are shared between sessions, so they never join the caller's transaction
+	ctx = mongo.NewSessionContext(ctx, nil)
+	doc := lockDocument{}
+	if err := m.Col.FindOne(ctx, bson.M{"_id": id}).Decode(&doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// update replaces an entry's live locks with what change returns (unless it reports no change),
+// starting over if another instance changed them in the meantime
+func (m MongoLocks) update(ctx context.Context, id primitive.ObjectID, change func(held []types.FileLock) ([]types.FileLock, bool)) error {
+	ctx = mongo.NewSessionContext(ctx, nil)
+	for {
+		doc, err := m.get(ctx, id)
+		if err != nil {
+			return err
+		}
+		var held []types.FileLock
+		if doc != nil {
+			held = liveLocks(doc.Locks, time.Now())
+		}
+		locks, changed := change(held)
+		if !changed {
+			return nil
+		}
+
+		expiresAt := time.Time{}
+		for _, lock := range locks {
+			if lock.ExpiresAt.After(expiresAt) {
+				expiresAt = lock.ExpiresAt
+			}
+		}
+
+		if doc == nil {
+			if len(locks) == 0 {
+				return nil
+			}
+			_, err := m.Col.InsertOne(ctx, lockDocument{EntryID: id, Locks: locks, Revision: 1, ExpiresAt: expiresAt})
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return err
+		}
+
+		filter := bson.M{"_id": id, "revision": doc.Revision}
+		if len(locks) == 0 {
+			result, err := m.Col.DeleteOne(ctx, filter)
+			if err != nil {
+				return err
+			}
+			if result.DeletedCount > 0 {
+				return nil
+			}
+		} else {
+			result, err := m.Col.UpdateOne(ctx, filter, bson.M{
+				"$set": bson.M{"locks": locks, "expires_at": expiresAt},
+				"$inc": bson.M{"revision": 1},
+			})
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount > 0 {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package filesystem
+
+import (
+	"context"
+	"strings"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Every node stores its materialized path (FsEntry.Path), so a lookup can fetch the root and
+// all of a path's ancestors in one query. Directory entries stay the source of truth: resolve
+// still walks them by id and only uses the prefetched nodes as a cache, so a stale or missing
+// path costs an extra round-trip but never a wrong answer.
+
+// childPath is the materialized path of name inside parent, or "" while parent isn't indexed
+func childPath(parent types.FsEntry, name string) string {
+	if parent.IsRoot {
+		return "/" + name
+	}
+	if parent.Path == "" {
+		return ""
+	}
+	return parent.Path + "/" + name
+}
+
+// prefetch loads the root and every node indexed under a prefix of splits in a single query
+func (s Store) prefetch(ctx context.Context, splits []string) (*types.FsEntry, map[primitive.ObjectID]types.FsEntry, error) {
+	paths := make([]string, len(splits)+1)
+	paths[0] = "/"
+	for i := range splits {
+		paths[i+1] = "/" + strings.Join(splits[:i+1], "/")
+	}
+
+	entries, err := s.Nodes.GetByPaths(ctx, paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var root *types.FsEntry
+	nodes := make(map[primitive.ObjectID]types.FsEntry, len(entries))
+	for i := range entries {
+		nodes[entries[i].ID] = entries[i]
+		if entries[i].IsRoot {
+			root = &entries[i]
+		}
+	}
+
+	if root == nil {
+		if root, err = s.Nodes.Root(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return root, nodes, nil
+}
+
+// prefetched returns the node with the given id, from the prefetched nodes if it's there
+func (s Store) prefetched(ctx context.Context, nodes map[primitive.ObjectID]types.FsEntry, id primitive.ObjectID) (*types.FsEntry, error) {
+	if entry, ok := nodes[id]; ok {
+		return &entry, nil
+	}
+	return s.Nodes.Get(ctx, id)
+}
+
+// reindexPaths rewrites the materialized path of a node and everything below it.
+// It fetches one level of the tree per round-trip and writes all paths in one batch.
+func (s Store) reindexPaths(ctx context.Context, id primitive.ObjectID, path string) error {
+	changed := map[primitive.ObjectID]string{}
+	seen := map[primitive.ObjectID]struct{}{id: {}}
+	level := map[primitive.ObjectID]string{id: path}
+	for len(level) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(level))
+		for id := range level {
+			ids = append(ids, id)
+		}
+
+		entries, err := s.Nodes.GetMany(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		next := map[primitive.ObjectID]string{}
+		for _, entry := range entries {
+			entry_path := level[entry.ID]
+			if entry.IsRoot {
+				entry_path = "/"
+			}
+			if entry.Path != entry_path {
+				changed[entry.ID] = entry_path
+			}
+
+			for _, ref := range entry.Entries {
+				if _, ok := seen[ref.RefID]; ok {
+					continue
+				}
+				seen[ref.RefID] = struct{}{}
+				if entry_path == "" {
+					next[ref.RefID] = ""
+				} else {
+					next[ref.RefID] = strings.TrimSuffix(entry_path, "/") + "/" + ref.Name
+				}
+			}
+		}
+		level = next
+	}
+
+	return s.Nodes.SetPaths(ctx, changed)
+}
+
+// ReindexPaths recomputes the materialized path of every node reachable from the root.
+// Run it at startup to index trees created before paths were stored.
+func (s Store) ReindexPaths(ctx context.Context) error {
+	root, err := s.Nodes.Root(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.reindexPaths(ctx, root.ID, "/")
+}
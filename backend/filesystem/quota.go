@@ -0,0 +1,461 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/goccy/go-yaml"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QuotaConfigPath is the file quota definitions are read from (see types.QuotaConfig)
+const QuotaConfigPath = "/etc/quotas"
+
+// ErrQuotaExceeded is returned when a write would take a tag or directory over its quota
+var ErrQuotaExceeded = errors.New("error: quota exceeded")
+
+// Quota kinds
+const (
+	TagQuota       = "tag"
+	DirectoryQuota = "directory"
+)
+
+// QuotaUsage is a quota definition with what it currently covers.
+// Bytes count each file's current contents once per file (copies share storage, but count twice).
+type QuotaUsage struct {
+	Kind    string           `yaml:"kind"`
+	Name    string           `yaml:"name"` // Tag or directory path
+	Limit   types.QuotaLimit `yaml:"limit"`
+	Bytes   int64            `yaml:"bytes"`
+	Entries int64            `yaml:"entries"`
+}
+
+// QuotaUsageTTL is how long a QuotaCache trusts a usage before measuring it again. Writes keep usages up to date
+// in between; the expiry bounds how far they drift from changes made by other backends.
+const QuotaUsageTTL = time.Minute
+
+// QuotaCache keeps the parsed quota config until the config file's revision changes, and the usage of each quota,
+// which writes add to as they happen. Changes that move usage around (removes, moves, chmod...) forget the
+// usages, so they're measured again when next needed.
+type QuotaCache struct {
+	mu         *sync.Mutex
+	file       primitive.ObjectID
+	revision   int64
+	config     *types.QuotaConfig
+	usages     map[quotaKey]cachedUsage
+	generation int64
+}
+
+type quotaKey struct {
+	kind, name string
+}
+
+type cachedUsage struct {
+	bytes, entries int64
+	measuredAt     time.Time
+}
+
+func NewQuotaCache() *QuotaCache {
+	return &QuotaCache{
+		mu:     new(sync.Mutex),
+		usages: map[quotaKey]cachedUsage{},
+	}
+}
+
+// Quotas reads the quota definitions. A missing config file means no quotas.
+func (s Store) Quotas(ctx context.Context) (*types.QuotaConfig, error) {
+	admin := []string{"sysadmin"}
+	file, err := s.Lookup(ctx, admin, QuotaConfigPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &types.QuotaConfig{}, nil
+		}
+		return nil, err
+	}
+	if s.QuotaCache != nil {
+		if config := s.QuotaCache.cachedConfig(*file); config != nil {
+			return config, nil
+		}
+	}
+
+	reader, err := s.ReadFileObj(ctx, *file, admin)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	config := types.QuotaConfig{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid quota config %q: %w", QuotaConfigPath, err)
+	}
+
+	if s.QuotaCache != nil {
+		s.QuotaCache.setConfig(*file, &config)
+	}
+	return &config, nil
+}
+
+// cachedConfig returns the config parsed from file, if it hasn't changed since
+func (c *QuotaCache) cachedConfig(file types.FsEntry) *types.QuotaConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config == nil || c.file != file.ID || c.revision != file.Revision {
+		return nil
+	}
+	return c.config
+}
+
+func (c *QuotaCache) setConfig(file types.FsEntry, config *types.QuotaConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file, c.revision, c.config = file.ID, file.Revision, config
+}
+
+// usage fills in quota's usage if it's cached and fresh, and returns the generation to store a measurement under otherwise
+func (c *QuotaCache) usage(quota *QuotaUsage) (bool, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.usages[quotaKey{quota.Kind, quota.Name}]
+	if !ok || time.Since(cached.measuredAt) > QuotaUsageTTL {
+		return false, c.generation
+	}
+	quota.Bytes, quota.Entries = cached.bytes, cached.entries
+	return true, c.generation
+}
+
+// setUsage caches a measured usage, unless usage changed while it was measured (since generation)
+func (c *QuotaCache) setUsage(quota QuotaUsage, generation int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.usages[quotaKey{quota.Kind, quota.Name}] = cachedUsage{bytes: quota.Bytes, entries: quota.Entries, measuredAt: time.Now()}
+	}
+}
+
+// add counts a write against the cached usages of quotas
+func (c *QuotaCache) add(quotas []QuotaUsage, bytes, entries int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, quota := range quotas {
+		key := quotaKey{quota.Kind, quota.Name}
+		if cached, ok := c.usages[key]; ok {
+			cached.bytes += bytes
+			cached.entries += entries
+			c.usages[key] = cached
+		}
+	}
+}
+
+// forget drops every cached usage
+func (c *QuotaCache) forget() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	clear(c.usages)
+}
+
+// quotaUsage fills in a quota's usage, from the cache while it's fresh (unless remeasure is set)
+func (s Store) quotaUsage(ctx context.Context, quota *QuotaUsage, remeasure bool) error {
+	if s.QuotaCache == nil {
+		return s.measureQuota(ctx, quota)
+	}
+	cached, generation := s.QuotaCache.usage(quota)
+	if cached && !remeasure {
+		return nil
+	}
+	if err := s.measureQuota(ctx, quota); err != nil {
+		return err
+	}
+	s.QuotaCache.setUsage(*quota, generation)
+	return nil
+}
+
+// countQuotas records a write that added bytes and entries under quotas (either may be negative)
+func (s Store) countQuotas(quotas []QuotaUsage, bytes, entries int64) {
+	if s.QuotaCache != nil && len(quotas) > 0 && (bytes != 0 || entries != 0) {
+		s.QuotaCache.add(quotas, bytes, entries)
+	}
+}
+
+// quotaUsageChanged is called after changes that move usage around in ways writes don't count
+func (s Store) quotaUsageChanged() {
+	if s.QuotaCache != nil {
+		s.QuotaCache.forget()
+	}
+}
+
+// QuotaUsages returns every defined quota with its current usage, ordered by kind and name
+func (s Store) QuotaUsages(ctx context.Context) ([]QuotaUsage, error) {
+	config, err := s.Quotas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make([]QuotaUsage, 0, len(config.Tags)+len(config.Directories))
+	for tag, limit := range config.Tags {
+		usages = append(usages, QuotaUsage{Kind: TagQuota, Name: tag, Limit: limit})
+	}
+	for path, limit := range config.Directories {
+		usages = append(usages, QuotaUsage{Kind: DirectoryQuota, Name: path, Limit: limit})
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Kind != usages[j].Kind {
+			return usages[i].Kind > usages[j].Kind
+		}
+		return usages[i].Name < usages[j].Name
+	})
+
+	// Reported usage is always measured
+	for i := range usages {
+		if err := s.quotaUsage(ctx, &usages[i], true); err != nil {
+			return nil, err
+		}
+	}
+	return usages, nil
+}
+
+// quotasFor returns the quotas covering a node owned by owners, created or changed inside parent
+func (s Store) quotasFor(ctx context.Context, parent types.FsEntry, owners []string) ([]QuotaUsage, error) {
+	config, err := s.Quotas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := []QuotaUsage{}
+	for tag, limit := range config.Tags {
+		if slices.Contains(owners, tag) {
+			quotas = append(quotas, QuotaUsage{Kind: TagQuota, Name: tag, Limit: limit})
+		}
+	}
+
+	parent_path := parent.Path
+	if parent.IsRoot {
+		parent_path = "/"
+	}
+	for path, limit := range config.Directories {
+		if parent_path != "" && (path == "/" || parent_path == path || strings.HasPrefix(parent_path, path+"/")) {
+			quotas = append(quotas, QuotaUsage{Kind: DirectoryQuota, Name: path, Limit: limit})
+		}
+	}
+
+	return quotas, nil
+}
+
+// quotaCheckedKey marks a context whose writes were already checked against quotas as a whole (by Copy)
+type quotaCheckedKey struct{}
+
+// checkQuotas fails with ErrQuotaExceeded if adding bytes and entries goes over any of quotas.
+// Shrinking is always allowed, even over quota.
+func (s Store) checkQuotas(ctx context.Context, quotas []QuotaUsage, bytes, entries int64) error {
+	if bytes <= 0 && entries <= 0 {
+		return nil
+	}
+	if checked, _ := ctx.Value(quotaCheckedKey{}).(bool); checked {
+		return nil
+	}
+
+	for _, quota := range quotas {
+		if err := s.quotaUsage(ctx, &quota, false); err != nil {
+			return err
+		}
+		if bytes > 0 && quota.Limit.Bytes > 0 && quota.Bytes+bytes > quota.Limit.Bytes {
+			return fmt.Errorf("%w: %s %q would use %d of %d bytes", ErrQuotaExceeded, quota.Kind, quota.Name, quota.Bytes+bytes, quota.Limit.Bytes)
+		}
+		if entries > 0 && quota.Limit.Entries > 0 && quota.Entries+entries > quota.Limit.Entries {
+			return fmt.Errorf("%w: %s %q would have %d of %d entries", ErrQuotaExceeded, quota.Kind, quota.Name, quota.Entries+entries, quota.Limit.Entries)
+		}
+	}
+
+	return nil
+}
+
+// enforceQuotas checks adding bytes and entries inside parent, for a node owned by owners,
+// and returns the quotas to count the write against once it's done
+func (s Store) enforceQuotas(ctx context.Context, parent types.FsEntry, owners []string, bytes, entries int64) ([]QuotaUsage, error) {
+	quotas, err := s.quotasFor(ctx, parent, owners)
+	if err != nil {
+		return nil, err
+	}
+	return quotas, s.checkQuotas(ctx, quotas, bytes, entries)
+}
+
+// quotaBudget returns how many bytes can still be added under quotas, or -1 if there's no byte limit
+func (s Store) quotaBudget(ctx context.Context, quotas []QuotaUsage) (int64, error) {
+	budget := int64(-1)
+	for _, quota := range quotas {
+		if quota.Limit.Bytes <= 0 {
+			continue
+		}
+		if err := s.quotaUsage(ctx, &quota, false); err != nil {
+			return 0, err
+		}
+		if remaining := max(quota.Limit.Bytes-quota.Bytes, 0); budget < 0 || remaining < budget {
+			budget = remaining
+		}
+	}
+	return budget, nil
+}
+
+// WriteBudget returns the largest size the file name inside parent can be written with, or -1 if unlimited
+func (s Store) WriteBudget(ctx context.Context, parent types.FsEntry, name string) (int64, error) {
+	owners := parent.ChildPermissions(types.File).UpdatePermissionTags
+	current := int64(0)
+	if ref, ok := parent.Entries.Get(name); ok {
+		entry, err := s.Nodes.Get(ctx, ref.RefID)
+		if err != nil {
+			return 0, err
+		}
+		owners = entry.Permissions.UpdatePermissionTags
+		if entry.FileReference != nil {
+			if current, err = s.Blobs.Size(ctx, *entry.FileReference); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return 0, err
+			}
+		}
+	}
+
+	quotas, err := s.quotasFor(ctx, parent, owners)
+	if err != nil {
+		return 0, err
+	}
+	budget, err := s.quotaBudget(ctx, quotas)
+	if err != nil || budget < 0 {
+		return budget, err
+	}
+	// The current contents get replaced, so their bytes are available too
+	return budget + current, nil
+}
+
+// measureQuota fills in the usage of a quota
+func (s Store) measureQuota(ctx context.Context, quota *QuotaUsage) error {
+	quota.Bytes, quota.Entries = 0, 0
+	file_refs := []primitive.ObjectID{}
+	count := func(entry types.FsEntry) {
+		quota.Entries++
+		if entry.EntryType == types.File && entry.FileReference != nil {
+			file_refs = append(file_refs, *entry.FileReference)
+		}
+	}
+
+	switch quota.Kind {
+	case TagQuota:
+		if err := s.Nodes.ForEachOwned(ctx, quota.Name, func(entry types.FsEntry) error {
+			count(entry)
+			return nil
+		}); err != nil {
+			return err
+		}
+	case DirectoryQuota:
+		dir, err := s.Lookup(ctx, []string{"sysadmin"}, quota.Name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Nothing to count until the directory exists
+				return nil
+			}
+			return err
+		}
+		if err := s.walkSubtree(ctx, *dir, count); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unknown quota kind %q", os.ErrInvalid, quota.Kind)
+	}
+
+	sizes, err := s.Blobs.Sizes(ctx, file_refs)
+	if err != nil {
+		return err
+	}
+	for _, ref := range file_refs {
+		quota.Bytes += sizes[ref]
+	}
+	return nil
+}
+
+// walkSubtree calls fn for every node below dir (not dir itself), one level per round-trip
+func (s Store) walkSubtree(ctx context.Context, dir types.FsEntry, fn func(entry types.FsEntry)) error {
+	seen := map[primitive.ObjectID]struct{}{dir.ID: {}}
+	level := []types.FsEntry{dir}
+	for len(level) > 0 {
+		ids := []primitive.ObjectID{}
+		for _, entry := range level {
+			for _, ref := range entry.Entries {
+				if _, ok := seen[ref.RefID]; !ok {
+					seen[ref.RefID] = struct{}{}
+					ids = append(ids, ref.RefID)
+				}
+			}
+		}
+
+		children, err := s.Nodes.GetMany(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			fn(child)
+		}
+		level = children
+	}
+	return nil
+}
+
+// subtreeSize returns the bytes and entries that copying entry would add
+func (s Store) subtreeSize(ctx context.Context, entry types.FsEntry) (int64, int64, error) {
+	entries := int64(0)
+	file_refs := []primitive.ObjectID{}
+	count := func(node types.FsEntry) {
+		entries++
+		if node.EntryType == types.File && node.FileReference != nil {
+			file_refs = append(file_refs, *node.FileReference)
+		}
+	}
+
+	count(entry)
+	if entry.EntryType == types.Directory {
+		if err := s.walkSubtree(ctx, entry, count); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	sizes, err := s.Blobs.Sizes(ctx, file_refs)
+	if err != nil {
+		return 0, 0, err
+	}
+	bytes := int64(0)
+	for _, ref := range file_refs {
+		bytes += sizes[ref]
+	}
+	return bytes, entries, nil
+}
+
+// quotaWriter fails with ErrQuotaExceeded once more than limit bytes are written through it
+type quotaWriter struct {
+	io.Writer
+	limit, written int64
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if q.limit >= 0 && q.written+int64(len(p)) > q.limit {
+		return 0, fmt.Errorf("%w: file can't be larger than %d bytes", ErrQuotaExceeded, q.limit)
+	}
+	n, err := q.Writer.Write(p)
+	q.written += int64(n)
+	return n, err
+}
+
+// LimitWriter returns a writer that fails with ErrQuotaExceeded once more than limit bytes go through it.
+// A negative limit means unlimited.
+func LimitWriter(w io.Writer, limit int64) io.Writer {
+	return &quotaWriter{Writer: w, limit: limit}
+}
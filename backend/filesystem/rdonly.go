@@ -3,12 +3,10 @@ package filesystem
 import (
 	"io"
 	"os"
-
-	"go.mongodb.org/mongo-driver/mongo/gridfs"
 )
 
 type RdonlyFileStream struct {
-	Stream *gridfs.DownloadStream
+	Stream io.ReadCloser
 }
 
 func (r RdonlyFileStream) Read(p []byte) (int, error) {
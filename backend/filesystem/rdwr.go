@@ -28,3 +28,15 @@ func (r *RdwrFileStream) Close() error {
 
 	return nil
 }
+
+// Abort closes the read side and discards the write
+func (r *RdwrFileStream) Abort() error {
+	err := r.ReadStream.Close()
+	if aborter, ok := r.WriteStream.(Aborter); ok {
+		if err2 := aborter.Abort(); err2 != nil {
+			return err2
+		}
+	}
+
+	return err
+}
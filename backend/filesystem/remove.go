@@ -0,0 +1,243 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// removeBatchSize bounds how many nodes or blobs a recursive remove loads or deletes per round-trip.
+// The context is checked between batches, so a cancelled remove stops early.
+const removeBatchSize = 500
+
+// RemoveFailure is a directory a recursive remove couldn't empty
+type RemoveFailure struct {
+	Path   string
+	Reason error
+}
+
+// ErrPartialRemove is returned when a recursive remove had to leave directories behind.
+// Everything else in the subtree was removed. Failed directories (and the directories above them) were kept.
+type ErrPartialRemove struct {
+	Removed int
+	Failed  []RemoveFailure
+}
+
+func (e ErrPartialRemove) Error() string {
+	failures := make([]string, len(e.Failed))
+	for i, failure := range e.Failed {
+		failures[i] = fmt.Sprintf("%s: %v", failure.Path, failure.Reason)
+	}
+	return fmt.Sprintf("removed %d entries, but couldn't empty %s", e.Removed, strings.Join(failures, ", "))
+}
+
+func (e ErrPartialRemove) Unwrap() error {
+	return types.ErrCantAccessFs
+}
+
+// removeAtomically is atomically for removes, where an ErrPartialRemove still commits what was removed
+func removeAtomically(ctx context.Context, s Store, fn func(ctx context.Context) (*types.FsEntry, error)) (*types.FsEntry, error) {
+	var partial error
+	entry, err := atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		partial = nil
+		entry, err := fn(ctx)
+		if errors.As(err, &ErrPartialRemove{}) {
+			partial = err
+			return entry, nil
+		}
+		return entry, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, partial
+}
+
+// removeNode is a node found while planning a remove
+type removeNode struct {
+	id     primitive.ObjectID
+	parent primitive.ObjectID
+	name   string
+	blobs  []primitive.ObjectID
+}
+
+// removePlan is what removing a subtree would do
+type removePlan struct {
+	nodes []removeNode
+	// kept holds the directories that stay behind: ones that couldn't be emptied and their ancestors
+	kept   map[primitive.ObjectID]struct{}
+	failed []RemoveFailure
+}
+
+// planRemove walks the subtree under top (at abs_path), checking that every directory it would empty
+// is writable by tags (or, with force, that tags can update its permissions).
+func (s Store) planRemove(ctx context.Context, top types.FsEntry, parent_id primitive.ObjectID, abs_path string, tags []string, force bool) (*removePlan, error) {
+	plan := removePlan{kept: map[primitive.ObjectID]struct{}{}}
+	parents := map[primitive.ObjectID]primitive.ObjectID{}
+	paths := map[primitive.ObjectID]string{top.ID: abs_path}
+	name := abs_path[strings.LastIndex(abs_path, "/")+1:]
+
+	visit := func(entry types.FsEntry, parent primitive.ObjectID, name string) []types.FsEntryReference {
+		node := removeNode{id: entry.ID, parent: parent, name: name}
+		if entry.FileReference != nil {
+			node.blobs = append(node.blobs, *entry.FileReference)
+		}
+		for _, version := range entry.Versions {
+			node.blobs = append(node.blobs, version.FileReference)
+		}
+		plan.nodes = append(plan.nodes, node)
+		parents[entry.ID] = parent
+
+		if entry.EntryType != types.Directory || len(entry.Entries) == 0 {
+			return nil
+		}
+		if !entry.Permissions.IsAllowed(types.WriteMode, tags) {
+			if !force || !entry.Permissions.IsAllowed(types.UpdatePermissionsMode, tags) {
+				plan.failed = append(plan.failed, RemoveFailure{Path: paths[entry.ID], Reason: types.ErrCantAccessFs})
+				for id := entry.ID; id != parent_id; id = parents[id] {
+					plan.kept[id] = struct{}{}
+				}
+				return nil
+			}
+		}
+		return entry.Entries
+	}
+
+	pending := []types.FsEntryReference{}
+	owners := map[primitive.ObjectID]primitive.ObjectID{}
+	for _, ref := range visit(top, parent_id, name) {
+		pending = append(pending, ref)
+		owners[ref.RefID] = top.ID
+		paths[ref.RefID] = paths[top.ID] + "/" + ref.Name
+	}
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		batch := pending[:min(len(pending), removeBatchSize)]
+		pending = pending[len(batch):]
+
+		ids := make([]primitive.ObjectID, len(batch))
+		for i, ref := range batch {
+			ids[i] = ref.RefID
+		}
+		children, err := s.Nodes.GetMany(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		by_id := make(map[primitive.ObjectID]types.FsEntry, len(children))
+		for _, child := range children {
+			by_id[child.ID] = child
+		}
+
+		for _, ref := range batch {
+			child, ok := by_id[ref.RefID]
+			if !ok {
+				// A dangling reference: dropping it from its directory is enough
+				plan.nodes = append(plan.nodes, removeNode{id: ref.RefID, parent: owners[ref.RefID], name: ref.Name})
+				continue
+			}
+			if _, seen := parents[child.ID]; seen {
+				continue
+			}
+			for _, grandchild := range visit(child, owners[ref.RefID], ref.Name) {
+				pending = append(pending, grandchild)
+				owners[grandchild.RefID] = child.ID
+				paths[grandchild.RefID] = paths[child.ID] + "/" + grandchild.Name
+			}
+		}
+	}
+
+	return &plan, nil
+}
+
+// applyRemove detaches the planned nodes from the directories that stay, then deletes them and the blobs only they used.
+// It returns how many nodes were deleted.
+//
+// Each step commits in its own transaction, so removing a huge subtree doesn't run into transaction limits.
+// The detach commits first: after it, the rest of the subtree is unreachable,
+// so a remove interrupted between batches only leaves garbage for fsck to recover.
+func (s Store) applyRemove(ctx context.Context, plan removePlan, now time.Time) (int, error) {
+	planned := make(map[primitive.ObjectID]struct{}, len(plan.nodes))
+	for _, node := range plan.nodes {
+		planned[node.id] = struct{}{}
+	}
+	deleted := func(id primitive.ObjectID) bool {
+		_, in_plan := planned[id]
+		_, kept := plan.kept[id]
+		return in_plan && !kept
+	}
+
+	detach := map[primitive.ObjectID][]removeNode{}
+	remove := []removeNode{}
+	for _, node := range plan.nodes {
+		if !deleted(node.id) {
+			continue
+		}
+		if !deleted(node.parent) {
+			detach[node.parent] = append(detach[node.parent], node)
+		}
+		remove = append(remove, node)
+	}
+
+	// The plan was made outside a transaction, so check nothing was swapped in under the names being detached
+	if err := s.Atomic(ctx, func(ctx context.Context) error {
+		for id, nodes := range detach {
+			dir, err := s.Nodes.Get(ctx, id)
+			if err != nil {
+				return err
+			}
+			names := make([]string, len(nodes))
+			for i, node := range nodes {
+				if ref, ok := dir.Entries.Get(node.name); !ok || ref.RefID != node.id {
+					return fmt.Errorf("%w: %q changed during the remove", ErrRevisionConflict, node.name)
+				}
+				names[i] = node.name
+			}
+			if _, err := s.Nodes.Update(ctx, id, NodeUpdate{
+				ModifiedAt:    &now,
+				AccessedAt:    &now,
+				RemoveEntries: names,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for len(remove) > 0 {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		batch := remove[:min(len(remove), removeBatchSize)]
+		remove = remove[len(batch):]
+
+		ids := make([]primitive.ObjectID, len(batch))
+		blobs := []primitive.ObjectID{}
+		for i, node := range batch {
+			ids[i] = node.id
+			blobs = append(blobs, node.blobs...)
+		}
+		if err := s.Atomic(ctx, func(ctx context.Context) error {
+			if err := s.Nodes.DeleteMany(ctx, ids); err != nil {
+				return err
+			}
+			// Copies and version histories share blobs, so only the ones nothing references anymore are freed
+			s.releaseBlobs(ctx, blobs)
+			return nil
+		}); err != nil {
+			return removed, err
+		}
+		removed += len(batch)
+	}
+
+	return removed, nil
+}
@@ -12,16 +12,15 @@ import (
 	"time"
 
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/gridfs"
 )
 
 type ErrLookupStop struct {
 	LastSuccessfulPath string
 	LastEntry          types.FsEntry
-	Reason             error
+	// Remaining holds the path components that couldn't be resolved, starting with the one that stopped the lookup
+	Remaining []string
+	Reason    error
 }
 
 func (e ErrLookupStop) Error() string {
@@ -32,90 +31,242 @@ func (e ErrLookupStop) Unwrap() error {
 	return e.Reason
 }
 
+// ErrSymlinkLoop is returned when resolving a path follows more than maxSymlinkHops symlinks
+var ErrSymlinkLoop = errors.New("error: too many levels of symbolic links")
+
+const maxSymlinkHops = 40
+
+// Store implements the permissioned virtual filesystem on top of a NodeStore and a BlobStore
 type Store struct {
-	Col    *mongo.Collection
-	Bucket *gridfs.Bucket
+	Nodes NodeStore
+	Blobs BlobStore
+	// Atime records access times for lookups. Nil disables access time tracking.
+	Atime *AtimeWriter
+	// Watcher publishes change events for the watch command. Nil disables watching.
+	Watcher *Watcher
+	// Locks holds advisory locks taken with Lock. Nil disables locking.
+	Locks LockStore
+	// QuotaCache keeps quota definitions and usage between writes. Nil measures usage on every write.
+	QuotaCache *QuotaCache
+}
+
+// Atomic runs fn as a single transaction when the NodeStore supports them (see Transactor),
+// so every Store call made with the ctx passed to fn is all-or-nothing.
+// fn may be retried, so it shouldn't have side effects outside the store.
+// Blobs released inside are freed once the outermost call succeeds.
+func (s Store) Atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	run := fn
+	if tx, ok := s.Nodes.(Transactor); ok {
+		run = func(ctx context.Context) error {
+			return tx.WithTransaction(ctx, fn)
+		}
+	}
+	if _, nested := ctx.Value(releasedBlobsKey{}).(*[]primitive.ObjectID); nested {
+		return run(ctx)
+	}
+
+	released := []primitive.ObjectID{}
+	err := run(context.WithValue(ctx, releasedBlobsKey{}, &released))
+	if err == nil && len(released) > 0 {
+		s.releaseBlobs(ctx, released)
+	}
+	return err
+}
+
+// atomically is Atomic for functions that return a value
+func atomically[T any](ctx context.Context, s Store, fn func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	err := s.Atomic(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = fn(ctx)
+		return err
+	})
+	return result, err
 }
 
+// Lookup resolves abs_path, following symlinks (including one in the final component)
 func (s Store) Lookup(ctx context.Context, tags []string, abs_path string) (*types.FsEntry, error) {
+	entry, _, err := s.resolve(ctx, tags, abs_path, true)
+	return entry, err
+}
+
+// LookupNoFollow resolves abs_path like Lookup, but returns a symlink in the final component as-is
+func (s Store) LookupNoFollow(ctx context.Context, tags []string, abs_path string) (*types.FsEntry, error) {
+	entry, _, err := s.resolve(ctx, tags, abs_path, false)
+	return entry, err
+}
+
+// Resolve returns abs_path with every symlink resolved.
+// The final component doesn't have to exist, in which case it's appended to its resolved parent.
+func (s Store) Resolve(ctx context.Context, tags []string, abs_path string) (string, error) {
+	if _, resolved, err := s.resolve(ctx, tags, abs_path, true); err != nil {
+		var lookupStopError ErrLookupStop
+		if errors.As(err, &lookupStopError) && errors.Is(err, os.ErrNotExist) && len(lookupStopError.Remaining) == 1 {
+			return CleanupAbsPath(lookupStopError.LastSuccessfulPath + "/" + lookupStopError.Remaining[0])
+		}
+		return "", err
+	} else {
+		return resolved, nil
+	}
+}
+
+func (s Store) resolve(ctx context.Context, tags []string, abs_path string, followLast bool) (*types.FsEntry, string, error) {
 	now := time.Now()
 	clean_path, err := CleanupAbsPath(abs_path)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	root := types.FsEntry{}
-	if err := s.Col.FindOne(ctx, bson.M{
-		"type":    types.Directory,
-		"is_root": true,
-	}).Decode(&root); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, os.ErrNotExist
-		} else {
-			return nil, err
-		}
+	splits := []string{}
+	if clean_path != "/" {
+		splits = strings.Split(clean_path[1:], "/")
 	}
 
-	splits := strings.Split(clean_path[1:], "/")
+	root, prefetched, err := s.prefetch(ctx, splits)
+	if err != nil {
+		return nil, "", err
+	}
 
 	// Catch for "/" case
-	if clean_path == "/" {
-		return &root, nil
+	if len(splits) == 0 {
+		return root, "/", nil
 	}
 
-	current := root
-	for i, split := range splits {
+	// Access times are only recorded once the lookup succeeds, for every node passed on the way
+	type visit struct {
+		entry types.FsEntry
+		path  string
+	}
+	visited := []visit{}
+	found := func(entry types.FsEntry) (*types.FsEntry, string, error) {
+		if s.Atime != nil {
+			for _, v := range visited {
+				s.Atime.Record(v.entry, v.path, now)
+			}
+		}
+		return &entry, "/" + strings.Join(splits, "/"), nil
+	}
+
+	hops := 0
+	current := *root
+	for i := 0; i < len(splits); i++ {
+		split := splits[i]
 		if !current.Permissions.IsAllowed(types.ExecuteMode, tags) {
-			return nil, types.ErrCantAccessFs
+			return nil, "", types.ErrCantAccessFs
 		}
 
-		var next types.FsEntry
-		if reference, ok := current.Entries.Get(split); !ok {
-			return nil, ErrLookupStop{
+		reference, ok := current.Entries.Get(split)
+		if !ok {
+			return nil, "", ErrLookupStop{
 				LastSuccessfulPath: "/" + strings.Join(splits[:i], "/"),
 				LastEntry:          current,
+				Remaining:          splits[i:],
 				Reason:             fmt.Errorf("%w: %q", os.ErrNotExist, split),
 			}
-		} else if err := s.Col.FindOneAndUpdate(ctx, bson.M{"_id": reference.RefID}, bson.M{
-			"$set": bson.M{
-				"timestamps.accessed_at": now,
-			},
-		}).Decode(&next); err != nil {
-			if err == mongo.ErrNoDocuments {
-				return nil, ErrLookupStop{
+		}
+		next, err := s.prefetched(ctx, prefetched, reference.RefID)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, "", ErrLookupStop{
 					LastSuccessfulPath: "/" + strings.Join(splits[:i], "/"),
 					LastEntry:          current,
+					Remaining:          splits[i:],
 					Reason:             fmt.Errorf("%w: %q", os.ErrNotExist, split),
 				}
 			} else {
-				return nil, err
+				return nil, "", err
+			}
+		}
+		visited = append(visited, visit{*next, "/" + strings.Join(splits[:i+1], "/")})
+
+		if next.EntryType == types.Symlink && (followLast || i < len(splits)-1) {
+			hops++
+			if hops > maxSymlinkHops {
+				return nil, "", ErrSymlinkLoop
+			}
+
+			// Restart from the root with the link target spliced in, so every component of the target gets permission-checked
+			target, err := AbsPath("/"+strings.Join(splits[:i], "/"), next.Target)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid symlink target %q: %w", next.Target, err)
 			}
+			rest := splits[i+1:]
+			splits = []string{}
+			if target != "/" {
+				splits = strings.Split(target[1:], "/")
+			}
+			splits = append(splits, rest...)
+			if root, prefetched, err = s.prefetch(ctx, splits); err != nil {
+				return nil, "", err
+			}
+			current = *root
+			i = -1
+			continue
 		} else if next.EntryType != types.Directory {
 			if i == len(splits)-1 {
-				return &next, nil
+				return found(*next)
 			} else {
-				return nil, ErrLookupStop{
+				return nil, "", ErrLookupStop{
 					LastSuccessfulPath: "/" + strings.Join(splits[:i], "/"),
 					LastEntry:          current,
+					Remaining:          splits[i:],
 					Reason:             fmt.Errorf("%w: %q is a file", os.ErrInvalid, split),
 				}
 			}
 		}
-		current = next
+		current = *next
 	}
-	return &current, nil
+	return found(current)
 }
 
+// MaxFileVersions is how many previous revisions of a file are kept. Older blobs are dropped from the history.
+const MaxFileVersions = 20
+
+// writerName returns the username of the caller (from the "auth_status" context value), or "" if there isn't one
+func writerName(ctx context.Context) string {
+	if status, ok := ctx.Value("auth_status").(types.AuthorizationStatus); ok {
+		return status.Username
+	}
+	return ""
+}
+
+// WriteFile points the named file at fileRef, creating it if needed.
+// Overwritten contents are kept in the file's version history.
 func (s Store) WriteFile(ctx context.Context, parentID primitive.ObjectID, name string, fileRef primitive.ObjectID, tags []string) (*types.FsEntry, error) {
+	return s.writeFile(ctx, parentID, name, fileRef, tags, writerName(ctx), nil)
+}
+
+// WriteFileIfRevision is WriteFile that only commits if the file is still at the given revision.
+// Revision 0 also matches a file that doesn't exist yet. Otherwise it fails with ErrRevisionConflict.
+func (s Store) WriteFileIfRevision(ctx context.Context, parentID primitive.ObjectID, name string, fileRef primitive.ObjectID, tags []string, revision int64) (*types.FsEntry, error) {
+	return s.writeFile(ctx, parentID, name, fileRef, tags, writerName(ctx), &revision)
+}
+
+func (s Store) writeFile(ctx context.Context, parentID primitive.ObjectID, name string, fileRef primitive.ObjectID, tags []string, writer string, ifRevision *int64) (*types.FsEntry, error) {
+	// Identical contents share one blob: point the file at the existing copy, then free fileRef
+	shared, err := s.sharedBlob(ctx, fileRef)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.writeFileNode(ctx, parentID, name, &shared, tags, writer, ifRevision)
+	})
+	if err != nil || shared == fileRef {
+		return entry, err
+	}
+
+	// sharedBlob pinned the shared copy, so it outlives a concurrent rm; fileRef is now an unused duplicate
+	s.releaseBlob(ctx, fileRef)
+	return entry, nil
+}
+
+// writeFileNode points the file name inside parentID at fileRef, creating it if needed. A nil fileRef empties the file.
+func (s Store) writeFileNode(ctx context.Context, parentID primitive.ObjectID, name string, fileRef *primitive.ObjectID, tags []string, writer string, ifRevision *int64) (*types.FsEntry, error) {
 	now := time.Now()
 
-	parent := types.FsEntry{}
-	if err := s.Col.FindOne(ctx, bson.M{"_id": parentID}).Decode(&parent); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, os.ErrNotExist
-		} else {
-			return nil, err
-		}
+	parent, err := s.Nodes.Get(ctx, parentID)
+	if err != nil {
+		return nil, err
 	}
 
 	if !parent.Permissions.IsAllowed(types.WriteMode, tags) || !parent.Permissions.IsAllowed(types.ExecuteMode, tags) {
@@ -126,57 +277,131 @@ func (s Store) WriteFile(ctx context.Context, parentID primitive.ObjectID, name
 	}
 
 	if reference, ok := parent.Entries.Get(name); ok {
-		updated := types.FsEntry{}
-		if err := s.Col.FindOneAndUpdate(ctx,
-			bson.M{"_id": reference.RefID},
-			bson.M{"$set": bson.M{
-				"file_ref":               fileRef,
-				"timestamps.modified_at": now,
-				"timestamps.accessed_at": now,
-			}}).Decode(&updated); err != nil {
+		if existing, err := s.Nodes.Get(ctx, reference.RefID); err != nil {
 			return nil, err
+		} else if existing.EntryType != types.File {
+			return nil, fmt.Errorf("%w: %q is a %s", os.ErrInvalid, name, existing.EntryType)
 		} else {
-			return &updated, nil
+			quotas, err := s.quotasFor(ctx, *parent, existing.Permissions.UpdatePermissionTags)
+			if err != nil {
+				return nil, err
+			}
+			growth := int64(0)
+			if len(quotas) > 0 {
+				if growth, err = s.writeGrowth(ctx, existing.FileReference, fileRef); err != nil {
+					return nil, err
+				}
+				if err := s.checkQuotas(ctx, quotas, growth, 0); err != nil {
+					return nil, err
+				}
+			}
+
+			var versions []types.FileVersion
+			if existing.FileReference != nil && (fileRef == nil || *existing.FileReference != *fileRef) {
+				versions = append(existing.Versions, types.FileVersion{
+					FileReference: *existing.FileReference,
+					ModifiedAt:    existing.Timestamps.ModifiedAt,
+					ModifiedBy:    existing.ModifiedBy,
+				})
+				if len(versions) > MaxFileVersions {
+					versions = versions[len(versions)-MaxFileVersions:]
+				}
+			}
+
+			updated, err := s.Nodes.Update(ctx, reference.RefID, NodeUpdate{
+				FileReference:      fileRef,
+				ClearFileReference: fileRef == nil,
+				ModifiedAt:         &now,
+				AccessedAt:         &now,
+				ModifiedBy:         &writer,
+				Versions:           versions,
+				IfRevision:         ifRevision,
+				BumpRevision:       true,
+			})
+			if err != nil {
+				return nil, err
+			}
+			s.countQuotas(quotas, growth, 0)
+			return updated, nil
 		}
+	} else if ifRevision != nil && *ifRevision != 0 {
+		// The file was removed since the caller saw it
+		return nil, ErrRevisionConflict
 	} else {
+		perms := parent.ChildPermissions(types.File)
+		quotas, err := s.quotasFor(ctx, *parent, perms.UpdatePermissionTags)
+		if err != nil {
+			return nil, err
+		}
+		growth := int64(0)
+		if len(quotas) > 0 {
+			if growth, err = s.writeGrowth(ctx, nil, fileRef); err != nil {
+				return nil, err
+			}
+			if err := s.checkQuotas(ctx, quotas, growth, 1); err != nil {
+				return nil, err
+			}
+		}
+
 		created := types.FsEntry{
 			ID:          primitive.NewObjectID(),
 			EntryType:   types.File,
-			Permissions: parent.Permissions,
+			Permissions: perms,
 			Timestamps: types.FileTimestamps{
 				CreatedAt:  now,
 				ModifiedAt: now,
 				AccessedAt: now,
 			},
-			FileReference: &fileRef,
+			FileReference: fileRef,
+			ModifiedBy:    writer,
+			Revision:      1,
+			Path:          childPath(*parent, name),
 		}
-		if _, err := s.Col.InsertOne(ctx, created); err != nil {
+		if err := s.Nodes.Insert(ctx, created); err != nil {
 			return nil, err
 		}
 
-		if err := s.Col.FindOneAndUpdate(ctx, bson.M{"_id": parentID},
-			bson.M{"$addToSet": bson.M{"entries": types.FsEntryReference{
+		if _, err := s.Nodes.Update(ctx, parentID, NodeUpdate{
+			AddEntries: []types.FsEntryReference{{
 				Name:  name,
 				RefID: created.ID,
-			}}}).Err(); err != nil {
+			}},
+		}); err != nil {
 			return nil, err
 		} else {
+			s.countQuotas(quotas, growth, 1)
 			return &created, nil
 		}
 	}
 }
 
-func (s Store) ReadFile(ctx context.Context, ID primitive.ObjectID, tags []string) (io.ReadCloser, error) {
-	file := types.FsEntry{}
-	if err := s.Col.FindOne(ctx, bson.M{"_id": ID}).Decode(&file); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, os.ErrNotExist
-		} else {
-			return nil, err
+// writeGrowth returns how many bytes replacing old_ref's contents with new_ref's adds (nil meaning no contents)
+func (s Store) writeGrowth(ctx context.Context, old_ref, new_ref *primitive.ObjectID) (int64, error) {
+	growth := int64(0)
+	if new_ref != nil {
+		size, err := s.Blobs.Size(ctx, *new_ref)
+		if err != nil {
+			return 0, err
 		}
+		growth = size
+	}
+	if old_ref != nil {
+		if old_size, err := s.Blobs.Size(ctx, *old_ref); err == nil {
+			growth -= old_size
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return growth, nil
+}
+
+func (s Store) ReadFile(ctx context.Context, ID primitive.ObjectID, tags []string) (io.ReadCloser, error) {
+	file, err := s.Nodes.Get(ctx, ID)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.ReadFileObj(ctx, file, tags)
+	return s.ReadFileObj(ctx, *file, tags)
 }
 
 func (s Store) ReadFileObj(ctx context.Context, file types.FsEntry, tags []string) (io.ReadCloser, error) {
@@ -188,7 +413,7 @@ func (s Store) ReadFileObj(ctx context.Context, file types.FsEntry, tags []strin
 		return io.NopCloser(bytes.NewReader(nil)), nil
 	}
 
-	return s.Bucket.OpenDownloadStream(*file.FileReference)
+	return s.Blobs.OpenDownload(ctx, *file.FileReference)
 }
 
 func (s Store) LookupRead(ctx context.Context, abs_path string, tags []string) (io.ReadCloser, error) {
@@ -201,10 +426,69 @@ func (s Store) LookupRead(ctx context.Context, abs_path string, tags []string) (
 	} else if entry.FileReference == nil {
 		return io.NopCloser(bytes.NewReader(nil)), nil
 	} else {
-		return s.Bucket.OpenDownloadStream(*entry.FileReference)
+		return s.Blobs.OpenDownload(ctx, *entry.FileReference)
 	}
 }
 
+// fileVersion looks up a readable file and returns the reference of one of its previous versions.
+// Versions are numbered from the newest: 1 is the contents before the latest write.
+func (s Store) fileVersion(ctx context.Context, abs_path string, tags []string, version int) (*types.FsEntry, *types.FileVersion, error) {
+	entry, err := s.Lookup(ctx, tags, abs_path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !entry.Permissions.IsAllowed(types.ReadMode, tags) {
+		return nil, nil, types.ErrCantAccessFs
+	}
+	if entry.EntryType != types.File {
+		return nil, nil, fmt.Errorf("%w: %q is not a file", os.ErrInvalid, abs_path)
+	}
+	if version < 1 || version > len(entry.Versions) {
+		return nil, nil, fmt.Errorf("%w: version %d (file has %d previous versions)", os.ErrNotExist, version, len(entry.Versions))
+	}
+
+	return entry, &entry.Versions[len(entry.Versions)-version], nil
+}
+
+// ReadVersion opens a previous version of a file. See fileVersion for numbering.
+func (s Store) ReadVersion(ctx context.Context, abs_path string, tags []string, version int) (io.ReadCloser, error) {
+	if _, fileVersion, err := s.fileVersion(ctx, abs_path, tags, version); err != nil {
+		return nil, err
+	} else {
+		return s.Blobs.OpenDownload(ctx, fileVersion.FileReference)
+	}
+}
+
+// RestoreVersion makes a previous version the file's current contents.
+// The contents being replaced are pushed onto the history like any other write.
+func (s Store) RestoreVersion(ctx context.Context, abs_path string, tags []string, version int) (*types.FsEntry, error) {
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.restoreVersion(ctx, abs_path, tags, version)
+	})
+}
+
+func (s Store) restoreVersion(ctx context.Context, abs_path string, tags []string, version int) (*types.FsEntry, error) {
+	resolved, err := s.Resolve(ctx, tags, abs_path)
+	if err != nil {
+		return nil, err
+	}
+	_, fileVersion, err := s.fileVersion(ctx, resolved, tags, version)
+	if err != nil {
+		return nil, err
+	}
+
+	folder_path, filename, err := DirUp(resolved)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := s.Lookup(ctx, tags, folder_path)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.WriteFile(ctx, parent.ID, filename, fileVersion.FileReference, tags)
+}
+
 func (s Store) LookupReadAll(ctx context.Context, abs_path string, tags []string) ([]byte, error) {
 	if reader, err := s.LookupRead(ctx, abs_path, tags); err != nil {
 		return nil, err
@@ -215,14 +499,16 @@ func (s Store) LookupReadAll(ctx context.Context, abs_path string, tags []string
 }
 
 func (s Store) WriteDirectory(ctx context.Context, parentID primitive.ObjectID, directoryName string, tags []string, dirTags *types.FsEntryPermissions) (*types.FsEntry, error) {
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.writeDirectory(ctx, parentID, directoryName, tags, dirTags)
+	})
+}
+
+func (s Store) writeDirectory(ctx context.Context, parentID primitive.ObjectID, directoryName string, tags []string, dirTags *types.FsEntryPermissions) (*types.FsEntry, error) {
 	now := time.Now()
-	parent := types.FsEntry{}
-	if err := s.Col.FindOne(ctx, bson.M{"_id": parentID}).Decode(&parent); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, os.ErrNotExist
-		} else {
-			return nil, err
-		}
+	parent, err := s.Nodes.Get(ctx, parentID)
+	if err != nil {
+		return nil, err
 	}
 
 	if !parent.Permissions.IsAllowed(types.WriteMode, tags) {
@@ -231,7 +517,7 @@ func (s Store) WriteDirectory(ctx context.Context, parentID primitive.ObjectID,
 	if parent.EntryType != types.Directory {
 		return nil, fmt.Errorf("parent isn't a directory")
 	}
-	perms := parent.Permissions
+	perms := parent.ChildPermissions(types.Directory)
 	if dirTags != nil {
 		if !parent.Permissions.CanUpdatePermTags(dirTags.UpdatePermissionTags, tags) {
 			return nil, types.ErrCantAccessFs
@@ -239,19 +525,23 @@ func (s Store) WriteDirectory(ctx context.Context, parentID primitive.ObjectID,
 		perms = *dirTags
 	}
 	for _, entry := range parent.Entries {
-		dir := types.FsEntry{}
 		if entry.Name != directoryName {
 			continue
 		}
-		if err := s.Col.FindOne(ctx, bson.M{"_id": entry.RefID}).Decode(&dir); err != nil {
-			if err != mongo.ErrNoDocuments {
+		if dir, err := s.Nodes.Get(ctx, entry.RefID); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
 				return nil, err
 			}
 		} else {
-			return &dir, nil
+			return dir, nil
 		}
 	}
 
+	quotas, err := s.enforceQuotas(ctx, *parent, perms.UpdatePermissionTags, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+
 	created := types.FsEntry{
 		ID:        primitive.NewObjectID(),
 		EntryType: types.Directory,
@@ -262,36 +552,41 @@ func (s Store) WriteDirectory(ctx context.Context, parentID primitive.ObjectID,
 			AccessedAt: now,
 		},
 		Permissions: perms,
+		Defaults:    parent.Defaults,
+		Path:        childPath(*parent, directoryName),
 	}
 
-	if _, err := s.Col.InsertOne(ctx, created); err != nil {
+	if err := s.Nodes.Insert(ctx, created); err != nil {
 		return nil, err
 	}
 
-	if err := s.Col.FindOneAndUpdate(ctx, bson.M{"_id": parentID},
-		bson.M{"$addToSet": bson.M{"entries": types.FsEntryReference{
+	if _, err := s.Nodes.Update(ctx, parentID, NodeUpdate{
+		AddEntries: []types.FsEntryReference{{
 			Name:  directoryName,
 			RefID: created.ID,
 		}},
-			"$set": bson.M{
-				"timestamps.modified_at": now,
-				"timestamps.accessed_at": now,
-			}}).Err(); err != nil {
+		ModifiedAt: &now,
+		AccessedAt: &now,
+	}); err != nil {
 		return nil, err
 	}
+	s.countQuotas(quotas, 0, 1)
 
 	return &created, nil
 }
 
 func (s Store) RemoveChild(ctx context.Context, parentID primitive.ObjectID, childName string, tags []string) (*types.FsEntry, error) {
+	defer s.quotaUsageChanged()
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.removeChild(ctx, parentID, childName, tags)
+	})
+}
+
+func (s Store) removeChild(ctx context.Context, parentID primitive.ObjectID, childName string, tags []string) (*types.FsEntry, error) {
 	now := time.Now()
-	parent := types.FsEntry{}
-	if err := s.Col.FindOne(ctx, bson.M{"_id": parentID}).Decode(&parent); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, os.ErrNotExist
-		} else {
-			return nil, err
-		}
+	parent, err := s.Nodes.Get(ctx, parentID)
+	if err != nil {
+		return nil, err
 	}
 
 	if !parent.Permissions.IsAllowed(types.WriteMode, tags) {
@@ -305,33 +600,25 @@ func (s Store) RemoveChild(ctx context.Context, parentID primitive.ObjectID, chi
 		return nil, os.ErrNotExist
 	}
 
-	updated := types.FsEntry{}
-	if err := s.Col.FindOneAndUpdate(ctx, bson.M{"_id": parentID},
-		bson.M{"$pull": bson.M{"entries": bson.M{"name": childName}},
-			"$set": bson.M{
-				"timestamps.modified_at": now,
-				"timestamps.accessed_at": now,
-			}}).Decode(&updated); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, os.ErrNotExist
-		} else {
-			return nil, err
-		}
-	}
-
-	return &updated, nil
+	return s.Nodes.Update(ctx, parentID, NodeUpdate{
+		RemoveEntries: []string{childName},
+		ModifiedAt:    &now,
+		AccessedAt:    &now,
+	})
 }
 
 func (s Store) Mkdir(ctx context.Context, abs_path string, tags []string, perms *types.FsEntryPermissions, mkParents bool) (*types.FsEntry, error) {
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.mkdir(ctx, abs_path, tags, perms, mkParents)
+	})
+}
+
+func (s Store) mkdir(ctx context.Context, abs_path string, tags []string, perms *types.FsEntryPermissions, mkParents bool) (*types.FsEntry, error) {
 
 	clean_path, err := CleanupAbsPath(abs_path)
 	if err != nil {
 		return nil, err
 	}
-	folder_name, _, err := DirUp(abs_path)
-	if err != nil {
-		return nil, err
-	}
 
 	entry, err := s.Lookup(ctx, tags, clean_path)
 	now := time.Now()
@@ -339,16 +626,17 @@ func (s Store) Mkdir(ctx context.Context, abs_path string, tags []string, perms
 		var lookupStopError ErrLookupStop
 		if errors.As(err, &lookupStopError) {
 			if errors.Is(lookupStopError.Reason, os.ErrNotExist) {
-				if lookupStopError.LastSuccessfulPath != folder_name && !mkParents {
+				if len(lookupStopError.Remaining) != 1 && !mkParents {
 					return nil, err
 				} else {
-					splits := strings.Split(strings.TrimPrefix(abs_path, lookupStopError.LastSuccessfulPath), "/")[1:]
+					splits := lookupStopError.Remaining
 					ids := make([]primitive.ObjectID, len(splits))
 					for i := range splits {
 						ids[i] = primitive.NewObjectID()
 					}
 
-					new_objects := make([]any, len(splits))
+					new_objects := make([]types.FsEntry, len(splits))
+					path_parent := lookupStopError.LastEntry
 					for i := range splits {
 						node := types.FsEntry{
 							ID: ids[i],
@@ -358,9 +646,12 @@ func (s Store) Mkdir(ctx context.Context, abs_path string, tags []string, perms
 								ModifiedAt: now,
 							},
 							EntryType:   types.Directory,
-							Permissions: lookupStopError.LastEntry.Permissions,
+							Permissions: lookupStopError.LastEntry.ChildPermissions(types.Directory),
+							Defaults:    lookupStopError.LastEntry.Defaults,
 							Entries:     make(types.FsReferenceList, 0),
+							Path:        childPath(path_parent, splits[i]),
 						}
+						path_parent = node
 						if i < len(splits)-1 {
 							node.Entries = append(node.Entries, types.FsEntryReference{
 								Name:  splits[i+1],
@@ -378,26 +669,32 @@ func (s Store) Mkdir(ctx context.Context, abs_path string, tags []string, perms
 						new_objects[i] = node
 					}
 
-					if _, err := s.Col.InsertMany(ctx, new_objects); err != nil {
+					owners := lookupStopError.LastEntry.ChildPermissions(types.Directory).UpdatePermissionTags
+					if perms != nil {
+						owners = append(slices.Clone(owners), perms.UpdatePermissionTags...)
+					}
+					quotas, err := s.enforceQuotas(ctx, lookupStopError.LastEntry, owners, 0, int64(len(new_objects)))
+					if err != nil {
 						return nil, err
 					}
 
-					if _, err := s.Col.UpdateOne(ctx, bson.M{"_id": lookupStopError.LastEntry.ID}, bson.M{
-						"$set": bson.M{
-							"timestamps.accessed_at": now,
-							"timestamps.modified_at": now,
-						},
-						"$addToSet": bson.M{
-							"entries": types.FsEntryReference{
-								Name:  splits[0],
-								RefID: new_objects[0].(types.FsEntry).ID,
-							},
-						},
+					if err := s.Nodes.Insert(ctx, new_objects...); err != nil {
+						return nil, err
+					}
+
+					if _, err := s.Nodes.Update(ctx, lookupStopError.LastEntry.ID, NodeUpdate{
+						AccessedAt: &now,
+						ModifiedAt: &now,
+						AddEntries: []types.FsEntryReference{{
+							Name:  splits[0],
+							RefID: new_objects[0].ID,
+						}},
 					}); err != nil {
 						return nil, err
 					}
+					s.countQuotas(quotas, 0, int64(len(new_objects)))
 
-					folder := new_objects[len(new_objects)-1].(types.FsEntry)
+					folder := new_objects[len(new_objects)-1]
 					return &folder, nil
 				}
 			} else {
@@ -411,7 +708,17 @@ func (s Store) Mkdir(ctx context.Context, abs_path string, tags []string, perms
 	}
 }
 
+// RemoveFile deletes abs_path. With rmDirectories, a directory is deleted with everything below it,
+// along with file contents nothing else references. Directories inside it that tags can't write to are kept
+// (with the directories above them), and reported in an ErrPartialRemove.
+// A recursive remove isn't one transaction: abs_path is detached first, then what was under it is deleted in batches
+// (see applyRemove), so if it fails partway the rest is left unreachable for fsck.
 func (s Store) RemoveFile(ctx context.Context, abs_path string, tags []string, force, rmDirectories bool) (*types.FsEntry, error) {
+	defer s.quotaUsageChanged()
+	return s.removeFile(ctx, abs_path, tags, force, rmDirectories)
+}
+
+func (s Store) removeFile(ctx context.Context, abs_path string, tags []string, force, rmDirectories bool) (*types.FsEntry, error) {
 	folder_name, filename, err := DirUp(abs_path)
 	if err != nil {
 		return nil, err
@@ -434,35 +741,41 @@ func (s Store) RemoveFile(ctx context.Context, abs_path string, tags []string, f
 	if entryReference, exists := parent.Entries.Get(filename); !exists {
 		return nil, os.ErrNotExist
 	} else {
-		var entry types.FsEntry
-		if err := s.Col.FindOne(ctx, bson.M{"_id": entryReference.RefID}).Decode(&entry); err != nil {
+		entry, err := s.Nodes.Get(ctx, entryReference.RefID)
+		if err != nil {
 			return nil, err
 		}
 		if entry.EntryType == types.Directory && !rmDirectories {
 			return nil, fmt.Errorf("%w: file is a directory", os.ErrInvalid)
 		}
-		if _, err := s.Col.DeleteOne(ctx, bson.M{"_id": entry.ID}); err != nil {
+
+		clean_path, err := CleanupAbsPath(abs_path)
+		if err != nil {
 			return nil, err
 		}
-
-		if err := s.Col.FindOneAndUpdate(ctx, bson.M{"_id": parent.ID}, bson.M{
-			"$set": bson.M{
-				"timestamps.modified_at": now,
-				"timestamps.accessed_at": now,
-			},
-			"$pull": bson.M{
-				"entries": bson.M{
-					"name": filename,
-				},
-			},
-		}).Err(); err != nil {
+		plan, err := s.planRemove(ctx, *entry, parent.ID, clean_path, tags, force)
+		if err != nil {
 			return nil, err
 		}
-		return &entry, nil
+		removed, err := s.applyRemove(ctx, *plan, now)
+		if err != nil {
+			return nil, err
+		}
+		if len(plan.failed) != 0 {
+			return entry, ErrPartialRemove{Removed: removed, Failed: plan.failed}
+		}
+		return entry, nil
 	}
 }
 
 func (s Store) Move(ctx context.Context, dest_path, src_path string, tags []string) (*types.FsEntryReference, error) {
+	defer s.quotaUsageChanged()
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntryReference, error) {
+		return s.move(ctx, dest_path, src_path, tags)
+	})
+}
+
+func (s Store) move(ctx context.Context, dest_path, src_path string, tags []string) (*types.FsEntryReference, error) {
 	now := time.Now()
 	source_folder_path, source_filename, err := DirUp(src_path)
 	if err != nil {
@@ -490,27 +803,20 @@ func (s Store) Move(ctx context.Context, dest_path, src_path string, tags []stri
 		if err != nil {
 			var lookupStopError ErrLookupStop
 			if errors.As(err, &lookupStopError) && errors.Is(err, os.ErrNotExist) {
-				dest_parent_path, dest_filename, _ := DirUp(dest_abs)
-				if lookupStopError.LastSuccessfulPath != dest_parent_path {
+				if len(lookupStopError.Remaining) != 1 {
 					return nil, err
 				}
+				dest_filename := lookupStopError.Remaining[0]
 
 				if !lookupStopError.LastEntry.Permissions.IsAllowed(types.WriteMode, tags) {
 					return nil, types.ErrCantAccessFs
 				}
 
-				if _, err := s.Col.UpdateOne(ctx, bson.M{"_id": lookupStopError.LastEntry.ID}, bson.M{
-					"$set": bson.M{
-						"timestamps.accessed_at": now,
-						"timestamps.modified_at": now,
-					},
-					"$pull": bson.M{"entries": bson.M{"name": dest_filename}},
-				}); err != nil {
-					return nil, err
-				}
-
-				if _, err := s.Col.UpdateByID(ctx, lookupStopError.LastEntry.ID, bson.M{
-					"$push": bson.M{"entries": types.FsEntryReference{
+				if _, err := s.Nodes.Update(ctx, lookupStopError.LastEntry.ID, NodeUpdate{
+					AccessedAt:    &now,
+					ModifiedAt:    &now,
+					RemoveEntries: []string{dest_filename},
+					AddEntries: []types.FsEntryReference{{
 						Name:  dest_filename,
 						RefID: entry.RefID,
 					}},
@@ -518,16 +824,18 @@ func (s Store) Move(ctx context.Context, dest_path, src_path string, tags []stri
 					return nil, err
 				}
 
-				if _, err := s.Col.UpdateOne(ctx, bson.M{"_id": source_folder.ID}, bson.M{
-					"$set": bson.M{
-						"timestamps.accessed_at": now,
-						"timestamps.modified_at": now,
-					},
-					"$pull": bson.M{"entries": bson.M{"name": source_filename}},
+				if _, err := s.Nodes.Update(ctx, source_folder.ID, NodeUpdate{
+					AccessedAt:    &now,
+					ModifiedAt:    &now,
+					RemoveEntries: []string{source_filename},
 				}); err != nil {
 					return nil, err
 				}
 
+				if err := s.reindexPaths(ctx, entry.RefID, childPath(lookupStopError.LastEntry, dest_filename)); err != nil {
+					return nil, err
+				}
+
 				return &entry, nil
 			} else {
 				return nil, err
@@ -537,18 +845,11 @@ func (s Store) Move(ctx context.Context, dest_path, src_path string, tags []stri
 				return nil, types.ErrCantAccessFs
 			}
 
-			if _, err := s.Col.UpdateOne(ctx, bson.M{"_id": dest_folder.ID}, bson.M{
-				"$set": bson.M{
-					"timestamps.accessed_at": now,
-					"timestamps.modified_at": now,
-				},
-				"$pull": bson.M{"entries": bson.M{"name": source_filename}},
-			}); err != nil {
-				return nil, err
-			}
-
-			if _, err := s.Col.UpdateByID(ctx, dest_folder.ID, bson.M{
-				"$push": bson.M{"entries": types.FsEntryReference{
+			if _, err := s.Nodes.Update(ctx, dest_folder.ID, NodeUpdate{
+				AccessedAt:    &now,
+				ModifiedAt:    &now,
+				RemoveEntries: []string{source_filename},
+				AddEntries: []types.FsEntryReference{{
 					Name:  source_filename,
 					RefID: entry.RefID,
 				}},
@@ -556,16 +857,18 @@ func (s Store) Move(ctx context.Context, dest_path, src_path string, tags []stri
 				return nil, err
 			}
 
-			if _, err := s.Col.UpdateByID(ctx, source_folder.ID, bson.M{
-				"$set": bson.M{
-					"timestamps.accessed_at": now,
-					"timestamps.modified_at": now,
-				},
-				"$pull": bson.M{"entries": bson.M{"name": source_filename}},
+			if _, err := s.Nodes.Update(ctx, source_folder.ID, NodeUpdate{
+				AccessedAt:    &now,
+				ModifiedAt:    &now,
+				RemoveEntries: []string{source_filename},
 			}); err != nil {
 				return nil, err
 			}
 
+			if err := s.reindexPaths(ctx, entry.RefID, childPath(*dest_folder, source_filename)); err != nil {
+				return nil, err
+			}
+
 			return &entry, nil
 		}
 	}
@@ -575,6 +878,9 @@ func UpdateTagList(old_tags []string, tag_name, op string) ([]string, error) {
 	new_list := slices.Clone(old_tags)
 	switch op {
 	case "+":
+		if slices.Contains(new_list, tag_name) {
+			return new_list, nil
+		}
 		return append(new_list, tag_name), nil
 	case "-":
 		lst := make([]string, 0, len(old_tags))
@@ -589,18 +895,17 @@ func UpdateTagList(old_tags []string, tag_name, op string) ([]string, error) {
 	}
 }
 
-func ListUpdateDoc(doc_key, tag_name, op string) (bson.M, error) {
-	switch op {
-	case "+":
-		return bson.M{"$addToSet": bson.M{doc_key: tag_name}}, nil
-	case "-":
-		return bson.M{"$pull": bson.M{doc_key: tag_name}}, nil
-	default:
-		return nil, os.ErrInvalid
+func (s Store) Chmod(ctx context.Context, path string, tags []string, tag_name, op string, perm types.FsAccessMode, recursive bool) (*types.FsEntry, error) {
+	if perm == types.UpdatePermissionsMode {
+		// Tag quotas count what their tags own
+		defer s.quotaUsageChanged()
 	}
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.chmod(ctx, path, tags, tag_name, op, perm, recursive)
+	})
 }
 
-func (s Store) Chmod(ctx context.Context, path string, tags []string, tag_name, op string, perm types.FsAccessMode, recursive bool) (*types.FsEntry, error) {
+func (s Store) chmod(ctx context.Context, path string, tags []string, tag_name, op string, perm types.FsAccessMode, recursive bool) (*types.FsEntry, error) {
 	now := time.Now()
 	abs_path, err := CleanupAbsPath(path)
 	if err != nil {
@@ -625,16 +930,16 @@ func (s Store) Chmod(ctx context.Context, path string, tags []string, tag_name,
 			}
 		}
 
-		doc_update := bson.M{}
+		new_perms := targetNode.Permissions
 		switch perm {
 		case types.WriteMode:
-			doc_update, err = ListUpdateDoc("permissions.write_tags", tag_name, op)
+			new_perms.WriteTags, err = UpdateTagList(new_perms.WriteTags, tag_name, op)
 		case types.ReadMode:
-			doc_update, err = ListUpdateDoc("permissions.read_tags", tag_name, op)
+			new_perms.ReadTags, err = UpdateTagList(new_perms.ReadTags, tag_name, op)
 		case types.ExecuteMode:
-			doc_update, err = ListUpdateDoc("permissions.execute_tags", tag_name, op)
+			new_perms.ExecuteTags, err = UpdateTagList(new_perms.ExecuteTags, tag_name, op)
 		case types.UpdatePermissionsMode:
-			doc_update, err = ListUpdateDoc("permissions.updatetag_tags", tag_name, op)
+			new_perms.UpdatePermissionTags, err = UpdateTagList(new_perms.UpdatePermissionTags, tag_name, op)
 		default:
 			return nil, os.ErrInvalid
 		}
@@ -644,27 +949,74 @@ func (s Store) Chmod(ctx context.Context, path string, tags []string, tag_name,
 
 		if targetNode.EntryType == types.Directory && recursive {
 			for _, entry := range targetNode.Entries {
+				// Symlinks aren't followed while recursing: their target may be outside (or above) this tree
+				if child, err := s.Nodes.Get(ctx, entry.RefID); err != nil {
+					return nil, fmt.Errorf("recursion failed on sub-dir %q: %v", entry.Name, err)
+				} else if child.EntryType == types.Symlink {
+					continue
+				}
 				if _, err := s.Chmod(ctx, abs_path+"/"+entry.Name, tags, tag_name, op, perm, true); err != nil {
 					return nil, fmt.Errorf("recursion failed on sub-dir %q: %v", entry.Name, err)
 				}
 			}
 		}
 
-		doc_update["$set"] = bson.M{}
-		set_doc := doc_update["$set"].(bson.M)
-		set_doc["timestamps.accessed_at"] = now
-		set_doc["timestamps.modified_at"] = now
+		return s.Nodes.Update(ctx, targetNode.ID, NodeUpdate{
+			Permissions: &new_perms,
+			AccessedAt:  &now,
+			ModifiedAt:  &now,
+		})
+	}
+}
 
-		var updated_node types.FsEntry
-		if err := s.Col.FindOneAndUpdate(ctx, bson.M{"_id": targetNode.ID}, doc_update).Decode(&updated_node); err != nil {
-			return nil, err
-		}
+// SetDefaults replaces the permissions the directory at abs_path gives entries created in it (nil removes them).
+// Like chmod, it needs the update permission on the directory, and each default's updatetag tags must be ones
+// tags could give the directory itself. Existing entries (and subdirectories' own defaults) are left alone.
+func (s Store) SetDefaults(ctx context.Context, abs_path string, tags []string, defaults *types.DefaultPermissions) (*types.FsEntry, error) {
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.setDefaults(ctx, abs_path, tags, defaults)
+	})
+}
 
-		return &updated_node, nil
+func (s Store) setDefaults(ctx context.Context, abs_path string, tags []string, defaults *types.DefaultPermissions) (*types.FsEntry, error) {
+	now := time.Now()
+	clean_path, err := CleanupAbsPath(abs_path)
+	if err != nil {
+		return nil, err
 	}
+
+	entry, err := s.Lookup(ctx, tags, clean_path)
+	if err != nil {
+		return nil, err
+	}
+	if entry.EntryType != types.Directory {
+		return nil, fmt.Errorf("%w: %q is not a directory", os.ErrInvalid, clean_path)
+	}
+	if !entry.Permissions.IsAllowed(types.UpdatePermissionsMode, tags) {
+		return nil, types.ErrCantAccessFs
+	}
+
+	update := NodeUpdate{AccessedAt: &now, ModifiedAt: &now}
+	if defaults == nil || (defaults.Files == nil && defaults.Directories == nil) {
+		update.ClearDefaults = true
+	} else {
+		for _, perms := range []*types.FsEntryPermissions{defaults.Files, defaults.Directories} {
+			if perms != nil && !entry.Permissions.CanUpdatePermTags(perms.UpdatePermissionTags, tags) {
+				return nil, types.ErrCantAccessFs
+			}
+		}
+		update.Defaults = defaults
+	}
+	return s.Nodes.Update(ctx, entry.ID, update)
 }
 
 func (s Store) Copy(ctx context.Context, dest_path, src_path string, tags []string, recursive bool) (*types.FsEntry, error) {
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.copyEntry(ctx, dest_path, src_path, tags, recursive)
+	})
+}
+
+func (s Store) copyEntry(ctx context.Context, dest_path, src_path string, tags []string, recursive bool) (*types.FsEntry, error) {
 	source_abs, err := CleanupAbsPath(src_path)
 	if err != nil {
 		return nil, err
@@ -692,24 +1044,23 @@ func (s Store) Copy(ctx context.Context, dest_path, src_path string, tags []stri
 	if err != nil {
 		var lookupStopError ErrLookupStop
 		if errors.As(err, &lookupStopError) && errors.Is(err, os.ErrNotExist) {
-			dest_parent_path, dest_filename, _ := DirUp(dest_abs)
-			if lookupStopError.LastSuccessfulPath != dest_parent_path {
+			if len(lookupStopError.Remaining) != 1 {
 				return nil, err
 			}
+			dest_filename := lookupStopError.Remaining[0]
 
+			if ctx, err = s.checkCopyQuotas(ctx, lookupStopError.LastEntry, *source_node); err != nil {
+				return nil, err
+			}
 			if source_node.EntryType == types.File {
-				return s.WriteFile(ctx, lookupStopError.LastEntry.ID, dest_filename, *source_node.FileReference, tags)
+				return s.copyFile(ctx, lookupStopError.LastEntry.ID, dest_filename, *source_node, tags)
 			}
 
 			if node, err := s.WriteDirectory(ctx, lookupStopError.LastEntry.ID, dest_filename, tags, nil); err != nil {
 				return nil, err
+			} else if err := s.copyChildren(ctx, dest_abs, source_abs, source_node, tags); err != nil {
+				return nil, err
 			} else {
-				for _, entry := range source_node.Entries {
-					if _, err := s.Copy(ctx, dest_abs, source_abs+"/"+entry.Name, tags, true); err != nil {
-						return nil, err
-					}
-				}
-
 				return node, nil
 			}
 		} else {
@@ -717,21 +1068,228 @@ func (s Store) Copy(ctx context.Context, dest_path, src_path string, tags []stri
 		}
 	} else {
 		_, source_filename, _ := DirUp(source_abs)
+		if ctx, err = s.checkCopyQuotas(ctx, *dest_folder, *source_node); err != nil {
+			return nil, err
+		}
 		if source_node.EntryType == types.File {
-			return s.WriteFile(ctx, dest_folder.ID, source_filename, *source_node.FileReference, tags)
+			return s.copyFile(ctx, dest_folder.ID, source_filename, *source_node, tags)
 		}
 
 		if node, err := s.WriteDirectory(ctx, dest_folder.ID, source_filename, tags, nil); err != nil {
 			return nil, err
+		} else if err := s.copyChildren(ctx, dest_abs+"/"+source_filename, source_abs, source_node, tags); err != nil {
+			return nil, err
 		} else {
-			new_dest := dest_abs + "/" + source_filename
-			for _, entry := range source_node.Entries {
-				if _, err := s.Copy(ctx, new_dest, source_abs+"/"+entry.Name, tags, true); err != nil {
-					return nil, err
-				}
+			return node, nil
+		}
+	}
+}
+
+// copyFile writes source's contents to the file name inside parentID.
+// Files touch created have no contents, so their copies are created (or emptied) without any either.
+func (s Store) copyFile(ctx context.Context, parentID primitive.ObjectID, name string, source types.FsEntry, tags []string) (*types.FsEntry, error) {
+	if source.FileReference != nil {
+		return s.WriteFile(ctx, parentID, name, *source.FileReference, tags)
+	}
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.writeFileNode(ctx, parentID, name, nil, tags, writerName(ctx), nil)
+	})
+}
+
+// checkCopyQuotas checks copying source into dest_parent against quotas as a whole, up front.
+// The returned ctx skips the per-node checks while the copy runs.
+func (s Store) checkCopyQuotas(ctx context.Context, dest_parent, source types.FsEntry) (context.Context, error) {
+	if checked, _ := ctx.Value(quotaCheckedKey{}).(bool); checked {
+		return ctx, nil
+	}
+
+	quotas, err := s.quotasFor(ctx, dest_parent, dest_parent.ChildPermissions(source.EntryType).UpdatePermissionTags)
+	if err != nil || len(quotas) == 0 {
+		return ctx, err
+	}
+	bytes, entries, err := s.subtreeSize(ctx, source)
+	if err != nil {
+		return ctx, err
+	}
+	if err := s.checkQuotas(ctx, quotas, bytes, entries); err != nil {
+		return ctx, err
+	}
+
+	return context.WithValue(ctx, quotaCheckedKey{}, true), nil
+}
+
+// copyChildren copies a directory's entries into dest_abs. Symlinks are copied as links rather than followed.
+func (s Store) copyChildren(ctx context.Context, dest_abs, source_abs string, source_node *types.FsEntry, tags []string) error {
+	for _, entry := range source_node.Entries {
+		child, err := s.Nodes.Get(ctx, entry.RefID)
+		if err != nil {
+			return err
+		}
+
+		if child.EntryType == types.Symlink {
+			if _, err := s.Symlink(ctx, dest_abs+"/"+entry.Name, child.Target, tags); err != nil {
+				return err
 			}
+		} else if _, err := s.Copy(ctx, dest_abs, source_abs+"/"+entry.Name, tags, true); err != nil {
+			return err
+		}
+	}
 
-			return node, nil
+	return nil
+}
+
+// Symlink creates a link at abs_path pointing to target. The target doesn't have to exist.
+func (s Store) Symlink(ctx context.Context, abs_path, target string, tags []string) (*types.FsEntry, error) {
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.symlink(ctx, abs_path, target, tags)
+	})
+}
+
+func (s Store) symlink(ctx context.Context, abs_path, target string, tags []string) (*types.FsEntry, error) {
+	now := time.Now()
+	if target == "" {
+		return nil, fmt.Errorf("%w: symlink target cannot be empty", os.ErrInvalid)
+	}
+
+	folder_path, filename, err := DirUp(abs_path)
+	if err != nil {
+		return nil, err
+	}
+	if filename == "" {
+		return nil, fmt.Errorf("%w: can't create a symlink at /", os.ErrInvalid)
+	}
+
+	parent, err := s.Lookup(ctx, tags, folder_path)
+	if err != nil {
+		return nil, err
+	}
+	if parent.EntryType != types.Directory {
+		return nil, fmt.Errorf("%w: parent not a directory", os.ErrInvalid)
+	}
+	if !parent.Permissions.IsAllowed(types.WriteMode, tags) || !parent.Permissions.IsAllowed(types.ExecuteMode, tags) {
+		return nil, types.ErrCantAccessFs
+	}
+	if _, exists := parent.Entries.Get(filename); exists {
+		return nil, os.ErrExist
+	}
+	perms := parent.ChildPermissions(types.Symlink)
+	quotas, err := s.enforceQuotas(ctx, *parent, perms.UpdatePermissionTags, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	created := types.FsEntry{
+		ID:          primitive.NewObjectID(),
+		EntryType:   types.Symlink,
+		Permissions: perms,
+		Timestamps: types.FileTimestamps{
+			CreatedAt:  now,
+			ModifiedAt: now,
+			AccessedAt: now,
+		},
+		Target: target,
+		Path:   childPath(*parent, filename),
+	}
+	if err := s.Nodes.Insert(ctx, created); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Nodes.Update(ctx, parent.ID, NodeUpdate{
+		AddEntries: []types.FsEntryReference{{
+			Name:  filename,
+			RefID: created.ID,
+		}},
+		ModifiedAt: &now,
+		AccessedAt: &now,
+	}); err != nil {
+		return nil, err
+	}
+	s.countQuotas(quotas, 0, 1)
+
+	return &created, nil
+}
+
+// Touch sets the modified time of the entry at abs_path (following symlinks) to now.
+// If nothing exists there, an empty file is created, or nothing happens (and entry is nil) if noCreate is set.
+// Touching an existing entry needs write access to it; creating a file needs write and execute access to its folder.
+func (s Store) Touch(ctx context.Context, abs_path string, tags []string, noCreate bool) (entry *types.FsEntry, created bool, err error) {
+	err = s.Atomic(ctx, func(ctx context.Context) error {
+		entry, created, err = s.touch(ctx, abs_path, tags, noCreate)
+		return err
+	})
+	return entry, created, err
+}
+
+func (s Store) touch(ctx context.Context, abs_path string, tags []string, noCreate bool) (*types.FsEntry, bool, error) {
+	now := time.Now()
+	resolved, err := s.Resolve(ctx, tags, abs_path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if existing, err := s.Lookup(ctx, tags, resolved); err == nil {
+		if !existing.Permissions.IsAllowed(types.WriteMode, tags) {
+			return nil, false, types.ErrCantAccessFs
 		}
+		updated, err := s.Nodes.Update(ctx, existing.ID, NodeUpdate{
+			ModifiedAt: &now,
+			AccessedAt: &now,
+		})
+		return updated, false, err
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	} else if noCreate {
+		return nil, false, nil
+	}
+
+	folder_path, filename, err := DirUp(resolved)
+	if err != nil {
+		return nil, false, err
+	}
+	parent, err := s.Lookup(ctx, tags, folder_path)
+	if err != nil {
+		return nil, false, err
+	}
+	if parent.EntryType != types.Directory {
+		return nil, false, fmt.Errorf("%w: parent not a directory", os.ErrInvalid)
 	}
+	if !parent.Permissions.IsAllowed(types.WriteMode, tags) || !parent.Permissions.IsAllowed(types.ExecuteMode, tags) {
+		return nil, false, types.ErrCantAccessFs
+	}
+	perms := parent.ChildPermissions(types.File)
+	quotas, err := s.enforceQuotas(ctx, *parent, perms.UpdatePermissionTags, 0, 1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Files without a blob read as empty, so there's nothing to upload
+	created := types.FsEntry{
+		ID:          primitive.NewObjectID(),
+		EntryType:   types.File,
+		Permissions: perms,
+		Timestamps: types.FileTimestamps{
+			CreatedAt:  now,
+			ModifiedAt: now,
+			AccessedAt: now,
+		},
+		ModifiedBy: writerName(ctx),
+		Revision:   1,
+		Path:       childPath(*parent, filename),
+	}
+	if err := s.Nodes.Insert(ctx, created); err != nil {
+		return nil, false, err
+	}
+	if _, err := s.Nodes.Update(ctx, parent.ID, NodeUpdate{
+		AddEntries: []types.FsEntryReference{{
+			Name:  filename,
+			RefID: created.ID,
+		}},
+		ModifiedAt: &now,
+		AccessedAt: &now,
+	}); err != nil {
+		return nil, false, err
+	}
+	s.countQuotas(quotas, 0, 1)
+
+	return &created, true, nil
 }
@@ -0,0 +1,1629 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testPerms(tags ...string) types.FsEntryPermissions {
+	return types.FsEntryPermissions{
+		ReadTags:             tags,
+		WriteTags:            tags,
+		ExecuteTags:          tags,
+		UpdatePermissionTags: tags,
+	}
+}
+
+// newTestStore returns an in-memory store with a root and a /home/alice directory owned by "user-alice"
+func newTestStore(t *testing.T) Store {
+	t.Helper()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	alice := types.FsEntry{
+		ID:          primitive.NewObjectID(),
+		EntryType:   types.Directory,
+		Permissions: testPerms("sysadmin", "user-alice"),
+		Timestamps:  types.FileTimestamps{CreatedAt: now, ModifiedAt: now, AccessedAt: now},
+		Entries:     types.FsReferenceList{},
+	}
+	home := types.FsEntry{
+		ID:        primitive.NewObjectID(),
+		EntryType: types.Directory,
+		Permissions: types.FsEntryPermissions{
+			ReadTags:             []string{"sysadmin"},
+			WriteTags:            []string{"sysadmin"},
+			ExecuteTags:          []string{"sysadmin", "user"},
+			UpdatePermissionTags: []string{"sysadmin"},
+		},
+		Timestamps: types.FileTimestamps{CreatedAt: now, ModifiedAt: now, AccessedAt: now},
+		Entries:    types.FsReferenceList{{Name: "alice", RefID: alice.ID}},
+	}
+	root := types.FsEntry{
+		ID:        primitive.NewObjectID(),
+		IsRoot:    true,
+		EntryType: types.Directory,
+		Permissions: types.FsEntryPermissions{
+			ReadTags:             []string{"sysadmin", "user"},
+			WriteTags:            []string{"sysadmin"},
+			ExecuteTags:          []string{"sysadmin", "user"},
+			UpdatePermissionTags: []string{"sysadmin"},
+		},
+		Timestamps: types.FileTimestamps{CreatedAt: now, ModifiedAt: now, AccessedAt: now},
+		Entries:    types.FsReferenceList{{Name: "home", RefID: home.ID}},
+	}
+
+	if err := store.Nodes.Insert(context.Background(), alice, home, root); err != nil {
+		t.Fatalf("failed to seed store: %v", err)
+	}
+
+	return store
+}
+
+func writeTestFile(t *testing.T, store Store, abs_path string, tags []string, content string) {
+	t.Helper()
+	ctx := context.Background()
+	folder_path, filename, err := DirUp(abs_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := store.Lookup(ctx, tags, folder_path)
+	if err != nil {
+		t.Fatalf("lookup %q: %v", folder_path, err)
+	}
+
+	fileRef := primitive.NewObjectID()
+	stream, err := store.Blobs.OpenUpload(ctx, fileRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.WriteFile(ctx, parent.ID, filename, fileRef, tags); err != nil {
+		t.Fatalf("write %q: %v", abs_path, err)
+	}
+}
+
+func TestStoreLookupPermissions(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	if _, err := store.Lookup(ctx, []string{"user", "user-alice"}, "/home/alice"); err != nil {
+		t.Fatalf("owner lookup failed: %v", err)
+	}
+
+	if _, err := store.Lookup(ctx, []string{"user-bob"}, "/home/alice"); !errors.Is(err, types.ErrCantAccessFs) {
+		t.Fatalf("expected access denied, got %v", err)
+	}
+
+	var stop ErrLookupStop
+	if _, err := store.Lookup(ctx, []string{"sysadmin"}, "/home/bob/file"); !errors.As(err, &stop) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrLookupStop wrapping ErrNotExist, got %v", err)
+	} else if stop.LastSuccessfulPath != "/home" {
+		t.Fatalf("expected lookup to stop at /home, got %q", stop.LastSuccessfulPath)
+	}
+}
+
+func TestStoreFileOperations(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	writeTestFile(t, store, "/home/alice/notes.txt", tags, "hello")
+	if data, err := store.LookupReadAll(ctx, "/home/alice/notes.txt", tags); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "hello" {
+		t.Fatalf("unexpected content %q", data)
+	}
+
+	if _, err := store.Mkdir(ctx, "/home/alice/a/b/c", tags, nil, true); err != nil {
+		t.Fatalf("mkdir -p failed: %v", err)
+	}
+	if _, err := store.Mkdir(ctx, "/home/alice/x/y", tags, nil, false); err == nil {
+		t.Fatal("expected mkdir without parents to fail")
+	}
+
+	if _, err := store.Move(ctx, "/home/alice/a/b/c/moved.txt", "/home/alice/notes.txt", tags); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	if _, err := store.Lookup(ctx, tags, "/home/alice/notes.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected source to be gone, got %v", err)
+	}
+
+	if _, err := store.Copy(ctx, "/home/alice/copy", "/home/alice/a", tags, true); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if reader, err := store.LookupRead(ctx, "/home/alice/copy/b/c/moved.txt", tags); err != nil {
+		t.Fatalf("copied file missing: %v", err)
+	} else {
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != "hello" {
+			t.Fatalf("unexpected copied content %q", data)
+		}
+	}
+
+	if _, err := store.RemoveFile(ctx, "/home/alice/copy", tags, false, false); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("expected directory removal without -r to fail, got %v", err)
+	}
+	if _, err := store.RemoveFile(ctx, "/home/alice/copy", tags, false, true); err != nil {
+		t.Fatalf("rm -r failed: %v", err)
+	}
+	if _, err := store.Lookup(ctx, tags, "/home/alice/copy"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected removed directory to be gone, got %v", err)
+	}
+}
+
+func TestStoreChmod(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	writeTestFile(t, store, "/home/alice/shared.txt", tags, "data")
+
+	if _, err := store.Chmod(ctx, "/home/alice", tags, "user-bob", "+", types.ReadMode, true); err != nil {
+		t.Fatalf("chmod failed: %v", err)
+	}
+	if _, err := store.Chmod(ctx, "/home/alice", tags, "user-bob", "+", types.ExecuteMode, false); err != nil {
+		t.Fatalf("chmod failed: %v", err)
+	}
+
+	bob := []string{"user", "user-bob"}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/shared.txt", bob); err != nil {
+		t.Fatalf("expected bob to read shared file: %v", err)
+	} else if string(data) != "data" {
+		t.Fatalf("unexpected content %q", data)
+	}
+
+	if _, err := store.Chmod(ctx, "/home/alice", bob, "user-bob", "+", types.WriteMode, false); !errors.Is(err, types.ErrCantAccessFs) {
+		t.Fatalf("expected bob's chmod to be denied, got %v", err)
+	}
+}
+
+func TestStoreDefaults(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := []string{"user", "user-alice"}
+	bob := []string{"user", "user-bob"}
+
+	// Files in the shared folder are readable by bob, while the folder itself stays alice's
+	shared_files := types.FsEntryPermissions{
+		ReadTags:             []string{"sysadmin", "user-alice", "user-bob"},
+		WriteTags:            []string{"sysadmin", "user-alice"},
+		ExecuteTags:          []string{"sysadmin", "user-alice"},
+		UpdatePermissionTags: []string{"sysadmin", "user-alice"},
+	}
+	if _, err := store.Mkdir(ctx, "/home/alice/shared", alice, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SetDefaults(ctx, "/home/alice/shared", alice, &types.DefaultPermissions{Files: &shared_files}); err != nil {
+		t.Fatalf("set defaults failed: %v", err)
+	}
+
+	writeTestFile(t, store, "/home/alice/shared/notes.txt", alice, "hello")
+	if entry := mustLookup(t, store, alice, "/home/alice/shared/notes.txt"); !slices.Equal(entry.Permissions.ReadTags, shared_files.ReadTags) {
+		t.Fatalf("expected the new file to get the default permissions, got %+v", entry.Permissions)
+	}
+	if _, _, err := store.Touch(ctx, "/home/alice/shared/empty.txt", alice, false); err != nil {
+		t.Fatal(err)
+	}
+	if entry := mustLookup(t, store, alice, "/home/alice/shared/empty.txt"); !slices.Equal(entry.Permissions.ReadTags, shared_files.ReadTags) {
+		t.Fatalf("expected touch to use the default permissions, got %+v", entry.Permissions)
+	}
+
+	// Subdirectories keep the folder's permissions (there's no directory default), and pass the defaults on
+	sub, err := store.Mkdir(ctx, "/home/alice/shared/a/b", alice, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(sub.Permissions.ReadTags, "user-bob") || sub.Defaults == nil || sub.Defaults.Files == nil {
+		t.Fatalf("unexpected subdirectory %+v", sub)
+	}
+	writeTestFile(t, store, "/home/alice/shared/a/b/deep.txt", alice, "deep")
+	if entry := mustLookup(t, store, alice, "/home/alice/shared/a/b/deep.txt"); !slices.Equal(entry.Permissions.ReadTags, shared_files.ReadTags) {
+		t.Fatalf("expected defaults to apply below subdirectories, got %+v", entry.Permissions)
+	}
+
+	// Explicit permissions still win
+	explicit := testPerms("sysadmin", "user-alice")
+	if dir, err := store.WriteDirectory(ctx, mustLookup(t, store, alice, "/home/alice/shared").ID, "private", alice, &explicit); err != nil || !slices.Equal(dir.Permissions.ReadTags, explicit.ReadTags) {
+		t.Fatalf("expected explicit permissions to be kept, got %+v (%v)", dir, err)
+	}
+
+	// Defaults need the same rights as chmod
+	if _, err := store.SetDefaults(ctx, "/home/alice/shared", bob, nil); !errors.Is(err, types.ErrCantAccessFs) {
+		t.Fatalf("expected bob to be denied, got %v", err)
+	}
+	stolen := testPerms("user-bob")
+	if _, err := store.SetDefaults(ctx, "/home/alice/shared", alice, &types.DefaultPermissions{Directories: &stolen}); !errors.Is(err, types.ErrCantAccessFs) {
+		t.Fatalf("expected alice to be unable to give away directories she creates, got %v", err)
+	}
+	if _, err := store.SetDefaults(ctx, "/home/alice/shared/notes.txt", alice, nil); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("expected setting defaults on a file to fail, got %v", err)
+	}
+
+	if entry, err := store.SetDefaults(ctx, "/home/alice/shared", alice, nil); err != nil || entry.Defaults != nil {
+		t.Fatalf("expected the defaults to be removed, got %+v (%v)", entry, err)
+	}
+	writeTestFile(t, store, "/home/alice/shared/later.txt", alice, "later")
+	if entry := mustLookup(t, store, alice, "/home/alice/shared/later.txt"); slices.Contains(entry.Permissions.ReadTags, "user-bob") {
+		t.Fatalf("expected files to copy the folder's permissions again, got %+v", entry.Permissions)
+	}
+}
+
+func TestStoreSymlinks(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	if _, err := store.Mkdir(ctx, "/home/alice/fleet", tags, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/fleet/plan.txt", tags, "route")
+
+	if _, err := store.Symlink(ctx, "/home/alice/shared", "fleet", tags); err != nil {
+		t.Fatalf("symlink failed: %v", err)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/shared/plan.txt", tags); err != nil {
+		t.Fatalf("lookup through symlink failed: %v", err)
+	} else if string(data) != "route" {
+		t.Fatalf("unexpected content %q", data)
+	}
+	if resolved, err := store.Resolve(ctx, tags, "/home/alice/shared/new.txt"); err != nil {
+		t.Fatal(err)
+	} else if resolved != "/home/alice/fleet/new.txt" {
+		t.Fatalf("unexpected resolved path %q", resolved)
+	}
+	if link, err := store.LookupNoFollow(ctx, tags, "/home/alice/shared"); err != nil || link.EntryType != types.Symlink {
+		t.Fatalf("expected LookupNoFollow to return the link, got %v (%v)", link, err)
+	}
+
+	// Following a link still checks every component of the target
+	if _, err := store.Symlink(ctx, "/home/alice/root-link", "/home/alice", tags); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lookup(ctx, []string{"user", "user-bob"}, "/home/alice/fleet/plan.txt"); !errors.Is(err, types.ErrCantAccessFs) {
+		t.Fatalf("expected access denied, got %v", err)
+	}
+
+	if _, err := store.Symlink(ctx, "/home/alice/a", "b", tags); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Symlink(ctx, "/home/alice/b", "a", tags); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lookup(ctx, tags, "/home/alice/a"); !errors.Is(err, ErrSymlinkLoop) {
+		t.Fatalf("expected symlink loop error, got %v", err)
+	}
+
+	// Copying a tree with a link to its own parent must copy the link instead of following it
+	if _, err := store.Symlink(ctx, "/home/alice/fleet/up", "..", tags); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Copy(ctx, "/home/alice/fleet-copy", "/home/alice/fleet", tags, true); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if link, err := store.LookupNoFollow(ctx, tags, "/home/alice/fleet-copy/up"); err != nil || link.Target != ".." {
+		t.Fatalf("expected copied link, got %v (%v)", link, err)
+	}
+
+	if _, err := store.RemoveFile(ctx, "/home/alice/shared", tags, false, false); err != nil {
+		t.Fatalf("rm of symlink failed: %v", err)
+	}
+	if _, err := store.Lookup(ctx, tags, "/home/alice/fleet/plan.txt"); err != nil {
+		t.Fatalf("removing the link shouldn't touch the target: %v", err)
+	}
+}
+
+func TestStoreVersions(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.WithValue(context.Background(), "auth_status", types.AuthorizationStatus{Username: "alice"})
+	tags := []string{"user", "user-alice"}
+
+	for _, content := range []string{"one", "two", "three"} {
+		writeTestFile(t, store, "/home/alice/plan.txt", tags, content)
+	}
+
+	if reader, err := store.ReadVersion(ctx, "/home/alice/plan.txt", tags, 2); err != nil {
+		t.Fatalf("read version failed: %v", err)
+	} else {
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != "one" {
+			t.Fatalf("expected version 2 to be %q, got %q", "one", data)
+		}
+	}
+	if _, err := store.ReadVersion(ctx, "/home/alice/plan.txt", tags, 3); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing version error, got %v", err)
+	}
+	if _, err := store.ReadVersion(ctx, "/home/alice/plan.txt", []string{"user-bob"}, 1); !errors.Is(err, types.ErrCantAccessFs) {
+		t.Fatalf("expected access denied, got %v", err)
+	}
+
+	if entry, err := store.RestoreVersion(ctx, "/home/alice/plan.txt", tags, 2); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	} else if entry.ModifiedBy != "alice" || len(entry.Versions) != 3 {
+		t.Fatalf("unexpected entry after restore: %+v", entry)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/plan.txt", tags); err != nil || string(data) != "one" {
+		t.Fatalf("expected restored contents, got %q (%v)", data, err)
+	}
+
+	// Rewriting identical contents shares the current blob, so each write needs new contents to add history
+	for i := 0; i < MaxFileVersions+5; i++ {
+		writeTestFile(t, store, "/home/alice/plan.txt", tags, "more "+strconv.Itoa(i))
+	}
+	if entry, err := store.Lookup(ctx, tags, "/home/alice/plan.txt"); err != nil {
+		t.Fatal(err)
+	} else if len(entry.Versions) != MaxFileVersions {
+		t.Fatalf("expected history to be capped at %d, got %d", MaxFileVersions, len(entry.Versions))
+	}
+}
+
+func TestStoreCollectGarbage(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	writeTestFile(t, store, "/home/alice/a.txt", tags, "first")
+	writeTestFile(t, store, "/home/alice/a.txt", tags, "second")
+	writeTestFile(t, store, "/home/alice/b.txt", tags, "gone")
+	if _, err := store.RemoveFile(ctx, "/home/alice/b.txt", tags, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A blob nothing references, backdated past the grace period
+	old_ref := primitive.NewObjectIDFromTimestamp(time.Now().Add(-2 * time.Hour))
+	if stream, err := store.Blobs.OpenUpload(ctx, old_ref); err != nil {
+		t.Fatal(err)
+	} else {
+		stream.Write([]byte("stale"))
+		stream.Close()
+	}
+
+	if report, err := store.CollectGarbage(ctx, true, DefaultGCGracePeriod); err != nil {
+		t.Fatal(err)
+	} else if report.RemovedBlobs != 1 || report.ReclaimedBytes != 5 {
+		t.Fatalf("unexpected dry run report: %+v", report)
+	}
+	if _, err := store.Blobs.Size(ctx, old_ref); err != nil {
+		t.Fatalf("dry run shouldn't delete blobs: %v", err)
+	}
+
+	// The removed file's blob was freed by RemoveFile, and history is kept
+	if report, err := store.CollectGarbage(ctx, false, 0); err != nil {
+		t.Fatal(err)
+	} else if report.RemovedBlobs != 1 || report.ReclaimedBytes != 5 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if reader, err := store.ReadVersion(ctx, "/home/alice/a.txt", tags, 1); err != nil {
+		t.Fatalf("version blob was collected: %v", err)
+	} else {
+		reader.Close()
+	}
+
+	// A detached directory shows up as orphaned, along with everything below it
+	if _, err := store.Mkdir(ctx, "/home/alice/tree/leaf", tags, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Nodes.Update(ctx, mustLookup(t, store, tags, "/home/alice").ID, NodeUpdate{RemoveEntries: []string{"tree"}}); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := store.CollectGarbage(ctx, true, 0); err != nil {
+		t.Fatal(err)
+	} else if len(report.OrphanedEntries) != 2 {
+		t.Fatalf("expected the tree and leaf directories to be orphaned, got %+v", report)
+	}
+}
+
+func TestStoreFsck(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	if report, err := store.Fsck(ctx, false, 0); err != nil {
+		t.Fatal(err)
+	} else if len(report.Issues) != 0 {
+		t.Fatalf("expected a clean tree, got %+v", report.Issues)
+	}
+
+	writeTestFile(t, store, "/home/alice/plan.txt", tags, "route")
+	alice, err := store.Lookup(ctx, tags, "/home/alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := store.Lookup(ctx, tags, "/home/alice/plan.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate half-finished operations
+	if err := store.Blobs.Delete(ctx, *plan.FileReference); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Nodes.Update(ctx, alice.ID, NodeUpdate{AddEntries: []types.FsEntryReference{
+		{Name: "ghost", RefID: primitive.NewObjectID()},
+		{Name: "plan.txt", RefID: alice.ID},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Mkdir(ctx, "/home/alice/tree/leaf", tags, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Nodes.Update(ctx, alice.ID, NodeUpdate{RemoveEntries: []string{"tree"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The detached tree is new, so it might still be linked in by a write in progress
+	report, err := store.Fsck(ctx, true, DefaultFsckGracePeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.RecentEntries != 2 || len(report.Recovered) != 0 {
+		t.Fatalf("expected the recent tree to be left alone, got %d recent, recovered %v", report.RecentEntries, report.Recovered)
+	}
+	for _, issue := range report.Issues {
+		if issue.Kind == FsckUnreachable {
+			t.Fatalf("expected recent nodes not to be reported, got %+v", issue)
+		}
+	}
+
+	report, err = store.Fsck(ctx, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]int{}
+	for _, issue := range report.Issues {
+		kinds[issue.Kind]++
+	}
+	if kinds[FsckMissingBlob] != 1 || kinds[FsckDanglingReference] != 1 || kinds[FsckDuplicateName] != 1 || kinds[FsckUnreachable] != 2 {
+		t.Fatalf("unexpected issues: %+v", report.Issues)
+	}
+	if len(report.Recovered) != 1 {
+		t.Fatalf("expected only the top of the detached tree to be recovered, got %v", report.Recovered)
+	}
+	if _, err := store.Lookup(ctx, []string{"sysadmin"}, report.Recovered[0]+"/leaf"); err != nil {
+		t.Fatalf("recovered tree isn't reachable: %v", err)
+	}
+
+	if report, err := store.Fsck(ctx, false, 0); err != nil {
+		t.Fatal(err)
+	} else {
+		for _, issue := range report.Issues {
+			if issue.Kind == FsckUnreachable {
+				t.Fatalf("expected repair to reattach everything, got %+v", issue)
+			}
+		}
+	}
+}
+
+// recordingNodes is a MemoryNodes that records transactions, to check which operations use them.
+// Like a real transaction, a failed one leaves the nodes as they were.
+type recordingNodes struct {
+	MemoryNodes
+	transactions *int
+}
+
+func (r recordingNodes) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	*r.transactions++
+	r.mu.RLock()
+	snapshot := maps.Clone(r.nodes)
+	r.mu.RUnlock()
+
+	err := fn(ctx)
+	if err != nil {
+		r.mu.Lock()
+		clear(r.nodes)
+		maps.Copy(r.nodes, snapshot)
+		r.mu.Unlock()
+	}
+	return err
+}
+
+func TestStoreAtomic(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	transactions := 0
+	store.Nodes = recordingNodes{MemoryNodes: store.Nodes.(MemoryNodes), transactions: &transactions}
+
+	if _, err := store.Mkdir(ctx, "/home/alice/a", tags, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Move(ctx, "/home/alice/b", "/home/alice/a", tags); err != nil {
+		t.Fatal(err)
+	}
+	if transactions < 2 {
+		t.Fatalf("expected mutations to run in transactions, got %d", transactions)
+	}
+
+	errAbort := errors.New("abort")
+	if err := store.Atomic(ctx, func(ctx context.Context) error { return errAbort }); !errors.Is(err, errAbort) {
+		t.Fatalf("expected Atomic to return fn's error, got %v", err)
+	}
+
+	// A failed fn rolls back its partial writes, and the contents it removed aren't freed
+	writeTestFile(t, store, "/home/alice/keep.txt", tags, "kept")
+	kept := mustLookup(t, store, tags, "/home/alice/keep.txt")
+	err := store.Atomic(ctx, func(ctx context.Context) error {
+		if _, err := store.Mkdir(ctx, "/home/alice/partial", tags, nil, false); err != nil {
+			return err
+		}
+		if _, err := store.RemoveFile(ctx, "/home/alice/keep.txt", tags, false, false); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected Atomic to return fn's error, got %v", err)
+	}
+	if _, err := store.Lookup(ctx, tags, "/home/alice/partial"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the directory made before the failure to be rolled back, got %v", err)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/keep.txt", tags); err != nil || string(data) != "kept" {
+		t.Fatalf("expected the removed file to be restored with its contents, got %q (%v)", data, err)
+	}
+	if _, err := store.Blobs.Size(ctx, *kept.FileReference); err != nil {
+		t.Fatalf("expected the removed file's contents to survive the rollback, got %v", err)
+	}
+
+	// Without transaction support, fn still runs and its error comes back, but partial writes stay
+	plain := newTestStore(t)
+	if _, ok := plain.Nodes.(Transactor); ok {
+		t.Fatal("expected the memory store to lack transactions")
+	}
+	err = plain.Atomic(ctx, func(ctx context.Context) error {
+		if _, err := plain.Mkdir(ctx, "/home/alice/partial", tags, nil, false); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected Atomic to return fn's error, got %v", err)
+	}
+	if _, err := plain.Lookup(ctx, tags, "/home/alice/partial"); err != nil {
+		t.Fatalf("expected the partial write to stay without transactions, got %v", err)
+	}
+}
+
+func TestStoreRevisions(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+	fsctx := FSContext{Store: store, UserTags: tags}
+
+	writeTestFile(t, store, "/home/alice/flight.yaml", tags, "a")
+	entry, err := store.Lookup(ctx, tags, "/home/alice/flight.yaml")
+	if err != nil {
+		t.Fatal(err)
+	} else if entry.Revision != 1 {
+		t.Fatalf("expected a new file to be at revision 1, got %d", entry.Revision)
+	}
+	alice, err := store.Lookup(ctx, tags, "/home/alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.WriteFileIfRevision(ctx, alice.ID, "flight.yaml", *entry.FileReference, tags, 0); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("expected a stale revision to conflict, got %v", err)
+	}
+	if updated, err := store.WriteFileIfRevision(ctx, alice.ID, "flight.yaml", *entry.FileReference, tags, 1); err != nil {
+		t.Fatalf("conditional write failed: %v", err)
+	} else if updated.Revision != 2 {
+		t.Fatalf("expected revision 2, got %d", updated.Revision)
+	}
+
+	// Two writers open the same file; the second one to close loses
+	first, err := fsctx.Open(ctx, "/home/alice/flight.yaml", os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := fsctx.Open(ctx, "/home/alice/flight.yaml", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Write([]byte("b"))
+	second.Write([]byte("c"))
+	if err := first.Close(); err != nil {
+		t.Fatalf("first writer should commit: %v", err)
+	}
+	if err := second.Close(); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("expected second writer to conflict, got %v", err)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/flight.yaml", tags); err != nil || string(data) != "ab" {
+		t.Fatalf("expected only the first write to land, got %q (%v)", data, err)
+	}
+
+	// Truncating opens don't depend on the old contents, so the last one to close wins
+	third, err := fsctx.Open(ctx, "/home/alice/flight.yaml", os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/flight.yaml", tags, "d")
+	third.Write([]byte("e"))
+	if err := third.Close(); err != nil {
+		t.Fatalf("expected an unconditional write to commit, got %v", err)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/flight.yaml", tags); err != nil || string(data) != "e" {
+		t.Fatalf("expected the last write to land, got %q (%v)", data, err)
+	}
+}
+
+// countingNodes is a MemoryNodes that counts single-node fetches, to check lookups are served by the path index
+type countingNodes struct {
+	MemoryNodes
+	gets *int
+}
+
+func (c countingNodes) Get(ctx context.Context, id primitive.ObjectID) (*types.FsEntry, error) {
+	*c.gets++
+	return c.MemoryNodes.Get(ctx, id)
+}
+
+func TestStorePathIndex(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	if _, err := store.Mkdir(ctx, "/home/alice/a/b", tags, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/a/b/flight.yaml", tags, "data")
+
+	// The seeded nodes predate the index
+	if err := store.ReindexPaths(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	gets := 0
+	store.Nodes = countingNodes{MemoryNodes: store.Nodes.(MemoryNodes), gets: &gets}
+	pathOf := func(abs_path string) string {
+		t.Helper()
+		entry, err := store.LookupNoFollow(ctx, tags, abs_path)
+		if err != nil {
+			t.Fatalf("lookup %q: %v", abs_path, err)
+		}
+		return entry.Path
+	}
+
+	if path := pathOf("/home/alice/a/b/flight.yaml"); path != "/home/alice/a/b/flight.yaml" {
+		t.Fatalf("unexpected path %q", path)
+	}
+	if gets != 0 {
+		t.Fatalf("expected an indexed lookup to need no per-node fetches, got %d", gets)
+	}
+
+	if _, err := store.Move(ctx, "/home/alice/c", "/home/alice/a", tags); err != nil {
+		t.Fatal(err)
+	}
+	if path := pathOf("/home/alice/c/b/flight.yaml"); path != "/home/alice/c/b/flight.yaml" {
+		t.Fatalf("expected move to reindex descendants, got %q", path)
+	}
+
+	if _, err := store.Copy(ctx, "/home/alice/d", "/home/alice/c", tags, true); err != nil {
+		t.Fatal(err)
+	}
+	if path := pathOf("/home/alice/d/b/flight.yaml"); path != "/home/alice/d/b/flight.yaml" {
+		t.Fatalf("expected copies to be indexed, got %q", path)
+	}
+
+	orphan, err := store.Lookup(ctx, tags, "/home/alice/d/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RemoveFile(ctx, "/home/alice/d", tags, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Nodes.Get(ctx, orphan.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected nodes below a removed directory to be deleted, got %v", err)
+	}
+
+	// A stale path mustn't change what a lookup resolves to
+	flight, err := store.Lookup(ctx, tags, "/home/alice/c/b/flight.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Nodes.SetPaths(ctx, map[primitive.ObjectID]string{flight.ID: "/home/alice/elsewhere"}); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := store.Lookup(ctx, tags, "/home/alice/c/b/flight.yaml"); err != nil || entry.ID != flight.ID {
+		t.Fatalf("expected lookup to fall back to directory entries, got %v (%v)", entry, err)
+	}
+}
+
+func TestStoreAtime(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	if _, err := store.Mkdir(ctx, "/home/alice/private", tags, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/flight.yaml", tags, "a")
+	writeTestFile(t, store, "/home/alice/private/notes", tags, "b")
+
+	overrides, err := ParseAtimeOverrides("/home/alice/private=noatime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Atime = NewAtimeWriter(store.Nodes, RelAtime, overrides)
+
+	before, err := store.Nodes.Get(ctx, mustLookup(t, store, tags, "/home/alice/flight.yaml").ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Atime.Pending() == 0 {
+		t.Fatal("expected the first read after a write to be queued under relatime")
+	}
+	if after, _ := store.Nodes.Get(ctx, before.ID); !after.Timestamps.AccessedAt.Equal(before.Timestamps.AccessedAt) {
+		t.Fatal("expected lookups not to write access times themselves")
+	}
+	if err := store.Atime.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := store.Nodes.Get(ctx, before.ID); !after.Timestamps.AccessedAt.After(before.Timestamps.AccessedAt) {
+		t.Fatal("expected the flush to record the access")
+	}
+
+	// Accessed since the last change, and recently: nothing to record
+	mustLookup(t, store, tags, "/home/alice/flight.yaml")
+	if pending := store.Atime.Pending(); pending != 0 {
+		t.Fatalf("expected relatime to skip a repeated read, got %d queued", pending)
+	}
+
+	notes := mustLookup(t, store, tags, "/home/alice/private/notes")
+	store.Atime.Flush(ctx)
+	if after, _ := store.Nodes.Get(ctx, notes.ID); !after.Timestamps.AccessedAt.Equal(notes.Timestamps.AccessedAt) {
+		t.Fatal("expected the noatime subtree to never record accesses")
+	}
+}
+
+func mustLookup(t *testing.T, store Store, tags []string, abs_path string) *types.FsEntry {
+	t.Helper()
+	entry, err := store.Lookup(context.Background(), tags, abs_path)
+	if err != nil {
+		t.Fatalf("lookup %q: %v", abs_path, err)
+	}
+	return entry
+}
+
+func TestStoreQuotas(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	admin := []string{"sysadmin"}
+	tags := []string{"user", "user-alice"}
+	fsctx := FSContext{Store: store, UserTags: tags}
+
+	// Directory quotas match on materialized paths, which the seeded nodes don't have yet
+	if err := store.ReindexPaths(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Mkdir(ctx, "/etc", admin, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, QuotaConfigPath, admin, "tags:\n  user-alice: {bytes: 10}\ndirectories:\n  /home/alice/flights: {entries: 2}\n")
+	if _, err := store.Mkdir(ctx, "/home/alice/flights", tags, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, store, "/home/alice/a", tags, "12345")
+
+	// Writing through a stream stops as soon as the tag's budget runs out
+	file, err := fsctx.Open(ctx, "/home/alice/b", os.O_WRONLY|os.O_CREATE, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("123456")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the stream to refuse going over quota, got %v", err)
+	}
+	if err := file.Close(); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected close to report the quota, got %v", err)
+	}
+	if _, err := store.Lookup(ctx, tags, "/home/alice/b"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected nothing to be committed, got %v", err)
+	}
+
+	// Overwriting a file only counts the growth
+	writeTestFile(t, store, "/home/alice/a", tags, "1234567890")
+
+	if _, err := store.Copy(ctx, "/home/alice/c", "/home/alice/a", tags, false); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected copy to go over the byte quota, got %v", err)
+	}
+
+	if _, err := store.Mkdir(ctx, "/home/alice/flights/x/y", tags, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Mkdir(ctx, "/home/alice/flights/z", tags, nil, false); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected mkdir to go over the entry quota, got %v", err)
+	}
+
+	usages, err := store.QuotaUsages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 2 || usages[0].Kind != TagQuota || usages[0].Bytes != 10 || usages[1].Entries != 2 {
+		t.Fatalf("unexpected usage: %+v", usages)
+	}
+}
+
+func TestStoreQuotaCache(t *testing.T) {
+	store := newTestStore(t)
+	store.QuotaCache = NewQuotaCache()
+	ctx := context.Background()
+	admin := []string{"sysadmin"}
+	tags := []string{"user", "user-alice"}
+
+	if _, err := store.Mkdir(ctx, "/etc", admin, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, QuotaConfigPath, admin, "tags:\n  user-alice: {bytes: 10}\n")
+	first, err := store.Quotas(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := store.Quotas(ctx); err != nil || again != first {
+		t.Fatalf("expected the parsed config to be reused, got %p and %p (%v)", first, again, err)
+	}
+
+	// Writes keep the measured usage up to date
+	writeTestFile(t, store, "/home/alice/a", tags, "12345")
+	writeTestFile(t, store, "/home/alice/a", tags, "1234")
+	if _, err := store.Mkdir(ctx, "/home/alice/dir", tags, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	usage := store.QuotaCache.usages[quotaKey{TagQuota, "user-alice"}]
+	if usage.bytes != 4 || usage.entries != 3 {
+		t.Fatalf("expected the cached usage to follow the writes, got %+v", usage)
+	}
+	if _, err := store.Copy(ctx, "/home/alice/b", "/home/alice/a", tags, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Copy(ctx, "/home/alice/c", "/home/alice/a", tags, false); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the counted copy to use up the quota, got %v", err)
+	}
+
+	// Removing forgets the usage, so it's measured again
+	if _, err := store.RemoveFile(ctx, "/home/alice/b", tags, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.QuotaCache.usages[quotaKey{TagQuota, "user-alice"}]; ok {
+		t.Fatal("expected removing to forget the cached usage")
+	}
+	if _, err := store.Copy(ctx, "/home/alice/c", "/home/alice/a", tags, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new config revision is read again
+	writeTestFile(t, store, QuotaConfigPath, admin, "tags:\n  user-alice: {bytes: 20}\n")
+	writeTestFile(t, store, "/home/alice/d", tags, "1234567890")
+	usages, err := store.QuotaUsages(ctx)
+	if err != nil || len(usages) != 1 || usages[0].Limit.Bytes != 20 || usages[0].Bytes != 18 {
+		t.Fatalf("unexpected usage: %+v (%v)", usages, err)
+	}
+}
+
+func TestStoreTrash(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.WithValue(context.Background(), "auth_status", types.AuthorizationStatus{Username: "alice"})
+	tags := []string{"user", "user-alice"}
+
+	if _, err := store.Mkdir(ctx, "/home/alice/flights/day1", tags, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/flights/day1/log.csv", tags, "alt,speed")
+	writeTestFile(t, store, "/home/alice/notes.txt", tags, "notes")
+
+	if _, err := store.Trash(ctx, "/home/alice/flights", tags, false, false); err == nil {
+		t.Fatal("expected trashing a directory without rmDirectories to fail")
+	}
+	if _, err := store.Trash(ctx, "/home/alice/flights", tags, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Trash(ctx, "/home/alice/notes.txt", tags, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lookup(ctx, tags, "/home/alice/flights"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected flights to be gone, got %v", err)
+	}
+
+	items, err := store.ListTrash(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[1].OriginalPath != "/home/alice/flights" || items[1].DeletedBy != "alice" || items[1].Type != "directory" {
+		t.Fatalf("unexpected trash: %+v", items)
+	}
+
+	// Restoring brings back the whole subtree, but not over something new
+	writeTestFile(t, store, "/home/alice/notes.txt", tags, "new notes")
+	if _, err := store.RestoreTrash(ctx, "alice", items[0].ID, tags); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected restore over a reused path to fail, got %v", err)
+	}
+	if _, err := store.RestoreTrash(ctx, "alice", items[1].ID, tags); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/flights/day1/log.csv", tags); err != nil || string(data) != "alt,speed" {
+		t.Fatalf("expected restored contents, got %q (%v)", data, err)
+	}
+	if entry := mustLookup(t, store, tags, "/home/alice/flights"); entry.Trash != nil {
+		t.Fatalf("expected trash info to be cleared, got %+v", entry.Trash)
+	}
+
+	// Only entries older than the retention are swept
+	if purged, err := store.SweepTrash(ctx, time.Hour); err != nil || purged != 0 {
+		t.Fatalf("expected nothing to be swept, got %d (%v)", purged, err)
+	}
+	if purged, err := store.SweepTrash(ctx, 0); err != nil || purged != 1 {
+		t.Fatalf("expected the old notes to be swept, got %d (%v)", purged, err)
+	}
+
+	if _, err := store.Trash(ctx, "/home/alice/notes.txt", tags, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := store.PurgeTrash(ctx, "alice", nil); err != nil || purged != 1 {
+		t.Fatalf("expected the trash to be emptied, got %d (%v)", purged, err)
+	}
+	if items, err := store.ListTrash(ctx, "alice"); err != nil || len(items) != 0 {
+		t.Fatalf("expected an empty trash, got %+v (%v)", items, err)
+	}
+}
+
+func TestStoreTrashAncestor(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.WithValue(context.Background(), "auth_status", types.AuthorizationStatus{Username: "alice"})
+	tags := []string{"sysadmin", "user", "user-alice"}
+	writeTestFile(t, store, "/home/alice/notes.txt", tags, "notes")
+
+	// The home directory and /home both hold alice's trash
+	for _, path := range []string{"/home/alice", "/home"} {
+		if _, err := store.Trash(ctx, path, tags, false, true); !errors.Is(err, ErrTrashInside) {
+			t.Fatalf("expected trashing %s to be refused, got %v", path, err)
+		}
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/notes.txt", tags); err != nil || string(data) != "notes" {
+		t.Fatalf("expected the home directory to stay, got %q (%v)", data, err)
+	}
+	if items, err := store.ListTrash(ctx, "alice"); err != nil || len(items) != 0 {
+		t.Fatalf("expected an empty trash, got %+v (%v)", items, err)
+	}
+}
+
+func TestStoreRemoveRecursive(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+	admin := []string{"sysadmin"}
+
+	if _, err := store.Mkdir(ctx, "/home/alice/flights/day1", tags, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/flights/day1/log.csv", tags, "alt,speed")
+	writeTestFile(t, store, "/home/alice/flights/day1/log.csv", tags, "alt,speed,heading")
+	if _, err := store.Copy(ctx, "/home/alice/kept.csv", "/home/alice/flights/day1/log.csv", tags, false); err != nil {
+		t.Fatal(err)
+	}
+	log := mustLookup(t, store, tags, "/home/alice/flights/day1/log.csv")
+	day1 := mustLookup(t, store, tags, "/home/alice/flights/day1")
+
+	locked := testPerms("sysadmin")
+	locked.ExecuteTags = []string{"sysadmin", "user"}
+	if _, err := store.Mkdir(ctx, "/home/alice/flights/audit", admin, &locked, false); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/flights/audit/report.txt", admin, "report")
+
+	// A cancelled remove stops before deleting anything
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := store.RemoveFile(cancelled, "/home/alice/flights", tags, false, true); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the remove to be cancelled, got %v", err)
+	}
+	mustLookup(t, store, tags, "/home/alice/flights/day1/log.csv")
+
+	// Everything but the directory alice can't empty (and the directories above it) goes
+	var partial ErrPartialRemove
+	if _, err := store.RemoveFile(ctx, "/home/alice/flights", tags, false, true); !errors.As(err, &partial) {
+		t.Fatalf("expected a partial remove, got %v", err)
+	}
+	if len(partial.Failed) != 1 || partial.Failed[0].Path != "/home/alice/flights/audit" || partial.Removed != 2 {
+		t.Fatalf("unexpected partial remove: %+v", partial)
+	}
+	mustLookup(t, store, admin, "/home/alice/flights/audit/report.txt")
+	for _, id := range []primitive.ObjectID{log.ID, day1.ID} {
+		if _, err := store.Nodes.Get(ctx, id); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s to be deleted, got %v", id.Hex(), err)
+		}
+	}
+
+	// Blobs are freed unless another node (here, the copy) still uses them
+	if _, err := store.Blobs.Size(ctx, log.Versions[0].FileReference); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the version blob to be freed, got %v", err)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/kept.csv", tags); err != nil || string(data) != "alt,speed,heading" {
+		t.Fatalf("expected the copy to keep its contents, got %q (%v)", data, err)
+	}
+}
+
+// failingDeletes is a recordingNodes whose DeleteMany fails on the call numbered failOn
+type failingDeletes struct {
+	recordingNodes
+	deletes *int
+	failOn  int
+}
+
+func (f failingDeletes) DeleteMany(ctx context.Context, ids []primitive.ObjectID) error {
+	*f.deletes++
+	if *f.deletes == f.failOn {
+		return errors.New("delete failed")
+	}
+	return f.recordingNodes.DeleteMany(ctx, ids)
+}
+
+func TestStoreRemoveBatches(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	transactions, deletes := 0, 0
+	nodes := failingDeletes{
+		recordingNodes: recordingNodes{MemoryNodes: store.Nodes.(MemoryNodes), transactions: &transactions},
+		deletes:        &deletes,
+		failOn:         -1,
+	}
+	store.Nodes = nodes
+
+	fill := func(dir string) {
+		t.Helper()
+		if _, err := store.Mkdir(ctx, dir, tags, nil, false); err != nil {
+			t.Fatal(err)
+		}
+		for i := range removeBatchSize + 1 {
+			writeTestFile(t, store, dir+"/"+strconv.Itoa(i)+".txt", tags, "data")
+		}
+	}
+
+	// The detach and each batch of deletes commit on their own
+	fill("/home/alice/big")
+	transactions = 0
+	if _, err := store.RemoveFile(ctx, "/home/alice/big", tags, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if transactions != 3 {
+		t.Fatalf("expected a transaction for the detach and one per batch, got %d", transactions)
+	}
+
+	// A remove that fails partway keeps the batches already deleted, and leaves the rest unreachable for fsck
+	fill("/home/alice/big")
+	deletes, nodes.failOn = 0, 2
+	store.Nodes = nodes
+	if _, err := store.RemoveFile(ctx, "/home/alice/big", tags, false, true); err == nil {
+		t.Fatal("expected the second batch to fail")
+	}
+	if _, err := store.Lookup(ctx, tags, "/home/alice/big"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the directory to be detached, got %v", err)
+	}
+	report, err := store.Fsck(ctx, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := 0
+	for _, issue := range report.Issues {
+		if issue.Kind == FsckUnreachable {
+			unreachable++
+		}
+	}
+	if unreachable != 2 {
+		t.Fatalf("expected the last batch's 2 files to be unreachable, got %+v", report.Issues)
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := []string{"user", "user-alice"}
+
+	watcher := NewWatcher(store.Nodes)
+	if err := watcher.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := watcher.Subscribe(ctx, []string{"/home/alice"}, true, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	// Other users can reach /home but not into alice's directory
+	other, err := watcher.Subscribe(ctx, []string{"/home"}, true, []string{"user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Unsubscribe()
+
+	expect := func(events ...string) {
+		t.Helper()
+		if err := watcher.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]bool{}
+		for range events {
+			next_ctx, cancel := context.WithTimeout(ctx, time.Second)
+			event, err := sub.Next(next_ctx)
+			cancel()
+			if err != nil {
+				t.Fatalf("expected events %v, got %v (%v)", events, got, err)
+			}
+			got[string(event.Kind)+" "+event.OldPath+" "+event.Path] = true
+		}
+		for _, event := range events {
+			if !got[event] {
+				t.Fatalf("expected events %v, got %v", events, got)
+			}
+		}
+	}
+
+	writeTestFile(t, store, "/home/alice/a.txt", alice, "one")
+	expect("create  /home/alice/a.txt")
+	writeTestFile(t, store, "/home/alice/a.txt", alice, "two")
+	expect("modify  /home/alice/a.txt")
+
+	if _, err := store.Mkdir(ctx, "/home/alice/dir", alice, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Move(ctx, "/home/alice/dir/b.txt", "/home/alice/a.txt", alice); err != nil {
+		t.Fatal(err)
+	}
+	expect("create  /home/alice/dir", "move /home/alice/a.txt /home/alice/dir/b.txt")
+
+	if _, err := store.Chmod(ctx, "/home/alice/dir", alice, "atc", "+", types.ReadMode, false); err != nil {
+		t.Fatal(err)
+	}
+	expect("chmod  /home/alice/dir")
+
+	if _, err := store.RemoveFile(ctx, "/home/alice/dir", alice, false, true); err != nil {
+		t.Fatal(err)
+	}
+	expect("delete  /home/alice/dir", "delete  /home/alice/dir/b.txt")
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if event, err := other.Next(short); err == nil {
+		t.Fatalf("expected events inside an inaccessible directory to be filtered, got %+v", event)
+	}
+}
+
+func TestStoreWatchAncestors(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := []string{"user", "user-alice"}
+	bob := []string{"user", "user-bob"}
+
+	// Bob can reach alice's shared directory, until she closes her home directory to him
+	if _, err := store.Chmod(ctx, "/home/alice", alice, "user-bob", "+", types.ExecuteMode, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Mkdir(ctx, "/home/alice/shared", alice, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := NewWatcher(store.Nodes)
+	run_ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	watcher.Start(run_ctx, 10*time.Millisecond)
+	sub, err := watcher.Subscribe(ctx, []string{"/home/alice/shared"}, true, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The running watcher picks changes up without being synced by hand
+	writeTestFile(t, store, "/home/alice/shared/a.txt", alice, "one")
+	next_ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if event, err := sub.Next(next_ctx); err != nil || event.Path != "/home/alice/shared/a.txt" {
+		t.Fatalf("expected a create event, got %+v (%v)", event, err)
+	}
+
+	if _, err := store.Chmod(ctx, "/home/alice", alice, "user-bob", "-", types.ExecuteMode, false); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/shared/secret.txt", alice, "two")
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if event, err := sub.Next(short); err == nil {
+		t.Fatalf("expected events below an inaccessible ancestor to be filtered, got %+v", event)
+	}
+
+	// The last subscriber stops the watcher and lets go of what it watched
+	sub.Unsubscribe()
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	if watcher.stop != nil || len(watcher.nodes) != 0 {
+		t.Fatalf("expected an idle watcher, got %d nodes", len(watcher.nodes))
+	}
+}
+
+func TestStoreWatchMoveVisibility(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	alice := []string{"user", "user-alice"}
+	bob := []string{"user", "user-bob"}
+
+	// Bob can reach alice's shared directory, but not her private one
+	if _, err := store.Chmod(ctx, "/home/alice", alice, "user-bob", "+", types.ExecuteMode, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"/home/alice/shared", "/home/alice/private"} {
+		if _, err := store.Mkdir(ctx, dir, alice, nil, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Chmod(ctx, "/home/alice/shared", alice, "user-bob", "+", types.ExecuteMode, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Chmod(ctx, "/home/alice/private", alice, "user-bob", "-", types.ExecuteMode, false); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/private/secret.txt", alice, "one")
+
+	watcher := NewWatcher(store.Nodes)
+	if err := watcher.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := watcher.Subscribe(ctx, []string{"/home/alice"}, true, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	owner, err := watcher.Subscribe(ctx, []string{"/home/alice"}, true, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Unsubscribe()
+
+	expect := func(sub *WatchSubscription, want string) {
+		t.Helper()
+		next_ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		event, err := sub.Next(next_ctx)
+		if err != nil {
+			t.Fatalf("expected %q, got %v", want, err)
+		}
+		if got := string(event.Kind) + " " + event.OldPath + " " + event.Path; got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+
+	// Moving into bob's sight is a create, without the hidden old path
+	if _, err := store.Move(ctx, "/home/alice/shared/public.txt", "/home/alice/private/secret.txt", alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect(sub, "create  /home/alice/shared/public.txt")
+	expect(owner, "move /home/alice/private/secret.txt /home/alice/shared/public.txt")
+
+	// Moving out of it is a delete, so the entry doesn't linger in his view
+	if _, err := store.Move(ctx, "/home/alice/private/secret.txt", "/home/alice/shared/public.txt", alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect(sub, "delete  /home/alice/shared/public.txt")
+	expect(owner, "move /home/alice/shared/public.txt /home/alice/private/secret.txt")
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if event, err := sub.Next(short); err == nil {
+		t.Fatalf("expected nothing else, got %+v", event)
+	}
+}
+
+func TestStoreLocks(t *testing.T) {
+	store := newTestStore(t)
+	store.Locks = NewLockTable()
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+	first := types.LockOwner{SocketID: "socket-1", ClientID: "client-1"}
+	second := types.LockOwner{SocketID: "socket-2", ClientID: "client-1"}
+	writeTestFile(t, store, "/home/alice/a.txt", tags, "one")
+
+	if _, err := store.Lock(ctx, "/home/alice/a.txt", tags, first, types.SharedLock, time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lock(ctx, "/home/alice/a.txt", tags, second, types.SharedLock, time.Minute, false); err != nil {
+		t.Fatalf("shared locks should coexist: %v", err)
+	}
+	if _, err := store.Lock(ctx, "/home/alice/a.txt", tags, first, types.ExclusiveLock, time.Minute, false); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked upgrading a lock someone else shares, got %v", err)
+	}
+
+	// Honouring readers are only kept out by exclusive locks, and writers by any lock
+	fsctx := FSContext{Store: store, UserTags: tags, HonourLocks: true}
+	first_ctx := context.WithValue(ctx, "lock_owner", first)
+	if file, err := fsctx.Open(first_ctx, "/home/alice/a.txt", os.O_RDONLY, 0); err != nil {
+		t.Fatalf("shared locks shouldn't stop reads: %v", err)
+	} else {
+		file.Close()
+	}
+	if _, err := fsctx.Open(first_ctx, "/home/alice/a.txt", os.O_WRONLY, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked writing a file someone else shares, got %v", err)
+	}
+
+	// Waiting lockers get the lock once the conflicting socket goes away
+	acquired := make(chan error)
+	go func() {
+		_, err := store.Lock(ctx, "/home/alice/a.txt", tags, first, types.ExclusiveLock, time.Minute, true)
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("lock should wait while shared, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if released, _ := store.Locks.ReleaseSocket(ctx, second.SocketID); released != 1 {
+		t.Fatalf("expected 1 lock released with the socket, got %d", released)
+	}
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsctx.Open(context.WithValue(ctx, "lock_owner", second), "/home/alice/a.txt", os.O_RDONLY, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked reading an exclusively locked file, got %v", err)
+	}
+	if file, err := fsctx.Open(first_ctx, "/home/alice/a.txt", os.O_WRONLY, 0); err != nil {
+		t.Fatalf("owners should be able to write their locked files: %v", err)
+	} else {
+		file.Close()
+	}
+	if file, err := (&FSContext{Store: store, UserTags: tags}).Open(ctx, "/home/alice/a.txt", os.O_RDONLY, 0); err != nil {
+		t.Fatalf("locks are advisory unless honoured: %v", err)
+	} else {
+		file.Close()
+	}
+
+	if err := store.Unlock(ctx, "/home/alice/a.txt", tags, second); !errors.Is(err, ErrNotLocked) {
+		t.Fatalf("expected ErrNotLocked, got %v", err)
+	}
+	if err := store.Unlock(ctx, "/home/alice/a.txt", tags, first); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired locks stop excluding others
+	if _, err := store.Lock(ctx, "/home/alice/a.txt", tags, first, types.ExclusiveLock, 10*time.Millisecond, false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := store.Lock(ctx, "/home/alice/a.txt", tags, second, types.ExclusiveLock, time.Minute, false); err != nil {
+		t.Fatalf("expired locks should be ignored: %v", err)
+	}
+	if locks, _ := store.Locks.List(ctx); len(locks) != 1 || locks[0].Owner != second {
+		t.Fatalf("expected only the second owner's lock, got %+v", locks)
+	}
+
+	if _, err := store.Lock(ctx, "/home/alice/a.txt", []string{"user"}, first, types.SharedLock, time.Minute, false); !errors.Is(err, types.ErrCantAccessFs) {
+		t.Fatalf("expected ErrCantAccessFs locking without access, got %v", err)
+	}
+}
+
+func TestStoreChecksums(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+	blobs := store.Blobs.(MemoryBlobs)
+
+	writeTestFile(t, store, "/home/alice/a.png", tags, "face")
+	writeTestFile(t, store, "/home/alice/b.png", tags, "face")
+	a, err := store.Lookup(ctx, tags, "/home/alice/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.Lookup(ctx, tags, "/home/alice/b.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *a.FileReference != *b.FileReference || len(blobs.blobs) != 1 {
+		t.Fatalf("expected identical contents to share one blob, got %v and %v (%d blobs)", a.FileReference, b.FileReference, len(blobs.blobs))
+	}
+	if checksums, err := store.Blobs.Checksums(ctx, []primitive.ObjectID{*a.FileReference}); err != nil {
+		t.Fatal(err)
+	} else if checksums[*a.FileReference] != Checksum([]byte("face")) {
+		t.Fatalf("expected the blob's sha256 to be recorded, got %q", checksums[*a.FileReference])
+	}
+	if referenced, err := store.Nodes.ReferencedBlobs(ctx, []primitive.ObjectID{*a.FileReference}); err != nil {
+		t.Fatal(err)
+	} else if referenced[*a.FileReference] != 2 {
+		t.Fatalf("expected 2 references to the shared blob, got %d", referenced[*a.FileReference])
+	}
+
+	// The shared blob lives until its last reference is gone
+	if _, err := store.RemoveFile(ctx, "/home/alice/a.png", tags, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/b.png", tags); err != nil || string(data) != "face" {
+		t.Fatalf("expected the copy to survive, got %q (%v)", data, err)
+	}
+
+	// Blobs from before checksums get theirs recorded, and corrupted ones are reported
+	legacy := primitive.NewObjectID()
+	blobs.blobs[legacy] = []byte("old")
+	blobs.blobs[*b.FileReference] = []byte("fade")
+	report, err := store.VerifyBlobs(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ScannedBlobs != 2 || report.RecordedBlobs != 1 || len(report.Corrupt) != 1 || report.Corrupt[0].ID != b.FileReference.Hex() {
+		t.Fatalf("unexpected verify report: %+v", report)
+	}
+	if checksums, _ := store.Blobs.Checksums(ctx, []primitive.ObjectID{legacy}); checksums[legacy] != Checksum([]byte("old")) {
+		t.Fatalf("expected the legacy blob's checksum to be recorded, got %q", checksums[legacy])
+	}
+}
+
+func TestStoreSharedBlobRemoved(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+
+	writeTestFile(t, store, "/home/alice/a.png", tags, "face")
+	a := mustLookup(t, store, tags, "/home/alice/a.png")
+	upload := primitive.NewObjectID()
+	stream, err := store.Blobs.OpenUpload(ctx, upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write([]byte("face")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write picks a's blob to share, then a is removed before the write lands
+	shared, err := store.sharedBlob(ctx, upload)
+	if err != nil || shared != *a.FileReference {
+		t.Fatalf("expected a's blob to be shared, got %v (%v)", shared, err)
+	}
+	if _, err := store.RemoveFile(ctx, "/home/alice/a.png", tags, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Blobs.Size(ctx, shared); err != nil {
+		t.Fatalf("expected the pinned blob to outlive its last reference, got %v", err)
+	}
+	if _, err := store.WriteFile(ctx, mustLookup(t, store, tags, "/home/alice").ID, "b.png", upload, tags); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.LookupReadAll(ctx, "/home/alice/b.png", tags); err != nil || string(data) != "face" {
+		t.Fatalf("expected the shared contents, got %q (%v)", data, err)
+	}
+
+	// GC leaves pinned blobs alone for the grace period
+	store.RemoveFile(ctx, "/home/alice/b.png", tags, false, false)
+	if report, err := store.CollectGarbage(ctx, false, DefaultGCGracePeriod); err != nil || report.RemovedBlobs != 0 {
+		t.Fatalf("expected the pinned blob to be kept, got %+v (%v)", report, err)
+	}
+	if report, err := store.CollectGarbage(ctx, false, 0); err != nil || report.RemovedBlobs != 1 {
+		t.Fatalf("expected the blob to be collected once its pin expired, got %+v (%v)", report, err)
+	}
+}
+
+func TestStoreArchive(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	tags := []string{"user", "user-alice"}
+	sysadmin := []string{"sysadmin"}
+
+	if _, err := store.Mkdir(ctx, "/home/alice/flights/2024", tags, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, store, "/home/alice/flights/2024/f1.csv", tags, "t,hr\n0,60\n")
+	writeTestFile(t, store, "/home/alice/flights/shared.txt", tags, "for bob")
+	writeTestFile(t, store, "/home/alice/flights/handover.txt", tags, "for bob to manage")
+	if _, err := store.Symlink(ctx, "/home/alice/flights/latest", "2024/f1.csv", tags); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Chmod(ctx, "/home/alice/flights/shared.txt", tags, "user-bob", "+", types.ReadMode, false); err != nil {
+		t.Fatal(err)
+	}
+	// Only a sysadmin can hand bob the permissions to manage a file
+	if _, err := store.Chmod(ctx, "/home/alice/flights/handover.txt", sysadmin, "user-bob", "+", types.UpdatePermissionsMode, false); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	f1 := mustLookup(t, store, tags, "/home/alice/flights/2024/f1.csv")
+	if _, err := store.Nodes.Update(ctx, f1.ID, NodeUpdate{ModifiedAt: &modified}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []ArchiveFormat{TarArchive, ZipArchive} {
+		dest := "/home/alice/restore-" + string(format)
+		if _, err := store.Mkdir(ctx, dest, tags, nil, false); err != nil {
+			t.Fatal(err)
+		}
+
+		var archive bytes.Buffer
+		report, err := store.Export(ctx, &archive, format, tags, "/home/alice/flights")
+		if err != nil {
+			t.Fatalf("%s export failed: %v", format, err)
+		}
+		if report.Files != 3 || report.Directories != 2 || report.Symlinks != 1 || len(report.Skipped) != 0 {
+			t.Fatalf("unexpected %s export report: %+v", format, report)
+		}
+
+		report, err = store.Import(ctx, bytes.NewReader(archive.Bytes()), tags, dest, ImportOptions{Permissions: true})
+		if err != nil {
+			t.Fatalf("%s import failed: %v", format, err)
+		}
+		if report.Files != 3 || report.Directories != 2 || report.Symlinks != 1 {
+			t.Fatalf("unexpected %s import report: %+v", format, report)
+		}
+		if len(report.Skipped) != 1 || report.Skipped[0].Path != "flights/handover.txt" {
+			t.Fatalf("expected only handover.txt's permissions to be refused, got %+v", report.Skipped)
+		}
+
+		if data, err := store.LookupReadAll(ctx, dest+"/flights/latest", tags); err != nil || string(data) != "t,hr\n0,60\n" {
+			t.Fatalf("expected the symlink to resolve to f1.csv, got %q (%v)", data, err)
+		}
+		if restored := mustLookup(t, store, tags, dest+"/flights/2024/f1.csv"); !restored.Timestamps.ModifiedAt.Equal(modified) {
+			t.Fatalf("expected the modification time to be restored, got %v", restored.Timestamps.ModifiedAt)
+		}
+		if data, err := store.LookupReadAll(ctx, dest+"/flights/shared.txt", []string{"user", "user-bob"}); err == nil {
+			t.Fatalf("bob shouldn't get past alice's home, read %q", data)
+		}
+		if restored := mustLookup(t, store, tags, dest+"/flights/shared.txt"); !restored.Permissions.IsAllowed(types.ReadMode, []string{"user-bob"}) {
+			t.Fatalf("expected shared.txt's permissions to be restored, got %+v", restored.Permissions)
+		}
+		if restored := mustLookup(t, store, tags, dest+"/flights/handover.txt"); restored.Permissions.IsAllowed(types.UpdatePermissionsMode, []string{"user-bob"}) {
+			t.Fatalf("alice shouldn't be able to hand bob permissions through an import, got %+v", restored.Permissions)
+		}
+
+		// Existing files are left alone unless overwriting
+		report, err = store.Import(ctx, bytes.NewReader(archive.Bytes()), tags, dest, ImportOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Files != 0 || len(report.Skipped) != 4 {
+			t.Fatalf("expected existing files and symlinks to be skipped, got %+v", report)
+		}
+	}
+
+	// Archives can't reach outside the destination
+	var evil bytes.Buffer
+	writer := tar.NewWriter(&evil)
+	writer.WriteHeader(&tar.Header{Name: "../../escape.txt", Typeflag: tar.TypeReg, Size: 4, Mode: 0o644})
+	writer.Write([]byte("evil"))
+	writer.Close()
+	report, err := store.Import(ctx, &evil, tags, "/home/alice/restore-tar", ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 0 || len(report.Skipped) != 1 {
+		t.Fatalf("expected the escaping entry to be skipped, got %+v", report)
+	}
+	if _, err := store.Lookup(ctx, sysadmin, "/home/escape.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected nothing to be written outside the destination, got %v", err)
+	}
+
+	if _, err := store.Import(ctx, bytes.NewReader([]byte("not an archive")), tags, "/home/alice", ImportOptions{}); !errors.Is(err, ErrUnknownArchive) {
+		t.Fatalf("expected ErrUnknownArchive, got %v", err)
+	}
+	if _, err := store.Import(ctx, bytes.NewReader(nil), tags, "/home", ImportOptions{}); !errors.Is(err, types.ErrCantAccessFs) {
+		t.Fatalf("expected importing into /home to be denied, got %v", err)
+	}
+}
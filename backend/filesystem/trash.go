@@ -0,0 +1,405 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TrashDirName is the directory inside a user's home that their deleted entries are moved into
+const TrashDirName = ".trash"
+
+// DefaultTrashRetention is how long trashed entries are kept before the sweeper deletes them
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// DefaultTrashSweepInterval is how often expired trash is looked for
+const DefaultTrashSweepInterval = time.Hour
+
+// ErrNoTrash is returned when the deleting user has no home directory to keep a trash in
+var ErrNoTrash = errors.New("error: no trash available (user has no home directory)")
+
+// ErrTrashInside is returned when a directory holding the deleting user's trash (like their home) is trashed
+var ErrTrashInside = errors.New("error: can't move a directory into the trash it contains")
+
+// errInTrash is returned by trash when the entry is already in (or is) the trash, so it has to be removed for good
+var errInTrash = errors.New("entry is in the trash")
+
+// TrashItem describes an entry in a user's trash
+type TrashItem struct {
+	ID              string `yaml:"id"` // Name of the entry inside the trash directory
+	Type            string `yaml:"type"`
+	types.TrashInfo `yaml:",inline"`
+}
+
+// trashDir returns /home/<username>/.trash, creating it (private to the user) if create is set
+func (s Store) trashDir(ctx context.Context, username string, create bool) (*types.FsEntry, error) {
+	if username == "" {
+		return nil, ErrNoTrash
+	}
+	home_path, err := AbsPath("/home", username)
+	if err != nil {
+		return nil, err
+	}
+
+	home, err := s.Lookup(ctx, []string{"sysadmin"}, home_path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoTrash
+		}
+		return nil, err
+	}
+	if home.EntryType != types.Directory {
+		return nil, ErrNoTrash
+	}
+
+	if ref, ok := home.Entries.Get(TrashDirName); ok {
+		if trash, err := s.Nodes.Get(ctx, ref.RefID); err != nil {
+			return nil, err
+		} else if trash.EntryType != types.Directory {
+			return nil, fmt.Errorf("%w: %s/%s is not a directory", os.ErrInvalid, home_path, TrashDirName)
+		} else {
+			return trash, nil
+		}
+	}
+	if !create {
+		return nil, fmt.Errorf("%w: trash is empty", os.ErrNotExist)
+	}
+
+	owner := []string{"sysadmin", "user-" + username}
+	return s.writeDirectory(ctx, home.ID, TrashDirName, []string{"sysadmin"}, &types.FsEntryPermissions{
+		ReadTags:             owner,
+		WriteTags:            owner,
+		ExecuteTags:          owner,
+		UpdatePermissionTags: owner,
+	})
+}
+
+// Trash moves abs_path into the calling user's trash instead of deleting it.
+// It takes the same permissions as RemoveFile, but a directory is only trashed if RemoveFile could remove all of it
+// (purging it later removes everything). Entries already in that trash are removed for good.
+func (s Store) Trash(ctx context.Context, abs_path string, tags []string, force, rmDirectories bool) (*types.FsEntry, error) {
+	defer s.quotaUsageChanged()
+	entry, err := removeAtomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.trash(ctx, abs_path, tags, force, rmDirectories)
+	})
+	if errors.Is(err, errInTrash) {
+		// Removes from the trash are permanent, and commit batch by batch like RemoveFile's
+		return s.removeFile(ctx, abs_path, tags, force, rmDirectories)
+	}
+	return entry, err
+}
+
+func (s Store) trash(ctx context.Context, abs_path string, tags []string, force, rmDirectories bool) (*types.FsEntry, error) {
+	now := time.Now()
+	folder_path, filename, err := DirUp(abs_path)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := s.Lookup(ctx, tags, folder_path)
+	if err != nil {
+		return nil, err
+	}
+	if parent.EntryType != types.Directory {
+		return nil, fmt.Errorf("%w: parent not a directory", os.ErrInvalid)
+	}
+	if !parent.Permissions.IsAllowed(types.WriteMode, tags) {
+		if !force || !parent.Permissions.IsAllowed(types.UpdatePermissionsMode, tags) {
+			return nil, types.ErrCantAccessFs
+		}
+	}
+
+	ref, exists := parent.Entries.Get(filename)
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	entry, err := s.Nodes.Get(ctx, ref.RefID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.EntryType == types.Directory && !rmDirectories {
+		return nil, fmt.Errorf("%w: file is a directory", os.ErrInvalid)
+	}
+
+	username := writerName(ctx)
+	trash, err := s.trashDir(ctx, username, true)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ID == trash.ID || entry.ID == trash.ID {
+		return nil, errInTrash
+	}
+
+	clean_path, err := CleanupAbsPath(abs_path)
+	if err != nil {
+		return nil, err
+	}
+	if entry.EntryType == types.Directory {
+		// Read again: the trash may have just been created inside it
+		if entry, err = s.Nodes.Get(ctx, ref.RefID); err != nil {
+			return nil, err
+		}
+		plan, err := s.planRemove(ctx, *entry, parent.ID, clean_path, tags, force)
+		if err != nil {
+			return nil, err
+		}
+		if len(plan.failed) != 0 {
+			return nil, ErrPartialRemove{Failed: plan.failed}
+		}
+		// Moving an ancestor of the trash into it would leave an unreachable cycle
+		for _, node := range plan.nodes {
+			if node.id == trash.ID {
+				return nil, ErrTrashInside
+			}
+		}
+	}
+
+	original_path := childPath(*parent, filename)
+	if original_path == "" {
+		original_path = clean_path
+	}
+
+	name := entry.ID.Hex()
+	if _, err := s.Nodes.Update(ctx, parent.ID, NodeUpdate{
+		ModifiedAt:    &now,
+		AccessedAt:    &now,
+		RemoveEntries: []string{filename},
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := s.Nodes.Update(ctx, trash.ID, NodeUpdate{
+		ModifiedAt: &now,
+		AccessedAt: &now,
+		AddEntries: []types.FsEntryReference{{Name: name, RefID: entry.ID}},
+	}); err != nil {
+		return nil, err
+	}
+	trashed, err := s.Nodes.Update(ctx, entry.ID, NodeUpdate{
+		Trash: &types.TrashInfo{
+			OriginalPath: original_path,
+			DeletedBy:    username,
+			DeletedAt:    now,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reindexPaths(ctx, entry.ID, childPath(*trash, name)); err != nil {
+		return nil, err
+	}
+
+	return trashed, nil
+}
+
+// ListTrash returns the entries in a user's trash, most recently deleted first
+func (s Store) ListTrash(ctx context.Context, username string) ([]TrashItem, error) {
+	trash, err := s.trashDir(ctx, username, false)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []TrashItem{}, nil
+		}
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(trash.Entries))
+	for i, ref := range trash.Entries {
+		ids[i] = ref.RefID
+	}
+	entries, err := s.Nodes.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	by_id := make(map[primitive.ObjectID]types.FsEntry, len(entries))
+	for _, entry := range entries {
+		by_id[entry.ID] = entry
+	}
+
+	items := make([]TrashItem, 0, len(trash.Entries))
+	for _, ref := range trash.Entries {
+		entry, ok := by_id[ref.RefID]
+		if !ok {
+			continue
+		}
+		item := TrashItem{ID: ref.Name, Type: entry.EntryType.String()}
+		if entry.Trash != nil {
+			item.TrashInfo = *entry.Trash
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+
+	return items, nil
+}
+
+// RestoreTrash moves an entry out of a user's trash back to its original path.
+// It needs write permission on the original parent directory, and fails if the path was reused.
+func (s Store) RestoreTrash(ctx context.Context, username, id string, tags []string) (*types.FsEntry, error) {
+	defer s.quotaUsageChanged()
+	return atomically(ctx, s, func(ctx context.Context) (*types.FsEntry, error) {
+		return s.restoreTrash(ctx, username, id, tags)
+	})
+}
+
+func (s Store) restoreTrash(ctx context.Context, username, id string, tags []string) (*types.FsEntry, error) {
+	now := time.Now()
+	trash, err := s.trashDir(ctx, username, false)
+	if err != nil {
+		return nil, err
+	}
+	ref, ok := trash.Entries.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q isn't in the trash", os.ErrNotExist, id)
+	}
+	entry, err := s.Nodes.Get(ctx, ref.RefID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Trash == nil {
+		return nil, fmt.Errorf("%w: %q has no original path", os.ErrInvalid, id)
+	}
+
+	folder_path, filename, err := DirUp(entry.Trash.OriginalPath)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := s.Lookup(ctx, tags, folder_path)
+	if err != nil {
+		return nil, err
+	}
+	if parent.EntryType != types.Directory {
+		return nil, fmt.Errorf("%w: %q is not a directory", os.ErrInvalid, folder_path)
+	}
+	if !parent.Permissions.IsAllowed(types.WriteMode, tags) {
+		return nil, types.ErrCantAccessFs
+	}
+	if _, exists := parent.Entries.Get(filename); exists {
+		return nil, fmt.Errorf("%w: %q", os.ErrExist, entry.Trash.OriginalPath)
+	}
+
+	if _, err := s.Nodes.Update(ctx, trash.ID, NodeUpdate{
+		ModifiedAt:    &now,
+		AccessedAt:    &now,
+		RemoveEntries: []string{id},
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := s.Nodes.Update(ctx, parent.ID, NodeUpdate{
+		ModifiedAt: &now,
+		AccessedAt: &now,
+		AddEntries: []types.FsEntryReference{{Name: filename, RefID: entry.ID}},
+	}); err != nil {
+		return nil, err
+	}
+	restored, err := s.Nodes.Update(ctx, entry.ID, NodeUpdate{ClearTrash: true})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reindexPaths(ctx, entry.ID, childPath(*parent, filename)); err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// PurgeTrash permanently deletes entries from a user's trash (all of them if ids is empty).
+// It returns how many entries were deleted.
+func (s Store) PurgeTrash(ctx context.Context, username string, ids []string) (int, error) {
+	defer s.quotaUsageChanged()
+	return s.purgeTrash(ctx, username, ids, time.Time{})
+}
+
+// purgeTrash deletes the given entries (or all of them), skipping entries deleted after cutoff (if set).
+// Each entry is removed like RemoveFile removes it, so a big trash isn't purged in one transaction.
+func (s Store) purgeTrash(ctx context.Context, username string, ids []string, cutoff time.Time) (int, error) {
+	trash, err := s.trashDir(ctx, username, false)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && len(ids) == 0 {
+			return 0, nil
+		}
+		return 0, err
+	}
+	trash_path, err := AbsPath("/home", username+"/"+TrashDirName)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		for _, ref := range trash.Entries {
+			ids = append(ids, ref.Name)
+		}
+	}
+
+	purged := 0
+	for _, id := range ids {
+		ref, ok := trash.Entries.Get(id)
+		if !ok {
+			return purged, fmt.Errorf("%w: %q isn't in the trash", os.ErrNotExist, id)
+		}
+		if !cutoff.IsZero() {
+			if entry, err := s.Nodes.Get(ctx, ref.RefID); err != nil {
+				return purged, err
+			} else if entry.Trash != nil && entry.Trash.DeletedAt.After(cutoff) {
+				continue
+			}
+		}
+
+		if _, err := s.removeFile(ctx, trash_path+"/"+id, []string{"sysadmin"}, true, true); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// SweepTrash permanently deletes trashed entries older than retention, from every user's trash
+func (s Store) SweepTrash(ctx context.Context, retention time.Duration) (int, error) {
+	defer s.quotaUsageChanged()
+	cutoff := time.Now().Add(-retention)
+	home, err := s.Lookup(ctx, []string{"sysadmin"}, "/home")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	purged := 0
+	for _, ref := range home.Entries {
+		count, err := s.purgeTrash(ctx, ref.Name, nil, cutoff)
+		purged += count
+		if err != nil && !errors.Is(err, ErrNoTrash) {
+			return purged, err
+		}
+	}
+
+	return purged, nil
+}
+
+// ScheduleTrashSweep runs SweepTrash every interval until ctx is done
+func ScheduleTrashSweep(ctx context.Context, store Store, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if purged, err := store.SweepTrash(ctx, retention); err != nil {
+				log.Printf("[trash] sweep failed: %v", err)
+			} else if purged > 0 {
+				log.Printf("[trash] permanently deleted %d entries older than %s", purged, retention)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package filesystem
+
+import (
+	"context"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DiskUsage describes the storage use of the whole filesystem
+type DiskUsage struct {
+	Entries     int64     `yaml:"entries"`
+	Files       int64     `yaml:"files"`
+	Directories int64     `yaml:"directories"`
+	Symlinks    int64     `yaml:"symlinks"`
+	Storage     BlobStats `yaml:"storage"`
+	// Orphaned counts are what a garbage collection run would find right now
+	OrphanedBlobs   int   `yaml:"orphaned_blobs"`
+	OrphanedBytes   int64 `yaml:"orphaned_bytes"`
+	OrphanedEntries int   `yaml:"orphaned_entries"`
+}
+
+// DiskUsage counts every node and blob. It scans the whole filesystem, so it's meant for operators.
+func (s Store) DiskUsage(ctx context.Context) (*DiskUsage, error) {
+	usage := DiskUsage{}
+	if err := s.Nodes.ForEach(ctx, func(entry types.FsEntry) error {
+		usage.Entries++
+		switch entry.EntryType {
+		case types.File:
+			usage.Files++
+		case types.Directory:
+			usage.Directories++
+		case types.Symlink:
+			usage.Symlinks++
+		}
+		return ctx.Err()
+	}); err != nil {
+		return nil, err
+	}
+
+	if statter, ok := s.Blobs.(BlobStatter); ok {
+		stats, err := statter.Stats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		usage.Storage = *stats
+	} else if err := s.Blobs.ForEach(ctx, func(id primitive.ObjectID, size int64) error {
+		usage.Storage.Blobs++
+		usage.Storage.Bytes += size
+		return ctx.Err()
+	}); err != nil {
+		return nil, err
+	}
+
+	report, err := s.CollectGarbage(ctx, true, DefaultGCGracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	usage.OrphanedBlobs = report.RemovedBlobs
+	usage.OrphanedBytes = report.ReclaimedBytes
+	usage.OrphanedEntries = len(report.OrphanedEntries)
+
+	return &usage, nil
+}
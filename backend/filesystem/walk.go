@@ -0,0 +1,75 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SkipDir can be returned by a WalkFunc called on a directory to skip its contents
+var SkipDir = errors.New("skip this directory")
+
+// WalkFunc is called by Walk for every entry it visits, with the entry's absolute path
+type WalkFunc func(abs_path string, entry types.FsEntry) error
+
+// Walk calls fn for abs_path and everything below it, depth first, in directory order.
+// It only descends into directories tags can execute, and doesn't follow symlinks below abs_path.
+// Each directory's children are fetched in one round-trip, and ctx is checked before every fetch.
+func (s Store) Walk(ctx context.Context, tags []string, abs_path string, fn WalkFunc) error {
+	clean_path, err := CleanupAbsPath(abs_path)
+	if err != nil {
+		return err
+	}
+	top, err := s.Lookup(ctx, tags, clean_path)
+	if err != nil {
+		return err
+	}
+
+	if err := s.walk(ctx, tags, clean_path, *top, fn); err != nil && !errors.Is(err, SkipDir) {
+		return err
+	}
+	return nil
+}
+
+func (s Store) walk(ctx context.Context, tags []string, abs_path string, entry types.FsEntry, fn WalkFunc) error {
+	if err := fn(abs_path, entry); err != nil {
+		return err
+	}
+	if entry.EntryType != types.Directory || len(entry.Entries) == 0 || !entry.Permissions.IsAllowed(types.ExecuteMode, tags) {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ids := make([]primitive.ObjectID, len(entry.Entries))
+	for i, ref := range entry.Entries {
+		ids[i] = ref.RefID
+	}
+	children, err := s.Nodes.GetMany(ctx, ids)
+	if err != nil {
+		return err
+	}
+	by_id := make(map[primitive.ObjectID]types.FsEntry, len(children))
+	for _, child := range children {
+		by_id[child.ID] = child
+	}
+
+	prefix := abs_path
+	if prefix != "/" {
+		prefix += "/"
+	}
+	for _, ref := range entry.Entries {
+		child, ok := by_id[ref.RefID]
+		if !ok {
+			// Dangling references are fsck's business
+			continue
+		}
+		if err := s.walk(ctx, tags, prefix+ref.Name, child, fn); err != nil && !errors.Is(err, SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,642 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WatchEventKind is the kind of change a WatchEvent reports
+type WatchEventKind string
+
+const (
+	WatchCreate WatchEventKind = "create"
+	WatchModify WatchEventKind = "modify"
+	WatchDelete WatchEventKind = "delete"
+	WatchMove   WatchEventKind = "move"
+	WatchChmod  WatchEventKind = "chmod"
+)
+
+// DefaultWatchPollInterval is how often a running Watcher rescans the watched nodes when change streams aren't available
+const DefaultWatchPollInterval = 2 * time.Second
+
+// maxWatchDepth bounds path reconstruction, in case a bad tree has a cycle
+const maxWatchDepth = 4096
+
+// WatchEvent describes a change to one entry
+type WatchEvent struct {
+	Kind WatchEventKind `yaml:"kind"`
+	Path string         `yaml:"path"`
+	// OldPath is where a moved entry used to be
+	OldPath string    `yaml:"old_path,omitempty"`
+	ID      string    `yaml:"id"`
+	Type    string    `yaml:"type"`
+	Time    time.Time `yaml:"time"`
+
+	// ancestorPerms are the permissions of the directories leading to the entry. Only callers that can
+	// execute all of them (and so could look the entry up) see the event. Empty for the root.
+	ancestorPerms []types.FsEntryPermissions
+	// oldAncestorPerms are the same for OldPath, since a move can cross into or out of what a caller can see
+	oldAncestorPerms []types.FsEntryPermissions
+}
+
+// ErrChangesUnsupported is returned by a NodeChangeStreamer whose server can't stream changes
+var ErrChangesUnsupported = errors.New("change streams aren't supported")
+
+// NodeChangeStreamer is implemented by node stores that can push changes as they happen
+type NodeChangeStreamer interface {
+	// WatchChanges calls fn with the current state of every node that changes (nil once it's deleted),
+	// until ctx is done or the stream fails. opened is called once the stream is listening, so the
+	// caller can take a snapshot without missing changes.
+	WatchChanges(ctx context.Context, opened func() error, fn func(id primitive.ObjectID, entry *types.FsEntry) error) error
+}
+
+// watchedNode is what a Watcher remembers about a node
+type watchedNode struct {
+	isRoot      bool
+	entryType   types.FsEntryType
+	fileRef     *primitive.ObjectID
+	revision    int64
+	modifiedAt  time.Time
+	target      string
+	permissions types.FsEntryPermissions
+	entries     types.FsReferenceList
+}
+
+// watchedRef is the directory entry a node is reachable through
+type watchedRef struct {
+	parent primitive.ObjectID
+	name   string
+}
+
+// watchStart is a node to scan from, and its path
+type watchStart struct {
+	id   primitive.ObjectID
+	path string
+}
+
+// announcement is how subscribers last saw a node
+type announcement struct {
+	path          string
+	ancestorPerms []types.FsEntryPermissions
+}
+
+// Watcher turns node changes into WatchEvents. It keeps a snapshot of the watched subtrees (and the
+// directories leading to them) and diffs every change against it, so it works the same whether changes
+// are streamed or found by rescanning. After Start, it only runs while something is subscribed.
+type Watcher struct {
+	Nodes  NodeStore
+	Events *util.EventHandler[WatchEvent]
+
+	mu           *sync.Mutex
+	subs         map[*WatchSubscription]struct{}
+	runCtx       context.Context
+	pollInterval time.Duration
+	stop         context.CancelFunc
+	nodes        map[primitive.ObjectID]watchedNode
+	parents      map[primitive.ObjectID]watchedRef
+	announced    map[primitive.ObjectID]announcement
+}
+
+func NewWatcher(nodes NodeStore) *Watcher {
+	return &Watcher{
+		Nodes:     nodes,
+		Events:    util.NewEventHandler[WatchEvent](),
+		mu:        new(sync.Mutex),
+		subs:      map[*WatchSubscription]struct{}{},
+		nodes:     map[primitive.ObjectID]watchedNode{},
+		parents:   map[primitive.ObjectID]watchedRef{},
+		announced: map[primitive.ObjectID]announcement{},
+	}
+}
+
+// Start lets the watcher Run (until ctx is done) whenever it has subscribers. It doesn't block.
+func (w *Watcher) Start(ctx context.Context, pollInterval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.runCtx, w.pollInterval = ctx, pollInterval
+	if len(w.subs) > 0 {
+		w.startRunning()
+	}
+}
+
+// startRunning runs the watcher until the last subscriber leaves. Callers hold w.mu.
+func (w *Watcher) startRunning() {
+	if w.runCtx == nil || w.stop != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(w.runCtx)
+	w.stop = cancel
+	go w.Run(ctx, w.pollInterval)
+}
+
+// Run feeds the watcher until ctx is done. It uses change streams when the node store supports them,
+// and otherwise rescans every pollInterval.
+func (w *Watcher) Run(ctx context.Context, pollInterval time.Duration) {
+	streamer, streaming := w.Nodes.(NodeChangeStreamer)
+	for ctx.Err() == nil {
+		if streaming {
+			err := streamer.WatchChanges(ctx, func() error {
+				return w.Sync(ctx)
+			}, func(id primitive.ObjectID, entry *types.FsEntry) error {
+				return w.applyStreamed(ctx, id, entry)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, ErrChangesUnsupported) {
+				log.Printf("[Watch] change streams unavailable, polling every %v instead: %v", pollInterval, err)
+				streaming = false
+				continue
+			}
+			log.Printf("[Watch] change stream failed, reconnecting: %v", err)
+		} else if err := w.Sync(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("[Watch] rescan failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Sync rescans the watched nodes and emits what changed since they were last seen.
+// Watched nodes it no longer finds were deleted, or moved somewhere nobody watches.
+func (w *Watcher) Sync(ctx context.Context) error {
+	w.mu.Lock()
+	subs := w.subscriptions()
+	w.mu.Unlock()
+
+	root, err := w.Nodes.Root(ctx)
+	if err != nil {
+		return err
+	}
+	current, err := w.walk(ctx, []watchStart{{id: root.ID, path: "/"}}, subs)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := time.Now()
+	scanned := make(map[primitive.ObjectID]struct{}, len(current))
+	for i := range current {
+		scanned[current[i].ID] = struct{}{}
+		w.apply(current[i].ID, &current[i], now)
+	}
+	for id := range w.nodes {
+		if _, ok := scanned[id]; !ok {
+			w.apply(id, nil, now)
+		}
+	}
+	return nil
+}
+
+// applyStreamed applies a streamed change to a watched node, then loads the watched nodes it links in
+func (w *Watcher) applyStreamed(ctx context.Context, id primitive.ObjectID, entry *types.FsEntry) error {
+	w.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	if _, known := w.nodes[id]; !known {
+		// Unwatched nodes are picked up once a watched directory links them in
+		w.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	missing := w.apply(id, entry, now)
+	if _, reachable := w.pathOf(id); entry != nil && !reachable && entry.Path != "" && !watchIncludes(w.subscriptions(), entry.Path) {
+		// Moved somewhere nobody watches (moves reindex the materialized paths of everything they move)
+		w.apply(id, nil, now)
+	}
+	subs := w.subscriptions()
+	w.mu.Unlock()
+
+	if len(missing) == 0 {
+		return nil
+	}
+	found, err := w.walk(ctx, missing, subs)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now = time.Now()
+	for i := range found {
+		if _, known := w.nodes[found[i].ID]; !known {
+			w.apply(found[i].ID, &found[i], now)
+		}
+	}
+	return nil
+}
+
+// walk fetches the nodes at starts, and everything below them that subs watch, parents before their children
+func (w *Watcher) walk(ctx context.Context, starts []watchStart, subs []*WatchSubscription) ([]types.FsEntry, error) {
+	found := []types.FsEntry{}
+	seen := map[primitive.ObjectID]struct{}{}
+	level := starts
+	for len(level) > 0 {
+		paths := make(map[primitive.ObjectID]string, len(level))
+		ids := make([]primitive.ObjectID, 0, len(level))
+		for _, start := range level {
+			if _, ok := seen[start.id]; ok {
+				continue
+			}
+			seen[start.id] = struct{}{}
+			paths[start.id] = start.path
+			ids = append(ids, start.id)
+		}
+
+		entries, err := w.Nodes.GetMany(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		level = nil
+		for _, entry := range entries {
+			found = append(found, entry)
+			for _, ref := range entry.Entries {
+				child_path := strings.TrimSuffix(paths[entry.ID], "/") + "/" + ref.Name
+				if watchIncludes(subs, child_path) {
+					level = append(level, watchStart{id: ref.RefID, path: child_path})
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+func watchNode(entry types.FsEntry) watchedNode {
+	return watchedNode{
+		isRoot:      entry.IsRoot,
+		entryType:   entry.EntryType,
+		fileRef:     entry.FileReference,
+		revision:    entry.Revision,
+		modifiedAt:  entry.Timestamps.ModifiedAt,
+		target:      entry.Target,
+		permissions: entry.Permissions,
+		entries:     entry.Entries,
+	}
+}
+
+// apply records a node's new state (nil if it was deleted) and emits the resulting events.
+// It returns the watched entries the node links to that aren't known yet.
+func (w *Watcher) apply(id primitive.ObjectID, entry *types.FsEntry, now time.Time) []watchStart {
+	prev, existed := w.nodes[id]
+	if entry == nil {
+		if !existed {
+			return nil
+		}
+		delete(w.nodes, id)
+		delete(w.parents, id)
+		if seen, ok := w.announced[id]; ok {
+			delete(w.announced, id)
+			w.emit(WatchEvent{Kind: WatchDelete, Path: seen.path, ID: id.Hex(), Type: prev.entryType.String(), Time: now, ancestorPerms: seen.ancestorPerms})
+		}
+		return nil
+	}
+
+	node := watchNode(*entry)
+	w.nodes[id] = node
+
+	// Re-point children first, so paths below are right before anything is reported
+	affected := []primitive.ObjectID{id}
+	for _, ref := range prev.entries {
+		if slices.Contains(node.entries, ref) {
+			continue
+		}
+		if w.parents[ref.RefID] == (watchedRef{parent: id, name: ref.Name}) {
+			delete(w.parents, ref.RefID)
+		}
+		affected = append(affected, ref.RefID)
+	}
+	missing := []watchStart{}
+	for _, ref := range node.entries {
+		if slices.Contains(prev.entries, ref) && w.parents[ref.RefID] == (watchedRef{parent: id, name: ref.Name}) {
+			continue
+		}
+		w.parents[ref.RefID] = watchedRef{parent: id, name: ref.Name}
+		affected = append(affected, ref.RefID)
+		if _, known := w.nodes[ref.RefID]; !known {
+			if path, ok := w.pathOf(ref.RefID); ok && watchIncludes(w.subscriptions(), path) {
+				missing = append(missing, watchStart{id: ref.RefID, path: path})
+			}
+		}
+	}
+	for _, affected_id := range affected {
+		w.refresh(affected_id, now)
+	}
+
+	seen, ok := w.announced[id]
+	if !existed || !ok {
+		return missing
+	}
+	// Directories change whenever their entries do, which the entries' own events already cover
+	if node.entryType != types.Directory && (node.revision != prev.revision || !node.modifiedAt.Equal(prev.modifiedAt) || node.target != prev.target) {
+		w.emit(WatchEvent{Kind: WatchModify, Path: seen.path, ID: id.Hex(), Type: node.entryType.String(), Time: now, ancestorPerms: seen.ancestorPerms})
+	}
+	if !samePermissions(node.permissions, prev.permissions) {
+		w.emit(WatchEvent{Kind: WatchChmod, Path: seen.path, ID: id.Hex(), Type: node.entryType.String(), Time: now, ancestorPerms: seen.ancestorPerms})
+		if node.entryType == types.Directory {
+			w.reannounce(id)
+		}
+	}
+	return missing
+}
+
+// refresh announces a node (and the subtree below it) at its current path, if that changed
+func (w *Watcher) refresh(id primitive.ObjectID, now time.Time) {
+	node, known := w.nodes[id]
+	path, reachable := w.pathOf(id)
+	if !known || !reachable {
+		// Detached nodes keep their last path until they're attached elsewhere or deleted
+		return
+	}
+
+	seen, announced := w.announced[id]
+	if announced && seen.path == path {
+		return
+	}
+	event := WatchEvent{Kind: WatchCreate, Path: path, ID: id.Hex(), Type: node.entryType.String(), Time: now, ancestorPerms: w.ancestorPerms(id)}
+	if announced {
+		event.Kind, event.OldPath, event.oldAncestorPerms = WatchMove, seen.path, seen.ancestorPerms
+	}
+	w.announced[id] = announcement{path: path, ancestorPerms: event.ancestorPerms}
+	w.emit(event)
+
+	for _, ref := range node.entries {
+		if w.parents[ref.RefID] == (watchedRef{parent: id, name: ref.Name}) {
+			w.refresh(ref.RefID, now)
+		}
+	}
+}
+
+// reannounce updates the ancestor permissions remembered for the subtree below a directory whose permissions changed
+func (w *Watcher) reannounce(id primitive.ObjectID) {
+	for _, ref := range w.nodes[id].entries {
+		if w.parents[ref.RefID] != (watchedRef{parent: id, name: ref.Name}) {
+			continue
+		}
+		if seen, ok := w.announced[ref.RefID]; ok {
+			seen.ancestorPerms = w.ancestorPerms(ref.RefID)
+			w.announced[ref.RefID] = seen
+			w.reannounce(ref.RefID)
+		}
+	}
+}
+
+// pathOf rebuilds a node's path from the directory entries leading to it
+func (w *Watcher) pathOf(id primitive.ObjectID) (string, bool) {
+	names := []string{}
+	for range maxWatchDepth {
+		if node, ok := w.nodes[id]; ok && node.isRoot {
+			path := "/"
+			for i := len(names) - 1; i >= 0; i-- {
+				path = strings.TrimSuffix(path, "/") + "/" + names[i]
+			}
+			return path, true
+		}
+		ref, ok := w.parents[id]
+		if !ok {
+			return "", false
+		}
+		if _, ok := w.nodes[ref.parent]; !ok {
+			return "", false
+		}
+		names = append(names, ref.name)
+		id = ref.parent
+	}
+	return "", false
+}
+
+// ancestorPerms collects the permissions of the directories leading to a node
+func (w *Watcher) ancestorPerms(id primitive.ObjectID) []types.FsEntryPermissions {
+	perms := []types.FsEntryPermissions{}
+	for range maxWatchDepth {
+		ref, ok := w.parents[id]
+		if !ok {
+			break
+		}
+		parent, ok := w.nodes[ref.parent]
+		if !ok {
+			break
+		}
+		perms = append(perms, parent.permissions)
+		if parent.isRoot {
+			break
+		}
+		id = ref.parent
+	}
+	return perms
+}
+
+func (w *Watcher) emit(event WatchEvent) {
+	w.Events.Emit(event)
+}
+
+// subscriptions lists the current subscribers. Callers hold w.mu.
+func (w *Watcher) subscriptions() []*WatchSubscription {
+	subs := make([]*WatchSubscription, 0, len(w.subs))
+	for sub := range w.subs {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// forgetUnwatched drops the nodes no subscriber watches any more. Callers hold w.mu.
+func (w *Watcher) forgetUnwatched() {
+	subs := w.subscriptions()
+	unwatched := []primitive.ObjectID{}
+	for id := range w.nodes {
+		if path, ok := w.pathOf(id); len(subs) == 0 || !ok || !watchIncludes(subs, path) {
+			unwatched = append(unwatched, id)
+		}
+	}
+	for _, id := range unwatched {
+		delete(w.nodes, id)
+		delete(w.parents, id)
+		delete(w.announced, id)
+	}
+}
+
+func samePermissions(a, b types.FsEntryPermissions) bool {
+	return strings.Join(a.ReadTags, ",") == strings.Join(b.ReadTags, ",") &&
+		strings.Join(a.WriteTags, ",") == strings.Join(b.WriteTags, ",") &&
+		strings.Join(a.ExecuteTags, ",") == strings.Join(b.ExecuteTags, ",") &&
+		strings.Join(a.UpdatePermissionTags, ",") == strings.Join(b.UpdatePermissionTags, ",")
+}
+
+// watchIncludes reports whether the watcher needs path for subs: it's watched, or leads to a watched path
+func watchIncludes(subs []*WatchSubscription, path string) bool {
+	if path == "/" {
+		return true
+	}
+	for _, sub := range subs {
+		if sub.watches(path) {
+			return true
+		}
+		for _, watched := range sub.paths {
+			if strings.HasPrefix(watched, path+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WatchSubscription receives the events for some paths that its tags allow it to see
+type WatchSubscription struct {
+	watcher   *Watcher
+	listener  *util.EventListener[WatchEvent]
+	paths     []string
+	recursive bool
+	tags      []string
+}
+
+// Subscribe listens for changes to the given paths and their direct children, or (with recursive)
+// everything below them. Callers should check the paths are accessible first.
+// The first subscriber starts the watcher running.
+func (w *Watcher) Subscribe(ctx context.Context, paths []string, recursive bool, tags []string) (*WatchSubscription, error) {
+	sub := &WatchSubscription{
+		watcher:   w,
+		paths:     paths,
+		recursive: recursive,
+		tags:      tags,
+	}
+
+	// Record what's already there, so it isn't reported as created
+	root, err := w.Nodes.Root(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found, err := w.walk(ctx, []watchStart{{id: root.ID, path: "/"}}, []*WatchSubscription{sub})
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, entry := range found {
+		if _, known := w.nodes[entry.ID]; !known {
+			w.nodes[entry.ID] = watchNode(entry)
+		}
+	}
+	for _, entry := range found {
+		for _, ref := range w.nodes[entry.ID].entries {
+			if _, ok := w.parents[ref.RefID]; !ok {
+				w.parents[ref.RefID] = watchedRef{parent: entry.ID, name: ref.Name}
+			}
+		}
+	}
+	for _, entry := range found {
+		if _, ok := w.announced[entry.ID]; ok {
+			continue
+		}
+		if path, ok := w.pathOf(entry.ID); ok {
+			w.announced[entry.ID] = announcement{path: path, ancestorPerms: w.ancestorPerms(entry.ID)}
+		}
+	}
+
+	sub.listener = w.Events.Subscribe()
+	w.subs[sub] = struct{}{}
+	w.startRunning()
+	return sub, nil
+}
+
+// Unsubscribe stops the subscription. The last one to leave stops the watcher.
+func (s *WatchSubscription) Unsubscribe() {
+	s.listener.Unsubscribe()
+
+	w := s.watcher
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.subs, s)
+	if len(w.subs) == 0 && w.stop != nil {
+		w.stop()
+		w.stop = nil
+	}
+	w.forgetUnwatched()
+}
+
+// View returns event as the subscriber may see it, and whether they should see it at all.
+// A move is only reported as one if they can see both sides: moving out of their sight is a delete,
+// and moving into it a create, so nothing leaks the side they can't see.
+func (s *WatchSubscription) View(event WatchEvent) (WatchEvent, bool) {
+	sees_new := s.watches(event.Path) && s.canReach(event.ancestorPerms)
+	if event.Kind != WatchMove {
+		return event, sees_new
+	}
+
+	sees_old := s.watches(event.OldPath) && s.canReach(event.oldAncestorPerms)
+	switch {
+	case sees_new && sees_old:
+		return event, true
+	case sees_new:
+		event.Kind, event.OldPath, event.oldAncestorPerms = WatchCreate, "", nil
+		return event, true
+	case sees_old:
+		event.Kind, event.Path, event.ancestorPerms = WatchDelete, event.OldPath, event.oldAncestorPerms
+		event.OldPath, event.oldAncestorPerms = "", nil
+		return event, true
+	}
+	return event, false
+}
+
+// canReach reports whether the subscriber can execute every directory leading to an entry
+func (s *WatchSubscription) canReach(ancestorPerms []types.FsEntryPermissions) bool {
+	for _, perms := range ancestorPerms {
+		if !perms.IsAllowed(types.ExecuteMode, s.tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *WatchSubscription) watches(path string) bool {
+	for _, watched := range s.paths {
+		if path == watched {
+			return true
+		}
+		prefix := strings.TrimSuffix(watched, "/") + "/"
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if s.recursive || !strings.Contains(path[len(prefix):], "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Next waits for the next event the subscriber should see
+func (s *WatchSubscription) Next(ctx context.Context) (WatchEvent, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return WatchEvent{}, ctx.Err()
+		case event, ok := <-s.listener.Out():
+			if !ok {
+				return WatchEvent{}, context.Canceled
+			}
+			if event, ok := s.View(event); ok {
+				return event, nil
+			}
+		}
+	}
+}
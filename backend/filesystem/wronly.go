@@ -2,18 +2,26 @@ package filesystem
 
 import (
 	"context"
+	"errors"
+	"io"
 	"os"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/gridfs"
 )
 
 type WronlyFileStream struct {
 	FileRef, ParentID primitive.ObjectID
-	Stream            *gridfs.UploadStream
+	Stream            io.WriteCloser
 	Store             Store
 	FileName          string
 	UserTags          []string
+	Writer            string // Username recorded in the file's version history
+	// IfRevision, when set, makes Close fail with ErrRevisionConflict if the file changed since it was opened
+	IfRevision *int64
+
+	// quota, when set, wraps Stream to stop writes past the file's quota budget
+	quota    *quotaWriter
+	exceeded bool
 }
 
 func (w *WronlyFileStream) Read(p []byte) (int, error) {
@@ -21,17 +29,39 @@ func (w *WronlyFileStream) Read(p []byte) (int, error) {
 }
 
 func (w *WronlyFileStream) Write(p []byte) (int, error) {
-	return w.Stream.Write(p)
+	if w.quota == nil {
+		return w.Stream.Write(p)
+	}
+
+	n, err := w.quota.Write(p)
+	if errors.Is(err, ErrQuotaExceeded) {
+		w.exceeded = true
+	}
+	return n, err
 }
 
 func (w *WronlyFileStream) Close() error {
+	// Committing what fit would leave a truncated file
+	if w.exceeded {
+		w.Abort()
+		return ErrQuotaExceeded
+	}
+
 	if err := w.Stream.Close(); err != nil {
 		return err
 	}
 
-	if _, err := w.Store.WriteFile(context.Background(), w.ParentID, w.FileName, w.FileRef, w.UserTags); err != nil {
+	if _, err := w.Store.writeFile(context.Background(), w.ParentID, w.FileName, w.FileRef, w.UserTags, w.Writer, w.IfRevision); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Abort discards the write: nothing is committed to the file
+func (w *WronlyFileStream) Abort() error {
+	if aborter, ok := w.Stream.(Aborter); ok {
+		return aborter.Abort()
+	}
+	return nil
+}
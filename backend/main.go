@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -12,13 +13,17 @@ import (
 	"time"
 
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/auth"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/bootstrap"
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/chatbot"
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/cmd"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/dav"
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/email"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/fileapi"
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/filesystem"
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/influx"
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/types"
 	"github.com/RoundRobinHood/cogniflight-cloud/backend/uazapi"
+	"github.com/RoundRobinHood/cogniflight-cloud/backend/util"
 	"github.com/RoundRobinHood/jlogging"
 	"github.com/gin-gonic/gin"
 	"github.com/sourcegraph/jsonrpc2"
@@ -75,13 +80,50 @@ func main() {
 		}
 	}
 
+	gc_interval := 24 * time.Hour
+	if interval_str := os.Getenv("GC_INTERVAL"); interval_str != "" {
+		if gc_interval, err = time.ParseDuration(interval_str); err != nil {
+			log.Fatal("invalid GC interval: ", err)
+		}
+	}
+
+	trash_retention := filesystem.DefaultTrashRetention
+	if retention_str := os.Getenv("TRASH_RETENTION"); retention_str != "" {
+		if trash_retention, err = time.ParseDuration(retention_str); err != nil {
+			log.Fatal("invalid trash retention: ", err)
+		}
+	}
+
+	watch_poll_interval := filesystem.DefaultWatchPollInterval
+	if interval_str := os.Getenv("WATCH_POLL_INTERVAL"); interval_str != "" {
+		if watch_poll_interval, err = time.ParseDuration(interval_str); err != nil || watch_poll_interval <= 0 {
+			log.Fatal("invalid watch poll interval: ", interval_str)
+		}
+	}
+
+	atime_policy := filesystem.RelAtime
+	if policy_str := os.Getenv("ATIME_POLICY"); policy_str != "" {
+		if atime_policy, err = filesystem.ParseAtimePolicy(policy_str); err != nil {
+			log.Fatal("invalid atime policy: ", err)
+		}
+	}
+	atime_overrides, err := filesystem.ParseAtimeOverrides(os.Getenv("ATIME_OVERRIDES"))
+	if err != nil {
+		log.Fatal("invalid atime overrides: ", err)
+	}
+
 	database := client.Database("cogniflight")
 	bucket, err := gridfs.NewBucket(database)
 	if err != nil {
 		log.Fatalf("Couldn't create gridFS bucket: %v", err)
 	}
 
-	fileStore := filesystem.Store{Col: database.Collection("vfs"), Bucket: bucket}
+	fileStore := filesystem.NewMongoStore(database.Collection("vfs"), bucket)
+	fileStore.Atime = filesystem.NewAtimeWriter(fileStore.Nodes, atime_policy, atime_overrides)
+	fileStore.Watcher = filesystem.NewWatcher(fileStore.Nodes)
+	// Locks are leases in Mongo, so every instance sharing the database honours them
+	fileStore.Locks = filesystem.MongoLocks{Col: database.Collection("vfs_locks")}
+	fileStore.QuotaCache = filesystem.NewQuotaCache()
 	sessionStore := types.NewSessionStore()
 
 	go func() {
@@ -100,21 +142,69 @@ func main() {
 					// No filesystem
 					log.Println("No filesystem found. Looking for bootstrap credentials for init...")
 
-					username := os.Getenv("BOOTSTRAP_USERNAME")
-					email := os.Getenv("BOOTSTRAP_EMAIL")
-					phone := os.Getenv("BOOTSTRAP_PHONE")
+					vars := bootstrap.Vars{
+						Username: os.Getenv("BOOTSTRAP_USERNAME"),
+						Email:    os.Getenv("BOOTSTRAP_EMAIL"),
+						Phone:    os.Getenv("BOOTSTRAP_PHONE"),
+					}
 					pwd := os.Getenv("BOOTSTRAP_PWD")
 
-					if username == "" || email == "" || phone == "" || pwd == "" {
+					if vars.Username == "" || vars.Email == "" || vars.Phone == "" || pwd == "" {
 						log.Fatal("No bootstrap credentials found. Nothing to provide users. exiting")
 					}
+					if err := vars.Validate(); err != nil {
+						log.Fatal("Invalid bootstrap credentials: ", err)
+					}
+					if vars.PasswordHash, err = util.HashPwd(pwd); err != nil {
+						log.Fatal("Failed to hash bootstrap password: ", err)
+					}
 
-					if err := InitFilesystem(fileStore, username, email, phone, pwd); err != nil {
+					manifest_bytes := bootstrap.DefaultManifest
+					if manifest_path := os.Getenv("BOOTSTRAP_MANIFEST"); manifest_path != "" {
+						if manifest_bytes, err = os.ReadFile(manifest_path); err != nil {
+							log.Fatal("Failed to read bootstrap manifest: ", err)
+						}
+					}
+					manifest, err := bootstrap.ParseManifest(manifest_bytes, vars)
+					if err != nil {
+						log.Fatal("Invalid bootstrap manifest: ", err)
+					}
+					if _, err := manifest.Apply(context.Background(), fileStore); err != nil {
 						log.Fatal("Failed to init file system: ", err)
 					}
 
 					fmt.Println("Successfully initialized file system")
 				}
+
+				if nodes, ok := fileStore.Nodes.(filesystem.MongoNodes); ok {
+					if err := nodes.CreateIndexes(context.Background()); err != nil {
+						log.Printf("[VFS] Couldn't create indexes: %v", err)
+					}
+				}
+				if blobs, ok := fileStore.Blobs.(filesystem.GridFSBlobs); ok {
+					if err := blobs.CreateIndexes(context.Background()); err != nil {
+						log.Printf("[VFS] Couldn't create blob indexes: %v", err)
+					}
+				}
+				if locks, ok := fileStore.Locks.(filesystem.MongoLocks); ok {
+					if err := locks.CreateIndexes(context.Background()); err != nil {
+						log.Printf("[VFS] Couldn't create lock indexes: %v", err)
+					}
+				}
+				if err := fileStore.ReindexPaths(context.Background()); err != nil {
+					log.Printf("[VFS] Couldn't index paths: %v", err)
+				}
+
+				if applied, err := bootstrap.Migrate(context.Background(), fileStore, bootstrap.Migrations); err != nil {
+					if !errors.Is(err, filesystem.ErrRevisionConflict) {
+						log.Fatal("[VFS] Migration failed (exiting): ", err)
+					}
+					log.Printf("[VFS] Another instance is migrating the filesystem: %v", err)
+				} else {
+					for _, migration := range applied {
+						log.Printf("[VFS] Applied migration %d (%s)", migration.Version, migration.Name)
+					}
+				}
 				break
 			}
 
@@ -141,6 +231,14 @@ func main() {
 	defer cancel()
 
 	mqttEvents := ListenMQTT(ctx)
+	go fileStore.Atime.Run(ctx, filesystem.DefaultAtimeFlushInterval)
+	fileStore.Watcher.Start(ctx, watch_poll_interval)
+	if gc_interval > 0 {
+		go filesystem.ScheduleGC(ctx, fileStore, gc_interval)
+	}
+	if trash_retention > 0 {
+		go filesystem.ScheduleTrashSweep(ctx, fileStore, trash_retention, filesystem.DefaultTrashSweepInterval)
+	}
 
 	stream := jsonrpc2.NewPlainObjectStream(conn)
 	jsonConn := jsonrpc2.NewConn(context.Background(), stream, nil)
@@ -155,6 +253,16 @@ func main() {
 	r.GET("/signup/check-username/:username", auth.SignupCheckUsername(fileStore))
 	r.POST("/signup", auth.Signup(fileStore))
 	r.POST("/login", auth.Login(fileStore))
+	files := r.Group("/fs", auth.AuthMiddleware(fileStore))
+	files.GET("/*path", fileapi.Download(fileStore))
+	files.HEAD("/*path", fileapi.Download(fileStore))
+	files.PUT("/*path", fileapi.Upload(fileStore))
+	files.DELETE("/*path", fileapi.Delete(fileStore))
+	webdav := r.Group("/dav", auth.BasicAuthChallenge("Cogniflight"), auth.AuthMiddleware(fileStore))
+	webdavHandler := dav.Handler(fileStore, "/dav")
+	for _, method := range dav.Methods {
+		webdav.Handle(method, "/*path", webdavHandler)
+	}
 	r.GET("/cmd-socket", auth.AuthMiddleware(fileStore),
 		cmd.CmdWebhook(
 			fileStore,
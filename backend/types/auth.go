@@ -1,8 +1,18 @@
 package types
 
+import "time"
+
 // AuthorizationStatus describes what prior auth middleware could determine about the current request.
 type AuthorizationStatus struct {
 	Username string
 	Tags     []string
 	SessID   string `yaml:"-" json:"-"`
 }
+
+// APIToken describes a token issued for clients that can't keep a session cookie (such as WebDAV clients).
+// Only a hash of the token itself is stored.
+type APIToken struct {
+	Username  string    `yaml:"username"`
+	Name      string    `yaml:"name"`
+	CreatedAt time.Time `yaml:"created_at"`
+}
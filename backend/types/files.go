@@ -135,6 +135,7 @@ type FsEntryType int
 const (
 	File FsEntryType = iota
 	Directory
+	Symlink
 )
 
 func (f FsEntryType) String() string {
@@ -143,19 +144,62 @@ func (f FsEntryType) String() string {
 		return "file"
 	case Directory:
 		return "directory"
+	case Symlink:
+		return "symlink"
 	default:
 		return "unknown"
 	}
 }
 
+// FileVersion is a previous revision of a file's contents
+type FileVersion struct {
+	FileReference primitive.ObjectID `bson:"file_ref"`
+	ModifiedAt    time.Time          `bson:"modified_at"`
+	ModifiedBy    string             `bson:"modified_by,omitempty"`
+}
+
+// DefaultPermissions are what a directory gives entries created in it, instead of its own permissions.
+// Files (and symlinks) get Files and subdirectories get Directories; either left nil means the directory's own.
+type DefaultPermissions struct {
+	Files       *FsEntryPermissions `bson:"files,omitempty" yaml:"files,omitempty"`
+	Directories *FsEntryPermissions `bson:"directories,omitempty" yaml:"directories,omitempty"`
+}
+
+// TrashInfo records where a trashed entry came from
+type TrashInfo struct {
+	OriginalPath string    `bson:"original_path" yaml:"original_path"`
+	DeletedBy    string    `bson:"deleted_by" yaml:"deleted_by"`
+	DeletedAt    time.Time `bson:"deleted_at" yaml:"deleted_at"`
+}
+
 type FsEntry struct {
 	ID            primitive.ObjectID  `bson:"_id"`
 	IsRoot        bool                `bson:"is_root"`
 	EntryType     FsEntryType         `bson:"type"`
 	Permissions   FsEntryPermissions  `bson:"permissions"`
 	Timestamps    FileTimestamps      `bson:"timestamps"`
-	Entries       FsReferenceList     `bson:"entries"`  // Represents a directory's contents as FsEntry references
-	FileReference *primitive.ObjectID `bson:"file_ref"` // GridFS file reference (for files)
+	Entries       FsReferenceList     `bson:"entries"`               // Represents a directory's contents as FsEntry references
+	FileReference *primitive.ObjectID `bson:"file_ref"`              // GridFS file reference (for files)
+	Target        string              `bson:"target,omitempty"`      // Path the link points to (for symlinks)
+	ModifiedBy    string              `bson:"modified_by,omitempty"` // Username of whoever wrote the current contents (for files)
+	Versions      []FileVersion       `bson:"versions,omitempty"`    // Previous contents, oldest first (for files)
+	Revision      int64               `bson:"revision"`              // Incremented on every content write, for compare-and-swap writes
+	Path          string              `bson:"path,omitempty"`        // Materialized absolute path, used to prefetch lookups (directory entries stay authoritative)
+	Trash         *TrashInfo          `bson:"trash,omitempty"`       // Set while the entry sits in a trash directory
+	Defaults      *DefaultPermissions `bson:"defaults,omitempty"`    // Permissions for new children, passed on to new subdirectories (for directories)
+}
+
+// ChildPermissions returns the permissions a new entry of the given type gets in this directory
+func (e FsEntry) ChildPermissions(entryType FsEntryType) FsEntryPermissions {
+	if e.Defaults != nil {
+		if entryType == Directory && e.Defaults.Directories != nil {
+			return *e.Defaults.Directories
+		}
+		if entryType != Directory && e.Defaults.Files != nil {
+			return *e.Defaults.Files
+		}
+	}
+	return e.Permissions
 }
 
 type FsStat struct {
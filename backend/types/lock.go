@@ -0,0 +1,35 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LockMode is how much of an entry an advisory lock claims
+type LockMode string
+
+const (
+	// SharedLock excludes exclusive locks (and writers honouring locks), but not other readers
+	SharedLock LockMode = "shared"
+	// ExclusiveLock excludes every other lock (and readers and writers honouring locks)
+	ExclusiveLock LockMode = "exclusive"
+)
+
+// LockOwner identifies who holds a lock: one client of one socket
+type LockOwner struct {
+	SocketID string `bson:"socket_id" yaml:"socket_id"`
+	ClientID string `bson:"client_id" yaml:"client_id"`
+}
+
+// FileLock is an advisory lock held on a filesystem entry until it expires or is released
+type FileLock struct {
+	EntryID primitive.ObjectID `bson:"entry_id" yaml:"entry_id"`
+	// Path is where the entry was when it was locked
+	Path       string    `bson:"path" yaml:"path"`
+	Mode       LockMode  `bson:"mode" yaml:"mode"`
+	Owner      LockOwner `bson:"owner" yaml:"owner"`
+	Username   string    `bson:"username" yaml:"username"`
+	AcquiredAt time.Time `bson:"acquired_at" yaml:"acquired_at"`
+	ExpiresAt  time.Time `bson:"expires_at" yaml:"expires_at"`
+}
@@ -0,0 +1,15 @@
+package types
+
+// QuotaLimit caps storage use. Zero means unlimited.
+type QuotaLimit struct {
+	Bytes   int64 `yaml:"bytes,omitempty"`
+	Entries int64 `yaml:"entries,omitempty"`
+}
+
+// QuotaConfig holds the quota definitions (stored as YAML in the filesystem)
+type QuotaConfig struct {
+	// Tags limits everything owned by a tag: nodes whose updatetag_tags contain it
+	Tags map[string]QuotaLimit `yaml:"tags,omitempty"`
+	// Directories limits everything below a directory, by absolute path
+	Directories map[string]QuotaLimit `yaml:"directories,omitempty"`
+}
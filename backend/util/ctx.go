@@ -40,3 +40,9 @@ func GetTags(ctx context.Context) []string {
 		return tags
 	}
 }
+
+// GetLockOwner returns the socket client a command runs for, if it runs for one
+func GetLockOwner(ctx context.Context) (types.LockOwner, bool) {
+	owner, ok := ctx.Value("lock_owner").(types.LockOwner)
+	return owner, ok
+}
@@ -0,0 +1,787 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/goccy/go-yaml"
+)
+
+// YQExpr is a parsed yq expression. Expressions run on documents decoded with yaml.UseOrderedMap,
+// so maps are yaml.MapSlice and keep their key order.
+//
+// Supported syntax:
+//   - paths: ".", ".a.b", ".a[0]", ".a[-1]", ".a[]", `.["a key"]`
+//   - pipes: "expr | expr"
+//   - literals: "string", 12, 1.5, true, false, null
+//   - comparisons and logic: ==, !=, <, <=, >, >=, and, or
+//   - functions: select(cond), has("key"), del(path), keys, length, not
+//   - assignment: "path = expr" (expr runs on the same input as path)
+type YQExpr struct {
+	root yqNode
+}
+
+// ErrYQSyntax is returned for expressions that can't be parsed
+var ErrYQSyntax = errors.New("invalid yq expression")
+
+// yqResult is a value with the path it was found at (nil if it isn't part of the document)
+type yqResult struct {
+	value any
+	path  []any
+}
+
+type yqNode interface {
+	eval(input yqResult) ([]yqResult, error)
+}
+
+func ParseYQ(expr string) (*YQExpr, error) {
+	tokens, err := yqTokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := yqParser{tokens: tokens}
+	node, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrYQSyntax, p.tokens[p.pos].text)
+	}
+	return &YQExpr{root: node}, nil
+}
+
+// Eval runs the expression on doc. Assignments and del return the updated document.
+func (e *YQExpr) Eval(doc any) ([]any, error) {
+	results, err := e.root.eval(yqResult{value: doc, path: []any{}})
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, len(results))
+	for i, result := range results {
+		values[i] = result.value
+	}
+	return values, nil
+}
+
+// Tokenizer
+
+type yqToken struct {
+	kind string // "op", "ident", "string", "number"
+	text string
+}
+
+func yqTokenize(expr string) ([]yqToken, error) {
+	tokens := []yqToken{}
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrYQSyntax)
+			}
+			text, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid string %s", ErrYQSyntax, expr[i:end+1])
+			}
+			tokens = append(tokens, yqToken{"string", text})
+			i = end + 1
+		case strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!=") || strings.HasPrefix(expr[i:], "<=") || strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, yqToken{"op", expr[i : i+2]})
+			i += 2
+		case strings.ContainsRune(".|[](),=<>", rune(c)):
+			tokens = append(tokens, yqToken{"op", string(c)})
+			i++
+		case c == '-' || unicode.IsDigit(rune(c)):
+			end := i + 1
+			for end < len(expr) && (unicode.IsDigit(rune(expr[end])) || strings.ContainsRune(".eE+-", rune(expr[end]))) {
+				end++
+			}
+			if _, err := strconv.ParseFloat(expr[i:end], 64); err != nil {
+				return nil, fmt.Errorf("%w: invalid number %q", ErrYQSyntax, expr[i:end])
+			}
+			tokens = append(tokens, yqToken{"number", expr[i:end]})
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for end < len(expr) && (expr[end] == '_' || expr[end] == '-' || unicode.IsLetter(rune(expr[end])) || unicode.IsDigit(rune(expr[end]))) {
+				end++
+			}
+			tokens = append(tokens, yqToken{"ident", expr[i:end]})
+			i = end
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q", ErrYQSyntax, c)
+		}
+	}
+	return tokens, nil
+}
+
+// Parser
+
+type yqParser struct {
+	tokens []yqToken
+	pos    int
+}
+
+func (p *yqParser) peek(kind, text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind && p.tokens[p.pos].text == text
+}
+
+func (p *yqParser) expect(text string) error {
+	if !p.peek("op", text) {
+		return fmt.Errorf("%w: expected %q", ErrYQSyntax, text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *yqParser) parsePipe() (yqNode, error) {
+	left, err := p.parseAssign()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("op", "|") {
+		p.pos++
+		right, err := p.parseAssign()
+		if err != nil {
+			return nil, err
+		}
+		left = yqPipe{left, right}
+	}
+	return left, nil
+}
+
+func (p *yqParser) parseAssign() (yqNode, error) {
+	target, err := p.parseBool("or")
+	if err != nil {
+		return nil, err
+	}
+	if !p.peek("op", "=") {
+		return target, nil
+	}
+	p.pos++
+	value, err := p.parseBool("or")
+	if err != nil {
+		return nil, err
+	}
+	return yqAssign{target, value}, nil
+}
+
+// parseBool parses "and"/"or" chains, with "and" binding tighter
+func (p *yqParser) parseBool(op string) (yqNode, error) {
+	next := func() (yqNode, error) {
+		if op == "or" {
+			return p.parseBool("and")
+		}
+		return p.parseCompare()
+	}
+
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("ident", op) {
+		p.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = yqLogic{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *yqParser) parseCompare() (yqNode, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.peek("op", op) {
+			p.pos++
+			right, err := p.parsePostfix()
+			if err != nil {
+				return nil, err
+			}
+			return yqCompare{op, left, right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *yqParser) parsePostfix() (yqNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if p.peek("op", "[") {
+			if node, err = p.parseIndex(node); err != nil {
+				return nil, err
+			}
+		} else if p.peek("op", ".") && p.pos+1 < len(p.tokens) && (p.tokens[p.pos+1].kind == "ident" || p.tokens[p.pos+1].kind == "string" || p.tokens[p.pos+1].text == "[") {
+			p.pos++
+			if p.peek("op", "[") {
+				if node, err = p.parseIndex(node); err != nil {
+					return nil, err
+				}
+			} else {
+				node = yqPipe{node, yqField{p.tokens[p.pos].text}}
+				p.pos++
+			}
+		} else {
+			return node, nil
+		}
+	}
+}
+
+// parseIndex parses "[]", "[N]" or `["key"]` after node
+func (p *yqParser) parseIndex(node yqNode) (yqNode, error) {
+	p.pos++ // [
+	if p.peek("op", "]") {
+		p.pos++
+		return yqPipe{node, yqIterate{}}, nil
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("%w: unterminated [", ErrYQSyntax)
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+	var next yqNode
+	switch token.kind {
+	case "string":
+		next = yqField{token.text}
+	case "number":
+		index, err := strconv.Atoi(token.text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid index %q", ErrYQSyntax, token.text)
+		}
+		next = yqIndex{index}
+	default:
+		return nil, fmt.Errorf("%w: invalid index %q", ErrYQSyntax, token.text)
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return yqPipe{node, next}, nil
+}
+
+func (p *yqParser) parsePrimary() (yqNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected end of expression", ErrYQSyntax)
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch {
+	case token.kind == "op" && token.text == ".":
+		// ".a" is handled here so "." alone stays the identity
+		if p.pos < len(p.tokens) && (p.tokens[p.pos].kind == "ident" || p.tokens[p.pos].kind == "string") {
+			p.pos++
+			return yqField{p.tokens[p.pos-1].text}, nil
+		}
+		return yqIdentity{}, nil
+	case token.kind == "op" && token.text == "(":
+		node, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	case token.kind == "string":
+		return yqLiteral{token.text}, nil
+	case token.kind == "number":
+		if n, err := strconv.ParseInt(token.text, 10, 64); err == nil {
+			return yqLiteral{n}, nil
+		}
+		n, _ := strconv.ParseFloat(token.text, 64)
+		return yqLiteral{n}, nil
+	case token.kind == "ident":
+		switch token.text {
+		case "true", "false":
+			return yqLiteral{token.text == "true"}, nil
+		case "null":
+			return yqLiteral{nil}, nil
+		case "keys", "length", "not":
+			return yqFunc{name: token.text}, nil
+		case "select", "has", "del":
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			arg, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			return yqFunc{name: token.text, arg: arg}, p.expect(")")
+		}
+		return nil, fmt.Errorf("%w: unknown function %q", ErrYQSyntax, token.text)
+	}
+	return nil, fmt.Errorf("%w: unexpected %q", ErrYQSyntax, token.text)
+}
+
+// Nodes
+
+type yqIdentity struct{}
+
+func (yqIdentity) eval(input yqResult) ([]yqResult, error) {
+	return []yqResult{input}, nil
+}
+
+type yqLiteral struct {
+	value any
+}
+
+func (l yqLiteral) eval(input yqResult) ([]yqResult, error) {
+	return []yqResult{{value: l.value}}, nil
+}
+
+type yqPipe struct {
+	left, right yqNode
+}
+
+func (p yqPipe) eval(input yqResult) ([]yqResult, error) {
+	lefts, err := p.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	results := []yqResult{}
+	for _, left := range lefts {
+		rights, err := p.right.eval(left)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, rights...)
+	}
+	return results, nil
+}
+
+// childPath extends a result's path (nil stays nil: values computed by the expression have no path)
+func childPath(path []any, elem any) []any {
+	if path == nil {
+		return nil
+	}
+	return append(slices.Clone(path), elem)
+}
+
+type yqField struct {
+	name string
+}
+
+func (f yqField) eval(input yqResult) ([]yqResult, error) {
+	switch value := input.value.(type) {
+	case nil:
+		return []yqResult{{value: nil, path: childPath(input.path, f.name)}}, nil
+	case yaml.MapSlice:
+		for _, item := range value {
+			if fmt.Sprint(item.Key) == f.name {
+				return []yqResult{{value: item.Value, path: childPath(input.path, f.name)}}, nil
+			}
+		}
+		return []yqResult{{value: nil, path: childPath(input.path, f.name)}}, nil
+	default:
+		return nil, fmt.Errorf("can't get field %q of %s", f.name, yqType(value))
+	}
+}
+
+type yqIndex struct {
+	index int
+}
+
+func (i yqIndex) eval(input yqResult) ([]yqResult, error) {
+	switch value := input.value.(type) {
+	case nil:
+		return []yqResult{{value: nil, path: childPath(input.path, i.index)}}, nil
+	case []any:
+		index := i.index
+		if index < 0 {
+			index += len(value)
+		}
+		if index < 0 || index >= len(value) {
+			return []yqResult{{value: nil, path: childPath(input.path, index)}}, nil
+		}
+		return []yqResult{{value: value[index], path: childPath(input.path, index)}}, nil
+	default:
+		return nil, fmt.Errorf("can't index %s", yqType(value))
+	}
+}
+
+type yqIterate struct{}
+
+func (yqIterate) eval(input yqResult) ([]yqResult, error) {
+	results := []yqResult{}
+	switch value := input.value.(type) {
+	case nil:
+	case []any:
+		for i, elem := range value {
+			results = append(results, yqResult{value: elem, path: childPath(input.path, i)})
+		}
+	case yaml.MapSlice:
+		for _, item := range value {
+			results = append(results, yqResult{value: item.Value, path: childPath(input.path, fmt.Sprint(item.Key))})
+		}
+	default:
+		return nil, fmt.Errorf("can't iterate over %s", yqType(value))
+	}
+	return results, nil
+}
+
+type yqCompare struct {
+	op          string
+	left, right yqNode
+}
+
+func (c yqCompare) eval(input yqResult) ([]yqResult, error) {
+	lefts, err := c.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	rights, err := c.right.eval(input)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []yqResult{}
+	for _, left := range lefts {
+		for _, right := range rights {
+			cmp, comparable := yqCompareValues(left.value, right.value)
+			var result bool
+			switch c.op {
+			case "==":
+				result = comparable && cmp == 0
+			case "!=":
+				result = !comparable || cmp != 0
+			case "<":
+				result = comparable && cmp < 0
+			case "<=":
+				result = comparable && cmp <= 0
+			case ">":
+				result = comparable && cmp > 0
+			case ">=":
+				result = comparable && cmp >= 0
+			}
+			results = append(results, yqResult{value: result})
+		}
+	}
+	return results, nil
+}
+
+type yqLogic struct {
+	op          string
+	left, right yqNode
+}
+
+func (l yqLogic) eval(input yqResult) ([]yqResult, error) {
+	lefts, err := l.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	results := []yqResult{}
+	for _, left := range lefts {
+		// Short-circuit like jq
+		if yqTruthy(left.value) == (l.op == "or") {
+			results = append(results, yqResult{value: l.op == "or"})
+			continue
+		}
+		rights, err := l.right.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, right := range rights {
+			results = append(results, yqResult{value: yqTruthy(right.value)})
+		}
+	}
+	return results, nil
+}
+
+type yqFunc struct {
+	name string
+	arg  yqNode
+}
+
+func (f yqFunc) eval(input yqResult) ([]yqResult, error) {
+	switch f.name {
+	case "select":
+		conds, err := f.arg.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, cond := range conds {
+			if yqTruthy(cond.value) {
+				return []yqResult{input}, nil
+			}
+		}
+		return []yqResult{}, nil
+	case "not":
+		return []yqResult{{value: !yqTruthy(input.value)}}, nil
+	case "length":
+		switch value := input.value.(type) {
+		case nil:
+			return []yqResult{{value: int64(0)}}, nil
+		case string:
+			return []yqResult{{value: int64(len([]rune(value)))}}, nil
+		case []any:
+			return []yqResult{{value: int64(len(value))}}, nil
+		case yaml.MapSlice:
+			return []yqResult{{value: int64(len(value))}}, nil
+		default:
+			return nil, fmt.Errorf("%s has no length", yqType(value))
+		}
+	case "keys":
+		keys := []any{}
+		switch value := input.value.(type) {
+		case []any:
+			for i := range value {
+				keys = append(keys, int64(i))
+			}
+		case yaml.MapSlice:
+			for _, item := range value {
+				keys = append(keys, item.Key)
+			}
+		default:
+			return nil, fmt.Errorf("%s has no keys", yqType(value))
+		}
+		return []yqResult{{value: keys}}, nil
+	case "has":
+		keys, err := f.arg.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		results := []yqResult{}
+		for _, key := range keys {
+			found := false
+			switch value := input.value.(type) {
+			case yaml.MapSlice:
+				for _, item := range value {
+					found = found || fmt.Sprint(item.Key) == fmt.Sprint(key.value)
+				}
+			case []any:
+				if index, ok := yqNumber(key.value); ok {
+					found = index >= 0 && int(index) < len(value)
+				}
+			}
+			results = append(results, yqResult{value: found})
+		}
+		return results, nil
+	case "del":
+		targets, err := f.arg.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		paths := [][]any{}
+		for _, target := range targets {
+			if target.path == nil {
+				return nil, fmt.Errorf("del() needs a path into the document")
+			}
+			paths = append(paths, target.path[len(input.path):])
+		}
+		// Delete later array elements first, so earlier indexes stay valid
+		slices.SortFunc(paths, func(a, b []any) int {
+			return -slices.CompareFunc(a, b, yqComparePathElems)
+		})
+		value := input.value
+		for _, path := range paths {
+			if value, err = yqDelete(value, path); err != nil {
+				return nil, err
+			}
+		}
+		return []yqResult{{value: value, path: input.path}}, nil
+	}
+	return nil, fmt.Errorf("unknown function %q", f.name)
+}
+
+type yqAssign struct {
+	target, value yqNode
+}
+
+func (a yqAssign) eval(input yqResult) ([]yqResult, error) {
+	values, err := a.value.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 1 {
+		return nil, fmt.Errorf("assigned value must be a single value, got %d", len(values))
+	}
+	targets, err := a.target.eval(input)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := input.value
+	for _, target := range targets {
+		if target.path == nil {
+			return nil, fmt.Errorf("can only assign to a path into the document")
+		}
+		if updated, err = yqSet(updated, target.path[len(input.path):], values[0].value); err != nil {
+			return nil, err
+		}
+	}
+	return []yqResult{{value: updated, path: input.path}}, nil
+}
+
+// Helpers
+
+// yqSet returns value with the element at path replaced, creating maps along the way
+func yqSet(value any, path []any, new_value any) (any, error) {
+	if len(path) == 0 {
+		return new_value, nil
+	}
+	switch key := path[0].(type) {
+	case string:
+		if value == nil {
+			value = yaml.MapSlice{}
+		}
+		m, ok := value.(yaml.MapSlice)
+		if !ok {
+			return nil, fmt.Errorf("can't set field %q of %s", key, yqType(value))
+		}
+		m = slices.Clone(m)
+		for i, item := range m {
+			if fmt.Sprint(item.Key) == key {
+				child, err := yqSet(item.Value, path[1:], new_value)
+				m[i].Value = child
+				return m, err
+			}
+		}
+		child, err := yqSet(nil, path[1:], new_value)
+		return append(m, yaml.MapItem{Key: key, Value: child}), err
+	case int:
+		list, ok := value.([]any)
+		if !ok && value != nil {
+			return nil, fmt.Errorf("can't index %s", yqType(value))
+		}
+		if key < 0 {
+			return nil, fmt.Errorf("index %d out of range", key)
+		}
+		list = slices.Clone(list)
+		for len(list) <= key {
+			list = append(list, nil)
+		}
+		child, err := yqSet(list[key], path[1:], new_value)
+		list[key] = child
+		return list, err
+	}
+	return nil, fmt.Errorf("invalid path element %v", path[0])
+}
+
+// yqDelete returns value without the element at path
+func yqDelete(value any, path []any) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	switch key := path[0].(type) {
+	case string:
+		m, ok := value.(yaml.MapSlice)
+		if !ok {
+			return value, nil
+		}
+		m = slices.Clone(m)
+		for i, item := range m {
+			if fmt.Sprint(item.Key) == key {
+				if len(path) == 1 {
+					return slices.Delete(m, i, i+1), nil
+				}
+				child, err := yqDelete(item.Value, path[1:])
+				m[i].Value = child
+				return m, err
+			}
+		}
+		return m, nil
+	case int:
+		list, ok := value.([]any)
+		if !ok || key < 0 || key >= len(list) {
+			return value, nil
+		}
+		list = slices.Clone(list)
+		if len(path) == 1 {
+			return slices.Delete(list, key, key+1), nil
+		}
+		child, err := yqDelete(list[key], path[1:])
+		list[key] = child
+		return list, err
+	}
+	return nil, fmt.Errorf("invalid path element %v", path[0])
+}
+
+func yqComparePathElems(a, b any) int {
+	x, x_int := a.(int)
+	y, y_int := b.(int)
+	if x_int && y_int {
+		return x - y
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func yqTruthy(value any) bool {
+	return value != nil && value != false
+}
+
+func yqNumber(value any) (float64, bool) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// yqCompareValues orders two values of the same kind. comparable is false for values of different kinds.
+func yqCompareValues(a, b any) (cmp int, comparable bool) {
+	if x, ok := yqNumber(a); ok {
+		if y, ok := yqNumber(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+		return 0, false
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0, true
+	}
+	return 0, false
+}
+
+func yqType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case yaml.MapSlice:
+		return "a map"
+	case []any:
+		return "a list"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	}
+	if _, ok := yqNumber(value); ok {
+		return "a number"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,158 @@
+package util
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+// runYQ evaluates expr on a YAML document, returning the results as a YAML list
+func runYQ(t *testing.T, doc, expr string) (string, error) {
+	t.Helper()
+	var value any
+	if err := yaml.UnmarshalWithOptions([]byte(doc), &value, yaml.UseOrderedMap()); err != nil {
+		t.Fatalf("invalid test document %q: %v", doc, err)
+	}
+	parsed, err := ParseYQ(expr)
+	if err != nil {
+		return "", err
+	}
+	results, err := parsed.Eval(value)
+	if err != nil {
+		return "", err
+	}
+	data, err := yaml.MarshalWithOptions(results, yaml.Flow(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// normalizeYAML re-encodes a YAML value the way runYQ does, so expectations can be written loosely
+func normalizeYAML(t *testing.T, text string) string {
+	t.Helper()
+	var value any
+	if err := yaml.UnmarshalWithOptions([]byte(text), &value, yaml.UseOrderedMap()); err != nil {
+		t.Fatalf("invalid expectation %q: %v", text, err)
+	}
+	data, err := yaml.MarshalWithOptions(value, yaml.Flow(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestParseYQErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "unterminated index", expr: ".["},
+		{name: "unterminated index with key", expr: `.["a"`},
+		{name: "empty select", expr: "select()"},
+		{name: "empty del", expr: "del()"},
+		{name: "trailing pipe", expr: ".a |"},
+		{name: "leading pipe", expr: "| .a"},
+		{name: "empty expression", expr: ""},
+		{name: "missing comparison operand", expr: ".a =="},
+		{name: "missing assigned value", expr: ".a ="},
+		{name: "unknown function", expr: "first"},
+		{name: "function without parentheses", expr: "select .a"},
+		{name: "unclosed parenthesis", expr: "(.a"},
+		{name: "unopened parenthesis", expr: ".a)"},
+		{name: "unterminated string", expr: `"abc`},
+		{name: "fractional index", expr: ".a[1.5]"},
+		{name: "identifier index", expr: ".a[b]"},
+		{name: "invalid number", expr: "1-2"},
+		{name: "invalid character", expr: ".a & .b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseYQ(tt.expr); !errors.Is(err, ErrYQSyntax) {
+				t.Errorf("ParseYQ(%q) error = %v, want ErrYQSyntax", tt.expr, err)
+			}
+		})
+	}
+}
+
+func TestYQEval(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		expr    string
+		want    string
+		wantErr bool
+	}{
+		// Indexes
+		{name: "last element", doc: "list: [a, b, c]", expr: ".list[-1]", want: "[c]"},
+		{name: "first element from the end", doc: "list: [a, b, c]", expr: ".list[-3]", want: "[a]"},
+		{name: "negative index past the start", doc: "list: [a, b, c]", expr: ".list[-4]", want: "[null]"},
+		{name: "index past the end", doc: "list: [a, b, c]", expr: ".list[3]", want: "[null]"},
+		{name: "index into a missing field", doc: "list: [a]", expr: ".missing[2]", want: "[null]"},
+		{name: "index into an empty list", doc: "list: []", expr: ".list[0]", want: "[null]"},
+		{name: "index into a string", doc: "list: [a]", expr: ".list[0][0]", wantErr: true},
+		{name: "index into a map", doc: "m: {a: 1}", expr: ".m[0]", wantErr: true},
+		{name: "field of a list", doc: "list: [a]", expr: ".list.a", wantErr: true},
+
+		// Assignment
+		{name: "assign past the end grows the list", doc: "list: [a]", expr: ".list[3] = \"d\"", want: "[{list: [a, null, null, d]}]"},
+		{name: "assign to a missing list creates it", doc: "{}", expr: ".list[1] = 1", want: "[{list: [null, 1]}]"},
+		{name: "assign creates nested maps", doc: "{}", expr: ".a.b.c = true", want: "[{a: {b: {c: true}}}]"},
+		{name: "assign creates maps inside grown lists", doc: "{}", expr: ".items[1].name = \"x\"", want: "[{items: [null, {name: x}]}]"},
+		{name: "assign keeps key order", doc: "{b: 1, a: 2}", expr: ".b = 3", want: "[{b: 3, a: 2}]"},
+		{name: "assign to a negative index", doc: "list: [a, b]", expr: ".list[-1] = \"z\"", want: "[{list: [a, z]}]"},
+		{name: "assign to a negative index of an empty list", doc: "list: []", expr: ".list[-1] = 1", wantErr: true},
+		{name: "assign to every element", doc: "list: [a, b]", expr: ".list[] = 0", want: "[{list: [0, 0]}]"},
+		{name: "assign a value from the document", doc: "{a: 1, b: 2}", expr: ".a = .b", want: "[{a: 2, b: 2}]"},
+		{name: "assign a field of a string", doc: "name: x", expr: ".name.first = 1", wantErr: true},
+		{name: "assign to a computed value", doc: "{}", expr: "1 = 2", wantErr: true},
+		{name: "assign several values", doc: "list: [a, b]", expr: ".x = .list[]", wantErr: true},
+
+		// del
+		{name: "del every element", doc: "list: [a, b, c]", expr: "del(.list[])", want: "[{list: []}]"},
+		{name: "del selected elements", doc: "list: [a, b, c, b]", expr: "del(.list[] | select(. == \"b\"))", want: "[{list: [a, c]}]"},
+		{name: "del selected maps", doc: "items: [{n: 1}, {n: 2}, {n: 3}]", expr: "del(.items[] | select(.n > 1))", want: "[{items: [{n: 1}]}]"},
+		{name: "del every map value", doc: "m: {a: 1, b: 2}", expr: "del(.m[])", want: "[{m: {}}]"},
+		{name: "del fields of every element", doc: "items: [{n: 1, x: 1}, {n: 2}]", expr: "del(.items[].x)", want: "[{items: [{n: 1}, {n: 2}]}]"},
+		{name: "del nothing", doc: "list: [a]", expr: "del(.list[] | select(. == \"z\"))", want: "[{list: [a]}]"},
+		{name: "del a missing field", doc: "{a: 1}", expr: "del(.b)", want: "[{a: 1}]"},
+		{name: "del a computed value", doc: "{a: 1}", expr: "del(1)", wantErr: true},
+		{name: "iterate a string", doc: "{a: x}", expr: "del(.a[])", wantErr: true},
+
+		// Comparisons between mixed types
+		{name: "number equals string", doc: "{}", expr: "1 == \"1\"", want: "[false]"},
+		{name: "number not equal to string", doc: "{}", expr: "1 != \"1\"", want: "[true]"},
+		{name: "number less than string", doc: "{}", expr: "1 < \"2\"", want: "[false]"},
+		{name: "string greater than number", doc: "{}", expr: "\"2\" >= 1", want: "[false]"},
+		{name: "integer equals float", doc: "{}", expr: "1 == 1.0", want: "[true]"},
+		{name: "integer less than float", doc: "{}", expr: "1 < 1.5", want: "[true]"},
+		{name: "null equals false", doc: "{}", expr: "null == false", want: "[false]"},
+		{name: "null equals missing field", doc: "{}", expr: ".a == null", want: "[true]"},
+		{name: "booleans aren't ordered", doc: "{}", expr: "false < true", want: "[false]"},
+		{name: "equal maps", doc: "{a: {x: 1}, b: {x: 1}}", expr: ".a == .b", want: "[true]"},
+		{name: "list compared with number", doc: "list: [1]", expr: ".list > 0", want: "[false]"},
+		{name: "strings order lexically", doc: "{}", expr: "\"10\" < \"9\"", want: "[true]"},
+		{name: "compare every element", doc: "list: [1, a, 3]", expr: ".list[] > 2", want: "[false, false, true]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runYQ(t, tt.doc, tt.expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("%q on %q = %s, want an error", tt.expr, tt.doc, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q on %q failed: %v", tt.expr, tt.doc, err)
+			}
+			if want := normalizeYAML(t, tt.want); got != want {
+				t.Errorf("%q on %q = %s, want %s", tt.expr, tt.doc, got, want)
+			}
+		})
+	}
+}
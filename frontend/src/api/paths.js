@@ -7,6 +7,7 @@ export default {
   logout: API_PREFIX + "/logout",
 
   socket: API_PREFIX + "/cmd-socket",
+  files: API_PREFIX + "/fs",
 
   signup: {
     checkUsername: API_PREFIX + "/signup/check-username",